@@ -0,0 +1,76 @@
+//go:build linux || windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"fk-gov/internal/control"
+)
+
+// pauseChoices are the pause durations offered in the menu; 0 pauses until
+// resumed.
+var pauseChoices = []struct {
+	title string
+	d     time.Duration
+}{
+	{"Pause for 15 Minutes", 15 * time.Minute},
+	{"Pause for 1 Hour", time.Hour},
+	{"Pause Until Resumed", 0},
+}
+
+// callSplitter sends one request to the splitter's control API.
+func callSplitter(socket string, req control.Request, out interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return control.Call(ctx, socket, req, out)
+}
+
+// pauseSplitter pauses splitting for d (until resumed if d is 0) or resumes
+// it when resume is set.
+func pauseSplitter(socket string, d time.Duration, resume bool) error {
+	if resume {
+		return callSplitter(socket, control.Request{Endpoint: control.EndpointResume}, nil)
+	}
+	req := control.Request{Endpoint: control.EndpointPause}
+	if d > 0 {
+		req.Duration = d.String()
+	}
+	var res control.PauseResult
+	if err := callSplitter(socket, req, &res); err != nil {
+		return err
+	}
+	if res.Warning != "" {
+		return fmt.Errorf("paused with warning: %s", res.Warning)
+	}
+	return nil
+}
+
+// pauseState is the splitter's pause state as shown in the menu; until is
+// the local resume time of a timed pause.
+type pauseState struct {
+	paused bool
+	until  string
+}
+
+// readPause asks the splitter whether it is paused.
+func readPause(socket string) (pauseState, error) {
+	var st control.Status
+	if err := callSplitter(socket, control.Request{Endpoint: control.EndpointStatus}, &st); err != nil {
+		return pauseState{}, err
+	}
+	p := pauseState{paused: st.Paused}
+	if st.Paused && st.ResumeAt != nil {
+		p.until = st.ResumeAt.Local().Format("15:04")
+	}
+	return p, nil
+}
+
+func (p pauseState) title() string {
+	if p.until != "" {
+		return "◐ Status: Paused until " + p.until
+	}
+	return "◐ Status: Paused"
+}
@@ -4,15 +4,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/getlantern/systray"
+
+	"fk-gov/internal/config"
+	"fk-gov/internal/control"
 )
 
 const (
@@ -21,7 +25,9 @@ const (
 
 func main() {
 	serviceName := flag.String("service-name", defaultServiceName, "systemd service name to control")
-	action := flag.String("action", "", "action mode: start|stop|restart|toggle|status (runs and exits; may prompt for elevation)")
+	action := flag.String("action", "", "action mode: start|stop|restart|toggle|status|pause|resume (runs and exits; may prompt for elevation)")
+	pauseFor := flag.Duration("for", 0, "with --action pause: resume automatically after this long (0 = until resumed)")
+	socket := flag.String("control-socket", control.DefaultPath(config.PlatformLinux), "splitter control socket")
 	flag.Parse()
 
 	name := strings.TrimSpace(*serviceName)
@@ -31,34 +37,46 @@ func main() {
 
 	act := strings.ToLower(strings.TrimSpace(*action))
 	if act != "" {
-		if err := runAction(name, act); err != nil {
+		if err := runAction(name, *socket, act, *pauseFor); err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
 		return
 	}
 
-	ui := &trayUI{serviceName: name}
+	ui := &trayUI{serviceName: name, socket: *socket}
 	systray.Run(ui.onReady, ui.onExit)
 }
 
 type trayUI struct {
 	serviceName string
+	socket      string
 
 	mStatus  *systray.MenuItem
 	mToggle  *systray.MenuItem
+	mPause   []*systray.MenuItem
+	mResume  *systray.MenuItem
 	mRestart *systray.MenuItem
 	mQuit    *systray.MenuItem
 
-	iconOn  []byte
-	iconOff []byte
-	iconErr []byte
+	iconOn     []byte
+	iconOff    []byte
+	iconErr    []byte
+	iconPaused []byte
+}
+
+// trayState is what the status line shows. pause is only known when the
+// control socket is readable.
+type trayState struct {
+	active bool
+	pause  pauseState
 }
 
 func (t *trayUI) onReady() {
-	t.iconOn = mustBuildIcoCircle(32, rgba{0x34, 0xc7, 0x59, 0xff})  // Apple system green
-	t.iconOff = mustBuildIcoCircle(32, rgba{0x8e, 0x8e, 0x93, 0xff}) // Apple system gray
-	t.iconErr = mustBuildIcoCircle(32, rgba{0xff, 0x3b, 0x30, 0xff}) // Apple system red
+	t.iconOn = mustBuildIcoCircle(32, rgba{0x34, 0xc7, 0x59, 0xff})     // Apple system green
+	t.iconOff = mustBuildIcoCircle(32, rgba{0x8e, 0x8e, 0x93, 0xff})    // Apple system gray
+	t.iconErr = mustBuildIcoCircle(32, rgba{0xff, 0x3b, 0x30, 0xff})    // Apple system red
+	t.iconPaused = mustBuildIcoCircle(32, rgba{0xff, 0x95, 0x00, 0xff}) // Apple system orange
 
 	systray.SetIcon(t.iconOff)
 	systray.SetTooltip("gov-pass")
@@ -71,6 +89,18 @@ func (t *trayUI) onReady() {
 	t.mRestart = systray.AddMenuItem("Restart Service…", "Restart the background service")
 	systray.AddSeparator()
 
+	for _, c := range pauseChoices {
+		item := systray.AddMenuItem(c.title, "Pass traffic through unchanged without stopping the service")
+		t.mPause = append(t.mPause, item)
+		go func(d time.Duration) {
+			for range item.ClickedCh {
+				go t.pause(d, false)
+			}
+		}(c.d)
+	}
+	t.mResume = systray.AddMenuItem("Resume Protection", "Split traffic again after a pause")
+	systray.AddSeparator()
+
 	t.mQuit = systray.AddMenuItem("Quit gov-pass", "Quit the application")
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -86,6 +116,8 @@ func (t *trayUI) onReady() {
 				go t.toggleService()
 			case <-t.mRestart.ClickedCh:
 				go t.restartService()
+			case <-t.mResume.ClickedCh:
+				go t.pause(0, true)
 			case <-t.mQuit.ClickedCh:
 				systray.Quit()
 				return
@@ -102,18 +134,24 @@ func (t *trayUI) toggleService() {
 	active, err := isServiceActive(t.serviceName)
 	if err == nil && active {
 		if err := elevatedSystemctl("stop", t.serviceName); err != nil {
-			log.Printf("stop service failed: %v", err)
+			slog.Error("stop service failed", "err", err)
 		}
 		return
 	}
 	if err := elevatedSystemctl("start", t.serviceName); err != nil {
-		log.Printf("start service failed: %v", err)
+		slog.Error("start service failed", "err", err)
+	}
+}
+
+func (t *trayUI) pause(d time.Duration, resume bool) {
+	if err := pauseService(t.socket, d, resume); err != nil {
+		slog.Error("pause/resume failed", "err", err)
 	}
 }
 
 func (t *trayUI) restartService() {
 	if err := elevatedSystemctl("restart", t.serviceName); err != nil {
-		log.Printf("restart service failed: %v", err)
+		slog.Error("restart service failed", "err", err)
 	}
 }
 
@@ -121,8 +159,11 @@ func (t *trayUI) pollStatus(ctx context.Context) {
 	ticker := time.NewTicker(1500 * time.Millisecond)
 	defer ticker.Stop()
 
-	var lastActive *bool
+	var last *trayState
 	var lastErr bool
+	// Stop asking the control socket once it refuses us; every refusal is
+	// logged by the splitter.
+	readControl := true
 
 	for {
 		select {
@@ -137,43 +178,71 @@ func (t *trayUI) pollStatus(ctx context.Context) {
 					t.mStatus.SetTitle("Status: Unknown")
 					t.mToggle.SetTitle("Start Service…")
 					t.mRestart.Disable()
+					t.setPauseEnabled(false)
 					lastErr = true
-					lastActive = nil
+					last = nil
 				}
 				continue
 			}
 			lastErr = false
 
-			if lastActive == nil || *lastActive != active {
-				lastActive = &active
+			cur := trayState{active: active}
+			if active && readControl {
+				p, err := readPause(t.socket)
+				if errors.Is(err, os.ErrPermission) {
+					readControl = false
+				}
+				cur.pause = p
+			}
+
+			if last == nil || *last != cur {
+				last = &cur
 
-				if active {
+				switch {
+				case cur.pause.paused:
+					systray.SetIcon(t.iconPaused)
+					systray.SetTooltip("gov-pass — Paused")
+					t.mStatus.SetTitle(cur.pause.title())
+					t.mToggle.SetTitle("Deactivate Protection…")
+				case active:
 					systray.SetIcon(t.iconOn)
 					systray.SetTooltip("gov-pass — Active")
 					t.mStatus.SetTitle("● Status: Active")
 					t.mToggle.SetTitle("Deactivate Protection…")
-					t.mRestart.Enable()
-				} else {
+				default:
 					systray.SetIcon(t.iconOff)
 					systray.SetTooltip("gov-pass — Stopped")
 					t.mStatus.SetTitle("○ Status: Inactive")
 					t.mToggle.SetTitle("Activate Protection…")
-					t.mRestart.Enable()
 				}
+				t.mRestart.Enable()
+				t.setPauseEnabled(active)
 			}
 		}
 	}
 }
 
-func runAction(serviceName string, action string) error {
+func (t *trayUI) setPauseEnabled(enabled bool) {
+	for _, item := range append([]*systray.MenuItem{t.mResume}, t.mPause...) {
+		if enabled {
+			item.Enable()
+		} else {
+			item.Disable()
+		}
+	}
+}
+
+func runAction(serviceName, socket, action string, pauseFor time.Duration) error {
 	action = strings.ToLower(strings.TrimSpace(action))
 	switch action {
-	case "start", "stop", "restart", "toggle", "status":
+	case "start", "stop", "restart", "toggle", "status", "pause", "resume":
 	default:
 		return fmt.Errorf("unknown action: %s", action)
 	}
 
 	switch action {
+	case "pause", "resume":
+		return pauseService(socket, pauseFor, action == "resume")
 	case "status":
 		active, err := isServiceActive(serviceName)
 		if err != nil {
@@ -222,3 +291,25 @@ func elevatedSystemctl(action string, serviceName string) error {
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+// pauseService pauses or resumes the running splitter through its control
+// socket. Callers outside the control group are asked to elevate with pkexec,
+// which reruns this binary as root.
+func pauseService(socket string, d time.Duration, resume bool) error {
+	err := pauseSplitter(socket, d, resume)
+	if !errors.Is(err, os.ErrPermission) || os.Geteuid() == 0 {
+		return err
+	}
+	exe, exeErr := os.Executable()
+	if exeErr != nil {
+		return err
+	}
+	action := "pause"
+	if resume {
+		action = "resume"
+	}
+	cmd := exec.Command("pkexec", exe, "--control-socket", socket, "--action", action, "--for", d.String())
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -3,11 +3,12 @@
 package main
 
 import (
+	"path/filepath"
 	"testing"
 )
 
 func TestRunAction_UnknownAction(t *testing.T) {
-	err := runAction("gov-pass", "unknown-action")
+	err := runAction("gov-pass", "", "unknown-action", 0)
 	if err == nil {
 		t.Fatal("expected error for unknown action")
 	}
@@ -20,8 +21,9 @@ func TestRunAction_ValidActions(t *testing.T) {
 	// These actions are recognized by runAction but will fail because systemd
 	// is not managing our test service. We just verify that they don't return
 	// the "unknown action" error.
-	for _, action := range []string{"start", "stop", "restart", "toggle", "status"} {
-		err := runAction("nonexistent-test-service-gov-pass", action)
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	for _, action := range []string{"start", "stop", "restart", "toggle", "status", "pause", "resume"} {
+		err := runAction("nonexistent-test-service-gov-pass", socket, action, 0)
 		if err != nil && err.Error() == "unknown action: "+action {
 			t.Errorf("action %q should be recognized", action)
 		}
@@ -17,6 +17,9 @@ import (
 	"golang.org/x/sys/windows/registry"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/mgr"
+
+	"fk-gov/internal/config"
+	"fk-gov/internal/control"
 )
 
 const (
@@ -28,7 +31,9 @@ const (
 
 func main() {
 	serviceName := flag.String("service-name", defaultServiceName, "Windows service name to control")
-	action := flag.String("action", "", "action mode: start|stop|restart|reload|toggle|status (runs and exits; may prompt for elevation)")
+	action := flag.String("action", "", "action mode: start|stop|restart|reload|toggle|status|pause|resume (runs and exits; may prompt for elevation)")
+	pauseFor := flag.Duration("for", 0, "with --action pause: resume automatically after this long (0 = until resumed)")
+	pipe := flag.String("control-pipe", control.DefaultPath(config.PlatformWindows), "splitter control pipe")
 	flag.Parse()
 
 	name := strings.TrimSpace(*serviceName)
@@ -38,37 +43,42 @@ func main() {
 
 	act := strings.ToLower(strings.TrimSpace(*action))
 	if act != "" {
-		if err := runAction(name, act); err != nil {
+		if err := runAction(name, *pipe, act, *pauseFor); err != nil {
 			// In windowsgui mode there may be no console; keep a non-zero exit code.
 			os.Exit(1)
 		}
 		return
 	}
 
-	ui := &trayUI{serviceName: name}
+	ui := &trayUI{serviceName: name, pipe: *pipe}
 	systray.Run(ui.onReady, ui.onExit)
 }
 
 type trayUI struct {
 	serviceName string
+	pipe        string
 
 	mDashboard *systray.MenuItem
 	mStatus    *systray.MenuItem
 	mToggle    *systray.MenuItem
 	mReload    *systray.MenuItem
 	mRestart   *systray.MenuItem
+	mPause     []*systray.MenuItem
+	mResume    *systray.MenuItem
 	mRunAtLog  *systray.MenuItem
 	mQuit      *systray.MenuItem
 
-	iconOn  []byte
-	iconOff []byte
-	iconErr []byte
+	iconOn     []byte
+	iconOff    []byte
+	iconErr    []byte
+	iconPaused []byte
 }
 
 func (t *trayUI) onReady() {
-	t.iconOn = mustBuildIcoCircle(32, rgba{0x34, 0xc7, 0x59, 0xff})  // Apple system green
-	t.iconOff = mustBuildIcoCircle(32, rgba{0x8e, 0x8e, 0x93, 0xff}) // Apple system gray
-	t.iconErr = mustBuildIcoCircle(32, rgba{0xff, 0x3b, 0x30, 0xff}) // Apple system red
+	t.iconOn = mustBuildIcoCircle(32, rgba{0x34, 0xc7, 0x59, 0xff})     // Apple system green
+	t.iconOff = mustBuildIcoCircle(32, rgba{0x8e, 0x8e, 0x93, 0xff})    // Apple system gray
+	t.iconErr = mustBuildIcoCircle(32, rgba{0xff, 0x3b, 0x30, 0xff})    // Apple system red
+	t.iconPaused = mustBuildIcoCircle(32, rgba{0xff, 0x95, 0x00, 0xff}) // Apple system orange
 
 	systray.SetIcon(t.iconOff)
 	systray.SetTooltip("gov-pass")
@@ -88,6 +98,21 @@ func (t *trayUI) onReady() {
 
 	systray.AddSeparator()
 
+	for _, c := range pauseChoices {
+		item := systray.AddMenuItem(c.title+"…", "Pass traffic through unchanged without stopping the service")
+		item.Disable()
+		t.mPause = append(t.mPause, item)
+		go func(d time.Duration) {
+			for range item.ClickedCh {
+				_ = elevateSelf(t.serviceName, "pause", "--for", d.String(), "--control-pipe", t.pipe)
+			}
+		}(c.d)
+	}
+	t.mResume = systray.AddMenuItem("Resume Protection…", "Split traffic again after a pause")
+	t.mResume.Disable()
+
+	systray.AddSeparator()
+
 	enabled, _ := runAtLoginEnabled()
 	t.mRunAtLog = systray.AddMenuItemCheckbox("Open at Login", "Launch tray at login", enabled)
 	if enabled {
@@ -116,6 +141,8 @@ func (t *trayUI) onReady() {
 				_ = elevateSelf(t.serviceName, "reload")
 			case <-t.mRestart.ClickedCh:
 				_ = elevateSelf(t.serviceName, "restart")
+			case <-t.mResume.ClickedCh:
+				_ = elevateSelf(t.serviceName, "resume", "--control-pipe", t.pipe)
 			case <-t.mRunAtLog.ClickedCh:
 				t.toggleRunAtLogin()
 			case <-t.mQuit.ClickedCh:
@@ -196,8 +223,12 @@ func (t *trayUI) pollStatus(ctx context.Context) {
 	defer ticker.Stop()
 
 	var lastState svc.State
+	var lastPause pauseState
 	var haveLast bool
 	var lastErr bool
+	// The pipe only admits elevated callers; an unelevated tray stops asking
+	// after the first refusal and cannot show the pause state.
+	readControl := true
 
 	for {
 		select {
@@ -213,39 +244,58 @@ func (t *trayUI) pollStatus(ctx context.Context) {
 					t.mToggle.SetTitle("Start Service…")
 					t.mReload.Disable()
 					t.mRestart.Disable()
+					t.setPauseEnabled(false)
 					lastErr = true
 				}
 				continue
 			}
 			lastErr = false
 
-			if !haveLast || state != lastState {
+			var pause pauseState
+			if state == svc.Running && readControl {
+				p, err := readPause(t.pipe)
+				if errors.Is(err, os.ErrPermission) {
+					readControl = false
+				}
+				pause = p
+			}
+
+			if !haveLast || state != lastState || pause != lastPause {
 				haveLast = true
 				lastState = state
-
-				switch state {
-				case svc.Running:
+				lastPause = pause
+				t.setPauseEnabled(state == svc.Running)
+
+				switch {
+				case state == svc.Running && pause.paused:
+					systray.SetIcon(t.iconPaused)
+					systray.SetTooltip("gov-pass — Paused")
+					t.mStatus.SetTitle(pause.title())
+					t.mToggle.SetTitle("Deactivate Protection…")
+					t.mReload.Enable()
+					t.mRestart.Enable()
+				case state == svc.Running:
 					systray.SetIcon(t.iconOn)
 					systray.SetTooltip("gov-pass — Active")
 					t.mStatus.SetTitle("● Status: Active")
 					t.mToggle.SetTitle("Deactivate Protection…")
 					t.mReload.Enable()
 					t.mRestart.Enable()
-				case svc.Stopped:
+				case state == svc.Stopped:
 					systray.SetIcon(t.iconOff)
 					systray.SetTooltip("gov-pass — Stopped")
 					t.mStatus.SetTitle("○ Status: Inactive")
 					t.mToggle.SetTitle("Activate Protection…")
 					t.mReload.Disable()
 					t.mRestart.Enable()
-				case svc.StartPending:
+				case state == svc.StartPending:
 					systray.SetIcon(t.iconOff)
 					systray.SetTooltip("gov-pass — Starting")
 					t.mStatus.SetTitle("◌ Status: Starting…")
 					t.mToggle.Disable()
 					t.mReload.Disable()
 					t.mRestart.Disable()
-				case svc.StopPending:
+				case state == svc.StopPending:
 					systray.SetIcon(t.iconOff)
 					systray.SetTooltip("gov-pass — Stopping")
 					t.mStatus.SetTitle("◌ Status: Stopping…")
@@ -270,19 +320,31 @@ func (t *trayUI) pollStatus(ctx context.Context) {
 	}
 }
 
-func runAction(serviceName string, action string) error {
+func (t *trayUI) setPauseEnabled(enabled bool) {
+	for _, item := range append([]*systray.MenuItem{t.mResume}, t.mPause...) {
+		if enabled {
+			item.Enable()
+		} else {
+			item.Disable()
+		}
+	}
+}
+
+func runAction(serviceName, pipe, action string, pauseFor time.Duration) error {
 	action = strings.ToLower(strings.TrimSpace(action))
 	switch action {
-	case "start", "stop", "restart", "reload", "toggle", "status":
+	case "start", "stop", "restart", "reload", "toggle", "status", "pause", "resume":
 	default:
 		return fmt.Errorf("unknown action: %s", action)
 	}
 
 	if !isElevated() && action != "status" {
-		return elevateSelf(serviceName, action)
+		return elevateSelf(serviceName, action, "--for", pauseFor.String(), "--control-pipe", pipe)
 	}
 
 	switch action {
+	case "pause", "resume":
+		return pauseSplitter(pipe, pauseFor, action == "resume")
 	case "status":
 		_, err := queryServiceState(serviceName)
 		return err
@@ -396,7 +458,7 @@ func isElevated() bool {
 	return tok.IsElevated()
 }
 
-func elevateSelf(serviceName string, action string) error {
+func elevateSelf(serviceName string, action string, extra ...string) error {
 	exe, err := os.Executable()
 	if err != nil {
 		return err
@@ -406,10 +468,10 @@ func elevateSelf(serviceName string, action string) error {
 		return errors.New("executable path is empty")
 	}
 
-	args := []string{
+	args := append([]string{
 		"--service-name", serviceName,
 		"--action", action,
-	}
+	}, extra...)
 	argStr := quoteArgs(args)
 
 	verb, _ := windows.UTF16PtrFromString("runas")
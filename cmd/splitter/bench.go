@@ -0,0 +1,331 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"fk-gov/internal/config"
+	"fk-gov/internal/engine"
+	"fk-gov/internal/flow"
+)
+
+const benchUsage = `usage: splitter bench [flags]
+
+Drives the engine with synthetic port-443 traffic through an in-memory
+adapter, without privileges or a network, and reports throughput, latency,
+hold time, allocations and fail-open reasons. Engine settings come from the
+flags and --config only, so runs with different --workers or caps can be
+compared.
+
+flags:
+`
+
+// runBench runs a synthetic load through the engine and prints a report to
+// stdout.
+func runBench(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	configPath := fs.String("config", "", "config file to take engine settings from")
+	duration := fs.Duration("duration", 10*time.Second, "stop starting connections after this long (0 = no limit)")
+	conns := fs.Int("connections", 0, "stop after this many connections (0 = no limit)")
+	connRate := fs.Float64("conn-rate", 0, "new connections per second (0 = as fast as the engine takes them)")
+	concurrency := fs.Int("concurrency", 256, "connections sending at once, their packets interleaved")
+	helloSize := fs.String("hello-size", "300-1800", "ClientHello record size in bytes, or a min-max range")
+	mss := fs.Int("mss", 1460, "largest TCP payload per packet")
+	reorder := fs.Float64("reorder", 0, "share of connections whose hello segments arrive out of order")
+	overlap := fs.Float64("overlap", 0, "share of connections that retransmit an overlapping hello segment")
+	nonTLS := fs.Float64("non-tls", 0, "share of connections that send plain HTTP instead of a ClientHello")
+	bulk := fs.Float64("bulk", 0, "share of connections that send --bulk-acks ACK-only packets after the hello")
+	bulkAcks := fs.Int("bulk-acks", 200, "ACK-only packets per bulk connection")
+	seed := fs.Int64("seed", 1, "random seed of the traffic mix")
+	cfgFlags := config.RegisterFlags(fs, config.CurrentPlatform())
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), benchUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("bench: unexpected arguments")
+	}
+	mix := benchMix{
+		Duration:    *duration,
+		Connections: *conns,
+		ConnRate:    *connRate,
+		Concurrency: *concurrency,
+		MSS:         *mss,
+		Reorder:     *reorder,
+		Overlap:     *overlap,
+		NonTLS:      *nonTLS,
+		Bulk:        *bulk,
+		BulkAcks:    *bulkAcks,
+		Seed:        *seed,
+	}
+	var err error
+	if mix.HelloMin, mix.HelloMax, err = parseSizeRange(*helloSize); err != nil {
+		return fmt.Errorf("bench: --hello-size: %w", err)
+	}
+	if err := mix.validate(); err != nil {
+		return fmt.Errorf("bench: %w", err)
+	}
+	eff, err := config.Load(config.LoadOptions{
+		Platform: config.CurrentPlatform(),
+		Path:     *configPath,
+		Flags:    cfgFlags.Values(),
+	})
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := setupLogging(eff.Config.Log); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := runBenchLoad(ctx, eff.Config.EngineConfig(), mix)
+	if err != nil {
+		return err
+	}
+	return writeBenchReport(stdout, res)
+}
+
+func (m benchMix) validate() error {
+	if m.Duration <= 0 && m.Connections <= 0 {
+		return errors.New("--duration or --connections must be positive")
+	}
+	if m.Concurrency < 1 || m.MSS < 1 || m.BulkAcks < 0 || m.ConnRate < 0 {
+		return errors.New("--concurrency and --mss must be positive, --bulk-acks and --conn-rate not negative")
+	}
+	for name, v := range map[string]float64{"reorder": m.Reorder, "overlap": m.Overlap, "non-tls": m.NonTLS, "bulk": m.Bulk} {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("--%s must be between 0 and 1", name)
+		}
+	}
+	return nil
+}
+
+// parseSizeRange parses "n" or "min-max".
+func parseSizeRange(s string) (int, int, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	min, err := strconv.Atoi(lo)
+	if err != nil {
+		return 0, 0, err
+	}
+	max := min
+	if isRange {
+		if max, err = strconv.Atoi(hi); err != nil {
+			return 0, 0, err
+		}
+	}
+	if min < 1 || max < min {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	return min, max, nil
+}
+
+// benchResult is what one bench run measured.
+type benchResult struct {
+	Workers     int
+	Elapsed     time.Duration
+	Connections int
+	Packets     int
+	Sent        uint64
+	Dropped     uint64
+	// Held counts captured packets still held when the load ended.
+	Held int
+	// Latency and PayloadLatency are p50, p90, p99, p99.9 and max, over
+	// every packet and over those that went through a worker.
+	Latency        []time.Duration
+	PayloadLatency []time.Duration
+	Stats          engine.Stats
+	// Peak* are the highest per-worker flow count and the highest held and
+	// reassembly bytes summed over the workers, sampled every 100ms.
+	PeakFlows           int
+	PeakHeldBytes       int64
+	PeakReassemblyBytes int64
+	Mallocs             uint64
+	AllocBytes          uint64
+}
+
+var benchPercentiles = []float64{50, 90, 99, 99.9}
+
+func runBenchLoad(ctx context.Context, cfg engine.Config, mix benchMix) (benchResult, error) {
+	load := newBenchLoad(mix)
+	ad := newBenchAdapter(load)
+	eng := engine.New(cfg, ad)
+	var res benchResult
+
+	var mu sync.Mutex
+	peaks := func(st engine.Stats) {
+		var held, reasm int64
+		for _, w := range st.Workers {
+			res.PeakFlows = max(res.PeakFlows, w.Flows)
+			held += w.HeldBytes
+			reasm += w.ReassemblyBytes
+		}
+		res.PeakHeldBytes = max(res.PeakHeldBytes, held)
+		res.PeakReassemblyBytes = max(res.PeakReassemblyBytes, reasm)
+	}
+	var before, after runtime.MemStats
+	var start time.Time
+	// The load ends once every packet was handed to the engine; wait for
+	// the workers to handle them before taking the figures.
+	ad.end = func(ctx context.Context) error {
+		if err := eng.Sync(ctx); err != nil {
+			return err
+		}
+		res.Elapsed = time.Since(start)
+		runtime.ReadMemStats(&after)
+		st, err := eng.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		res.Stats = st
+		res.Workers = len(st.Workers)
+		peaks(st)
+		mu.Unlock()
+		return nil
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				if st, err := eng.Stats(runCtx); err == nil {
+					mu.Lock()
+					peaks(st)
+					mu.Unlock()
+				}
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start = time.Now()
+	err := eng.Run(runCtx)
+	cancel()
+	<-sampled
+	if ctx.Err() != nil {
+		return res, errors.New("bench: interrupted")
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return res, fmt.Errorf("bench: %w", err)
+	}
+
+	res.Connections = load.started
+	res.Packets = load.packets
+	res.Sent, res.Dropped = ad.sent.Load(), ad.dropped.Load()
+	res.Held = ad.held()
+	res.Mallocs = after.Mallocs - before.Mallocs
+	res.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	ad.mu.Lock()
+	res.Latency = append(ad.all.percentiles(benchPercentiles...), ad.all.max)
+	res.PayloadLatency = append(ad.payload.percentiles(benchPercentiles...), ad.payload.max)
+	ad.mu.Unlock()
+	return res, nil
+}
+
+func writeBenchReport(stdout io.Writer, r benchResult) error {
+	secs := r.Elapsed.Seconds()
+	if secs <= 0 {
+		secs = 1e-9
+	}
+	perPkt := func(n uint64) float64 {
+		if r.Packets == 0 {
+			return 0
+		}
+		return float64(n) / float64(r.Packets)
+	}
+	st := r.Stats
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "load\t%d connections, %d packets in %s, %d workers\n", r.Connections, r.Packets, r.Elapsed.Round(time.Millisecond), r.Workers)
+	fmt.Fprintf(tw, "throughput\t%.0f packets/s, %.0f connections/s\n", float64(r.Packets)/secs, float64(r.Connections)/secs)
+	fmt.Fprintf(tw, "latency\tall: %s\n", formatLatency(r.Latency))
+	fmt.Fprintf(tw, "\tvia worker: %s\n", formatLatency(r.PayloadLatency))
+	fmt.Fprintf(tw, "hold time\t%s\n", formatHoldTime(st.HoldTime))
+	fmt.Fprintf(tw, "verdicts\t%d sent, %d dropped, %d still held\n", r.Sent, r.Dropped, r.Held)
+	fmt.Fprintf(tw, "flows\t%d created, %d split, %d failed open, %d evicted idle\n", st.FlowsCreated, st.Splits, st.FailOpens, st.FlowsEvicted)
+	fmt.Fprintf(tw, "fail-opens\t%s\n", formatFailOpens(st.FailOpensByReason))
+	fmt.Fprintf(tw, "peaks\t%d flows per worker, %d held bytes, %d reassembly bytes\n", r.PeakFlows, r.PeakHeldBytes, r.PeakReassemblyBytes)
+	fmt.Fprintf(tw, "allocations\t%.1f allocs/packet, %.0f bytes/packet (including the load generator)\n", perPkt(r.Mallocs), perPkt(r.AllocBytes))
+	return tw.Flush()
+}
+
+func formatLatency(l []time.Duration) string {
+	if len(l) != len(benchPercentiles)+1 {
+		return "-"
+	}
+	parts := make([]string, 0, len(l))
+	for i, p := range benchPercentiles {
+		parts = append(parts, fmt.Sprintf("p%s %s", strconv.FormatFloat(p, 'f', -1, 64), roundLatency(l[i])))
+	}
+	parts = append(parts, fmt.Sprintf("max %s", roundLatency(l[len(l)-1])))
+	return strings.Join(parts, "  ")
+}
+
+// roundLatency keeps three or four significant digits.
+func roundLatency(d time.Duration) string {
+	switch {
+	case d >= time.Millisecond:
+		d = d.Round(time.Microsecond)
+	case d >= time.Microsecond:
+		d = d.Round(10 * time.Nanosecond)
+	}
+	return d.String()
+}
+
+func formatHoldTime(h engine.Histogram) string {
+	if h.Count == 0 {
+		return "no flows held packets"
+	}
+	parts := []string{fmt.Sprintf("%d flows, mean %s", h.Count, (h.Sum / time.Duration(h.Count)).Round(time.Microsecond))}
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		if i < len(h.Bounds) {
+			parts = append(parts, fmt.Sprintf("<=%s %d", h.Bounds[i], n))
+		} else {
+			parts = append(parts, fmt.Sprintf(">%s %d", h.Bounds[len(h.Bounds)-1], n))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatFailOpens(byReason map[flow.FailOpenReason]uint64) string {
+	var parts []string
+	for r := flow.FailOpenReason(1); int(r) < flow.NumFailOpenReasons; r++ {
+		if n := byReason[r]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", r, n))
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
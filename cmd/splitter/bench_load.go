@@ -0,0 +1,370 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fk-gov/internal/packet"
+)
+
+// benchMix describes the synthetic traffic of splitter bench.
+type benchMix struct {
+	// Duration and Connections end the run, whichever comes first; zero
+	// means no limit.
+	Duration    time.Duration
+	Connections int
+	// ConnRate is new connections per second; zero starts them as fast as
+	// the engine takes packets.
+	ConnRate float64
+	// Concurrency is how many connections send at once; their packets are
+	// interleaved round-robin.
+	Concurrency int
+	// HelloMin and HelloMax bound the ClientHello record size in bytes.
+	HelloMin, HelloMax int
+	MSS                int
+	// Reorder, Overlap, NonTLS and Bulk are the shares of connections whose
+	// first two segments arrive swapped, that retransmit part of a segment,
+	// that send plain HTTP instead of TLS, and that follow the hello with
+	// BulkAcks ACK-only packets.
+	Reorder, Overlap, NonTLS, Bulk float64
+	BulkAcks                       int
+	Seed                           int64
+}
+
+// benchLoad generates the packets of a benchMix. It is only used from the
+// engine's receive goroutine.
+type benchLoad struct {
+	mix   benchMix
+	rng   *rand.Rand
+	start time.Time
+	// started counts connections; active holds the packets each open one
+	// still has to send.
+	started int
+	active  [][]*packet.Packet
+	next    int
+	packets int
+}
+
+func newBenchLoad(mix benchMix) *benchLoad {
+	return &benchLoad{mix: mix, rng: rand.New(rand.NewSource(mix.Seed))}
+}
+
+// packet returns the next packet, waiting for the connection rate when no
+// connection is open, or io.EOF once the run is over and every connection
+// has finished.
+func (g *benchLoad) packet(ctx context.Context) (*packet.Packet, error) {
+	if g.start.IsZero() {
+		g.start = time.Now()
+	}
+	for {
+		for len(g.active) < g.mix.Concurrency {
+			wait, ok := g.due()
+			if !ok {
+				break
+			}
+			if wait > 0 {
+				if len(g.active) > 0 {
+					break
+				}
+				t := time.NewTimer(wait)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					return nil, ctx.Err()
+				}
+				continue
+			}
+			g.active = append(g.active, g.conn(g.started))
+			g.started++
+		}
+		if len(g.active) == 0 {
+			return nil, io.EOF
+		}
+		if g.next >= len(g.active) {
+			g.next = 0
+		}
+		pkts := g.active[g.next]
+		pkt := pkts[0]
+		if len(pkts) == 1 {
+			last := len(g.active) - 1
+			g.active[g.next] = g.active[last]
+			g.active = g.active[:last]
+		} else {
+			g.active[g.next] = pkts[1:]
+			g.next++
+		}
+		g.packets++
+		return pkt, nil
+	}
+}
+
+// due reports whether another connection may start and how long until it
+// is due under the connection rate.
+func (g *benchLoad) due() (time.Duration, bool) {
+	if g.mix.Connections > 0 && g.started >= g.mix.Connections {
+		return 0, false
+	}
+	elapsed := time.Since(g.start)
+	if g.mix.Duration > 0 && elapsed >= g.mix.Duration {
+		return 0, false
+	}
+	if g.mix.ConnRate <= 0 {
+		return 0, true
+	}
+	at := time.Duration(float64(g.started) / g.mix.ConnRate * float64(time.Second))
+	return at - elapsed, true
+}
+
+// conn builds the packets of connection n: SYN, the first flight in MSS
+// segments, the bulk ACKs and FIN.
+func (g *benchLoad) conn(n int) []*packet.Packet {
+	src := [4]byte{10, byte(n >> 16), byte(n >> 8), byte(n)}
+	srcPort := uint16(1024 + (n>>24)&0xff)
+	dst := [4]byte{203, 0, 113, byte(1 + n%250)}
+	isn := g.rng.Uint32()
+	mk := func(seq uint32, flags uint8, payload []byte) *packet.Packet {
+		return benchPacket(src, srcPort, dst, seq, flags, payload)
+	}
+
+	var data []byte
+	if g.rng.Float64() < g.mix.NonTLS {
+		data = []byte("GET / HTTP/1.1\r\nHost: bench.example\r\nUser-Agent: splitter-bench\r\n\r\n")
+	} else {
+		size := g.mix.HelloMin
+		if g.mix.HelloMax > size {
+			size += g.rng.Intn(g.mix.HelloMax - size + 1)
+		}
+		data = benchHello("bench.example", size)
+	}
+
+	type segment struct{ off, end int }
+	var segs []segment
+	for off := 0; off < len(data); off += g.mix.MSS {
+		segs = append(segs, segment{off, min(off+g.mix.MSS, len(data))})
+	}
+	if g.rng.Float64() < g.mix.Overlap {
+		if len(segs) == 1 {
+			segs = append(segs, segs[0])
+		} else {
+			// Start a later segment halfway into the one before it.
+			i := 1 + g.rng.Intn(len(segs)-1)
+			segs[i].off -= (segs[i-1].end - segs[i-1].off) / 2
+		}
+	}
+	if len(segs) > 1 && g.rng.Float64() < g.mix.Reorder {
+		i := g.rng.Intn(len(segs) - 1)
+		segs[i], segs[i+1] = segs[i+1], segs[i]
+	}
+
+	pkts := make([]*packet.Packet, 0, len(segs)+2)
+	pkts = append(pkts, mk(isn, packet.TCPFlagSYN, nil))
+	seq := isn + 1
+	for _, s := range segs {
+		pkts = append(pkts, mk(seq+uint32(s.off), packet.TCPFlagACK|packet.TCPFlagPSH, data[s.off:s.end]))
+	}
+	seq += uint32(len(data))
+	if g.rng.Float64() < g.mix.Bulk {
+		for i := 0; i < g.mix.BulkAcks; i++ {
+			pkts = append(pkts, mk(seq, packet.TCPFlagACK, nil))
+		}
+	}
+	return append(pkts, mk(seq, packet.TCPFlagFIN|packet.TCPFlagACK, nil))
+}
+
+func benchPacket(src [4]byte, srcPort uint16, dst [4]byte, seq uint32, flags uint8, payload []byte) *packet.Packet {
+	buf := make([]byte, 40+len(payload))
+	buf[0] = 0x45
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)))
+	buf[8] = 64
+	buf[9] = 6
+	copy(buf[12:16], src[:])
+	copy(buf[16:20], dst[:])
+	binary.BigEndian.PutUint16(buf[20:], srcPort)
+	binary.BigEndian.PutUint16(buf[22:], 443)
+	binary.BigEndian.PutUint32(buf[24:], seq)
+	buf[32] = 5 << 4
+	buf[33] = flags
+	binary.BigEndian.PutUint16(buf[34:], 64240)
+	copy(buf[40:], payload)
+	return &packet.Packet{Data: buf, Source: packet.SourceCaptured}
+}
+
+// benchHello returns a TLS record holding a ClientHello for sni, padded
+// with a padding extension to size bytes when it is shorter.
+func benchHello(sni string, size int) []byte {
+	n := len(sni)
+	exts := []byte{0x00, 0x00, byte((n + 5) >> 8), byte(n + 5), byte((n + 3) >> 8), byte(n + 3), 0x00, byte(n >> 8), byte(n)}
+	exts = append(exts, sni...)
+	// version, random, session ID, one cipher suite, null compression and
+	// the extensions length.
+	const fixed = 2 + 32 + 1 + 4 + 2 + 2
+	if pad := size - 5 - 4 - fixed - len(exts) - 4; pad >= 0 {
+		if pad > 16384-4-fixed-len(exts)-4 {
+			pad = 16384 - 4 - fixed - len(exts) - 4
+		}
+		exts = append(exts, 0x00, 0x15, byte(pad>>8), byte(pad))
+		exts = append(exts, make([]byte, pad)...)
+	}
+	body := append([]byte{0x03, 0x03}, make([]byte, 32)...)
+	body = append(body, 0, 0x00, 0x02, 0x13, 0x01, 0x01, 0x00, byte(len(exts)>>8), byte(len(exts)))
+	body = append(body, exts...)
+	hs := append([]byte{0x01, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{0x16, 0x03, 0x01, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+}
+
+// benchAdapter feeds a benchLoad to the engine and times every captured
+// packet from Recv until the engine sends or drops it.
+type benchAdapter struct {
+	load *benchLoad
+	// end runs once the load is exhausted, before Recv returns io.EOF.
+	end func(context.Context) error
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+
+	mu       sync.Mutex
+	inflight map[*packet.Packet]benchInflight
+	// all times every packet; payload only those that went through a
+	// worker rather than the ACK-only fast path.
+	all, payload latencySample
+}
+
+type benchInflight struct {
+	at      time.Time
+	payload bool
+}
+
+func newBenchAdapter(load *benchLoad) *benchAdapter {
+	return &benchAdapter{
+		load:     load,
+		inflight: make(map[*packet.Packet]benchInflight),
+		all:      newLatencySample(load.mix.Seed),
+		payload:  newLatencySample(load.mix.Seed + 1),
+	}
+}
+
+func (a *benchAdapter) Recv(ctx context.Context) (*packet.Packet, error) {
+	pkt, err := a.load.packet(ctx)
+	if err == io.EOF && a.end != nil {
+		if err := a.end(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	in := benchInflight{at: time.Now(), payload: len(pkt.Data) > 40}
+	a.mu.Lock()
+	a.inflight[pkt] = in
+	a.mu.Unlock()
+	return pkt, nil
+}
+
+func (a *benchAdapter) Send(ctx context.Context, pkt *packet.Packet) error {
+	a.sent.Add(1)
+	a.done(pkt)
+	return nil
+}
+
+func (a *benchAdapter) Drop(ctx context.Context, pkt *packet.Packet) error {
+	a.dropped.Add(1)
+	a.done(pkt)
+	return nil
+}
+
+func (a *benchAdapter) done(pkt *packet.Packet) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	in, ok := a.inflight[pkt]
+	if !ok {
+		// A segment the engine built.
+		return
+	}
+	delete(a.inflight, pkt)
+	d := now.Sub(in.at)
+	a.all.add(d)
+	if in.payload {
+		a.payload.add(d)
+	}
+}
+
+// CalcChecksums computes both checksums like the real adapters, so the
+// cost is part of the result.
+func (a *benchAdapter) CalcChecksums(pkt *packet.Packet) error {
+	if pkt == nil || len(pkt.Data) < 40 {
+		return nil
+	}
+	ipHeaderLen := int(pkt.Data[0]&0x0f) * 4
+	packet.SetIPv4ChecksumZero(pkt.Data)
+	packet.SetTCPChecksumZero(pkt.Data, ipHeaderLen)
+	packet.SetIPv4Checksum(pkt.Data, packet.IPv4Checksum(pkt.Data, ipHeaderLen))
+	packet.SetTCPChecksum(pkt.Data, ipHeaderLen, packet.TCPChecksumIPv4(pkt.Data, ipHeaderLen))
+	return nil
+}
+
+func (a *benchAdapter) Flush(ctx context.Context) error { return nil }
+
+func (a *benchAdapter) Close() error { return nil }
+
+// held is the number of captured packets the engine neither sent nor
+// dropped.
+func (a *benchAdapter) held() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.inflight)
+}
+
+// latencySample keeps a uniform sample of durations (reservoir sampling)
+// so percentiles of long runs fit in fixed memory.
+type latencySample struct {
+	rng   *rand.Rand
+	n     int
+	max   time.Duration
+	items []time.Duration
+}
+
+const latencySampleSize = 1 << 16
+
+func newLatencySample(seed int64) latencySample {
+	return latencySample{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (s *latencySample) add(d time.Duration) {
+	s.n++
+	if d > s.max {
+		s.max = d
+	}
+	if len(s.items) < latencySampleSize {
+		s.items = append(s.items, d)
+	} else if i := s.rng.Intn(s.n); i < latencySampleSize {
+		s.items[i] = d
+	}
+}
+
+// percentiles returns the given percentiles of the sample, in order.
+func (s *latencySample) percentiles(ps ...float64) []time.Duration {
+	sorted := append([]time.Duration(nil), s.items...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	out := make([]time.Duration, len(ps))
+	if len(sorted) == 0 {
+		return out
+	}
+	for i, p := range ps {
+		idx := int(p / 100 * float64(len(sorted)))
+		if idx >= len(sorted) {
+			idx = len(sorted) - 1
+		}
+		out[i] = sorted[idx]
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"fk-gov/internal/engine"
+	"fk-gov/internal/flow"
+	"fk-gov/internal/tls"
+)
+
+func TestBenchHello_Size(t *testing.T) {
+	for _, size := range []int{1, 300, 517, 1800, 20000} {
+		rec := benchHello("bench.example", size)
+		if _, res := tls.DetectClientHelloRecord(rec); res != tls.ResultMatch {
+			t.Fatalf("size %d: not a ClientHello record (%v)", size, res)
+		}
+		if name, ok := tls.ServerName(rec); !ok || name != "bench.example" {
+			t.Fatalf("size %d: server name %q, %v", size, name, ok)
+		}
+		want := size
+		switch {
+		case size < 100:
+			// Too small to pad: the bare hello.
+			want = len(benchHello("bench.example", 0))
+		case size > 16384+5:
+			want = 16384 + 5
+		}
+		if len(rec) != want {
+			t.Fatalf("size %d: record is %d bytes, want %d", size, len(rec), want)
+		}
+	}
+}
+
+func TestBenchLoad(t *testing.T) {
+	cfg := engine.DefaultConfig()
+	cfg.WorkerCount = 2
+	mix := benchMix{
+		Connections: 200,
+		Concurrency: 16,
+		HelloMin:    300,
+		HelloMax:    3000,
+		MSS:         1000,
+		Reorder:     0.3,
+		NonTLS:      0.2,
+		Bulk:        0.2,
+		BulkAcks:    10,
+		Seed:        7,
+	}
+	res, err := runBenchLoad(context.Background(), cfg, mix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := res.Stats
+	if res.Connections != 200 || st.FlowsCreated != 200 {
+		t.Fatalf("connections = %d, flows created = %d; want 200", res.Connections, st.FlowsCreated)
+	}
+	if st.Splits+st.FailOpens != 200 {
+		t.Fatalf("%d splits + %d fail-opens, want one outcome per flow", st.Splits, st.FailOpens)
+	}
+	if n := st.FailOpensByReason[flow.FailOpenNotTLS]; n == 0 || n > 80 {
+		t.Fatalf("not-tls fail-opens = %d, want about 40", n)
+	}
+	if res.Held != 0 {
+		t.Fatalf("%d packets still held", res.Held)
+	}
+	// Every captured packet is sent or dropped, plus the split segments.
+	if got := res.Sent + res.Dropped; got < uint64(res.Packets) {
+		t.Fatalf("%d verdicts for %d packets", got, res.Packets)
+	}
+	if res.Workers != 2 || res.Latency[0] <= 0 {
+		t.Fatalf("workers = %d, latency = %v", res.Workers, res.Latency)
+	}
+
+	var out bytes.Buffer
+	if err := writeBenchReport(&out, res); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"200 connections", "packets/s", "via worker: p50", "not-tls", "allocs/packet"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report has no %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestParseSizeRange(t *testing.T) {
+	for in, want := range map[string][2]int{"517": {517, 517}, "300-1800": {300, 1800}} {
+		lo, hi, err := parseSizeRange(in)
+		if err != nil || lo != want[0] || hi != want[1] {
+			t.Fatalf("%q = %d, %d, %v", in, lo, hi, err)
+		}
+	}
+	for _, in := range []string{"", "0", "10-5", "a-b"} {
+		if _, _, err := parseSizeRange(in); err == nil {
+			t.Fatalf("%q parsed", in)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"log/slog"
+
+	"fk-gov/internal/config"
+	"fk-gov/internal/engine"
+	"fk-gov/internal/logging"
+	"fk-gov/internal/pcapng"
+)
+
+// startCapture replaces the engine's packet capture with the one c asks
+// for, closing the old one. Like metrics, a file that cannot be opened only
+// disables the capture.
+func startCapture(eng *engine.Engine, c config.Config) {
+	if c.Capture.Path == "" {
+		stopCapture(eng)
+		return
+	}
+	cp, err := pcapng.Open(c.CaptureOptions())
+	if err != nil {
+		slog.Warn("packet capture disabled", logging.Event(logging.EventCaptureFailed), "path", c.Capture.Path, logging.Err(err))
+		stopCapture(eng)
+		return
+	}
+	eng.SetCapture(cp).Close()
+	slog.Info("packet capture started", logging.Event(logging.EventCaptureStarted), "path", c.Capture.Path, "hosts", c.Capture.Hosts, "max_bytes", c.Capture.MaxBytes)
+}
+
+func stopCapture(eng *engine.Engine) {
+	eng.SetCapture(nil).Close()
+}
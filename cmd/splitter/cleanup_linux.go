@@ -0,0 +1,48 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"fk-gov/internal/hostnet"
+	"fk-gov/internal/logging"
+)
+
+// runCleanup implements `splitter cleanup`: it reverts the rules and offload
+// changes recorded in the state journal of a splitter that died without
+// cleaning up.
+func runCleanup(args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	stateDir := fs.String("state-dir", installedConfig().NFQueue.StateDir, "directory holding the state journal")
+	force := fs.Bool("force", false, "replay the journal even if its owner process still appears to run")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	st, err := loadJournal(*stateDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			slog.Info("no state journal; nothing to clean up", logging.Event(logging.EventCleanupSkipped), "state_dir", *stateDir)
+			return nil
+		}
+		return err
+	}
+	if st.ownerAlive() && !*force {
+		return fmt.Errorf("splitter pid %d is still running; stop it first or use --force", st.PID)
+	}
+
+	if _, err := replayJournal(st, hostnet.SetOffload); err != nil {
+		return fmt.Errorf("cleanup incomplete (journal kept): %w", err)
+	}
+	if err := os.Remove(filepath.Join(*stateDir, journalFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	slog.Info("cleanup complete", logging.Event(logging.EventCleanup))
+	return nil
+}
@@ -0,0 +1,257 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"fk-gov/internal/config"
+)
+
+func subcommand() string {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		return os.Args[1]
+	}
+	return ""
+}
+
+const configUsage = `usage: splitter config <command> [flags]
+
+commands:
+  validate [file...]       check files (default: the installed config and conf.d drop-ins)
+  print-effective          show the merged configuration and where each value came from
+  schema                   print the JSON Schema of the config file
+  migrate [-in-place] file upgrade a file to schema version %d
+`
+
+// runConfig implements `splitter config`.
+func runConfig(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprintf(stdout, configUsage, config.CurrentVersion)
+		return errors.New("config: missing command")
+	}
+	switch args[0] {
+	case "validate":
+		return runConfigValidate(args[1:], stdout)
+	case "print-effective":
+		return runConfigPrintEffective(args[1:], stdout)
+	case "schema":
+		_, err := stdout.Write(config.Schema())
+		return err
+	case "migrate":
+		return runConfigMigrate(args[1:], stdout)
+	case "help", "-h", "--help":
+		fmt.Fprintf(stdout, configUsage, config.CurrentVersion)
+		return nil
+	default:
+		fmt.Fprintf(stdout, configUsage, config.CurrentVersion)
+		return fmt.Errorf("config: unknown command %q", args[0])
+	}
+}
+
+func runConfigValidate(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		dir := config.DefaultDir(config.CurrentPlatform())
+		if p := config.FindFile(dir); p != "" {
+			paths = append(paths, p)
+		}
+		dropIns, err := config.DropIns(dir)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, dropIns...)
+		if len(paths) == 0 {
+			fmt.Fprintf(stdout, "no config files in %s; built-in defaults apply\n", dir)
+			return nil
+		}
+	}
+
+	invalid := 0
+	for _, p := range paths {
+		f, err := config.CheckFile(p)
+		if err != nil {
+			invalid++
+			for _, e := range splitErrors(err) {
+				fmt.Fprintln(stdout, e)
+			}
+			continue
+		}
+		for _, e := range f.Unknown {
+			fmt.Fprintf(stdout, "%s:%d: warning: unknown key %s is ignored\n", p, e.Line, e.Key)
+		}
+		note := ""
+		if f.Version < config.CurrentVersion {
+			note = fmt.Sprintf("; run `splitter config migrate` to upgrade to version %d", config.CurrentVersion)
+		}
+		fmt.Fprintf(stdout, "%s: ok (version %d%s)\n", p, f.Version, note)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("config: %d of %d file(s) invalid", invalid, len(paths))
+	}
+	return nil
+}
+
+func splitErrors(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		return j.Unwrap()
+	}
+	return []error{err}
+}
+
+type effectiveValue struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Source   string `json:"source"`
+	Location string `json:"location,omitempty"`
+}
+
+func runConfigPrintEffective(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("config print-effective", flag.ContinueOnError)
+	configPath := fs.String("config", "", "config file (default: the platform's config directory)")
+	platform := fs.String("platform", config.CurrentPlatform(), "platform whose sections, env and flags apply: linux, windows or freebsd")
+	jsonOut := fs.Bool("json", false, "print results as JSON")
+	// Accept every platform's flags so --platform can preview another host.
+	cfgFlags := config.RegisterFlags(fs, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	flags := cfgFlags.Values()
+	for name := range flags {
+		for _, f := range config.Fields() {
+			if f.Flag == name && !f.AppliesTo(*platform) {
+				return fmt.Errorf("--%s (%s) does not apply to %s", name, f.Key, *platform)
+			}
+		}
+	}
+
+	eff, err := config.Load(config.LoadOptions{
+		Platform: *platform,
+		Path:     *configPath,
+		Dir:      config.DefaultDir(*platform),
+		Env:      os.Environ(),
+		Flags:    flags,
+	})
+	if err != nil {
+		return err
+	}
+
+	var values []effectiveValue
+	for _, f := range config.PlatformFields(*platform) {
+		o := eff.Origins[f.Key]
+		values = append(values, effectiveValue{Key: f.Key, Value: f.Get(&eff.Config), Source: string(o.Source), Location: o.Location})
+	}
+	files := make([]string, 0, len(eff.Files))
+	for _, f := range eff.Files {
+		files = append(files, f.Path)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Platform string           `json:"platform"`
+			Files    []string         `json:"files"`
+			Values   []effectiveValue `json:"values"`
+		}{*platform, files, values})
+	}
+
+	if len(files) == 0 {
+		fmt.Fprintln(stdout, "# files: none (built-in defaults)")
+	} else {
+		fmt.Fprintf(stdout, "# files: %s\n", strings.Join(files, ", "))
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, v := range values {
+		value := v.Value
+		if value == "" {
+			value = `""`
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", v.Key, value, config.Origin{Source: config.Source(v.Source), Location: v.Location})
+	}
+	return tw.Flush()
+}
+
+func runConfigMigrate(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("config migrate", flag.ContinueOnError)
+	inPlace := fs.Bool("in-place", false, "rewrite the file instead of printing the result")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("config migrate: expected exactly one file")
+	}
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := config.Parse(path, data)
+	if err != nil {
+		return err
+	}
+	for _, e := range f.Unknown {
+		// Migration keeps them; the splitter ignores them with a warning.
+		fmt.Fprintf(os.Stderr, "%s:%d: unknown key %s is kept but ignored\n", path, e.Line, e.Key)
+	}
+	from := f.Version
+	out, changed, err := config.Migrate(f, data)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Fprintf(os.Stderr, "%s: already at version %d\n", path, from)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: migrated from version %d to %d\n", path, from, config.CurrentVersion)
+	}
+	if !*inPlace {
+		_, err := stdout.Write(out)
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	return replaceFile(path, out)
+}
+
+// replaceFile swaps in new content atomically, keeping the file mode.
+func replaceFile(path string, data []byte) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(st.Mode().Perm()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,97 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigPrintEffectiveJSON(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte("{\n  \"version\": 1,\n  \"engine\": {\"split_chunk\": 3},\n  \"windivert\": {\"queue_len\": 99}\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOV_PASS_WORKERS", "6")
+
+	var buf bytes.Buffer
+	err := runConfig([]string{"print-effective", "--json", "--platform", "windows", "--config", path, "--queue-time", "10"}, &buf)
+	if err != nil {
+		t.Fatalf("print-effective: %v", err)
+	}
+	var out struct {
+		Files  []string         `json:"files"`
+		Values []effectiveValue `json:"values"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("unmarshal: %v\n%s", err, buf.String())
+	}
+	got := make(map[string]effectiveValue)
+	for _, v := range out.Values {
+		got[v.Key] = v
+	}
+	want := map[string]effectiveValue{
+		"engine.split_chunk":      {Key: "engine.split_chunk", Value: "3", Source: "file", Location: path + ":3"},
+		"engine.workers":          {Key: "engine.workers", Value: "6", Source: "env", Location: "GOV_PASS_WORKERS"},
+		"windivert.queue_len":     {Key: "windivert.queue_len", Value: "99", Source: "file", Location: path + ":4"},
+		"windivert.queue_time_ms": {Key: "windivert.queue_time_ms", Value: "10", Source: "flag", Location: "--queue-time"},
+		"engine.gc_interval":      {Key: "engine.gc_interval", Value: "5s", Source: "default"},
+	}
+	for key, w := range want {
+		if got[key] != w {
+			t.Fatalf("%s: got %+v want %+v", key, got[key], w)
+		}
+	}
+	if _, ok := got["nfqueue.queue_num"]; ok {
+		t.Fatalf("linux section listed for windows")
+	}
+	if len(out.Files) != 1 || out.Files[0] != path {
+		t.Fatalf("files mismatch: %v", out.Files)
+	}
+}
+
+func TestConfigValidateAndMigrate(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(good, []byte("{\n  \"engine\": {\n    \"workers\": 2,\n    \"legacy\": true\n  }\n}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, []byte("{\n  \"version\": 1,\n  \"nfqueue\": {\"queue_num\": 70000}\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := runConfig([]string{"validate", good, bad}, &buf); err == nil {
+		t.Fatalf("expected validation failure")
+	}
+	if !strings.Contains(buf.String(), bad+":3: nfqueue.queue_num (--queue-num) must be in 0..65535") {
+		t.Fatalf("missing line-accurate error:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), good+":4: warning: unknown key engine.legacy is ignored") {
+		t.Fatalf("missing unknown key warning:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), good+": ok (version 0;") {
+		t.Fatalf("missing ok line:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := runConfig([]string{"migrate", "--in-place", good}, &buf); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	data, err := os.ReadFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "{\n  \"version\": 1,\n") {
+		t.Fatalf("not migrated:\n%s", data)
+	}
+	if st, err := os.Stat(good); err != nil || st.Mode().Perm() != 0o600 {
+		t.Fatalf("mode not kept: %v %v", st.Mode(), err)
+	}
+}
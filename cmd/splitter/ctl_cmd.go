@@ -0,0 +1,410 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"fk-gov/internal/config"
+	"fk-gov/internal/control"
+	"fk-gov/internal/engine"
+	"fk-gov/internal/logging"
+)
+
+// startControl serves the control API until ctx is done. A socket that
+// cannot be opened is logged and does not stop the splitter.
+// ruleDrifts, when not nil, is reported in status.
+func startControl(ctx context.Context, c config.Control, platform string, eng *engine.Engine, r *reloader, ruleDrifts func() uint64) {
+	if !c.Enabled {
+		return
+	}
+	path := c.Socket
+	if path == "" {
+		path = control.DefaultPath(platform)
+	}
+	ln, err := control.Listen(path, c.Group)
+	if err != nil {
+		slog.Warn("control API disabled", logging.Event(logging.EventControlDisabled), "path", path, logging.Err(err))
+		return
+	}
+	srv := &control.Server{Engine: eng, Config: r, Level: &logLevel, Platform: platform, Started: time.Now(), RuleDrifts: ruleDrifts}
+	slog.Info("control API listening", logging.Event(logging.EventControlListening), "path", path)
+	go func() {
+		if err := srv.Serve(ctx, ln); err != nil {
+			slog.Error("control API stopped", logging.Event(logging.EventControlDisabled), "path", path, logging.Err(err))
+		}
+	}()
+}
+
+const ctlUsage = `usage: splitter ctl [--socket path] [--json] [--out file] <command>
+
+commands:
+  status                   show whether the splitter is running, paused and since when
+  config                   show the running configuration and where each value came from
+  config set key=value...  apply engine settings in place until the next restart
+  config reload            re-read the config files, like SIGHUP
+  pause [--for duration]   pass all traffic through unchanged, until resume or for duration
+  resume                   split again after pause
+  stats                    show packet counters and per-worker queues
+  flows [--limit N]        list tracked flows
+  log-level [LEVEL]        show or set the log level: debug, info, warn or error
+  trace [--limit N]        show the recorded flow events (trace.enabled), oldest first
+  handshakes [--limit N]   show how servers answered, by split strategy and destination
+                           (engine.track_handshakes)
+`
+
+// runCtl implements `splitter ctl`.
+func runCtl(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	socket := fs.String("socket", "", "control socket or pipe (default: control.socket of the installed config, else "+control.DefaultPath(config.CurrentPlatform())+")")
+	jsonOut := fs.Bool("json", false, "print the raw JSON result")
+	out := fs.String("out", "", "write the result to this file instead of stdout")
+	timeout := fs.Duration("timeout", 10*time.Second, "give up after this long")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), ctlUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("ctl: missing command")
+	}
+	path := *socket
+	if path == "" {
+		path = installedControlPath()
+	}
+
+	req, err := ctlRequest(fs.Args())
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		stdout = f
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var raw json.RawMessage
+	if err := control.Call(ctx, path, req, &raw); err != nil {
+		return fmt.Errorf("ctl %s: %w", req.Endpoint, err)
+	}
+	if *jsonOut {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, raw, "", "  "); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		_, err := buf.WriteTo(stdout)
+		return err
+	}
+	return printCtl(stdout, req.Endpoint, raw)
+}
+
+// installedControlPath is control.socket of the installed config; a broken
+// or missing config falls back to the platform default.
+func installedControlPath() string {
+	platform := config.CurrentPlatform()
+	eff, err := config.Load(config.LoadOptions{
+		Platform: platform,
+		Dir:      config.DefaultDir(platform),
+		Env:      os.Environ(),
+	})
+	if err == nil && eff.Config.Control.Socket != "" {
+		return eff.Config.Control.Socket
+	}
+	return control.DefaultPath(platform)
+}
+
+func ctlRequest(args []string) (control.Request, error) {
+	cmd, rest := args[0], args[1:]
+	switch cmd {
+	case "pause":
+		fs := flag.NewFlagSet("ctl pause", flag.ContinueOnError)
+		d := fs.Duration("for", 0, "resume automatically after this long (0 = until `splitter ctl resume`)")
+		if err := fs.Parse(rest); err != nil {
+			return control.Request{}, err
+		}
+		if fs.NArg() > 0 {
+			return control.Request{}, fmt.Errorf("ctl pause: unexpected arguments %v", fs.Args())
+		}
+		if *d < 0 {
+			return control.Request{}, errors.New("ctl pause: --for must be >= 0")
+		}
+		req := control.Request{Endpoint: control.EndpointPause}
+		if *d > 0 {
+			req.Duration = d.String()
+		}
+		return req, nil
+	case "status", "resume", "stats":
+		if len(rest) > 0 {
+			return control.Request{}, fmt.Errorf("ctl %s: unexpected arguments %v", cmd, rest)
+		}
+		return control.Request{Endpoint: cmd}, nil
+	case "config":
+		if len(rest) == 0 || rest[0] == "get" && len(rest) == 1 {
+			return control.Request{Endpoint: control.EndpointConfig}, nil
+		}
+		switch rest[0] {
+		case "reload":
+			return control.Request{Endpoint: control.EndpointConfigReload}, nil
+		case "set":
+			if len(rest) == 1 {
+				return control.Request{}, errors.New("ctl config set: expected key=value")
+			}
+			values := make(map[string]string)
+			for _, kv := range rest[1:] {
+				key, value, ok := strings.Cut(kv, "=")
+				if !ok || key == "" {
+					return control.Request{}, fmt.Errorf("ctl config set: %q is not key=value", kv)
+				}
+				values[key] = value
+			}
+			return control.Request{Endpoint: control.EndpointConfigApply, Values: values}, nil
+		}
+		return control.Request{}, fmt.Errorf("ctl config: unknown command %q", rest[0])
+	case "flows":
+		fs := flag.NewFlagSet("ctl flows", flag.ContinueOnError)
+		limit := fs.Int("limit", 100, "maximum number of flows to list (0 = all)")
+		if err := fs.Parse(rest); err != nil {
+			return control.Request{}, err
+		}
+		return control.Request{Endpoint: control.EndpointFlows, Limit: *limit}, nil
+	case "trace":
+		fs := flag.NewFlagSet("ctl trace", flag.ContinueOnError)
+		limit := fs.Int("limit", 0, "only the most recent N events (0 = all)")
+		if err := fs.Parse(rest); err != nil {
+			return control.Request{}, err
+		}
+		return control.Request{Endpoint: control.EndpointTrace, Limit: *limit}, nil
+	case "handshakes":
+		fs := flag.NewFlagSet("ctl handshakes", flag.ContinueOnError)
+		limit := fs.Int("limit", 20, "only the N destinations with the most flows (0 = all)")
+		if err := fs.Parse(rest); err != nil {
+			return control.Request{}, err
+		}
+		return control.Request{Endpoint: control.EndpointHandshakes, Limit: *limit}, nil
+	case "log-level":
+		if len(rest) > 1 {
+			return control.Request{}, errors.New("ctl log-level: expected at most one level")
+		}
+		req := control.Request{Endpoint: control.EndpointLogLevel}
+		if len(rest) == 1 {
+			if _, err := logging.ParseLevel(rest[0]); err != nil {
+				return control.Request{}, err
+			}
+			req.Level = rest[0]
+		}
+		return req, nil
+	}
+	return control.Request{}, fmt.Errorf("ctl: unknown command %q", cmd)
+}
+
+func printCtl(stdout io.Writer, endpoint string, raw json.RawMessage) error {
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	switch endpoint {
+	case control.EndpointStatus:
+		var st control.Status
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return err
+		}
+		state := "running"
+		if st.Paused {
+			state = "paused (traffic passes through unchanged)"
+			if st.ResumeAt != nil {
+				state = fmt.Sprintf("paused until %s (traffic passes through unchanged)", st.ResumeAt.Local().Format(time.RFC3339))
+			}
+		} else if st.Shadow {
+			state = "shadow (traffic passes through; splits are only recorded)"
+		}
+		files := "none (built-in defaults)"
+		if len(st.ConfigFiles) > 0 {
+			files = strings.Join(st.ConfigFiles, ", ")
+		}
+		fmt.Fprintf(tw, "state:\t%s\n", state)
+		fmt.Fprintf(tw, "pid:\t%d\n", st.PID)
+		fmt.Fprintf(tw, "started:\t%s (up %s)\n", st.Started.Format(time.RFC3339), st.Uptime)
+		fmt.Fprintf(tw, "workers:\t%d\n", st.Workers)
+		fmt.Fprintf(tw, "flows:\t%d\n", st.Flows)
+		if st.RuleDrifts != nil {
+			fmt.Fprintf(tw, "rule drifts:\t%d (reinstalled by the watchdog)\n", *st.RuleDrifts)
+		}
+		fmt.Fprintf(tw, "config files:\t%s\n", files)
+		fmt.Fprintf(tw, "log level:\t%s\n", st.LogLevel)
+	case control.EndpointConfig:
+		var res control.ConfigResult
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+		for _, v := range res.Values {
+			value := v.Value
+			if value == "" {
+				value = `""`
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", v.Key, value, config.Origin{Source: config.Source(v.Source), Location: v.Location})
+		}
+	case control.EndpointConfigApply, control.EndpointConfigReload:
+		var res control.ApplyResult
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, "applied")
+		for _, c := range res.RestartRequired {
+			fmt.Fprintf(tw, "requires restart:\t%s\t%s -> %s\t(%s)\n", c.Key, c.From, c.To, c.Reason)
+		}
+	case control.EndpointPause, control.EndpointResume:
+		var res control.PauseResult
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return err
+		}
+		switch {
+		case res.Paused && res.ResumeAt != nil:
+			fmt.Fprintf(tw, "paused; traffic passes through unchanged until %s\n", res.ResumeAt.Local().Format(time.RFC3339))
+		case res.Paused:
+			fmt.Fprintln(tw, "paused; traffic passes through unchanged until `splitter ctl resume`")
+		default:
+			fmt.Fprintln(tw, "resumed")
+		}
+		if res.Warning != "" {
+			fmt.Fprintf(tw, "warning: %s\n", res.Warning)
+		}
+	case control.EndpointStats:
+		var st control.Stats
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "paused:\t%v\n", st.Paused)
+		fmt.Fprintf(tw, "received:\t%d\n", st.Received)
+		fmt.Fprintf(tw, "splits:\t%d\n", st.Splits)
+		fmt.Fprintf(tw, "fail-opens:\t%d\n", st.FailOpens)
+		reasons := make([]string, 0, len(st.FailOpensByReason))
+		for r := range st.FailOpensByReason {
+			reasons = append(reasons, r)
+		}
+		sort.Strings(reasons)
+		for _, r := range reasons {
+			fmt.Fprintf(tw, "  %s:\t%d\n", r, st.FailOpensByReason[r])
+		}
+		fmt.Fprintf(tw, "bypassed (paused):\t%d\n", st.Bypassed)
+		if st.CanaryPercent < 100 || st.ControlFlows > 0 {
+			fmt.Fprintf(tw, "canary:\t%d%% of new flows\n", st.CanaryPercent)
+			fmt.Fprintf(tw, "  control flows (unsplit):\t%d\n", st.ControlFlows)
+		}
+		if st.Shadow || st.ShadowSplits+st.ShadowFailOpens > 0 {
+			fmt.Fprintf(tw, "shadow:\t%v\n", st.Shadow)
+			fmt.Fprintf(tw, "  would split:\t%d\n", st.ShadowSplits)
+			fmt.Fprintf(tw, "  would fail open:\t%d\n", st.ShadowFailOpens)
+		}
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "WORKER\tFLOWS\tQUEUED\tHELD BYTES\tREASSEMBLY BYTES")
+		for _, w := range st.Workers {
+			fmt.Fprintf(tw, "%d\t%d\t%d/%d\t%d\t%d\n", w.ID, w.Flows, w.Queued, w.QueueCap, w.HeldBytes, w.ReassemblyBytes)
+		}
+	case control.EndpointFlows:
+		var flows []control.Flow
+		if err := json.Unmarshal(raw, &flows); err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, "WORKER\tSOURCE\tDESTINATION\tSTATE\tHELD\tBUFFERED\tIDLE\tFAIL-OPEN REASON")
+		for _, f := range flows {
+			reason := f.FailOpenReason
+			if reason == "" {
+				reason = "-"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", f.Worker, f.Src, f.Dst, f.State, f.HeldPackets, f.BufferedBytes, f.Idle, reason)
+		}
+	case control.EndpointLogLevel:
+		var res control.LogLevelResult
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "log level: %s\n", res.Level)
+	case control.EndpointTrace:
+		var res control.TraceResult
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return err
+		}
+		if !res.Enabled {
+			fmt.Fprintln(stdout, "tracing is off (set trace.enabled, e.g. splitter ctl config set trace.enabled=true)")
+			break
+		}
+		writeTrace(stdout, res)
+	case control.EndpointHandshakes:
+		var res control.HandshakesResult
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return err
+		}
+		if !res.Enabled {
+			fmt.Fprintln(tw, "handshake tracking is off (set engine.track_handshakes and restart)")
+			if len(res.ByStrategy) == 0 {
+				break
+			}
+		}
+		fmt.Fprintln(tw, "STRATEGY\tFLOWS\tSERVER-HELLO\tRST\tALERT\tTIMEOUT")
+		for _, h := range res.ByStrategy {
+			printHandshake(tw, h.Strategy, h)
+		}
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "DESTINATION\tSTRATEGY\tFLOWS\tSERVER-HELLO\tRST\tALERT\tTIMEOUT")
+		for _, h := range res.ByDestination {
+			dst := h.Dst
+			if dst == "0.0.0.0" {
+				dst = "(other)"
+			}
+			printHandshake(tw, dst+"\t"+h.Strategy, h)
+		}
+	}
+	return tw.Flush()
+}
+
+// printHandshake writes one row of outcome counts after the leading
+// columns.
+func printHandshake(w io.Writer, lead string, h control.Handshake) {
+	fmt.Fprintf(w, "%s\t%d", lead, h.Flows)
+	for _, o := range []string{"server-hello", "rst", "alert", "timeout"} {
+		n := h.Outcomes[o]
+		if h.Flows == 0 {
+			fmt.Fprintf(w, "\t%d", n)
+			continue
+		}
+		fmt.Fprintf(w, "\t%d (%.0f%%)", n, 100*float64(n)/float64(h.Flows))
+	}
+	fmt.Fprintln(w)
+}
+
+// writeTrace writes one event per line, not aligned: lines are meant for
+// grep.
+func writeTrace(w io.Writer, res control.TraceResult) {
+	if res.Overwritten > 0 {
+		fmt.Fprintf(w, "# %d older events overwritten\n", res.Overwritten)
+	}
+	for _, ev := range res.Events {
+		line := fmt.Sprintf("%s worker=%d %s -> %s %s", ev.Time.Local().Format("2006-01-02T15:04:05.000000"), ev.Worker, ev.Src, ev.Dst, ev.Kind)
+		if ev.Bytes > 0 {
+			line += fmt.Sprintf(" bytes=%d", ev.Bytes)
+		}
+		if ev.Detail != "" {
+			line += " " + ev.Detail
+		}
+		fmt.Fprintln(w, line)
+	}
+}
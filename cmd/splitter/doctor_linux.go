@@ -0,0 +1,499 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"fk-gov/internal/hostnet"
+)
+
+type doctorStatus string
+
+const (
+	doctorPass doctorStatus = "pass"
+	doctorWarn doctorStatus = "warn"
+	doctorFail doctorStatus = "fail"
+)
+
+type doctorResult struct {
+	Check  string       `json:"check"`
+	Status doctorStatus `json:"status"`
+	Detail string       `json:"detail"`
+	Hint   string       `json:"hint,omitempty"`
+}
+
+type doctorOptions struct {
+	QueueNum uint16
+	Mark     uint32
+	Iface    string
+	Unit     string
+	// TrackHandshakes and AutoRules decide whether conntrack accounting is
+	// required.
+	TrackHandshakes bool
+	AutoRules       bool
+}
+
+// runDoctor implements `splitter doctor`: read-only preflight checks for the
+// things that usually make NFQUEUE startup fail. It returns an error when any
+// check fails so scripts can rely on the exit status.
+func runDoctor(args []string, stdout io.Writer) error {
+	installed := installedConfig()
+	nq := installed.NFQueue
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	queueNum := fs.Int("queue-num", nq.QueueNum, "NFQUEUE number the splitter will bind")
+	mark := fs.Int("mark", nq.Mark, "SO_MARK the splitter will set on reinjected packets")
+	iface := fs.String("iface", nq.Iface, "egress interface to inspect (default: auto-detect)")
+	trackHandshakes := fs.Bool("track-handshakes", installed.Engine.TrackHandshakes, "check what --track-handshakes needs")
+	unit := fs.String("unit", "gov-pass.service", "systemd unit to inspect for sandboxing that blocks netlink")
+	jsonOut := fs.Bool("json", false, "print results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *queueNum < 0 || *queueNum > 65535 {
+		return errors.New("queue-num must be in 0..65535")
+	}
+	if *mark < 0 {
+		return errors.New("mark must be >= 0")
+	}
+
+	results := runDoctorChecks(doctorOptions{
+		QueueNum: uint16(*queueNum),
+		Mark:     uint32(*mark),
+		Iface:    strings.TrimSpace(*iface),
+		Unit:     *unit,
+
+		TrackHandshakes: *trackHandshakes,
+		AutoRules:       nq.AutoRules,
+	})
+
+	if *jsonOut {
+		if err := writeDoctorJSON(stdout, results); err != nil {
+			return err
+		}
+	} else {
+		writeDoctorText(stdout, results)
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Status == doctorFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("doctor: %d check(s) failed", failed)
+	}
+	return nil
+}
+
+func runDoctorChecks(opts doctorOptions) []doctorResult {
+	status, _ := os.ReadFile("/proc/self/status")
+	queues, queuesErr := os.ReadFile("/proc/net/netfilter/nfnetlink_queue")
+	rules, rulesErr := hostnet.FwmarkRules()
+	acct, acctErr := os.ReadFile(conntrackAcctPath)
+
+	return []doctorResult{
+		checkPrivileges(os.Geteuid(), status),
+		checkQueueModule(queueModuleLoaded()),
+		checkQueueConflict(queues, queuesErr, opts.QueueNum),
+		checkMarkCollision(rules, rulesErr, opts.Mark),
+		checkFirewallBackends(gatherFirewallInfo()),
+		checkOffloadState(opts.Iface),
+		checkSystemdNetlink(probeNetlink(), readUnitFiles(opts.Unit)),
+		checkConntrackAccounting(acct, acctErr, opts),
+	}
+}
+
+func writeDoctorText(w io.Writer, results []doctorResult) {
+	for _, r := range results {
+		fmt.Fprintf(w, "%-4s  %-17s %s\n", strings.ToUpper(string(r.Status)), r.Check, r.Detail)
+		if r.Hint != "" && r.Status != doctorPass {
+			fmt.Fprintf(w, "      %-17s hint: %s\n", "", r.Hint)
+		}
+	}
+}
+
+func writeDoctorJSON(w io.Writer, results []doctorResult) error {
+	summary := map[doctorStatus]int{doctorPass: 0, doctorWarn: 0, doctorFail: 0}
+	for _, r := range results {
+		summary[r.Status]++
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Checks  []doctorResult       `json:"checks"`
+		Summary map[doctorStatus]int `json:"summary"`
+	}{results, summary})
+}
+
+func checkPrivileges(euid int, procStatus []byte) doctorResult {
+	r := doctorResult{Check: "privileges"}
+	if euid == 0 {
+		r.Status, r.Detail = doctorPass, "running as root"
+		return r
+	}
+	caps, ok := parseCapEff(procStatus)
+	admin := ok && caps&(1<<unix.CAP_NET_ADMIN) != 0
+	raw := ok && caps&(1<<unix.CAP_NET_RAW) != 0
+	switch {
+	case admin && raw:
+		r.Status = doctorWarn
+		r.Detail = "not root, but CAP_NET_ADMIN and CAP_NET_RAW are effective"
+		r.Hint = "NFQUEUE works; --auto-rules and --auto-offload still require root (or disable them and manage rules yourself)"
+	default:
+		var missing []string
+		if !admin {
+			missing = append(missing, "CAP_NET_ADMIN")
+		}
+		if !raw {
+			missing = append(missing, "CAP_NET_RAW")
+		}
+		r.Status = doctorFail
+		r.Detail = "not root; missing " + strings.Join(missing, ", ")
+		r.Hint = "run as root, or grant capabilities: setcap cap_net_admin,cap_net_raw+ep /path/to/splitter"
+	}
+	return r
+}
+
+func parseCapEff(procStatus []byte) (uint64, bool) {
+	scanner := bufio.NewScanner(strings.NewReader(string(procStatus)))
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return 0, false
+		}
+		return caps, true
+	}
+	return 0, false
+}
+
+func queueModuleLoaded() bool {
+	for _, p := range []string{"/sys/module/nfnetlink_queue", "/proc/net/netfilter/nfnetlink_queue"} {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func checkQueueModule(loaded bool) doctorResult {
+	r := doctorResult{Check: "nfnetlink_queue"}
+	if loaded {
+		r.Status, r.Detail = doctorPass, "module loaded"
+		return r
+	}
+	r.Status = doctorWarn
+	r.Detail = "module not loaded (the kernel loads it on first bind when it is available)"
+	r.Hint = "modprobe nfnetlink_queue; on minimal kernels install the extra modules package"
+	return r
+}
+
+// checkQueueConflict looks for another listener bound to the queue in
+// /proc/net/netfilter/nfnetlink_queue. The first column is the queue number
+// and the second the netlink port ID of the consumer.
+func checkQueueConflict(procQueues []byte, readErr error, queue uint16) doctorResult {
+	r := doctorResult{Check: "queue-number"}
+	if readErr != nil {
+		r.Status = doctorPass
+		r.Detail = fmt.Sprintf("queue %d: no queue is bound yet", queue)
+		return r
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(procQueues)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil || uint16(n) != queue {
+			continue
+		}
+		r.Status = doctorFail
+		r.Detail = fmt.Sprintf("queue %d is already bound by netlink port %s (another NFQUEUE consumer such as suricata, snort or a second splitter)", queue, fields[1])
+		r.Hint = "stop the other consumer or pick a free queue with --queue-num"
+		return r
+	}
+	r.Status = doctorPass
+	r.Detail = fmt.Sprintf("queue %d is free", queue)
+	return r
+}
+
+// checkConntrackAccounting reports whether the inbound rule of
+// engine.track_handshakes can limit itself to the start of each connection.
+func checkConntrackAccounting(data []byte, readErr error, opts doctorOptions) doctorResult {
+	r := doctorResult{Check: "conntrack-acct"}
+	switch {
+	case conntrackAcctOn(data, readErr):
+		r.Status, r.Detail = doctorPass, "net.netfilter.nf_conntrack_acct=1"
+		return r
+	case !opts.TrackHandshakes:
+		r.Status, r.Detail = doctorPass, "accounting is off; only --track-handshakes needs it"
+		return r
+	case opts.AutoRules:
+		r.Status = doctorFail
+		r.Detail = "accounting is off; the splitter refuses to start with --track-handshakes"
+	default:
+		r.Status = doctorWarn
+		r.Detail = "accounting is off; a connbytes or ct packets limit on your own inbound rule does not work"
+	}
+	r.Hint = "modprobe nf_conntrack && sysctl -w net.netfilter.nf_conntrack_acct=1, and persist it in /etc/sysctl.d"
+	return r
+}
+
+// checkMarkCollision reports policy routing rules that route packets carrying
+// our SO_MARK differently from the original (unmarked) packets. WireGuard and
+// Tailscale both install such fwmark rules.
+func checkMarkCollision(rules []hostnet.FwmarkRule, readErr error, mark uint32) doctorResult {
+	r := doctorResult{Check: "so-mark"}
+	if readErr != nil {
+		r.Status = doctorWarn
+		r.Detail = fmt.Sprintf("could not list ip rules: %v", readErr)
+		return r
+	}
+	if mark == 0 {
+		r.Status = doctorWarn
+		r.Detail = "mark=0: reinjected packets cannot be told apart from new ones"
+		r.Hint = "use a non-zero --mark"
+		return r
+	}
+	var hits []string
+	for _, rule := range rules {
+		if rule.Matches(mark) == rule.Matches(0) {
+			continue
+		}
+		not := ""
+		if rule.Invert {
+			not = "not "
+		}
+		hits = append(hits, fmt.Sprintf("priority %d: %sfwmark %#x/%#x lookup %d", rule.Priority, not, rule.Mark, rule.Mask, rule.Table))
+	}
+	if len(hits) == 0 {
+		r.Status = doctorPass
+		r.Detail = fmt.Sprintf("mark %#x does not change policy routing (%d fwmark rule(s))", mark, len(rules))
+		return r
+	}
+	r.Status = doctorWarn
+	r.Detail = fmt.Sprintf("mark %#x changes routing of reinjected packets: %s", mark, strings.Join(hits, "; "))
+	r.Hint = "choose a --mark bit these rules ignore (e.g. outside the rule's mask)"
+	return r
+}
+
+type firewallInfo struct {
+	nft           bool
+	iptables      bool
+	iptablesMode  string // "nf_tables", "legacy" or "" when unknown
+	legacyTables  []string
+	nftTableCount int
+}
+
+func gatherFirewallInfo() firewallInfo {
+	var fw firewallInfo
+	if path, ok := lookPath("nft"); ok {
+		fw.nft = true
+		if out, err := runCommand(path, "list", "tables"); err == nil {
+			for _, line := range strings.Split(out, "\n") {
+				if strings.HasPrefix(line, "table ") {
+					fw.nftTableCount++
+				}
+			}
+		}
+	}
+	if path, ok := lookPath("iptables"); ok {
+		fw.iptables = true
+		if out, err := runCommand(path, "-V"); err == nil {
+			switch {
+			case strings.Contains(out, "nf_tables"):
+				fw.iptablesMode = "nf_tables"
+			case strings.Contains(out, "legacy"):
+				fw.iptablesMode = "legacy"
+			}
+		}
+	}
+	if data, err := os.ReadFile("/proc/net/ip_tables_names"); err == nil {
+		fw.legacyTables = strings.Fields(string(data))
+	}
+	return fw
+}
+
+func checkFirewallBackends(fw firewallInfo) doctorResult {
+	r := doctorResult{Check: "firewall-backend"}
+	if !fw.nft && !fw.iptables {
+		r.Status = doctorWarn
+		r.Detail = "neither nft nor iptables is installed"
+		r.Hint = "install nftables, or keep --auto-install-tools=true so the splitter installs it"
+		return r
+	}
+	backend := "iptables"
+	if fw.nft {
+		backend = "nft"
+	}
+	if len(fw.legacyTables) > 0 && backend == "nft" {
+		r.Status = doctorWarn
+		r.Detail = fmt.Sprintf("rules go to nft while legacy iptables tables (%s) are active; both run on the same hooks and a legacy DROP or REDIRECT can win", strings.Join(fw.legacyTables, ", "))
+		r.Hint = "migrate the host to iptables-nft (update-alternatives --set iptables /usr/sbin/iptables-nft) so all rules live in nftables"
+		return r
+	}
+	if backend == "iptables" && fw.iptablesMode == "legacy" && fw.nftTableCount > 0 {
+		r.Status = doctorWarn
+		r.Detail = "rules go to iptables-legacy while nftables tables exist"
+		r.Hint = "install nft so gov-pass uses nftables, or switch iptables to the nf_tables variant"
+		return r
+	}
+	r.Status = doctorPass
+	r.Detail = "rules will be installed via " + backend
+	if fw.iptablesMode != "" {
+		r.Detail += " (iptables variant: " + fw.iptablesMode + ")"
+	}
+	return r
+}
+
+func checkOffloadState(iface string) doctorResult {
+	r := doctorResult{Check: "offload"}
+	if iface == "" {
+		detected, err := hostnet.EgressInterface(hostnet.ProbeDestination)
+		if err != nil {
+			r.Status = doctorWarn
+			r.Detail = fmt.Sprintf("could not detect egress interface: %v", err)
+			r.Hint = "pass --iface"
+			return r
+		}
+		iface = detected
+	}
+	st, err := hostnet.ReadOffload(iface)
+	if err != nil {
+		r.Status = doctorWarn
+		r.Detail = fmt.Sprintf("%s: could not read offload state: %v", iface, err)
+		return r
+	}
+	return assessOffload(iface, st)
+}
+
+func assessOffload(iface string, st hostnet.OffloadState) doctorResult {
+	r := doctorResult{Check: "offload"}
+	detail := fmt.Sprintf("%s: gro=%s gso=%s tso=%s", iface, onOff(st.GRO), onOff(st.GSO), onOff(st.TSO))
+	if !st.GRO && !st.GSO && !st.TSO {
+		r.Status, r.Detail = doctorPass, detail
+		return r
+	}
+	r.Status = doctorWarn
+	r.Detail = detail + "; oversized segments bypass splitting"
+	r.Hint = fmt.Sprintf("keep --auto-offload=true (default) or run: ethtool -K %s gro off gso off tso off", iface)
+	return r
+}
+
+// probeNetlink opens a netfilter netlink socket the way the NFQUEUE adapter
+// does; a seccomp address family filter rejects it with EAFNOSUPPORT.
+func probeNetlink() error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return err
+	}
+	return unix.Close(fd)
+}
+
+// readUnitFiles returns the text of the unit and its drop-ins, keyed by path,
+// in the order systemd applies them.
+func readUnitFiles(unit string) []unitFile {
+	var files []unitFile
+	for _, dir := range []string{"/usr/lib/systemd/system", "/lib/systemd/system", "/etc/systemd/system"} {
+		if data, err := os.ReadFile(filepath.Join(dir, unit)); err == nil {
+			files = []unitFile{{path: filepath.Join(dir, unit), text: string(data)}}
+		}
+	}
+	for _, dir := range []string{"/usr/lib/systemd/system", "/lib/systemd/system", "/etc/systemd/system"} {
+		dropins, _ := filepath.Glob(filepath.Join(dir, unit+".d", "*.conf"))
+		for _, p := range dropins {
+			if data, err := os.ReadFile(p); err == nil {
+				files = append(files, unitFile{path: p, text: string(data)})
+			}
+		}
+	}
+	return files
+}
+
+type unitFile struct {
+	path string
+	text string
+}
+
+func checkSystemdNetlink(probeErr error, files []unitFile) doctorResult {
+	r := doctorResult{Check: "systemd-netlink"}
+	if probeErr != nil && errors.Is(probeErr, unix.EAFNOSUPPORT) {
+		r.Status = doctorFail
+		r.Detail = "AF_NETLINK sockets are blocked for this process"
+		r.Hint = "add AF_NETLINK to RestrictAddressFamilies= in the unit running the splitter"
+		return r
+	}
+	if len(files) == 0 {
+		r.Status = doctorPass
+		r.Detail = "netlink sockets allowed; no gov-pass unit installed"
+		return r
+	}
+	if blocked, where := restrictsNetlink(files); blocked {
+		r.Status = doctorFail
+		r.Detail = "RestrictAddressFamilies= in " + where + " blocks AF_NETLINK; NFQUEUE, offload control and egress detection will fail"
+		r.Hint = "add AF_NETLINK to RestrictAddressFamilies= (see packaging/rpm/gov-pass.service)"
+		return r
+	}
+	r.Status = doctorPass
+	r.Detail = "netlink sockets allowed by " + files[0].path
+	return r
+}
+
+// restrictsNetlink evaluates RestrictAddressFamilies= across a unit and its
+// drop-ins with systemd's merge rules: allow-lists accumulate, a "~" prefix
+// makes a deny-list, and an empty assignment resets the setting.
+func restrictsNetlink(files []unitFile) (bool, string) {
+	var (
+		set   bool
+		deny  bool
+		where string
+		fams  = make(map[string]bool)
+	)
+	for _, f := range files {
+		scanner := bufio.NewScanner(strings.NewReader(f.text))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			value, ok := strings.CutPrefix(line, "RestrictAddressFamilies=")
+			if !ok {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if value == "" {
+				set, deny, fams = false, false, make(map[string]bool)
+				continue
+			}
+			isDeny := strings.HasPrefix(value, "~")
+			if !set || isDeny != deny {
+				fams = make(map[string]bool)
+			}
+			set, deny, where = true, isDeny, f.path
+			for _, fam := range strings.Fields(strings.TrimPrefix(value, "~")) {
+				fams[fam] = true
+			}
+		}
+	}
+	if !set {
+		return false, ""
+	}
+	if fams["none"] {
+		return true, where
+	}
+	return fams["AF_NETLINK"] == deny, where
+}
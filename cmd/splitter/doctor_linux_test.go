@@ -0,0 +1,179 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"fk-gov/internal/hostnet"
+)
+
+func TestCheckPrivileges(t *testing.T) {
+	status := func(capEff string) []byte {
+		return []byte("Name:\tsplitter\nCapInh:\t0000000000000000\nCapEff:\t" + capEff + "\n")
+	}
+	tests := []struct {
+		name   string
+		euid   int
+		status []byte
+		want   doctorStatus
+	}{
+		{name: "root", euid: 0, want: doctorPass},
+		{name: "caps", euid: 1000, status: status("0000000000003000"), want: doctorWarn},
+		{name: "admin only", euid: 1000, status: status("0000000000001000"), want: doctorFail},
+		{name: "none", euid: 1000, status: status("0000000000000000"), want: doctorFail},
+		{name: "unreadable", euid: 1000, want: doctorFail},
+	}
+	for _, tt := range tests {
+		if got := checkPrivileges(tt.euid, tt.status); got.Status != tt.want {
+			t.Fatalf("%s: got %s (%s), want %s", tt.name, got.Status, got.Detail, tt.want)
+		}
+	}
+}
+
+func TestCheckQueueConflict(t *testing.T) {
+	proc := []byte("    0  23456     0 2 65535     0     0        0  1\n  100  12345     3 2 65535     0     0       42  1\n")
+
+	if got := checkQueueConflict(proc, nil, 100); got.Status != doctorFail || !strings.Contains(got.Detail, "12345") {
+		t.Fatalf("bound queue: %+v", got)
+	}
+	if got := checkQueueConflict(proc, nil, 101); got.Status != doctorPass {
+		t.Fatalf("free queue: %+v", got)
+	}
+	if got := checkQueueConflict(nil, os.ErrNotExist, 100); got.Status != doctorPass {
+		t.Fatalf("no proc file: %+v", got)
+	}
+}
+
+func TestCheckMarkCollision(t *testing.T) {
+	rules := []hostnet.FwmarkRule{
+		// Tailscale: unmarked and mark=1 packets are both routed via table 52.
+		{Priority: 5270, Table: 52, Mark: 0x80000, Mask: 0xff0000, Invert: true},
+		// wg-quick: marked packets bypass the tunnel.
+		{Priority: 100, Table: 51820, Mark: 0xca6c, Mask: 0xffffffff},
+	}
+
+	if got := checkMarkCollision(rules, nil, 1); got.Status != doctorPass {
+		t.Fatalf("mark 1: %+v", got)
+	}
+	if got := checkMarkCollision(rules, nil, 0xca6c); got.Status != doctorWarn || !strings.Contains(got.Detail, "lookup 51820") {
+		t.Fatalf("wireguard mark: %+v", got)
+	}
+	if got := checkMarkCollision(rules, nil, 0x80000); got.Status != doctorWarn || !strings.Contains(got.Detail, "not fwmark") {
+		t.Fatalf("tailscale mark: %+v", got)
+	}
+	if got := checkMarkCollision(nil, errors.New("denied"), 1); got.Status != doctorWarn {
+		t.Fatalf("read error: %+v", got)
+	}
+}
+
+func TestCheckFirewallBackends(t *testing.T) {
+	tests := []struct {
+		name string
+		fw   firewallInfo
+		want doctorStatus
+	}{
+		{name: "nft only", fw: firewallInfo{nft: true, iptables: true, iptablesMode: "nf_tables"}, want: doctorPass},
+		{name: "nft with legacy tables", fw: firewallInfo{nft: true, iptables: true, iptablesMode: "legacy", legacyTables: []string{"filter", "nat"}}, want: doctorWarn},
+		{name: "legacy iptables with nft tables", fw: firewallInfo{iptables: true, iptablesMode: "legacy", nftTableCount: 2}, want: doctorWarn},
+		{name: "legacy iptables only", fw: firewallInfo{iptables: true, iptablesMode: "legacy", legacyTables: []string{"filter"}}, want: doctorPass},
+		{name: "nothing", fw: firewallInfo{}, want: doctorWarn},
+	}
+	for _, tt := range tests {
+		if got := checkFirewallBackends(tt.fw); got.Status != tt.want {
+			t.Fatalf("%s: got %s (%s), want %s", tt.name, got.Status, got.Detail, tt.want)
+		}
+	}
+}
+
+func TestAssessOffload(t *testing.T) {
+	if got := assessOffload("eth0", hostnet.OffloadState{}); got.Status != doctorPass {
+		t.Fatalf("all off: %+v", got)
+	}
+	got := assessOffload("eth0", hostnet.OffloadState{GRO: true})
+	if got.Status != doctorWarn || !strings.Contains(got.Hint, "ethtool -K eth0") {
+		t.Fatalf("gro on: %+v", got)
+	}
+}
+
+func TestCheckSystemdNetlink(t *testing.T) {
+	unit := unitFile{path: "/etc/systemd/system/gov-pass.service", text: "[Service]\nRestrictAddressFamilies=AF_INET AF_INET6 AF_NETLINK AF_PACKET\n"}
+	narrowed := unitFile{path: "/etc/systemd/system/gov-pass.service.d/harden.conf", text: "[Service]\nRestrictAddressFamilies=\nRestrictAddressFamilies=AF_INET AF_INET6\n"}
+	denied := unitFile{path: "/etc/systemd/system/gov-pass.service.d/deny.conf", text: "[Service]\nRestrictAddressFamilies=~AF_NETLINK\n"}
+	added := unitFile{path: "/etc/systemd/system/gov-pass.service.d/add.conf", text: "[Service]\nRestrictAddressFamilies=AF_NETLINK\n"}
+
+	tests := []struct {
+		name  string
+		probe error
+		files []unitFile
+		want  doctorStatus
+	}{
+		{name: "no unit", want: doctorPass},
+		{name: "shipped unit", files: []unitFile{unit}, want: doctorPass},
+		{name: "reset drop-in", files: []unitFile{unit, narrowed}, want: doctorFail},
+		{name: "allow-lists merge", files: []unitFile{unit, narrowed, added}, want: doctorPass},
+		{name: "deny-list", files: []unitFile{denied}, want: doctorFail},
+		{name: "live probe", probe: unix.EAFNOSUPPORT, want: doctorFail},
+	}
+	for _, tt := range tests {
+		if got := checkSystemdNetlink(tt.probe, tt.files); got.Status != tt.want {
+			t.Fatalf("%s: got %s (%s), want %s", tt.name, got.Status, got.Detail, tt.want)
+		}
+	}
+}
+
+func TestWriteDoctorJSON(t *testing.T) {
+	var buf bytes.Buffer
+	results := []doctorResult{
+		{Check: "privileges", Status: doctorPass, Detail: "running as root"},
+		{Check: "queue-number", Status: doctorFail, Detail: "queue 100 is already bound", Hint: "use --queue-num"},
+	}
+	if err := writeDoctorJSON(&buf, results); err != nil {
+		t.Fatalf("writeDoctorJSON: %v", err)
+	}
+	var decoded struct {
+		Checks  []doctorResult `json:"checks"`
+		Summary map[string]int `json:"summary"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(decoded.Checks) != 2 || decoded.Checks[1].Hint != "use --queue-num" {
+		t.Fatalf("checks mismatch: %+v", decoded.Checks)
+	}
+	if decoded.Summary["pass"] != 1 || decoded.Summary["fail"] != 1 || decoded.Summary["warn"] != 0 {
+		t.Fatalf("summary mismatch: %v", decoded.Summary)
+	}
+}
+
+func TestCheckConntrackAccounting(t *testing.T) {
+	track := doctorOptions{TrackHandshakes: true, AutoRules: true}
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+		opts doctorOptions
+		want doctorStatus
+	}{
+		{name: "on", data: []byte("1\n"), opts: track, want: doctorPass},
+		{name: "off, not tracking", data: []byte("0\n"), want: doctorPass},
+		{name: "off, auto rules", data: []byte("0\n"), opts: track, want: doctorFail},
+		{name: "not loaded", err: os.ErrNotExist, opts: track, want: doctorFail},
+		{name: "off, own rules", data: []byte("0\n"), opts: doctorOptions{TrackHandshakes: true}, want: doctorWarn},
+	}
+	for _, tt := range tests {
+		if got := checkConntrackAccounting(tt.data, tt.err, tt.opts); got.Status != tt.want {
+			t.Fatalf("%s: got %s (%s), want %s", tt.name, got.Status, got.Detail, tt.want)
+		}
+	}
+	if err := checkConntrackAcct(nil, os.ErrNotExist); err == nil || !strings.Contains(err.Error(), "nf_conntrack_acct=1") {
+		t.Fatalf("startup check with accounting off: %v", err)
+	}
+}
@@ -0,0 +1,355 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"fk-gov/internal/hostnet"
+	"fk-gov/internal/logging"
+)
+
+const (
+	journalFileName     = "state.json"
+	journalVersion      = 1
+	journalNftTable     = "gov_pass"
+	journalNftChain     = "output"
+	journalNftRuleTag   = "gov-pass"
+	journalIptablesName = "GOVPASS_OUTPUT"
+)
+
+// journalState is everything needed to undo the system changes of a splitter
+// that did not get to run its own cleanup.
+type journalState struct {
+	Version   int                `json:"version"`
+	PID       int                `json:"pid"`
+	Exe       string             `json:"exe,omitempty"`
+	StartedAt time.Time          `json:"started_at"`
+	Rules     *journalRules      `json:"rules,omitempty"`
+	Offload   []journalInterface `json:"offload,omitempty"`
+}
+
+type journalRules struct {
+	Backend         string `json:"backend"`
+	Path            string `json:"path"`
+	QueueNum        uint16 `json:"queue_num"`
+	Mark            uint32 `json:"mark"`
+	ExcludeLoopback bool   `json:"exclude_loopback"`
+	NFLogGroup      uint16 `json:"nflog_group,omitempty"`
+	Inbound         bool   `json:"inbound,omitempty"`
+	// Handles are the nft rule handles, or empty for iptables where the
+	// dedicated chain identifies our rules.
+	Handles []int  `json:"handles,omitempty"`
+	Chain   string `json:"chain,omitempty"`
+}
+
+type journalInterface struct {
+	Iface string `json:"iface"`
+	// Original is nil when the state could not be read or restore is disabled.
+	Original *hostnet.OffloadState `json:"original,omitempty"`
+}
+
+// stateJournal persists journalState so that a later `splitter cleanup` or the
+// next start can revert what this process changed. Every update is written
+// before the change it describes is applied.
+type stateJournal struct {
+	path string
+
+	mu    sync.Mutex
+	state journalState
+	// carried are offload states a stale journal could not restore. They stay
+	// in this journal, and in the one left behind at exit, until an offload
+	// manager adopts them.
+	carried []journalInterface
+}
+
+func newStateJournal(dir string) *stateJournal {
+	exe, _ := os.Executable()
+	return &stateJournal{
+		path: filepath.Join(dir, journalFileName),
+		state: journalState{
+			Version:   journalVersion,
+			PID:       os.Getpid(),
+			Exe:       exe,
+			StartedAt: time.Now().UTC(),
+		},
+	}
+}
+
+func (j *stateJournal) recordRules(r *ruleSet) error {
+	rules := &journalRules{
+		Backend:         r.backend,
+		Path:            r.path,
+		QueueNum:        r.opts.QueueNum,
+		Mark:            r.opts.Mark,
+		ExcludeLoopback: r.opts.ExcludeLoopback,
+		NFLogGroup:      r.opts.NFLogGroup,
+		Inbound:         r.opts.Inbound,
+	}
+	switch r.backend {
+	case "nft":
+		// Before the first install the chain may not exist yet; the tag sweep
+		// on replay covers rules added after this point.
+		if handles, err := taggedNftHandles(r.path, journalNftTable, journalNftChain, journalNftRuleTag); err == nil {
+			rules.Handles = handles
+		}
+	case "iptables":
+		rules.Chain = journalIptablesName
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state.Rules = rules
+	return j.writeLocked()
+}
+
+func (j *stateJournal) recordOffload(original map[string]*hostnet.OffloadState) error {
+	ifaces := make([]journalInterface, 0, len(original))
+	for iface, st := range original {
+		entry := journalInterface{Iface: iface}
+		if st != nil {
+			copied := *st
+			entry.Original = &copied
+		}
+		ifaces = append(ifaces, entry)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, entry := range j.carried {
+		if _, ok := original[entry.Iface]; !ok {
+			ifaces = append(ifaces, entry)
+		}
+	}
+	sort.Slice(ifaces, func(a, b int) bool { return ifaces[a].Iface < ifaces[b].Iface })
+	j.state.Offload = ifaces
+	return j.writeLocked()
+}
+
+// carry keeps entries, the offload states a stale journal could not restore,
+// in this journal so they are not lost when it replaces the stale one.
+func (j *stateJournal) carry(entries []journalInterface) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.carried = entries
+	j.state.Offload = append([]journalInterface(nil), entries...)
+	return j.writeLocked()
+}
+
+// takeCarried hands the carried entries to the caller, which becomes
+// responsible for restoring and recording them.
+func (j *stateJournal) takeCarried() []journalInterface {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := j.carried
+	j.carried = nil
+	return entries
+}
+
+// remove deletes the journal after a clean shutdown. Carried entries nobody
+// restored are left in a journal of their own for the next start or
+// `splitter cleanup`.
+func (j *stateJournal) remove() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.carried) > 0 {
+		j.state = journalState{Version: journalVersion, StartedAt: j.state.StartedAt, Offload: j.carried}
+		slog.Warn("offload state from an earlier run still not restored; journal kept", logging.Event(logging.EventJournalFailed), "path", j.path)
+		return j.writeLocked()
+	}
+	if dryRunf("remove state journal %s", j.path) {
+		return nil
+	}
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (j *stateJournal) writeLocked() error {
+	if dryRunf("write state journal %s", j.path) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(j.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), journalFileName+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
+
+func loadJournal(dir string) (*journalState, error) {
+	data, err := os.ReadFile(filepath.Join(dir, journalFileName))
+	if err != nil {
+		return nil, err
+	}
+	var st journalState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("parse state journal: %w", err)
+	}
+	if st.Version != journalVersion {
+		return nil, fmt.Errorf("unsupported state journal version %d", st.Version)
+	}
+	return &st, nil
+}
+
+// ownerAlive reports whether the process that wrote the journal still runs.
+// A live PID running a different executable is treated as reused.
+func (st *journalState) ownerAlive() bool {
+	if st.PID <= 0 || st.PID == os.Getpid() {
+		return false
+	}
+	if err := syscall.Kill(st.PID, 0); err != nil && !errors.Is(err, syscall.EPERM) {
+		return false
+	}
+	if st.Exe == "" {
+		return true
+	}
+	exe, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(st.PID), "exe"))
+	if err != nil {
+		return true
+	}
+	return strings.TrimSuffix(exe, " (deleted)") == st.Exe
+}
+
+// replayJournal reverts the changes recorded in st. It keeps going after a
+// failure so that one vanished interface does not leave rules behind, and
+// returns the offload entries it could not restore.
+func replayJournal(st *journalState, setOffload func(string, hostnet.OffloadState) error) ([]journalInterface, error) {
+	var errs []error
+	var unrestored []journalInterface
+
+	if r := st.Rules; r != nil {
+		path := r.Path
+		if _, err := os.Stat(path); err != nil {
+			if p, ok := lookPath(r.Backend); ok {
+				path = p
+			}
+		}
+		rules := &ruleSet{
+			backend: r.Backend,
+			path:    path,
+			opts:    ruleOptions{QueueNum: r.QueueNum, Mark: r.Mark, ExcludeLoopback: r.ExcludeLoopback, NFLogGroup: r.NFLogGroup, Inbound: r.Inbound},
+		}
+		if r.Backend == "nft" {
+			for _, h := range r.Handles {
+				_, _ = runCommand(path, "delete", "rule", "inet", journalNftTable, journalNftChain, "handle", strconv.Itoa(h))
+			}
+		}
+		if err := rules.uninstall(); err != nil {
+			errs = append(errs, fmt.Errorf("remove %s rules: %w", r.Backend, err))
+		} else {
+			slog.Info("cleanup: rules removed", logging.Event(logging.EventRulesRemoved), "backend", r.Backend)
+		}
+	}
+
+	for _, entry := range st.Offload {
+		if entry.Original == nil {
+			continue
+		}
+		if err := setOffload(entry.Iface, *entry.Original); err != nil {
+			errs = append(errs, fmt.Errorf("restore offload on %s: %w", entry.Iface, err))
+			unrestored = append(unrestored, entry)
+			continue
+		}
+		slog.Info("cleanup: offload restored", logging.Event(logging.EventOffloadRestored), "iface", entry.Iface,
+			"gro", entry.Original.GRO, "gso", entry.Original.GSO, "tso", entry.Original.TSO)
+	}
+
+	return unrestored, errors.Join(errs...)
+}
+
+// recoverStaleJournal replays a journal left behind by a splitter that was
+// killed before it could clean up. It refuses to touch a journal whose owner
+// is still running. The offload states it could not restore are returned
+// for the caller's journal to carry; rules are reinstalled and recorded
+// anew, so they need no carrying.
+func recoverStaleJournal(dir string, setOffload func(string, hostnet.OffloadState) error) ([]journalInterface, error) {
+	st, err := loadJournal(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if st.ownerAlive() {
+		return nil, fmt.Errorf("another splitter (pid %d) owns %s", st.PID, filepath.Join(dir, journalFileName))
+	}
+	slog.Warn("stale state journal found; restoring system state", logging.Event(logging.EventJournalStale),
+		"pid", st.PID, "started", st.StartedAt.Format(time.RFC3339))
+	if isDryRun() {
+		setOffload = func(iface string, o hostnet.OffloadState) error {
+			dryRunf("ethtool -K %s gro %s gso %s tso %s", iface, onOff(o.GRO), onOff(o.GSO), onOff(o.TSO))
+			return nil
+		}
+	}
+	unrestored, err := replayJournal(st, setOffload)
+	if err != nil {
+		slog.Warn("stale journal cleanup incomplete; unrestored offload states are kept in the new journal", logging.Event(logging.EventJournalFailed), logging.Err(err))
+	}
+	if len(unrestored) > 0 {
+		// The new journal is written over this one; the caller carries the
+		// entries over before anything else is recorded.
+		return unrestored, nil
+	}
+	if dryRunf("remove state journal %s", filepath.Join(dir, journalFileName)) {
+		return nil, nil
+	}
+	if err := os.Remove(filepath.Join(dir, journalFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func taggedNftHandles(path string, table string, chain string, tag string) ([]int, error) {
+	out, err := runCommand(path, "-a", "list", "chain", "inet", table, chain)
+	if err != nil {
+		return nil, err
+	}
+	want := fmt.Sprintf("comment \"%s\"", tag)
+	var handles []int
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, want) {
+			continue
+		}
+		if h, ok := parseNftHandle(line); ok {
+			handles = append(handles, h)
+		}
+	}
+	return handles, scanner.Err()
+}
@@ -0,0 +1,215 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"fk-gov/internal/hostnet"
+)
+
+func TestStateJournalRoundTrip(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "gov-pass")
+	t.Setenv("NFT_LIST_CHAIN_OUTPUT", "meta mark & 0x00000001 == 0x00000001 return comment \"gov-pass\" # handle 7\n"+
+		"meta nfproto ipv4 tcp dport 443 queue flags bypass to 100 comment \"gov-pass\" # handle 9\n")
+	nft := writeExecScript(t, `printf "%s" "${NFT_LIST_CHAIN_OUTPUT:-}"`)
+
+	j := newStateJournal(dir)
+	if err := j.recordRules(&ruleSet{backend: "nft", path: nft, opts: ruleOptions{QueueNum: 100, Mark: 1}}); err != nil {
+		t.Fatalf("recordRules: %v", err)
+	}
+	eth0 := hostnet.OffloadState{GRO: true, TSO: true}
+	if err := j.recordOffload(map[string]*hostnet.OffloadState{"eth0": &eth0, "wlan0": nil}); err != nil {
+		t.Fatalf("recordOffload: %v", err)
+	}
+
+	st, err := loadJournal(dir)
+	if err != nil {
+		t.Fatalf("loadJournal: %v", err)
+	}
+	if st.PID != os.Getpid() || st.ownerAlive() {
+		t.Fatalf("own journal must not count as a live owner: pid=%d", st.PID)
+	}
+	if st.Rules == nil || st.Rules.Backend != "nft" || st.Rules.QueueNum != 100 {
+		t.Fatalf("rules mismatch: %+v", st.Rules)
+	}
+	if len(st.Rules.Handles) != 2 || st.Rules.Handles[0] != 7 || st.Rules.Handles[1] != 9 {
+		t.Fatalf("handles mismatch: %v", st.Rules.Handles)
+	}
+	if len(st.Offload) != 2 || st.Offload[0].Iface != "eth0" || *st.Offload[0].Original != eth0 || st.Offload[1].Original != nil {
+		t.Fatalf("offload mismatch: %+v", st.Offload)
+	}
+
+	if err := j.remove(); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := loadJournal(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected journal to be gone, got %v", err)
+	}
+	if err := j.remove(); err != nil {
+		t.Fatalf("second remove: %v", err)
+	}
+}
+
+func TestReplayJournal(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "nft.log")
+	t.Setenv("FAKE_LOG_FILE", logFile)
+	nft := writeExecScript(t, `
+echo "$*" >> "$FAKE_LOG_FILE"
+if [ "${1:-}" = "-a" ]; then
+  printf "%s\n" 'tcp dport 443 queue num 100 bypass comment "gov-pass" # handle 12'
+fi
+`)
+
+	eth0 := hostnet.OffloadState{GRO: true, GSO: true}
+	st := &journalState{
+		Version: journalVersion,
+		Rules:   &journalRules{Backend: "nft", Path: nft, QueueNum: 100, Handles: []int{4}},
+		Offload: []journalInterface{
+			{Iface: "gone0", Original: &hostnet.OffloadState{GRO: true}},
+			{Iface: "eth0", Original: &eth0},
+			{Iface: "wlan0"},
+		},
+	}
+	restored := make(map[string]hostnet.OffloadState)
+	unrestored, err := replayJournal(st, func(iface string, s hostnet.OffloadState) error {
+		if iface == "gone0" {
+			return errors.New("no such device")
+		}
+		restored[iface] = s
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected error for the vanished interface")
+	}
+	if len(restored) != 1 || restored["eth0"] != eth0 {
+		t.Fatalf("restored mismatch: %v", restored)
+	}
+	if len(unrestored) != 1 || unrestored[0].Iface != "gone0" {
+		t.Fatalf("unrestored = %+v, want gone0", unrestored)
+	}
+
+	lines := readLines(t, logFile)
+	assertLineContains(t, lines, "delete rule inet gov_pass output handle 4")
+	assertLineContains(t, lines, "delete rule inet gov_pass output handle 12")
+}
+
+func TestRecoverStaleJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	setOffload := func(string, hostnet.OffloadState) error { return nil }
+	if _, err := recoverStaleJournal(dir, setOffload); err != nil {
+		t.Fatalf("missing journal: %v", err)
+	}
+
+	// A journal owned by a live process running another executable is stale.
+	sleep := exec.Command("sleep", "30")
+	if err := sleep.Start(); err != nil {
+		t.Skipf("sleep unavailable: %v", err)
+	}
+	defer func() {
+		_ = sleep.Process.Kill()
+		_ = sleep.Wait()
+	}()
+
+	j := newStateJournal(dir)
+	j.state.PID = sleep.Process.Pid
+	if err := j.recordOffload(nil); err != nil {
+		t.Fatalf("recordOffload: %v", err)
+	}
+	if _, err := recoverStaleJournal(dir, setOffload); err != nil {
+		t.Fatalf("recoverStaleJournal: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalFileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stale journal not removed: %v", err)
+	}
+
+	// The same PID with a matching executable is a live owner.
+	sleepExe, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(sleep.Process.Pid), "exe"))
+	if err != nil {
+		t.Skipf("readlink sleep exe: %v", err)
+	}
+	j.state.Exe = sleepExe
+	if err := j.recordOffload(nil); err != nil {
+		t.Fatalf("recordOffload: %v", err)
+	}
+	if _, err := recoverStaleJournal(dir, setOffload); err == nil {
+		t.Fatalf("expected live owner to be refused")
+	}
+	if err := runCleanup([]string{"--state-dir", dir}); err == nil {
+		t.Fatalf("cleanup must refuse a live owner without --force")
+	}
+	if err := runCleanup([]string{"--state-dir", dir, "--force"}); err != nil {
+		t.Fatalf("cleanup --force: %v", err)
+	}
+	if err := runCleanup([]string{"--state-dir", dir}); err != nil {
+		t.Fatalf("cleanup without journal: %v", err)
+	}
+}
+
+func TestRecoverStaleJournal_CarriesUnrestoredOffload(t *testing.T) {
+	dir := t.TempDir()
+	original := hostnet.OffloadState{GRO: true, GSO: true, TSO: true}
+	stale := newStateJournal(dir)
+	stale.state.PID = 0
+	if err := stale.recordOffload(map[string]*hostnet.OffloadState{"usb0": &original}); err != nil {
+		t.Fatalf("recordOffload: %v", err)
+	}
+
+	carried, err := recoverStaleJournal(dir, func(string, hostnet.OffloadState) error {
+		return errors.New("no such device")
+	})
+	if err != nil {
+		t.Fatalf("recoverStaleJournal: %v", err)
+	}
+	if len(carried) != 1 || carried[0].Iface != "usb0" || *carried[0].Original != original {
+		t.Fatalf("carried = %+v", carried)
+	}
+
+	// The new run's journal keeps the entry next to what it records itself,
+	// and leaves it behind at exit when nobody restored it.
+	j := newStateJournal(dir)
+	if err := j.carry(carried); err != nil {
+		t.Fatalf("carry: %v", err)
+	}
+	if err := j.recordOffload(map[string]*hostnet.OffloadState{"eth0": {}}); err != nil {
+		t.Fatalf("recordOffload: %v", err)
+	}
+	st, err := loadJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Offload) != 2 || st.Offload[1].Iface != "usb0" || *st.Offload[1].Original != original {
+		t.Fatalf("journal offload = %+v", st.Offload)
+	}
+	if err := j.remove(); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	st, err = loadJournal(dir)
+	if err != nil {
+		t.Fatalf("journal with carried entries removed: %v", err)
+	}
+	if st.ownerAlive() || st.Rules != nil || len(st.Offload) != 1 || st.Offload[0].Iface != "usb0" {
+		t.Fatalf("kept journal = %+v", st)
+	}
+
+	// Once an offload manager adopts the entries, a clean exit removes it.
+	j = newStateJournal(dir)
+	if err := j.carry(carried); err != nil {
+		t.Fatalf("carry: %v", err)
+	}
+	m := newOffloadManager(true)
+	m.record = j.recordOffload
+	m.adopt(j.takeCarried())
+	if err := j.remove(); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalFileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("journal not removed after adoption: %v", err)
+	}
+}
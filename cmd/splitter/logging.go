@@ -0,0 +1,76 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"fk-gov/internal/config"
+	"fk-gov/internal/logging"
+)
+
+// logLevel is the running log level; `splitter ctl log-level` and reloads
+// change it.
+var logLevel slog.LevelVar
+
+// logOutput is where the splitter logs: stderr, or the service log file on
+// Windows.
+var logOutput io.Writer = os.Stderr
+
+// setupLogging makes a handler for c the default logger. The standard log
+// package writes through it too.
+func setupLogging(c config.Log) error {
+	level, err := logging.ParseLevel(c.Level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+	logger, err := logging.New(logOutput, logging.Options{
+		Level:     &logLevel,
+		Format:    c.Format,
+		Redact:    c.Redact,
+		RateLimit: c.RateLimit,
+	})
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// logStarted records the start of the splitter and the files its
+// configuration came from.
+func logStarted(eff *config.Effective, platform string) {
+	files := make([]string, 0, len(eff.Files))
+	for _, f := range eff.Files {
+		files = append(files, f.Path)
+	}
+	slog.Info("splitter started", logging.Event(logging.EventStarted), "platform", platform, "pid", os.Getpid(), "config_files", files)
+	warnUnknownKeys(eff.Files)
+}
+
+// warnUnknownKeys logs the keys config files set that this build does not
+// know. They are ignored, like the original Windows loader did.
+func warnUnknownKeys(files []*config.File) {
+	for _, f := range files {
+		for _, e := range f.Unknown {
+			slog.Warn("unknown config key ignored", logging.Event(logging.EventConfigWarning), "key", e.Key, "location", fmt.Sprintf("%s:%d", f.Path, e.Line))
+		}
+	}
+}
+
+func logStopped() {
+	slog.Info("splitter stopped", logging.Event(logging.EventStopped))
+}
+
+// exitOnError reports err and exits non-zero.
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+	slog.Error(err.Error())
+	os.Exit(1)
+}
@@ -6,129 +6,84 @@ import (
 	"context"
 	"errors"
 	"flag"
-	"log"
+	"fmt"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
-	"time"
 
 	"fk-gov/internal/adapter"
+	"fk-gov/internal/config"
 	"fk-gov/internal/engine"
 )
 
+// traceDumpSignal asks for the trace to be written to trace.dump_path.
+const traceDumpSignal = syscall.SIGINFO
+
 func main() {
-	cfg := engine.DefaultConfig()
-	const defaultDivertPort = 10000
+	switch subcommand() {
+	case "bench":
+		exitOnError(runBench(os.Args[2:], os.Stdout))
+		return
+	case "config":
+		exitOnError(runConfig(os.Args[2:], os.Stdout))
+		return
+	case "ctl":
+		exitOnError(runCtl(os.Args[2:], os.Stdout))
+		return
+	case "replay":
+		exitOnError(runReplay(os.Args[2:], os.Stdout))
+		return
+	}
 
-	splitMode := flag.String("split-mode", "tls-hello", "split trigger: tls-hello or immediate")
-	splitChunk := flag.Int("split-chunk", cfg.SplitChunk, "first split size in bytes")
-	collectTimeout := flag.Duration("collect-timeout", cfg.CollectTimeout, "reassembly collect timeout")
-	maxBuffer := flag.Int("max-buffer", cfg.MaxBufferBytes, "max reassembly buffer size in bytes")
-	maxHeld := flag.Int("max-held-pkts", cfg.MaxHeldPackets, "max held packets per flow")
-	maxSegPayload := flag.Int("max-seg-payload", cfg.MaxSegmentPayload, "max segment payload size (0=unlimited)")
-	workers := flag.Int("workers", cfg.WorkerCount, "worker count for sharded processing")
-	flowTimeout := flag.Duration("flow-timeout", cfg.FlowIdleTimeout, "idle timeout for flow cleanup")
-	gcInterval := flag.Duration("gc-interval", cfg.GCInterval, "flow GC interval")
-	maxFlows := flag.Int("max-flows-per-worker", cfg.MaxFlowsPerWorker, "max tracked flows per worker (0=unlimited)")
-	maxReassembly := flag.Int("max-reassembly-bytes-per-worker", cfg.MaxReassemblyBytesPerWorker, "max total reassembly bytes per worker (0=unlimited)")
-	maxHeldBytes := flag.Int("max-held-bytes-per-worker", cfg.MaxHeldBytesPerWorker, "max total held packet bytes per worker (0=unlimited)")
-	shutdownFailOpenTimeout := flag.Duration("shutdown-fail-open-timeout", cfg.ShutdownFailOpenTimeout, "shutdown fail-open drain timeout per worker (0=use default)")
-	shutdownFailOpenMaxPkts := flag.Int("shutdown-fail-open-max-pkts", cfg.ShutdownFailOpenMaxPackets, "shutdown fail-open max packets per worker (0=use default)")
-	adapterFlushTimeout := flag.Duration("adapter-flush-timeout", cfg.AdapterFlushTimeout, "adapter flush timeout on shutdown (0=use default)")
-	divertPort := flag.Int("divert-port", defaultDivertPort, "pf divert-to port")
+	configPath := flag.String("config", "", "config file (default: JSON, first of config or config.json in /usr/local/etc/gov-pass; drop-ins in /usr/local/etc/gov-pass/conf.d are applied after it)")
+	cfgFlags := config.RegisterFlags(flag.CommandLine, config.PlatformFreeBSD)
 	flag.Parse()
 
-	mode, err := parseSplitMode(*splitMode)
+	loadOpts := config.LoadOptions{
+		Platform: config.PlatformFreeBSD,
+		Path:     *configPath,
+		Dir:      config.DefaultDir(config.PlatformFreeBSD),
+		Env:      os.Environ(),
+		Flags:    cfgFlags.Values(),
+	}
+	eff, err := config.Load(loadOpts)
 	if err != nil {
-		log.Fatalf("invalid split-mode: %v", err)
-	}
-	if *splitChunk < 1 {
-		log.Fatal("split-chunk must be >= 1")
-	}
-	if *maxBuffer < 1 {
-		log.Fatal("max-buffer must be >= 1")
-	}
-	if *maxHeld < 1 {
-		log.Fatal("max-held-pkts must be >= 1")
-	}
-	if *maxSegPayload < 0 {
-		log.Fatal("max-seg-payload must be >= 0")
-	}
-	if *workers < 1 {
-		log.Fatal("workers must be >= 1")
-	}
-	if *collectTimeout < 1*time.Millisecond {
-		log.Fatal("collect-timeout must be >= 1ms")
-	}
-	if *flowTimeout < 1*time.Millisecond {
-		log.Fatal("flow-timeout must be >= 1ms")
-	}
-	if *gcInterval < 1*time.Millisecond {
-		log.Fatal("gc-interval must be >= 1ms")
-	}
-	if *maxFlows < 0 {
-		log.Fatal("max-flows-per-worker must be >= 0")
-	}
-	if *maxReassembly < 0 {
-		log.Fatal("max-reassembly-bytes-per-worker must be >= 0")
-	}
-	if *maxHeldBytes < 0 {
-		log.Fatal("max-held-bytes-per-worker must be >= 0")
-	}
-	if *shutdownFailOpenTimeout < 0 {
-		log.Fatal("shutdown-fail-open-timeout must be >= 0")
-	}
-	if *shutdownFailOpenMaxPkts < 0 {
-		log.Fatal("shutdown-fail-open-max-pkts must be >= 0")
-	}
-	if *adapterFlushTimeout < 0 {
-		log.Fatal("adapter-flush-timeout must be >= 0")
-	}
-	if *divertPort < 1 || *divertPort > 65535 {
-		log.Fatal("divert-port must be in 1..65535")
+		exitOnError(fmt.Errorf("invalid configuration: %w", err))
 	}
-
-	cfg.SplitMode = mode
-	cfg.SplitChunk = *splitChunk
-	cfg.CollectTimeout = *collectTimeout
-	cfg.MaxBufferBytes = *maxBuffer
-	cfg.MaxHeldPackets = *maxHeld
-	cfg.MaxSegmentPayload = *maxSegPayload
-	cfg.MaxFlowsPerWorker = *maxFlows
-	cfg.MaxReassemblyBytesPerWorker = *maxReassembly
-	cfg.MaxHeldBytesPerWorker = *maxHeldBytes
-	cfg.ShutdownFailOpenTimeout = *shutdownFailOpenTimeout
-	cfg.ShutdownFailOpenMaxPackets = *shutdownFailOpenMaxPkts
-	cfg.AdapterFlushTimeout = *adapterFlushTimeout
-	cfg.WorkerCount = *workers
-	cfg.FlowIdleTimeout = *flowTimeout
-	cfg.GCInterval = *gcInterval
+	exitOnError(setupLogging(eff.Config.Log))
+	logStarted(eff, config.PlatformFreeBSD)
+	defer logStopped()
+	cfg := eff.Config.EngineConfig()
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	opts := adapter.DivertOptions{
-		Port: uint16(*divertPort),
+		Port: uint16(eff.Config.Divert.Port),
 	}
 	ad, err := adapter.NewDivert(opts)
 	if err != nil {
-		log.Fatalf("divert open failed: %v", err)
+		exitOnError(fmt.Errorf("divert open failed: %w", err))
 	}
 	eng := engine.New(cfg, ad)
+	eng.SetTrace(eff.Config.TraceRing())
+	startCapture(eng, eff.Config)
+	defer stopCapture(eng)
 
-	if err := eng.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
-		log.Fatalf("engine stopped: %v", err)
-	}
-}
+	r := newReloader(eng, config.PlatformFreeBSD, loadOpts.Flags, eff, func(flags map[string]string) (*config.Effective, error) {
+		opts := loadOpts
+		opts.Flags = flags
+		return config.Load(opts)
+	})
+	reloadCtx, reloadCancel := context.WithCancel(ctx)
+	defer reloadCancel()
+	go reloadOnSIGHUP(reloadCtx, r)
+	go pauseOnSignals(reloadCtx, eng, r)
+	go dumpTraceOnSignal(reloadCtx, eng, r)
+	startControl(reloadCtx, eff.Config.Control, config.PlatformFreeBSD, eng, r, nil)
+	startMetrics(reloadCtx, eff.Config.Metrics, eng, ad, nil)
 
-func parseSplitMode(value string) (engine.SplitMode, error) {
-	switch strings.ToLower(value) {
-	case "immediate":
-		return engine.SplitModeImmediate, nil
-	case "tls-hello":
-		return engine.SplitModeTLSHello, nil
-	default:
-		return engine.SplitModeTLSHello, errors.New("expected tls-hello or immediate")
+	if err := eng.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		exitOnError(fmt.Errorf("engine stopped: %w", err))
 	}
 }
@@ -8,7 +8,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -16,230 +16,256 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
-	"time"
 
 	"fk-gov/internal/adapter"
+	"fk-gov/internal/config"
 	"fk-gov/internal/engine"
+	"fk-gov/internal/hostnet"
+	"fk-gov/internal/logging"
 )
 
+// traceDumpSignal asks for the trace to be written to trace.dump_path.
+const traceDumpSignal = syscall.SIGPWR
+
 func main() {
-	if err := run(); err != nil {
-		log.Fatal(err)
+	var err error
+	switch subcommand() {
+	case "bench":
+		err = runBench(os.Args[2:], os.Stdout)
+	case "cleanup":
+		err = runCleanup(os.Args[2:])
+	case "config":
+		err = runConfig(os.Args[2:], os.Stdout)
+	case "ctl":
+		err = runCtl(os.Args[2:], os.Stdout)
+	case "doctor":
+		err = runDoctor(os.Args[2:], os.Stdout)
+	case "replay":
+		err = runReplay(os.Args[2:], os.Stdout)
+	default:
+		err = run()
 	}
+	exitOnError(err)
 }
 
-func run() error {
-	cfg := engine.DefaultConfig()
-	const (
-		defaultQueueNum    = 100
-		defaultQueueMaxLen = 4096
-		defaultCopyRange   = 0xffff
-		defaultMark        = 1
-	)
+// installedConfig loads the config file and GOV_PASS_* environment the
+// service runs with, for subcommands whose defaults should follow it. A
+// broken config falls back to the built-in defaults.
+func installedConfig() config.Config {
+	eff, err := config.Load(config.LoadOptions{
+		Platform: config.PlatformLinux,
+		Dir:      config.DefaultDir(config.PlatformLinux),
+		Env:      os.Environ(),
+	})
+	if err != nil {
+		slog.Warn("ignoring configuration", logging.Event(logging.EventConfigIgnored), logging.Err(err))
+		return config.Defaults()
+	}
+	return eff.Config
+}
 
-	splitMode := flag.String("split-mode", "tls-hello", "split trigger: tls-hello or immediate")
-	splitChunk := flag.Int("split-chunk", cfg.SplitChunk, "first split size in bytes")
-	collectTimeout := flag.Duration("collect-timeout", cfg.CollectTimeout, "reassembly collect timeout")
-	maxBuffer := flag.Int("max-buffer", cfg.MaxBufferBytes, "max reassembly buffer size in bytes")
-	maxHeld := flag.Int("max-held-pkts", cfg.MaxHeldPackets, "max held packets per flow")
-	maxSegPayload := flag.Int("max-seg-payload", cfg.MaxSegmentPayload, "max segment payload size (0=unlimited)")
-	workers := flag.Int("workers", cfg.WorkerCount, "worker count for sharded processing")
-	flowTimeout := flag.Duration("flow-timeout", cfg.FlowIdleTimeout, "idle timeout for flow cleanup")
-	gcInterval := flag.Duration("gc-interval", cfg.GCInterval, "flow GC interval")
-	maxFlows := flag.Int("max-flows-per-worker", cfg.MaxFlowsPerWorker, "max tracked flows per worker (0=unlimited)")
-	maxReassembly := flag.Int("max-reassembly-bytes-per-worker", cfg.MaxReassemblyBytesPerWorker, "max total reassembly bytes per worker (0=unlimited)")
-	maxHeldBytes := flag.Int("max-held-bytes-per-worker", cfg.MaxHeldBytesPerWorker, "max total held packet bytes per worker (0=unlimited)")
-	shutdownFailOpenTimeout := flag.Duration("shutdown-fail-open-timeout", cfg.ShutdownFailOpenTimeout, "shutdown fail-open drain timeout per worker (0=use default)")
-	shutdownFailOpenMaxPkts := flag.Int("shutdown-fail-open-max-pkts", cfg.ShutdownFailOpenMaxPackets, "shutdown fail-open max packets per worker (0=use default)")
-	adapterFlushTimeout := flag.Duration("adapter-flush-timeout", cfg.AdapterFlushTimeout, "adapter flush timeout on shutdown (0=use default)")
-	queueNum := flag.Int("queue-num", defaultQueueNum, "NFQUEUE number")
-	queueMaxLen := flag.Int("queue-maxlen", defaultQueueMaxLen, "NFQUEUE maxlen (0=kernel default)")
-	copyRange := flag.Int("copy-range", defaultCopyRange, "NFQUEUE copy range in bytes (0=full packet)")
-	mark := flag.Int("mark", defaultMark, "SO_MARK for reinjected packets")
-	autoRules := flag.Bool("auto-rules", true, "auto install/uninstall NFQUEUE rules (nft or iptables)")
-	autoOffload := flag.Bool("auto-offload", true, "auto disable GRO/GSO/TSO (ethtool)")
-	autoOffloadRestore := flag.Bool("auto-offload-restore", true, "restore GRO/GSO/TSO settings on exit when auto-offload is enabled")
-	autoInstallTools := flag.Bool("auto-install-tools", true, "auto install missing system tools (nft/iptables/ip/ethtool) when auto helpers are enabled")
-	iface := flag.String("iface", "", "egress interface for offload disable (default: auto-detect)")
-	noLoopback := flag.Bool("no-loopback", false, "do not exclude loopback from NFQUEUE rules")
+func run() error {
+	configPath := flag.String("config", "", "config file (default: JSON, first of config or config.json in /etc/gov-pass; drop-ins in /etc/gov-pass/conf.d are applied after it)")
+	dryRun := flag.Bool("dry-run", false, "print every rule, offload, package and adapter step in order without executing it, then exit")
+	cfgFlags := config.RegisterFlags(flag.CommandLine, config.PlatformLinux)
 	flag.Parse()
 
-	mode, err := parseSplitMode(*splitMode)
-	if err != nil {
-		return fmt.Errorf("invalid split-mode: %w", err)
-	}
-	if *splitChunk < 1 {
-		return errors.New("split-chunk must be >= 1")
-	}
-	if *maxBuffer < 1 {
-		return errors.New("max-buffer must be >= 1")
-	}
-	if *maxHeld < 1 {
-		return errors.New("max-held-pkts must be >= 1")
-	}
-	if *maxSegPayload < 0 {
-		return errors.New("max-seg-payload must be >= 0")
-	}
-	if *workers < 1 {
-		return errors.New("workers must be >= 1")
-	}
-	if *collectTimeout < 1*time.Millisecond {
-		return errors.New("collect-timeout must be >= 1ms")
-	}
-	if *flowTimeout < 1*time.Millisecond {
-		return errors.New("flow-timeout must be >= 1ms")
-	}
-	if *gcInterval < 1*time.Millisecond {
-		return errors.New("gc-interval must be >= 1ms")
+	loadOpts := config.LoadOptions{
+		Platform: config.PlatformLinux,
+		Path:     *configPath,
+		Dir:      config.DefaultDir(config.PlatformLinux),
+		Env:      os.Environ(),
+		Flags:    cfgFlags.Values(),
 	}
-	if *maxFlows < 0 {
-		return errors.New("max-flows-per-worker must be >= 0")
-	}
-	if *maxReassembly < 0 {
-		return errors.New("max-reassembly-bytes-per-worker must be >= 0")
-	}
-	if *maxHeldBytes < 0 {
-		return errors.New("max-held-bytes-per-worker must be >= 0")
-	}
-	if *shutdownFailOpenTimeout < 0 {
-		return errors.New("shutdown-fail-open-timeout must be >= 0")
-	}
-	if *shutdownFailOpenMaxPkts < 0 {
-		return errors.New("shutdown-fail-open-max-pkts must be >= 0")
-	}
-	if *adapterFlushTimeout < 0 {
-		return errors.New("adapter-flush-timeout must be >= 0")
-	}
-	if *queueNum < 0 || *queueNum > 65535 {
-		return errors.New("queue-num must be in 0..65535")
-	}
-	if *queueMaxLen < 0 {
-		return errors.New("queue-maxlen must be >= 0")
-	}
-	if *copyRange < 0 {
-		return errors.New("copy-range must be >= 0")
-	}
-	if *mark < 0 {
-		return errors.New("mark must be >= 0")
+	eff, err := config.Load(loadOpts)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
 	}
-	if *autoRules && *mark == 0 {
-		return errors.New("auto-rules requires mark > 0 for reinjection bypass; set --mark or disable --auto-rules")
+	if err := setupLogging(eff.Config.Log); err != nil {
+		return err
 	}
-
-	cfg.SplitMode = mode
-	cfg.SplitChunk = *splitChunk
-	cfg.CollectTimeout = *collectTimeout
-	cfg.MaxBufferBytes = *maxBuffer
-	cfg.MaxHeldPackets = *maxHeld
-	cfg.MaxSegmentPayload = *maxSegPayload
-	cfg.MaxFlowsPerWorker = *maxFlows
-	cfg.MaxReassemblyBytesPerWorker = *maxReassembly
-	cfg.MaxHeldBytesPerWorker = *maxHeldBytes
-	cfg.ShutdownFailOpenTimeout = *shutdownFailOpenTimeout
-	cfg.ShutdownFailOpenMaxPackets = *shutdownFailOpenMaxPkts
-	cfg.AdapterFlushTimeout = *adapterFlushTimeout
-	cfg.WorkerCount = *workers
-	cfg.FlowIdleTimeout = *flowTimeout
-	cfg.GCInterval = *gcInterval
+	logStarted(eff, config.PlatformLinux)
+	defer logStopped()
+	cfg := eff.Config.EngineConfig()
+	nq := eff.Config.NFQueue
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	if *autoRules || *autoOffload {
+	if *dryRun {
+		runner = newDryRunRunner(os.Stdout)
+		defer func() { runner = execRunner{} }()
+	}
+
+	if (nq.AutoRules || nq.AutoOffload) && !*dryRun {
 		if os.Geteuid() != 0 {
 			return errors.New("auto-rules/auto-offload require root; run as root or set --auto-rules=false --auto-offload=false")
 		}
 	}
 
-	if err := ensureLinuxExternalTools(*autoInstallTools, linuxToolNeeds{
-		AutoRules:   *autoRules,
-		AutoOffload: *autoOffload,
-		NeedIP:      *autoOffload && strings.TrimSpace(*iface) == "",
+	if nq.AutoRules && cfg.TrackHandshakes {
+		if err := checkConntrackAcct(os.ReadFile(conntrackAcctPath)); err != nil {
+			return err
+		}
+	}
+
+	var journal *stateJournal
+	if nq.AutoRules || nq.AutoOffload {
+		carried, err := recoverStaleJournal(nq.StateDir, hostnet.SetOffload)
+		if err != nil {
+			return err
+		}
+		journal = newStateJournal(nq.StateDir)
+		if err := journal.carry(carried); err != nil {
+			return fmt.Errorf("state journal write failed: %w", err)
+		}
+		defer func() {
+			if err := journal.remove(); err != nil {
+				slog.Error("state journal remove failed", logging.Event(logging.EventJournalFailed), logging.Err(err))
+			}
+		}()
+	}
+
+	if err := ensureLinuxExternalTools(nq.AutoInstallTools, linuxToolNeeds{
+		AutoRules: nq.AutoRules,
 	}); err != nil {
 		return err
 	}
 
 	var rulesCleanup func() error
-	if *autoRules {
+	var ruleDrifts func() uint64
+	if nq.AutoRules {
 		opts := ruleOptions{
-			QueueNum:        uint16(*queueNum),
-			Mark:            uint32(*mark),
-			ExcludeLoopback: !*noLoopback,
+			QueueNum:        uint16(nq.QueueNum),
+			Mark:            uint32(nq.Mark),
+			ExcludeLoopback: !nq.NoLoopback,
+			NFLogGroup:      uint16(nq.NFLogGroup),
+			Inbound:         cfg.TrackHandshakes,
 		}
-		cleanup, backend, err := installRules(opts)
+		rules, err := selectRuleBackend(opts)
 		if err != nil {
 			return fmt.Errorf("auto rule install failed: %w", err)
 		}
-		rulesCleanup = cleanup
-		log.Printf("auto rules installed via %s", backend)
+		if err := journal.recordRules(rules); err != nil {
+			return fmt.Errorf("state journal write failed: %w", err)
+		}
+		if err := rules.install(); err != nil {
+			_ = rules.uninstall()
+			return fmt.Errorf("auto rule install failed: %w", err)
+		}
+		if err := journal.recordRules(rules); err != nil {
+			slog.Warn("state journal update failed", logging.Event(logging.EventJournalFailed), logging.Err(err))
+		}
+		rulesCleanup = rules.uninstall
+		slog.Info("auto rules installed", logging.Event(logging.EventRulesInstalled), "backend", rules.backend)
 		defer func() {
 			if rulesCleanup == nil {
 				return
 			}
 			if err := rulesCleanup(); err != nil {
-				log.Printf("auto rule uninstall failed: %v", err)
+				slog.Error("auto rule uninstall failed", logging.Event(logging.EventRulesRemoveFailed), "backend", rules.backend, logging.Err(err))
 			}
 		}()
+
+		if nq.RulesCheckInterval > 0 && !*dryRun {
+			watchdog := newRuleWatchdog(rules, nq.RulesCheckInterval)
+			watchdog.reinstall = func() error {
+				if err := rules.reinstall(); err != nil {
+					return err
+				}
+				if err := journal.recordRules(rules); err != nil {
+					slog.Warn("state journal update failed", logging.Event(logging.EventJournalFailed), logging.Err(err))
+				}
+				return nil
+			}
+			ruleDrifts = watchdog.Drifts
+			watchCtx, watchCancel := context.WithCancel(ctx)
+			watchDone := make(chan struct{})
+			go func() {
+				defer close(watchDone)
+				watchdog.run(watchCtx)
+			}()
+			defer func() {
+				watchCancel()
+				<-watchDone
+				if n := watchdog.Drifts(); n > 0 {
+					slog.Info("auto rules were restored after drift", logging.Event(logging.EventRulesReinstalled), "backend", rules.backend, "drifts", n)
+				}
+			}()
+		}
 	}
 
-	if *autoOffload {
-		ifaceName := strings.TrimSpace(*iface)
-		if ifaceName == "" {
-			detected, err := detectEgressInterface()
+	if nq.AutoOffload {
+		detectEgress := func() (string, error) {
+			return hostnet.EgressInterface(hostnet.ProbeDestination)
+		}
+		ifaceName := strings.TrimSpace(nq.Iface)
+		autoDetected := ifaceName == ""
+		if autoDetected {
+			detected, err := detectEgress()
 			if err != nil {
 				if rulesCleanup != nil {
 					_ = rulesCleanup()
 				}
-				return fmt.Errorf("auto offload failed: %w", err)
+				return fmt.Errorf("auto offload failed: %w; use --iface", err)
 			}
 			ifaceName = detected
 		}
 
-		var restore *offloadState
-		if *autoOffloadRestore {
-			st, err := readOffloadState(ifaceName)
-			if err != nil {
-				log.Printf("warning: could not read offload state on %s; restore disabled: %v", ifaceName, err)
-			} else {
-				restore = &st
+		offload := newOffloadManager(nq.AutoOffloadRestore)
+		offload.record = journal.recordOffload
+		offload.adopt(journal.takeCarried())
+		if *dryRun {
+			offload.set = func(iface string, st hostnet.OffloadState) error {
+				dryRunf("ethtool -K %s gro %s gso %s tso %s", iface, onOff(st.GRO), onOff(st.GSO), onOff(st.TSO))
+				return nil
 			}
 		}
+		defer offload.restoreAll()
 
-		if restore != nil {
-			st := *restore
-			defer func() {
-				if err := applyOffloadState(ifaceName, st); err != nil {
-					log.Printf("offload restore failed on %s: %v", ifaceName, err)
-				} else {
-					log.Printf("offload restored on %s (gro=%v gso=%v tso=%v)", ifaceName, st.gro, st.gso, st.tso)
-				}
-			}()
-		}
-
-		if err := disableOffload(ifaceName); err != nil {
+		if err := offload.switchTo(ifaceName); err != nil {
 			if rulesCleanup != nil {
 				_ = rulesCleanup()
 			}
 			return fmt.Errorf("disable offload failed: %w", err)
 		}
-		log.Printf("offload disabled on %s (gro/gso/tso)", ifaceName)
-	}
 
-	if *mark == 0 {
-		log.Printf("warning: mark=0; ensure NFQUEUE bypass rules prevent reinjection loops")
+		if autoDetected && nq.WatchEgress && !*dryRun {
+			watchCtx, watchCancel := context.WithCancel(ctx)
+			watchDone := make(chan struct{})
+			go func() {
+				defer close(watchDone)
+				offload.watch(watchCtx, detectEgress)
+			}()
+			defer func() {
+				watchCancel()
+				<-watchDone
+			}()
+			slog.Info("watching route/link changes for egress interface moves", logging.Event(logging.EventEgressWatch))
+		}
 	}
 
-	opts := adapter.NFQueueOptions{
-		QueueNum:    uint16(*queueNum),
-		QueueMaxLen: uint32(*queueMaxLen),
-		CopyRange:   uint32(*copyRange),
-		Mark:        uint32(*mark),
-	}
-	ad, err := adapter.NewNFQueue(opts)
-	if err != nil {
-		return fmt.Errorf("NFQUEUE open failed: %w", err)
+	ad, err := openLinuxAdapter(nq, cfg, *dryRun)
+	if err != nil || ad == nil {
+		return err
 	}
 	eng := engine.New(cfg, ad)
+	eng.SetTrace(eff.Config.TraceRing())
+	startCapture(eng, eff.Config)
+	defer stopCapture(eng)
+
+	r := newReloader(eng, config.PlatformLinux, loadOpts.Flags, eff, func(flags map[string]string) (*config.Effective, error) {
+		opts := loadOpts
+		opts.Flags = flags
+		return config.Load(opts)
+	})
+	reloadCtx, reloadCancel := context.WithCancel(ctx)
+	defer reloadCancel()
+	go reloadOnSIGHUP(reloadCtx, r)
+	go pauseOnSignals(reloadCtx, eng, r)
+	go dumpTraceOnSignal(reloadCtx, eng, r)
+	startControl(reloadCtx, eff.Config.Control, config.PlatformLinux, eng, r, ruleDrifts)
+	startMetrics(reloadCtx, eff.Config.Metrics, eng, ad, ruleDrifts)
 
 	if err := eng.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
 		return fmt.Errorf("engine stopped: %w", err)
@@ -247,37 +273,149 @@ func run() error {
 	return nil
 }
 
-func parseSplitMode(value string) (engine.SplitMode, error) {
-	switch strings.ToLower(value) {
-	case "immediate":
-		return engine.SplitModeImmediate, nil
-	case "tls-hello":
-		return engine.SplitModeTLSHello, nil
-	default:
-		return engine.SplitModeTLSHello, errors.New("expected tls-hello or immediate")
+// openLinuxAdapter opens the NFLOG adapter when an NFLOG group is set and
+// the NFQUEUE adapter otherwise. A dry run prints the steps and returns a
+// nil adapter.
+func openLinuxAdapter(nq config.NFQueue, cfg engine.Config, dryRun bool) (adapter.Adapter, error) {
+	if nq.NFLogGroup > 0 {
+		opts := adapter.NFLogOptions{
+			Group:     uint16(nq.NFLogGroup),
+			CopyRange: uint32(nq.CopyRange),
+		}
+		if dryRun {
+			dryRunf("open NFLOG group=%d copy-range=%d", opts.Group, opts.CopyRange)
+			dryRunf("run engine in shadow mode: workers=%d split-mode=%s; shutdown steps follow", cfg.WorkerCount, cfg.SplitMode)
+			return nil, nil
+		}
+		ad, err := adapter.NewNFLog(opts)
+		if err != nil {
+			return nil, fmt.Errorf("NFLOG open failed: %w", err)
+		}
+		return ad, nil
+	}
+
+	if nq.Mark == 0 {
+		slog.Warn("mark=0; ensure NFQUEUE bypass rules prevent reinjection loops", logging.Event(logging.EventConfigWarning))
+	}
+
+	opts := adapter.NFQueueOptions{
+		QueueNum:    uint16(nq.QueueNum),
+		QueueMaxLen: uint32(nq.QueueMaxLen),
+		CopyRange:   uint32(nq.CopyRange),
+		Mark:        uint32(nq.Mark),
+	}
+	if dryRun {
+		dryRunf("open NFQUEUE queue=%d maxlen=%d copy-range=%d", opts.QueueNum, opts.QueueMaxLen, opts.CopyRange)
+		dryRunf("open raw socket AF_INET/IPPROTO_RAW with SO_MARK=%d", opts.Mark)
+		dryRunf("run engine: workers=%d split-mode=%s; shutdown steps follow", cfg.WorkerCount, cfg.SplitMode)
+		return nil, nil
 	}
+	ad, err := adapter.NewNFQueue(opts)
+	if err != nil {
+		return nil, fmt.Errorf("NFQUEUE open failed: %w", err)
+	}
+	return ad, nil
 }
 
 type ruleOptions struct {
 	QueueNum        uint16
 	Mark            uint32
 	ExcludeLoopback bool
+	// NFLogGroup replaces the queue rule with one that logs to this group.
+	NFLogGroup uint16
+	// Inbound also sends the first packets servers send back from port 443,
+	// for engine.track_handshakes.
+	Inbound bool
 }
 
-func installRules(opts ruleOptions) (func() error, string, error) {
+// inboundPackets is how many packets of each connection's reply direction
+// the inbound rule sends to the splitter: enough to see the ServerHello,
+// an alert or a reset without queueing the whole download. The limit
+// needs conntrack accounting, which checkConntrackAcct requires before the
+// rule is installed.
+const inboundPackets = 16
+
+// conntrackAcctPath is net.netfilter.nf_conntrack_acct. It only exists once
+// nf_conntrack is loaded, and the module loads with accounting off.
+const conntrackAcctPath = "/proc/sys/net/netfilter/nf_conntrack_acct"
+
+// conntrackAcctOn reports whether the nf_conntrack_acct sysctl, as read from
+// conntrackAcctPath, is on.
+func conntrackAcctOn(data []byte, readErr error) bool {
+	return readErr == nil && strings.TrimSpace(string(data)) == "1"
+}
+
+// checkConntrackAcct refuses engine.track_handshakes when conntrack does not
+// count packets, since the inbound rule's per-connection limit cannot work.
+func checkConntrackAcct(data []byte, readErr error) error {
+	if conntrackAcctOn(data, readErr) {
+		return nil
+	}
+	return fmt.Errorf("engine.track_handshakes needs conntrack accounting to limit the inbound rule to %d packets per connection; run `modprobe nf_conntrack && sysctl -w net.netfilter.nf_conntrack_acct=1` (and persist it in /etc/sysctl.d) or set --track-handshakes=false", inboundPackets)
+}
+
+// ruleSet is an installed set of NFQUEUE or NFLOG rules together with the
+// backend that owns them.
+type ruleSet struct {
+	backend string
+	path    string
+	opts    ruleOptions
+}
+
+// selectRuleBackend picks nft when available and iptables otherwise. Nothing
+// is installed until install is called.
+func selectRuleBackend(opts ruleOptions) (*ruleSet, error) {
 	if path, ok := lookPath("nft"); ok {
-		if err := installNftRules(path, opts); err != nil {
-			return nil, "", err
-		}
-		return func() error { return uninstallNftRules(path) }, "nft", nil
+		return &ruleSet{backend: "nft", path: path, opts: opts}, nil
 	}
 	if path, ok := lookPath("iptables"); ok {
-		if err := installIptablesRules(path, opts); err != nil {
-			return nil, "", err
+		return &ruleSet{backend: "iptables", path: path, opts: opts}, nil
+	}
+	if isDryRun() {
+		// The tools would have been installed by ensureLinuxExternalTools.
+		return &ruleSet{backend: "nft", path: "nft", opts: opts}, nil
+	}
+	return nil, errors.New("nft or iptables not found in PATH")
+}
+
+func (r *ruleSet) install() error {
+	if r.backend == "nft" {
+		return installNftRules(r.path, r.opts)
+	}
+	return installIptablesRules(r.path, r.opts)
+}
+
+func (r *ruleSet) uninstall() error {
+	if r.backend == "nft" {
+		// Handles are only known once the rules exist, so a dry run cannot
+		// print the individual delete commands.
+		if dryRunf("%s -a list chain inet gov_pass output, then delete each rule tagged gov-pass by handle", r.path) {
+			if r.opts.Inbound {
+				dryRunf("%s -a list chain inet gov_pass input, then delete each rule tagged gov-pass by handle", r.path)
+			}
+			return nil
 		}
-		return func() error { return uninstallIptablesRules(path, opts) }, "iptables", nil
+		if err := uninstallNftRules(r.path); err != nil {
+			return err
+		}
+		if r.opts.Inbound {
+			return uninstallNftInboundRules(r.path)
+		}
+		return nil
 	}
-	return nil, "", errors.New("nft or iptables not found in PATH")
+	// The uninstall loop repeats -D until it fails, which never happens in a
+	// dry run.
+	if dryRunf("%s -t mangle -D OUTPUT -j GOVPASS_OUTPUT (repeated until absent)", r.path) {
+		dryRunf("%s -t mangle -F GOVPASS_OUTPUT", r.path)
+		dryRunf("%s -t mangle -X GOVPASS_OUTPUT", r.path)
+		if r.opts.Inbound {
+			dryRunf("%s -t mangle -D INPUT -j GOVPASS_INPUT (repeated until absent)", r.path)
+			dryRunf("%s -t mangle -F GOVPASS_INPUT", r.path)
+			dryRunf("%s -t mangle -X GOVPASS_INPUT", r.path)
+		}
+		return nil
+	}
+	return uninstallIptablesRules(r.path, r.opts)
 }
 
 func installNftRules(path string, opts ruleOptions) error {
@@ -322,14 +460,68 @@ func installNftRules(path string, opts ruleOptions) error {
 		}
 	}
 
-	queue := fmt.Sprintf("%d", opts.QueueNum)
-	// Restrict the queue rule to IPv4 only. The splitter currently only supports
-	// AF_INET and will fail-open non-IPv4 packets.
-	args := []string{"add", "rule", "inet", table, chain, "meta", "nfproto", "ipv4", "tcp", "dport", "443", "queue", "num", queue, "bypass", "comment", tag}
-	if _, err := runCommand(path, args...); err != nil {
-		return fmt.Errorf("nft add queue rule failed: %w", err)
+	if opts.NFLogGroup != 0 {
+		group := fmt.Sprintf("%d", opts.NFLogGroup)
+		args := []string{"add", "rule", "inet", table, chain, "meta", "nfproto", "ipv4", "tcp", "dport", "443", "log", "group", group, "comment", tag}
+		if _, err := runCommand(path, args...); err != nil {
+			return fmt.Errorf("nft add log rule failed: %w", err)
+		}
+	} else {
+		queue := fmt.Sprintf("%d", opts.QueueNum)
+		// Restrict the queue rule to IPv4 only. The splitter currently only supports
+		// AF_INET and will fail-open non-IPv4 packets.
+		args := []string{"add", "rule", "inet", table, chain, "meta", "nfproto", "ipv4", "tcp", "dport", "443", "queue", "num", queue, "bypass", "comment", tag}
+		if _, err := runCommand(path, args...); err != nil {
+			return fmt.Errorf("nft add queue rule failed: %w", err)
+		}
+	}
+
+	if opts.Inbound {
+		return installNftInboundRules(path, opts)
+	}
+	return nil
+}
+
+// installNftInboundRules adds the input chain that sends the start of each
+// server's reply to the same queue or log group.
+func installNftInboundRules(path string, opts ruleOptions) error {
+	const (
+		table = "gov_pass"
+		chain = "input"
+		tag   = "gov-pass"
+	)
+
+	if _, err := runCommand(path, "list", "chain", "inet", table, chain); err != nil {
+		args := []string{
+			"add", "chain", "inet", table, chain,
+			"{", "type", "filter", "hook", "input", "priority", "mangle", ";", "policy", "accept", ";", "}",
+		}
+		if _, err := runCommand(path, args...); err != nil {
+			return fmt.Errorf("nft add input chain failed: %w", err)
+		}
+	}
+
+	if err := deleteTaggedNftRules(path, table, chain, tag); err != nil {
+		return fmt.Errorf("nft delete old input rules failed: %w", err)
+	}
+
+	if opts.ExcludeLoopback {
+		args := []string{"add", "rule", "inet", table, chain, "iifname", "lo", "return", "comment", tag}
+		if _, err := runCommand(path, args...); err != nil {
+			return fmt.Errorf("nft add inbound loopback bypass failed: %w", err)
+		}
 	}
 
+	args := []string{"add", "rule", "inet", table, chain, "meta", "nfproto", "ipv4", "tcp", "sport", "443", "ct", "reply", "packets", "<", strconv.Itoa(inboundPackets)}
+	if opts.NFLogGroup != 0 {
+		args = append(args, "log", "group", fmt.Sprintf("%d", opts.NFLogGroup))
+	} else {
+		args = append(args, "queue", "num", fmt.Sprintf("%d", opts.QueueNum), "bypass")
+	}
+	args = append(args, "comment", tag)
+	if _, err := runCommand(path, args...); err != nil {
+		return fmt.Errorf("nft add inbound rule failed: %w", err)
+	}
 	return nil
 }
 
@@ -348,6 +540,16 @@ func uninstallNftRules(path string) error {
 	return nil
 }
 
+func uninstallNftInboundRules(path string) error {
+	if _, err := runCommand(path, "list", "table", "inet", "gov_pass"); err != nil {
+		return nil
+	}
+	if err := deleteTaggedNftRules(path, "gov_pass", "input", "gov-pass"); err != nil {
+		return fmt.Errorf("nft delete input rules failed: %w", err)
+	}
+	return nil
+}
+
 func deleteTaggedNftRules(path string, table string, chain string, tag string) error {
 	out, err := runCommand(path, "-a", "list", "chain", "inet", table, chain)
 	if err != nil {
@@ -430,11 +632,61 @@ func installIptablesRules(path string, opts ruleOptions) error {
 		}
 	}
 
-	queue := fmt.Sprintf("%d", opts.QueueNum)
-	if _, err := runCommand(path, "-t", table, "-A", chain, "-p", "tcp", "--dport", "443", "-j", "NFQUEUE", "--queue-num", queue, "--queue-bypass"); err != nil {
-		return fmt.Errorf("iptables queue rule failed: %w", err)
+	if opts.NFLogGroup != 0 {
+		group := fmt.Sprintf("%d", opts.NFLogGroup)
+		if _, err := runCommand(path, "-t", table, "-A", chain, "-p", "tcp", "--dport", "443", "-j", "NFLOG", "--nflog-group", group); err != nil {
+			return fmt.Errorf("iptables log rule failed: %w", err)
+		}
+	} else {
+		queue := fmt.Sprintf("%d", opts.QueueNum)
+		if _, err := runCommand(path, "-t", table, "-A", chain, "-p", "tcp", "--dport", "443", "-j", "NFQUEUE", "--queue-num", queue, "--queue-bypass"); err != nil {
+			return fmt.Errorf("iptables queue rule failed: %w", err)
+		}
 	}
 
+	if opts.Inbound {
+		return installIptablesInboundRules(path, opts)
+	}
+	return nil
+}
+
+// installIptablesInboundRules mirrors installNftInboundRules with a
+// GOVPASS_INPUT chain jumped to from INPUT.
+func installIptablesInboundRules(path string, opts ruleOptions) error {
+	const (
+		table  = "mangle"
+		parent = "INPUT"
+		chain  = "GOVPASS_INPUT"
+	)
+
+	if err := ensureIptablesChain(path, table, chain); err != nil {
+		return fmt.Errorf("iptables create input chain failed: %w", err)
+	}
+	if _, err := runCommand(path, "-t", table, "-F", chain); err != nil {
+		return fmt.Errorf("iptables flush input chain failed: %w", err)
+	}
+	checkJump := []string{"-t", table, "-C", parent, "-j", chain}
+	addJump := []string{"-t", table, "-I", parent, "1", "-j", chain}
+	if err := ensureIptablesRule(path, checkJump, addJump); err != nil {
+		return fmt.Errorf("iptables install input jump failed: %w", err)
+	}
+
+	if opts.ExcludeLoopback {
+		if _, err := runCommand(path, "-t", table, "-A", chain, "-i", "lo", "-j", "RETURN"); err != nil {
+			return fmt.Errorf("iptables inbound loopback bypass failed: %w", err)
+		}
+	}
+
+	args := []string{"-t", table, "-A", chain, "-p", "tcp", "--sport", "443",
+		"-m", "connbytes", "--connbytes", fmt.Sprintf("0:%d", inboundPackets-1), "--connbytes-dir", "reply", "--connbytes-mode", "packets"}
+	if opts.NFLogGroup != 0 {
+		args = append(args, "-j", "NFLOG", "--nflog-group", fmt.Sprintf("%d", opts.NFLogGroup))
+	} else {
+		args = append(args, "-j", "NFQUEUE", "--queue-num", fmt.Sprintf("%d", opts.QueueNum), "--queue-bypass")
+	}
+	if _, err := runCommand(path, args...); err != nil {
+		return fmt.Errorf("iptables inbound rule failed: %w", err)
+	}
 	return nil
 }
 
@@ -444,7 +696,6 @@ func uninstallIptablesRules(path string, opts ruleOptions) error {
 		parent = "OUTPUT"
 		chain  = "GOVPASS_OUTPUT"
 	)
-	_ = opts
 
 	// Remove jump(s) from OUTPUT (best-effort).
 	for {
@@ -459,6 +710,16 @@ func uninstallIptablesRules(path string, opts ruleOptions) error {
 	if _, err := runCommand(path, "-t", table, "-X", chain); err != nil {
 		// ignore
 	}
+	if !opts.Inbound {
+		return nil
+	}
+	for {
+		if _, err := runCommand(path, "-t", table, "-D", "INPUT", "-j", "GOVPASS_INPUT"); err != nil {
+			break
+		}
+	}
+	_, _ = runCommand(path, "-t", table, "-F", "GOVPASS_INPUT")
+	_, _ = runCommand(path, "-t", table, "-X", "GOVPASS_INPUT")
 	return nil
 }
 
@@ -483,171 +744,6 @@ func ensureIptablesChain(path string, table string, chain string) error {
 	return nil
 }
 
-type offloadState struct {
-	gro bool
-	gso bool
-	tso bool
-}
-
-func readOffloadState(iface string) (offloadState, error) {
-	iface = strings.TrimSpace(iface)
-	if iface == "" {
-		return offloadState{}, errors.New("iface is empty")
-	}
-	path, ok := lookPath("ethtool")
-	if !ok {
-		return offloadState{}, errors.New("ethtool not found in PATH")
-	}
-	out, err := runCommand(path, "-k", iface)
-	if err != nil {
-		return offloadState{}, err
-	}
-
-	var st offloadState
-	foundGro := false
-	foundGso := false
-	foundTso := false
-
-	scanner := bufio.NewScanner(strings.NewReader(out))
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		switch {
-		case strings.HasPrefix(line, "generic-receive-offload:"):
-			if v, ok := parseEthtoolOnOff(line); ok {
-				st.gro = v
-				foundGro = true
-			}
-		case strings.HasPrefix(line, "generic-segmentation-offload:"):
-			if v, ok := parseEthtoolOnOff(line); ok {
-				st.gso = v
-				foundGso = true
-			}
-		case strings.HasPrefix(line, "tcp-segmentation-offload:"):
-			if v, ok := parseEthtoolOnOff(line); ok {
-				st.tso = v
-				foundTso = true
-			}
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return offloadState{}, err
-	}
-	if !foundGro || !foundGso || !foundTso {
-		return offloadState{}, errors.New("could not parse ethtool offload state")
-	}
-	return st, nil
-}
-
-func parseEthtoolOnOff(line string) (bool, bool) {
-	idx := strings.Index(line, ":")
-	if idx < 0 {
-		return false, false
-	}
-	rest := strings.TrimSpace(line[idx+1:])
-	fields := strings.Fields(rest)
-	if len(fields) == 0 {
-		return false, false
-	}
-	switch strings.ToLower(fields[0]) {
-	case "on":
-		return true, true
-	case "off":
-		return false, true
-	default:
-		return false, false
-	}
-}
-
-func applyOffloadState(iface string, st offloadState) error {
-	iface = strings.TrimSpace(iface)
-	if iface == "" {
-		return errors.New("iface is empty")
-	}
-	path, ok := lookPath("ethtool")
-	if !ok {
-		return errors.New("ethtool not found in PATH")
-	}
-
-	gro := "off"
-	if st.gro {
-		gro = "on"
-	}
-	gso := "off"
-	if st.gso {
-		gso = "on"
-	}
-	tso := "off"
-	if st.tso {
-		tso = "on"
-	}
-
-	if _, err := runCommand(path, "-K", iface, "gro", gro, "gso", gso, "tso", tso); err != nil {
-		return err
-	}
-	return nil
-}
-
-func disableOffload(iface string) error {
-	iface = strings.TrimSpace(iface)
-	if iface == "" {
-		return errors.New("iface is empty")
-	}
-	path, ok := lookPath("ethtool")
-	if !ok {
-		return errors.New("ethtool not found in PATH")
-	}
-	if _, err := runCommand(path, "-K", iface, "gro", "off", "gso", "off", "tso", "off"); err != nil {
-		return err
-	}
-	return nil
-}
-
-func detectEgressInterface() (string, error) {
-	path, ok := lookPath("ip")
-	if !ok {
-		return "", errors.New("ip command not found in PATH; use --iface")
-	}
-
-	out, err := runCommand(path, "-4", "route", "get", "1.1.1.1")
-	if err == nil {
-		if iface := parseRouteDev(out); iface != "" {
-			return iface, nil
-		}
-	}
-
-	out, err = runCommand(path, "-4", "route", "show", "default")
-	if err != nil {
-		return "", fmt.Errorf("ip route lookup failed: %w", err)
-	}
-	if iface := parseRouteDev(out); iface != "" {
-		return iface, nil
-	}
-	return "", errors.New("could not detect egress interface; use --iface")
-}
-
-func parseRouteDev(output string) string {
-	fields := strings.Fields(output)
-	for i := 0; i+1 < len(fields); i++ {
-		if fields[i] == "dev" {
-			return fields[i+1]
-		}
-	}
-	return ""
-}
-
-func runCommand(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		trimmed := strings.TrimSpace(string(out))
-		if trimmed != "" {
-			return string(out), fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, trimmed)
-		}
-		return string(out), fmt.Errorf("%s %s failed: %w", name, strings.Join(args, " "), err)
-	}
-	return string(out), nil
-}
-
 func lookPath(name string) (string, bool) {
 	path, err := exec.LookPath(name)
 	if err == nil {
@@ -663,93 +759,46 @@ func lookPath(name string) (string, bool) {
 }
 
 type linuxToolNeeds struct {
-	AutoRules   bool
-	AutoOffload bool
-	NeedIP      bool
+	AutoRules bool
 }
 
 func ensureLinuxExternalTools(autoInstall bool, needs linuxToolNeeds) error {
-	if !needs.AutoRules && !needs.AutoOffload {
+	if !needs.AutoRules {
 		return nil
 	}
-
-	missing := make([]string, 0, 4)
-	wantPkgs := make(map[string]struct{})
-
-	if needs.AutoRules {
-		if _, ok := lookPath("nft"); !ok {
-			if _, ok2 := lookPath("iptables"); !ok2 {
-				missing = append(missing, "nft or iptables")
-				// Install both to maximize compatibility across distros/setups.
-				wantPkgs["nftables"] = struct{}{}
-				wantPkgs["iptables"] = struct{}{}
-			}
-		}
-	}
-
-	if needs.AutoOffload {
-		if _, ok := lookPath("ethtool"); !ok {
-			missing = append(missing, "ethtool")
-			wantPkgs["ethtool"] = struct{}{}
-		}
-		if needs.NeedIP {
-			if _, ok := lookPath("ip"); !ok {
-				missing = append(missing, "ip")
-				// Package name differs by distro; map per package manager.
-				wantPkgs["__iproute__"] = struct{}{}
-			}
-		}
+	if _, ok := lookPath("nft"); ok {
+		return nil
 	}
-
-	if len(missing) == 0 {
+	if _, ok := lookPath("iptables"); ok {
 		return nil
 	}
 
 	if !autoInstall {
-		return fmt.Errorf("missing required external tools: %s (install them or set --auto-install-tools=true)", strings.Join(missing, ", "))
+		return errors.New("missing required external tools: nft or iptables (install them or set --auto-install-tools=true)")
 	}
 
 	mgrKind, mgrPath, ok := detectLinuxPackageManager()
 	if !ok {
-		return fmt.Errorf("missing required external tools: %s (no supported package manager found; install tools manually)", strings.Join(missing, ", "))
+		return errors.New("missing required external tools: nft or iptables (no supported package manager found; install tools manually)")
 	}
 
-	pkgs := make([]string, 0, len(wantPkgs))
-	for p := range wantPkgs {
-		if p == "__iproute__" {
-			pkgs = append(pkgs, iproutePackageName(mgrKind))
-			continue
-		}
-		pkgs = append(pkgs, p)
-	}
-	if len(pkgs) == 0 {
-		return fmt.Errorf("missing required external tools: %s", strings.Join(missing, ", "))
-	}
-
-	log.Printf("installing missing tools via %s: %s", mgrKind, strings.Join(pkgs, " "))
+	// Install both to maximize compatibility across distros/setups.
+	pkgs := []string{"nftables", "iptables"}
+	slog.Info("installing missing tools", logging.Event(logging.EventToolsInstall), "manager", mgrKind, "packages", strings.Join(pkgs, " "))
 	if err := installLinuxPackages(mgrKind, mgrPath, pkgs); err != nil {
 		return fmt.Errorf("auto-install-tools failed: %w", err)
 	}
 
-	// Re-check after install.
-	if needs.AutoRules {
-		if _, ok := lookPath("nft"); !ok {
-			if _, ok2 := lookPath("iptables"); !ok2 {
-				return errors.New("auto-install-tools completed, but nft/iptables still missing")
-			}
-		}
+	// Re-check after install. A dry run installed nothing, so assume it would
+	// have worked.
+	if isDryRun() {
+		return nil
 	}
-	if needs.AutoOffload {
-		if _, ok := lookPath("ethtool"); !ok {
-			return errors.New("auto-install-tools completed, but ethtool still missing")
-		}
-		if needs.NeedIP {
-			if _, ok := lookPath("ip"); !ok {
-				return errors.New("auto-install-tools completed, but ip still missing")
-			}
+	if _, ok := lookPath("nft"); !ok {
+		if _, ok2 := lookPath("iptables"); !ok2 {
+			return errors.New("auto-install-tools completed, but nft/iptables still missing")
 		}
 	}
-
 	return nil
 }
 
@@ -762,15 +811,6 @@ func detectLinuxPackageManager() (kind string, path string, ok bool) {
 	return "", "", false
 }
 
-func iproutePackageName(mgrKind string) string {
-	switch mgrKind {
-	case "dnf", "yum":
-		return "iproute"
-	default:
-		return "iproute2"
-	}
-}
-
 func installLinuxPackages(mgrKind string, mgrPath string, pkgs []string) error {
 	switch mgrKind {
 	case "apt-get":
@@ -806,18 +846,9 @@ func installLinuxPackages(mgrKind string, mgrPath string, pkgs []string) error {
 	}
 }
 
-func runCommandEnv(env []string, name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	if len(env) > 0 {
-		cmd.Env = append(os.Environ(), env...)
-	}
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		trimmed := strings.TrimSpace(string(out))
-		if trimmed != "" {
-			return string(out), fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, trimmed)
-		}
-		return string(out), fmt.Errorf("%s %s failed: %w", name, strings.Join(args, " "), err)
+func onOff(v bool) string {
+	if v {
+		return "on"
 	}
-	return string(out), nil
+	return "off"
 }
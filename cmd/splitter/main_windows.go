@@ -7,120 +7,67 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strings"
+	"strconv"
 	"syscall"
 
 	"fk-gov/internal/adapter"
+	"fk-gov/internal/config"
+	"fk-gov/internal/control"
 	"fk-gov/internal/driver"
 	"fk-gov/internal/engine"
+	"fk-gov/internal/logging"
 )
 
 const (
-	defaultQueueLen             uint64 = 4096
-	defaultQueueTimeMs          uint64 = 2000
-	defaultQueueSize            uint64 = 32 * 1024 * 1024
-	defaultWinDivertServiceName        = "WinDivert"
-	defaultAppServiceName              = "gov-pass"
-	defaultWinDivertFilter             = "outbound and ip and tcp.DstPort == 443"
+	defaultWinDivertServiceName = "WinDivert"
+	defaultAppServiceName       = "gov-pass"
 )
 
 func main() {
-	if err := run(); err != nil {
-		log.Fatal(err)
+	var err error
+	switch subcommand() {
+	case "bench":
+		err = runBench(os.Args[2:], os.Stdout)
+	case "config":
+		err = runConfig(os.Args[2:], os.Stdout)
+	case "ctl":
+		err = runCtl(os.Args[2:], os.Stdout)
+	case "replay":
+		err = runReplay(os.Args[2:], os.Stdout)
+	default:
+		err = run()
 	}
+	exitOnError(err)
 }
 
 func run() error {
-	defaultCfg, _ := windowsDefaults()
-
-	splitMode := flag.String("split-mode", "tls-hello", "split trigger: tls-hello or immediate")
-	splitChunk := flag.Int("split-chunk", defaultCfg.SplitChunk, "first split size in bytes")
-	collectTimeout := flag.Duration("collect-timeout", defaultCfg.CollectTimeout, "reassembly collect timeout")
-	maxBuffer := flag.Int("max-buffer", defaultCfg.MaxBufferBytes, "max reassembly buffer size in bytes")
-	maxHeld := flag.Int("max-held-pkts", defaultCfg.MaxHeldPackets, "max held packets per flow")
-	maxSegPayload := flag.Int("max-seg-payload", defaultCfg.MaxSegmentPayload, "max segment payload size (0=unlimited)")
-	workers := flag.Int("workers", defaultCfg.WorkerCount, "worker count for sharded processing")
-	flowTimeout := flag.Duration("flow-timeout", defaultCfg.FlowIdleTimeout, "idle timeout for flow cleanup")
-	gcInterval := flag.Duration("gc-interval", defaultCfg.GCInterval, "flow GC interval")
-	maxFlows := flag.Int("max-flows-per-worker", defaultCfg.MaxFlowsPerWorker, "max tracked flows per worker (0=unlimited)")
-	maxReassembly := flag.Int("max-reassembly-bytes-per-worker", defaultCfg.MaxReassemblyBytesPerWorker, "max total reassembly bytes per worker (0=unlimited)")
-	maxHeldBytes := flag.Int("max-held-bytes-per-worker", defaultCfg.MaxHeldBytesPerWorker, "max total held packet bytes per worker (0=unlimited)")
-	shutdownFailOpenTimeout := flag.Duration("shutdown-fail-open-timeout", defaultCfg.ShutdownFailOpenTimeout, "shutdown fail-open drain timeout per worker (0=use default)")
-	shutdownFailOpenMaxPkts := flag.Int("shutdown-fail-open-max-pkts", defaultCfg.ShutdownFailOpenMaxPackets, "shutdown fail-open max packets per worker (0=use default)")
-	adapterFlushTimeout := flag.Duration("adapter-flush-timeout", defaultCfg.AdapterFlushTimeout, "adapter flush timeout on shutdown (0=use default)")
-	filter := flag.String("filter", defaultWinDivertFilter, "WinDivert filter")
-	queueLen := flag.Uint("queue-len", uint(defaultQueueLen), "WinDivert queue length (0=driver default)")
-	queueTime := flag.Uint("queue-time", uint(defaultQueueTimeMs), "WinDivert queue time in ms (0=driver default)")
-	queueSize := flag.Uint("queue-size", uint(defaultQueueSize), "WinDivert queue size in bytes (0=driver default)")
-	driverDir := flag.String("windivert-dir", "", "directory containing WinDivert.dll/.sys/.cat (default: exe dir)")
-	driverSys := flag.String("windivert-sys", "", "driver sys filename (default: WinDivert64.sys or WinDivert.sys)")
-	autoInstall := flag.Bool("auto-install", true, "auto install/start WinDivert driver")
-	autoUninstall := flag.Bool("auto-uninstall", true, "auto uninstall if installed by this run")
-	autoDownload := flag.Bool("auto-download-windivert", true, "auto download pinned WinDivert zip if required files are missing")
-	configPath := flag.String("config", "", "path to config json (default in service: %ProgramData%\\gov-pass\\config.json)")
+	cfgFlags := config.RegisterFlags(flag.CommandLine, config.PlatformWindows)
+	configPath := flag.String("config", "", "path to JSON config file (default in service: %ProgramData%\\gov-pass\\config.json)")
 	asService := flag.Bool("service", false, "run as Windows service (SCM)")
 	serviceName := flag.String("service-name", defaultAppServiceName, "Windows service name (used with --service)")
 	serviceLog := flag.String("service-log", "", "log file path for --service (default: %ProgramData%\\gov-pass\\splitter.log)")
 	flag.Parse()
 
-	setFlags := make(map[string]bool)
-	flag.CommandLine.Visit(func(f *flag.Flag) {
-		setFlags[f.Name] = true
-	})
-
 	if !*asService && isWindowsServiceProcess() {
 		*asService = true
 	}
 
-	args := windowsCLIArgs{
-		SplitMode:      *splitMode,
-		SplitChunk:     *splitChunk,
-		CollectTimeout: *collectTimeout,
-		MaxBufferBytes: *maxBuffer,
-		MaxHeldPackets: *maxHeld,
-		MaxSegPayload:  *maxSegPayload,
-		Workers:        *workers,
-		FlowTimeout:    *flowTimeout,
-		GCInterval:     *gcInterval,
-		MaxFlows:       *maxFlows,
-		MaxReassembly:  *maxReassembly,
-		MaxHeldBytes:   *maxHeldBytes,
-		ShutdownFailOpenTimeout:    *shutdownFailOpenTimeout,
-		ShutdownFailOpenMaxPackets: *shutdownFailOpenMaxPkts,
-		AdapterFlushTimeout:        *adapterFlushTimeout,
-
-		Filter:    *filter,
-		QueueLen:  uint64(*queueLen),
-		QueueTime: uint64(*queueTime),
-		QueueSize: uint64(*queueSize),
-
-		WinDivertDir: strings.TrimSpace(*driverDir),
-		WinDivertSys: strings.TrimSpace(*driverSys),
-
-		AutoInstall:   *autoInstall,
-		AutoUninstall: *autoUninstall,
-		AutoDownload:  *autoDownload,
-		ConfigPath:    strings.TrimSpace(*configPath),
-	}
+	flags := cfgFlags.Values()
 
 	if *asService {
 		return runService(*serviceName, *serviceLog, func(ctx context.Context, reload <-chan struct{}) error {
-			return runWindowsService(ctx, args, setFlags, reload)
+			return runWindowsService(ctx, *configPath, flags, reload)
 		})
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	cfg, wc, err := effectiveWindowsConfig(args, setFlags, false)
-	if err != nil {
-		return err
-	}
-	return runWindows(ctx, cfg, wc)
+	return runWindows(ctx, *configPath, flags)
 }
 
 type windowsRunConfig struct {
@@ -136,13 +83,22 @@ type windowsRunConfig struct {
 	AutoDownloadFiles   bool
 }
 
-func runWindows(ctx context.Context, cfg engine.Config, wc windowsRunConfig) error {
+func runWindows(ctx context.Context, configPath string, flags map[string]string) error {
+	eff, wc, err := effectiveWindowsConfig(configPath, flags, false)
+	if err != nil {
+		return err
+	}
+	if err := setupLogging(eff.Config.Log); err != nil {
+		return err
+	}
+	logStarted(eff, config.PlatformWindows)
+	defer logStopped()
+
 	exeDir := ""
 	if exe, err := os.Executable(); err == nil {
 		exeDir = filepath.Dir(exe)
 	}
 
-	var err error
 	var driverDir string
 	wc, driverDir, err = ensureWinDivertFiles(ctx, wc, exeDir)
 	if err != nil {
@@ -169,7 +125,7 @@ func runWindows(ctx context.Context, cfg engine.Config, wc windowsRunConfig) err
 	if cleanup != nil {
 		defer func() {
 			if err := cleanup(); err != nil {
-				log.Printf("driver cleanup failed: %v", err)
+				slog.Error("driver cleanup failed", logging.Event(logging.EventDriverFailed), logging.Err(err))
 			}
 		}()
 	}
@@ -178,7 +134,11 @@ func runWindows(ctx context.Context, cfg engine.Config, wc windowsRunConfig) err
 	if err != nil {
 		return fmt.Errorf("WinDivert open failed: %w", err)
 	}
-	eng := engine.New(cfg, ad)
+	eng := engine.New(eff.Config.EngineConfig(), ad)
+	eng.SetTrace(eff.Config.TraceRing())
+	startCapture(eng, eff.Config)
+	defer stopCapture(eng)
+	newWindowsReloader(ctx, eng, ad, eff, configPath, flags, false)
 
 	if err := eng.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
 		return fmt.Errorf("engine stopped: %w", err)
@@ -186,11 +146,16 @@ func runWindows(ctx context.Context, cfg engine.Config, wc windowsRunConfig) err
 	return nil
 }
 
-func runWindowsService(ctx context.Context, args windowsCLIArgs, setFlags map[string]bool, reload <-chan struct{}) error {
-	cfg, wc, err := effectiveWindowsConfig(args, setFlags, true)
+func runWindowsService(ctx context.Context, configPath string, flags map[string]string, reload <-chan struct{}) error {
+	eff, wc, err := effectiveWindowsConfig(configPath, flags, true)
 	if err != nil {
 		return err
 	}
+	if err := setupLogging(eff.Config.Log); err != nil {
+		return err
+	}
+	logStarted(eff, config.PlatformWindows)
+	defer logStopped()
 
 	exeDir := ""
 	if exe, err := os.Executable(); err == nil {
@@ -224,7 +189,7 @@ func runWindowsService(ctx context.Context, args windowsCLIArgs, setFlags map[st
 	if cleanup != nil {
 		defer func() {
 			if err := cleanup(); err != nil {
-				log.Printf("driver cleanup failed: %v", err)
+				slog.Error("driver cleanup failed", logging.Event(logging.EventDriverFailed), logging.Err(err))
 			}
 		}()
 	}
@@ -233,81 +198,21 @@ func runWindowsService(ctx context.Context, args windowsCLIArgs, setFlags map[st
 	if err != nil {
 		return fmt.Errorf("WinDivert open failed: %w", err)
 	}
-	eng := engine.New(cfg, ad)
+	eng := engine.New(eff.Config.EngineConfig(), ad)
+	eng.SetTrace(eff.Config.TraceRing())
+	startCapture(eng, eff.Config)
+	defer stopCapture(eng)
+	r := newWindowsReloader(ctx, eng, ad, eff, configPath, flags, true)
 
 	errCh := make(chan error, 1)
 	go func() {
 		errCh <- eng.Run(ctx)
 	}()
 
-	curCfg := cfg
-	curWc := wc
-	log.Printf("engine started (workers=%d)", curCfg.WorkerCount)
-
 	for {
 		select {
 		case <-reload:
-			newCfg, newWc, err := effectiveWindowsConfig(args, setFlags, true)
-			if err != nil {
-				log.Printf("reload failed: %v", err)
-				continue
-			}
-
-			if newWc.Filter != curWc.Filter {
-				log.Printf("reload: windivert.filter changed; requires service restart to apply")
-			}
-			if strings.TrimSpace(newWc.WinDivertDir) != strings.TrimSpace(curWc.WinDivertDir) ||
-				strings.TrimSpace(newWc.WinDivertSys) != strings.TrimSpace(curWc.WinDivertSys) {
-				log.Printf("reload: windivert_dir/sys changed; requires service restart to apply")
-			}
-
-			// Best-effort update of queue parameters in-place. "0" means "use driver
-			// default", which cannot be applied without re-opening the handle.
-			if newWc.AdapterOpts.QueueLen != curWc.AdapterOpts.QueueLen {
-				if newWc.AdapterOpts.QueueLen == 0 {
-					log.Printf("reload: queue_len=0 requires service restart to apply (revert to driver default)")
-				} else if err := ad.UpdateOptions(adapter.WinDivertOptions{QueueLen: newWc.AdapterOpts.QueueLen}); err != nil {
-					log.Printf("reload: update queue_len failed: %v", err)
-				} else {
-					curWc.AdapterOpts.QueueLen = newWc.AdapterOpts.QueueLen
-				}
-			}
-			if newWc.AdapterOpts.QueueTime != curWc.AdapterOpts.QueueTime {
-				if newWc.AdapterOpts.QueueTime == 0 {
-					log.Printf("reload: queue_time_ms=0 requires service restart to apply (revert to driver default)")
-				} else if err := ad.UpdateOptions(adapter.WinDivertOptions{QueueTime: newWc.AdapterOpts.QueueTime}); err != nil {
-					log.Printf("reload: update queue_time_ms failed: %v", err)
-				} else {
-					curWc.AdapterOpts.QueueTime = newWc.AdapterOpts.QueueTime
-				}
-			}
-			if newWc.AdapterOpts.QueueSize != curWc.AdapterOpts.QueueSize {
-				if newWc.AdapterOpts.QueueSize == 0 {
-					log.Printf("reload: queue_size_bytes=0 requires service restart to apply (revert to driver default)")
-				} else if err := ad.UpdateOptions(adapter.WinDivertOptions{QueueSize: newWc.AdapterOpts.QueueSize}); err != nil {
-					log.Printf("reload: update queue_size_bytes failed: %v", err)
-				} else {
-					curWc.AdapterOpts.QueueSize = newWc.AdapterOpts.QueueSize
-				}
-			}
-
-			applyCfg := newCfg
-			if applyCfg.WorkerCount != curCfg.WorkerCount {
-				log.Printf("reload: workers changed; requires service restart to apply (%d -> %d)", curCfg.WorkerCount, applyCfg.WorkerCount)
-				applyCfg.WorkerCount = curCfg.WorkerCount
-			}
-			if applyCfg.WorkerQueueSize != curCfg.WorkerQueueSize {
-				log.Printf("reload: worker_queue_size changed; requires service restart to apply (%d -> %d)", curCfg.WorkerQueueSize, applyCfg.WorkerQueueSize)
-				applyCfg.WorkerQueueSize = curCfg.WorkerQueueSize
-			}
-
-			if err := eng.Reload(applyCfg); err != nil {
-				log.Printf("reload: engine config apply failed: %v", err)
-				continue
-			}
-			curCfg = applyCfg
-			log.Printf("reload: engine config applied (split_mode=%v split_chunk=%d collect_timeout=%s)", curCfg.SplitMode, curCfg.SplitChunk, curCfg.CollectTimeout)
-
+			_, _ = r.Apply(nil)
 		case err := <-errCh:
 			if err != nil && !errors.Is(err, context.Canceled) {
 				return fmt.Errorf("engine stopped: %w", err)
@@ -323,13 +228,48 @@ func runWindowsService(ctx context.Context, args windowsCLIArgs, setFlags map[st
 	}
 }
 
-func parseSplitMode(value string) (engine.SplitMode, error) {
-	switch strings.ToLower(value) {
-	case "immediate":
-		return engine.SplitModeImmediate, nil
-	case "tls-hello":
-		return engine.SplitModeTLSHello, nil
-	default:
-		return engine.SplitModeTLSHello, errors.New("expected tls-hello or immediate")
+// newWindowsReloader applies engine settings and WinDivert queue parameters
+// in place on reload, and serves the control API and metrics until ctx is
+// done.
+func newWindowsReloader(ctx context.Context, eng *engine.Engine, ad *adapter.WinDivertAdapter, eff *config.Effective, configPath string, flags map[string]string, asService bool) *reloader {
+	r := newReloader(eng, config.PlatformWindows, flags, eff, func(flags map[string]string) (*config.Effective, error) {
+		eff, _, err := effectiveWindowsConfig(configPath, flags, asService)
+		return eff, err
+	})
+	r.applyPlatform = func(cur, next config.Config) []control.Change {
+		return updateWinDivertQueue(ad, cur.WinDivert, next.WinDivert)
+	}
+	startControl(ctx, eff.Config.Control, config.PlatformWindows, eng, r, nil)
+	startMetrics(ctx, eff.Config.Metrics, eng, ad, nil)
+	return r
+}
+
+// updateWinDivertQueue applies changed queue parameters to the open handle
+// and returns the ones it could not apply. 0 means "driver default", which
+// cannot be restored without reopening the handle.
+func updateWinDivertQueue(ad *adapter.WinDivertAdapter, cur, next config.WinDivert) []control.Change {
+	params := []struct {
+		key      string
+		from, to uint64
+		opts     adapter.WinDivertOptions
+	}{
+		{"windivert.queue_len", cur.QueueLen, next.QueueLen, adapter.WinDivertOptions{QueueLen: next.QueueLen}},
+		{"windivert.queue_time_ms", cur.QueueTimeMs, next.QueueTimeMs, adapter.WinDivertOptions{QueueTime: next.QueueTimeMs}},
+		{"windivert.queue_size_bytes", cur.QueueSizeBytes, next.QueueSizeBytes, adapter.WinDivertOptions{QueueSize: next.QueueSizeBytes}},
+	}
+	var failed []control.Change
+	for _, p := range params {
+		if p.from == p.to {
+			continue
+		}
+		c := control.Change{Key: p.key, From: strconv.FormatUint(p.from, 10), To: strconv.FormatUint(p.to, 10)}
+		if p.to == 0 {
+			c.Reason = "0 reverts to the driver default, which needs the WinDivert handle to be reopened"
+			failed = append(failed, c)
+		} else if err := ad.UpdateOptions(p.opts); err != nil {
+			c.Reason = fmt.Sprintf("updating the open WinDivert handle failed: %v", err)
+			failed = append(failed, c)
+		}
 	}
+	return failed
 }
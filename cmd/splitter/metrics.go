@@ -0,0 +1,47 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+
+	"fk-gov/internal/adapter"
+	"fk-gov/internal/config"
+	"fk-gov/internal/engine"
+	"fk-gov/internal/logging"
+	"fk-gov/internal/metrics"
+)
+
+// startMetrics serves /metrics until ctx is done. Like the control API, a
+// listener that cannot be opened only disables metrics. ruleDrifts, when not
+// nil, is exported as gov_pass_rule_drifts_total.
+func startMetrics(ctx context.Context, c config.Metrics, eng *engine.Engine, ad adapter.Adapter, ruleDrifts func() uint64) {
+	if c.Listen == "" {
+		return
+	}
+	// Validation refuses other addresses unless metrics.allow_remote is set.
+	if !c.Loopback() {
+		slog.Warn("metrics listener is not loopback-only; the endpoint has no authentication", logging.Event(logging.EventMetricsExposed), "listen", c.Listen)
+	}
+	ln, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		slog.Warn("metrics disabled", logging.Event(logging.EventMetricsDisabled), "listen", c.Listen, logging.Err(err))
+		return
+	}
+	slog.Info("metrics listening", logging.Event(logging.EventMetricsListening), "url", "http://"+ln.Addr().String()+"/metrics")
+	collect := metrics.EngineCollector(eng, ad)
+	if ruleDrifts != nil {
+		engineCollect := collect
+		collect = func(ctx context.Context, w *metrics.Writer) {
+			engineCollect(ctx, w)
+			w.Counter("gov_pass_rule_drifts", "Times the auto rules were found removed or reordered and reinstalled.", metrics.Sample{Value: float64(ruleDrifts())})
+		}
+	}
+	go func() {
+		if err := metrics.Serve(ctx, ln, metrics.Handler(collect)); err != nil {
+			slog.Error("metrics listener stopped", logging.Event(logging.EventMetricsDisabled), logging.Err(err))
+		}
+	}()
+}
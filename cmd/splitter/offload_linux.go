@@ -0,0 +1,193 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"fk-gov/internal/hostnet"
+	"fk-gov/internal/logging"
+)
+
+// egressSettleDelay coalesces bursts of rtnetlink notifications (a roam or VPN
+// bring-up emits many link/route messages) into one re-detection.
+const egressSettleDelay = 500 * time.Millisecond
+
+// offloadManager disables GRO/GSO/TSO on whichever interface is currently the
+// egress and restores the original settings of interfaces that stop being
+// the egress.
+type offloadManager struct {
+	restore bool
+	read    func(iface string) (hostnet.OffloadState, error)
+	set     func(iface string, st hostnet.OffloadState) error
+	// record, when set, persists the original states before they are changed
+	// so a crashed process can be cleaned up later.
+	record func(original map[string]*hostnet.OffloadState) error
+
+	mu      sync.Mutex
+	current string
+	// original holds the pre-change state of every interface we touched. A nil
+	// entry means the state could not be read and is not restored.
+	original map[string]*hostnet.OffloadState
+}
+
+func newOffloadManager(restore bool) *offloadManager {
+	return &offloadManager{
+		restore:  restore,
+		read:     hostnet.ReadOffload,
+		set:      hostnet.SetOffload,
+		original: make(map[string]*hostnet.OffloadState),
+	}
+}
+
+// Current returns the interface offload is currently disabled on.
+func (m *offloadManager) Current() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// switchTo disables offload on iface and restores the previous egress
+// interface, if any.
+func (m *offloadManager) switchTo(iface string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if iface == m.current {
+		return nil
+	}
+	prev := m.current
+
+	if _, seen := m.original[iface]; !seen {
+		m.original[iface] = nil
+		if m.restore {
+			st, err := m.read(iface)
+			if err != nil {
+				slog.Warn("could not read offload state; restore disabled", logging.Event(logging.EventOffloadFailed), "iface", iface, logging.Err(err))
+			} else {
+				m.original[iface] = &st
+			}
+		}
+	}
+	m.recordLocked()
+	if err := m.set(iface, hostnet.OffloadState{}); err != nil {
+		return err
+	}
+	m.current = iface
+	slog.Info("offload disabled (gro/gso/tso)", logging.Event(logging.EventOffloadDisabled), "iface", iface)
+
+	if prev != "" {
+		m.releaseLocked(prev)
+		m.recordLocked()
+	}
+	return nil
+}
+
+// adopt takes over original states an earlier run recorded but could not
+// restore, so they are restored instead of the disabled state read now.
+func (m *offloadManager) adopt(entries []journalInterface) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.Original == nil {
+			continue
+		}
+		st := *entry.Original
+		m.original[entry.Iface] = &st
+	}
+	m.recordLocked()
+}
+
+// restoreAll restores every interface still tracked. It is safe to call more
+// than once.
+func (m *offloadManager) restoreAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for iface := range m.original {
+		m.releaseLocked(iface)
+	}
+	m.current = ""
+	m.recordLocked()
+}
+
+func (m *offloadManager) recordLocked() {
+	if m.record == nil {
+		return
+	}
+	if err := m.record(m.original); err != nil {
+		slog.Warn("state journal update failed", logging.Event(logging.EventJournalFailed), logging.Err(err))
+	}
+}
+
+func (m *offloadManager) releaseLocked(iface string) {
+	st, ok := m.original[iface]
+	delete(m.original, iface)
+	if !ok || st == nil {
+		return
+	}
+	if err := m.set(iface, *st); err != nil {
+		slog.Error("offload restore failed", logging.Event(logging.EventOffloadFailed), "iface", iface, logging.Err(err))
+		return
+	}
+	slog.Info("offload restored", logging.Event(logging.EventOffloadRestored), "iface", iface, "gro", st.GRO, "gso", st.GSO, "tso", st.TSO)
+}
+
+// watch follows egress changes reported by rtnetlink and moves offload
+// handling to the new egress interface. It returns when ctx is canceled.
+func (m *offloadManager) watch(ctx context.Context, detect func() (string, error)) {
+	changes := make(chan struct{}, 1)
+	go func() {
+		err := hostnet.WatchRouteChanges(ctx, func() {
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			slog.Error("egress watcher stopped", logging.Event(logging.EventEgressFailed), logging.Err(err))
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		}
+
+		timer := time.NewTimer(egressSettleDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		select {
+		case <-changes:
+		default:
+		}
+
+		m.reconcile(detect)
+	}
+}
+
+func (m *offloadManager) reconcile(detect func() (string, error)) {
+	iface, err := detect()
+	if err != nil {
+		slog.Warn("egress re-detect failed", logging.Event(logging.EventEgressFailed), logging.Err(err))
+		return
+	}
+	prev := m.Current()
+	if iface == prev {
+		return
+	}
+	slog.Info("egress interface changed", logging.Event(logging.EventEgressChanged), "from", prev, "to", iface)
+	if err := m.switchTo(iface); err != nil {
+		slog.Error("disable offload failed", logging.Event(logging.EventOffloadFailed), "iface", iface, logging.Err(err))
+	}
+}
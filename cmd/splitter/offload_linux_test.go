@@ -0,0 +1,171 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"fk-gov/internal/hostnet"
+)
+
+type fakeOffload struct {
+	state   map[string]hostnet.OffloadState
+	readErr map[string]error
+	calls   []string
+}
+
+func newFakeOffload() *fakeOffload {
+	return &fakeOffload{
+		state:   make(map[string]hostnet.OffloadState),
+		readErr: make(map[string]error),
+	}
+}
+
+func (f *fakeOffload) manager(restore bool) *offloadManager {
+	m := newOffloadManager(restore)
+	m.read = func(iface string) (hostnet.OffloadState, error) {
+		if err := f.readErr[iface]; err != nil {
+			return hostnet.OffloadState{}, err
+		}
+		return f.state[iface], nil
+	}
+	m.set = func(iface string, st hostnet.OffloadState) error {
+		f.calls = append(f.calls, fmt.Sprintf("%s gro=%v gso=%v tso=%v", iface, st.GRO, st.GSO, st.TSO))
+		f.state[iface] = st
+		return nil
+	}
+	return m
+}
+
+func TestOffloadManagerSwitchRestoresPrevious(t *testing.T) {
+	on := hostnet.OffloadState{GRO: true, GSO: true, TSO: true}
+	f := newFakeOffload()
+	f.state["wlan0"] = on
+	f.state["tun0"] = hostnet.OffloadState{GSO: true}
+	m := f.manager(true)
+
+	if err := m.switchTo("wlan0"); err != nil {
+		t.Fatalf("switchTo wlan0: %v", err)
+	}
+	if err := m.switchTo("tun0"); err != nil {
+		t.Fatalf("switchTo tun0: %v", err)
+	}
+	if got := m.Current(); got != "tun0" {
+		t.Fatalf("current: got %q", got)
+	}
+	if f.state["wlan0"] != on {
+		t.Fatalf("wlan0 not restored: %+v", f.state["wlan0"])
+	}
+	if f.state["tun0"] != (hostnet.OffloadState{}) {
+		t.Fatalf("tun0 offload not disabled: %+v", f.state["tun0"])
+	}
+
+	// Switching back re-reads wlan0, since its entry was released.
+	if err := m.switchTo("wlan0"); err != nil {
+		t.Fatalf("switchTo wlan0 again: %v", err)
+	}
+	m.restoreAll()
+	m.restoreAll()
+	if f.state["wlan0"] != on || f.state["tun0"] != (hostnet.OffloadState{GSO: true}) {
+		t.Fatalf("restoreAll mismatch: %+v", f.state)
+	}
+	if got := m.Current(); got != "" {
+		t.Fatalf("current after restoreAll: got %q", got)
+	}
+}
+
+func TestOffloadManagerNoRestore(t *testing.T) {
+	f := newFakeOffload()
+	f.state["eth0"] = hostnet.OffloadState{GRO: true}
+	m := f.manager(false)
+
+	if err := m.switchTo("eth0"); err != nil {
+		t.Fatalf("switchTo: %v", err)
+	}
+	if err := m.switchTo("eth0"); err != nil {
+		t.Fatalf("switchTo same iface: %v", err)
+	}
+	m.restoreAll()
+	if len(f.calls) != 1 {
+		t.Fatalf("expected a single disable call, got %v", f.calls)
+	}
+}
+
+func TestOffloadManagerUnreadableStateIsNotRestored(t *testing.T) {
+	f := newFakeOffload()
+	f.readErr["eth0"] = errors.New("unsupported")
+	m := f.manager(true)
+
+	if err := m.switchTo("eth0"); err != nil {
+		t.Fatalf("switchTo: %v", err)
+	}
+	m.restoreAll()
+	if len(f.calls) != 1 {
+		t.Fatalf("expected only the disable call, got %v", f.calls)
+	}
+}
+
+func TestOffloadManagerAdoptRestoresEarlierOriginal(t *testing.T) {
+	on := hostnet.OffloadState{GRO: true, GSO: true, TSO: true}
+	f := newFakeOffload()
+	// A crashed run left eth0 disabled and could not restore it at startup.
+	f.state["eth0"] = hostnet.OffloadState{}
+	m := f.manager(true)
+	m.adopt([]journalInterface{{Iface: "eth0", Original: &on}, {Iface: "wlan0"}})
+
+	if err := m.switchTo("eth0"); err != nil {
+		t.Fatalf("switchTo: %v", err)
+	}
+	m.restoreAll()
+	if f.state["eth0"] != on {
+		t.Fatalf("eth0 restored to %+v, want %+v", f.state["eth0"], on)
+	}
+}
+
+func TestOffloadManagerReconcile(t *testing.T) {
+	f := newFakeOffload()
+	f.state["eth0"] = hostnet.OffloadState{GRO: true}
+	f.state["usb0"] = hostnet.OffloadState{TSO: true}
+	m := f.manager(true)
+	if err := m.switchTo("eth0"); err != nil {
+		t.Fatalf("switchTo: %v", err)
+	}
+
+	m.reconcile(func() (string, error) { return "", errors.New("no route") })
+	if got := m.Current(); got != "eth0" {
+		t.Fatalf("failed detect must keep current, got %q", got)
+	}
+
+	m.reconcile(func() (string, error) { return "usb0", nil })
+	if got := m.Current(); got != "usb0" {
+		t.Fatalf("current: got %q", got)
+	}
+	if f.state["eth0"] != (hostnet.OffloadState{GRO: true}) {
+		t.Fatalf("eth0 not restored: %+v", f.state["eth0"])
+	}
+}
+
+func TestOffloadManagerRecordsBeforeChange(t *testing.T) {
+	f := newFakeOffload()
+	f.state["eth0"] = hostnet.OffloadState{GRO: true}
+	m := f.manager(true)
+
+	var snapshots []int
+	m.record = func(original map[string]*hostnet.OffloadState) error {
+		// The original state must be persisted before offload is disabled.
+		if st := original["eth0"]; st != nil && len(f.calls) != 0 {
+			t.Fatalf("record ran after set: %v", f.calls)
+		}
+		snapshots = append(snapshots, len(original))
+		return nil
+	}
+	if err := m.switchTo("eth0"); err != nil {
+		t.Fatalf("switchTo: %v", err)
+	}
+	m.restoreAll()
+	if len(snapshots) != 2 || snapshots[0] != 1 || snapshots[1] != 0 {
+		t.Fatalf("snapshots mismatch: %v", snapshots)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build linux || freebsd
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"fk-gov/internal/control"
+	"fk-gov/internal/engine"
+)
+
+// pauseOnSignals pauses the engine on SIGUSR1 and resumes it on SIGUSR2
+// until ctx is done. control.signal_pause is read when the signal arrives,
+// so a reload changes it for the next pause.
+func pauseOnSignals(ctx context.Context, eng *engine.Engine, r *reloader) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case s := <-sig:
+			if s == syscall.SIGUSR2 {
+				control.Resume(eng, "SIGUSR2")
+				continue
+			}
+			_ = control.Pause(eng, r.Effective().Config.Control.SignalPause, "SIGUSR1")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
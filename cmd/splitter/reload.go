@@ -0,0 +1,210 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"fk-gov/internal/config"
+	"fk-gov/internal/control"
+	"fk-gov/internal/engine"
+	"fk-gov/internal/logging"
+)
+
+// restartReason explains why a key only takes effect at startup. Keys that
+// return "" are applied in place by Engine.Reload or the platform hook.
+func restartReason(key string) string {
+	switch key {
+	case "nfqueue.queue_num", "nfqueue.mark":
+		return "the NFQUEUE handle, raw socket and rules are set up at startup"
+	case "nfqueue.nflog_group":
+		return "the NFLOG or NFQUEUE handle and rules are set up at startup"
+	case "engine.track_handshakes":
+		return "inbound capture is set up at startup"
+	case "nfqueue.queue_maxlen", "nfqueue.copy_range":
+		return "the NFQUEUE handle is opened at startup"
+	case "nfqueue.auto_rules", "nfqueue.no_loopback", "nfqueue.rules_check_interval":
+		return "rules are installed at startup"
+	case "nfqueue.auto_offload", "nfqueue.auto_offload_restore", "nfqueue.iface", "nfqueue.watch_egress":
+		return "offload handling is set up at startup"
+	case "nfqueue.auto_install_tools":
+		return "external tools are checked at startup"
+	case "nfqueue.state_dir":
+		return "the state journal is opened at startup"
+	case "windivert.filter":
+		return "the WinDivert handle is opened with its filter at startup"
+	case "windivert.queue_len", "windivert.queue_time_ms", "windivert.queue_size_bytes":
+		return ""
+	case "windivert.windivert_dir", "windivert.windivert_sys", "windivert.auto_install_driver", "windivert.auto_uninstall_driver", "windivert.auto_download_files":
+		return "the WinDivert driver is set up at startup"
+	case "divert.port":
+		return "the divert socket is bound at startup"
+	case "control.enabled", "control.socket", "control.group":
+		return "the control socket is opened at startup"
+	case "control.signal_pause":
+		return ""
+	case "metrics.listen", "metrics.allow_remote":
+		return "the metrics listener is opened at startup"
+	case "log.level":
+		return ""
+	case "log.format", "log.redact", "log.rate_limit":
+		return "the log handler is set up at startup"
+	case "trace.enabled", "trace.size", "trace.dst", "trace.sni", "trace.dump_path":
+		return ""
+	case "capture.path", "capture.max_bytes", "capture.max_files", "capture.snaplen", "capture.hosts":
+		return ""
+	}
+	if f, ok := config.LookupField(key); ok && f.Section() == "engine" {
+		return ""
+	}
+	return "only read at startup"
+}
+
+// planReload compares the running configuration with a re-read one. It
+// returns the configuration that will be running after the reload, which
+// keeps the current value of every restart-only key, and the changes that
+// were skipped.
+func planReload(cur, next config.Config, platform string) (config.Config, []control.Change) {
+	applied := next
+	var skipped []control.Change
+	for _, f := range config.PlatformFields(platform) {
+		from, to := f.Get(&cur), f.Get(&next)
+		if from == to {
+			continue
+		}
+		reason := restartReason(f.Key)
+		if reason == "" {
+			continue
+		}
+		skipped = append(skipped, control.Change{Key: f.Key, From: from, To: to, Reason: reason})
+		if err := f.Set(&applied, from); err != nil {
+			// Get output always parses; keep going with the new value.
+			slog.Error("reload: restore failed", logging.Event(logging.EventReloadFailed), "key", f.Key, logging.Err(err))
+		}
+	}
+	return applied, skipped
+}
+
+// reloader re-reads and applies the configuration for SIGHUP, the Windows
+// service paramchange and the control API.
+type reloader struct {
+	eng      *engine.Engine
+	platform string
+	// load reads the configuration with flags as the command-line layer.
+	load func(flags map[string]string) (*config.Effective, error)
+	// applyPlatform applies the platform's in-place settings that are not
+	// engine settings and returns the ones that need a restart after all.
+	applyPlatform func(cur, next config.Config) []control.Change
+
+	mu    sync.Mutex
+	flags map[string]string
+	// overrides are the keys set through the control API. Only keys that
+	// apply in place are accepted; they stay on top of the command line
+	// until the process restarts.
+	overrides map[string]bool
+	eff       *config.Effective
+}
+
+func newReloader(eng *engine.Engine, platform string, flags map[string]string, eff *config.Effective, load func(map[string]string) (*config.Effective, error)) *reloader {
+	return &reloader{
+		eng:       eng,
+		platform:  platform,
+		load:      load,
+		flags:     flags,
+		overrides: make(map[string]bool),
+		eff:       eff,
+	}
+}
+
+// Effective returns the configuration that is running.
+func (r *reloader) Effective() *config.Effective {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.eff
+}
+
+// Apply re-reads the configuration with values (config keys) layered over
+// the command line, applies what can change in place and returns the
+// changes that need a restart. Nothing changes when it fails.
+func (r *reloader) Apply(values map[string]string) ([]control.Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	flags := make(map[string]string, len(r.flags)+len(values))
+	for k, v := range r.flags {
+		flags[k] = v
+	}
+	overrides := make(map[string]bool, len(r.overrides)+len(values))
+	for k := range r.overrides {
+		overrides[k] = true
+	}
+	for key, value := range values {
+		f, ok := config.LookupField(key)
+		if !ok {
+			return nil, fmt.Errorf("unknown config key %q", key)
+		}
+		if !f.AppliesTo(r.platform) {
+			return nil, fmt.Errorf("%s does not apply to %s", key, r.platform)
+		}
+		if reason := restartReason(key); reason != "" {
+			return nil, fmt.Errorf("%s cannot change at runtime (%s); edit the config file and restart", key, reason)
+		}
+		flags[f.Flag] = value
+		overrides[key] = true
+	}
+
+	eff, err := r.load(flags)
+	if err != nil {
+		slog.Error("reload failed; keeping current configuration", logging.Event(logging.EventReloadFailed), logging.Err(err))
+		return nil, err
+	}
+	warnUnknownKeys(eff.Files)
+	cur := r.eff.Config
+	applied, skipped := planReload(cur, eff.Config, r.platform)
+	if r.applyPlatform != nil {
+		for _, c := range r.applyPlatform(cur, applied) {
+			if f, ok := config.LookupField(c.Key); ok {
+				_ = f.Set(&applied, c.From)
+			}
+			skipped = append(skipped, c)
+		}
+	}
+	level, err := logging.ParseLevel(applied.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+	cfg := applied.EngineConfig()
+	if err := r.eng.Reload(cfg); err != nil {
+		slog.Error("reload: engine config apply failed", logging.Event(logging.EventReloadFailed), logging.Err(err))
+		return nil, fmt.Errorf("engine config apply failed: %w", err)
+	}
+
+	for key := range overrides {
+		eff.Origins[key] = config.Origin{Source: config.SourceControl, Location: "splitter ctl"}
+	}
+	for _, c := range skipped {
+		slog.Warn("reload: change requires a restart to apply", logging.Event(logging.EventReloadRestartRequired), "key", c.Key, "from", c.From, "to", c.To, "reason", c.Reason)
+		eff.Origins[c.Key] = r.eff.Origins[c.Key]
+	}
+	// A level set with `splitter ctl log-level` stays until the configured
+	// one changes.
+	if applied.Log.Level != cur.Log.Level {
+		logLevel.Set(level)
+	}
+	// Any change to what is traced starts over with an empty ring.
+	if applied.TraceRingChanged(cur) {
+		r.eng.SetTrace(applied.TraceRing())
+	}
+	// So does a capture: the file is truncated and its header rewritten.
+	if applied.Capture != cur.Capture {
+		startCapture(r.eng, applied)
+	}
+	eff.Config = applied
+	r.eff = eff
+	r.flags = flags
+	r.overrides = overrides
+	slog.Info("reload: engine config applied", logging.Event(logging.EventReloadApplied), "workers", cfg.WorkerCount, "worker_queue_size", cfg.WorkerQueueSize, "split_mode", cfg.SplitMode, "split_chunk", cfg.SplitChunk, "collect_timeout", cfg.CollectTimeout)
+	return skipped, nil
+}
@@ -0,0 +1,142 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fk-gov/internal/adapter"
+	"fk-gov/internal/config"
+	"fk-gov/internal/control"
+	"fk-gov/internal/engine"
+)
+
+func TestPlanReload(t *testing.T) {
+	cur := config.Defaults()
+	cur.Engine.Workers = 4
+	next := cur
+	next.Engine.SplitChunk = 9
+	next.Engine.CollectTimeout = time.Second
+	next.Engine.Workers = 8
+	next.NFQueue.Mark = 7
+	next.Divert.Port = 9000
+
+	applied, skipped := planReload(cur, next, config.PlatformLinux)
+	if applied.Engine.SplitChunk != 9 || applied.Engine.CollectTimeout != time.Second || applied.Engine.Workers != 8 {
+		t.Fatalf("engine change not applied: %+v", applied.Engine)
+	}
+	if applied.NFQueue.Mark != cur.NFQueue.Mark {
+		t.Fatalf("restart-only change applied: mark=%d", applied.NFQueue.Mark)
+	}
+
+	got := make(map[string]control.Change)
+	for _, c := range skipped {
+		got[c.Key] = c
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected only mark to be skipped, got %+v", skipped)
+	}
+	if c := got["nfqueue.mark"]; c.From != "1" || c.To != "7" || c.Reason == "" {
+		t.Fatalf("mark change: %+v", c)
+	}
+	// divert.port does not apply on Linux.
+	if _, ok := got["divert.port"]; ok {
+		t.Fatalf("divert.port reported on linux")
+	}
+
+	// A second reload against the applied config reports the same keys again
+	// until the process restarts.
+	_, again := planReload(applied, next, config.PlatformLinux)
+	if len(again) != 1 {
+		t.Fatalf("restart-only changes forgotten: %+v", again)
+	}
+}
+
+func TestReloaderApply(t *testing.T) {
+	load := func(flags map[string]string) (*config.Effective, error) {
+		return config.Load(config.LoadOptions{Platform: config.PlatformLinux, Flags: flags})
+	}
+	eff, err := load(map[string]string{"split-chunk": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := engine.New(eff.Config.EngineConfig(), adapter.NewStub())
+	r := newReloader(eng, config.PlatformLinux, map[string]string{"split-chunk": "3"}, eff, load)
+
+	if _, err := r.Apply(map[string]string{"engine.workers": "3"}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if got := eng.Config().WorkerCount; got != 3 {
+		t.Fatalf("workers not applied: %d", got)
+	}
+	cur := r.Effective()
+	if o := cur.Origins["engine.workers"]; o.Source != config.SourceControl {
+		t.Fatalf("workers origin: %+v", o)
+	}
+	if cur.Config.Engine.SplitChunk != 3 {
+		t.Fatalf("command-line flag lost: split_chunk=%d", cur.Config.Engine.SplitChunk)
+	}
+
+	for _, values := range []map[string]string{
+		{"nfqueue.mark": "5"},
+		{"divert.port": "9000"},
+		{"engine.nope": "1"},
+		{"engine.workers": "0"},
+	} {
+		if _, err := r.Apply(values); err == nil {
+			t.Fatalf("apply %v: expected an error", values)
+		}
+	}
+	if r.Effective() != cur || eng.Config().WorkerCount != 3 {
+		t.Fatalf("failed apply changed the running configuration")
+	}
+
+	// log.level applies live; log.format needs a restart.
+	defer logLevel.Set(logLevel.Level())
+	if _, err := r.Apply(map[string]string{"log.level": "debug"}); err != nil {
+		t.Fatalf("apply log.level: %v", err)
+	}
+	if got := logLevel.Level(); got != slog.LevelDebug {
+		t.Fatalf("log level not applied: %v", got)
+	}
+	if _, err := r.Apply(map[string]string{"log.format": "json"}); err == nil {
+		t.Fatalf("apply log.format: expected an error")
+	}
+
+	// trace settings apply live, each change with a fresh ring.
+	if _, err := r.Apply(map[string]string{"trace.enabled": "true", "trace.sni": "example.com"}); err != nil {
+		t.Fatalf("apply trace: %v", err)
+	}
+	ring := eng.Trace()
+	if ring == nil {
+		t.Fatal("tracing not enabled")
+	}
+	if _, err := r.Apply(map[string]string{"trace.enabled": "true", "trace.sni": "example.org"}); err != nil || eng.Trace() == ring {
+		t.Fatalf("trace filter change not applied: %v", err)
+	}
+
+	// capture settings apply live too; the file is created right away.
+	capPath := filepath.Join(t.TempDir(), "cap.pcapng")
+	if _, err := r.Apply(map[string]string{"capture.path": capPath}); err != nil {
+		t.Fatalf("apply capture: %v", err)
+	}
+	defer stopCapture(eng)
+	if _, err := os.Stat(capPath); err != nil {
+		t.Fatalf("capture not started: %v", err)
+	}
+	if _, err := r.Apply(map[string]string{"capture.hosts": "nope"}); err == nil {
+		t.Fatalf("apply capture.hosts: expected an error")
+	}
+
+	// A plain reload keeps values set through the control API.
+	if _, err := r.Apply(nil); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := eng.Config().WorkerCount; got != 3 {
+		t.Fatalf("control override lost on reload: workers=%d", got)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build linux || freebsd
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadOnSIGHUP re-reads the configuration on every SIGHUP until ctx is
+// done. Command-line flags keep overriding the file and environment.
+func reloadOnSIGHUP(ctx context.Context, r *reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			_, _ = r.Apply(nil)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
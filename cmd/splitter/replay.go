@@ -0,0 +1,138 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sync"
+	"text/tabwriter"
+
+	"fk-gov/internal/adapter"
+	"fk-gov/internal/config"
+	"fk-gov/internal/engine"
+	"fk-gov/internal/flow"
+)
+
+const replayUsage = `usage: splitter replay [flags] <capture.pcap|capture.pcapng>
+
+Runs the engine over the IPv4 packets of a capture file, without privileges
+or a network, and prints what it did to each port-443 flow. Engine settings
+come from the flags and --config only, never from the installed config, so
+a replay gives the same result on any host.
+
+flags:
+`
+
+// runReplay replays a capture through the engine and prints a per-flow
+// summary to stdout.
+func runReplay(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configPath := fs.String("config", "", "config file to take engine settings from")
+	out := fs.String("out", "", "write the packets the engine sent and dropped to this pcapng file")
+	clock := fs.String("clock", "virtual", "time the engine sees: virtual (the capture's timestamps, so collect timeouts replay as recorded) or wall")
+	cfgFlags := config.RegisterFlags(fs, config.CurrentPlatform())
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), replayUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("replay: expected exactly one capture file")
+	}
+	if *clock != "virtual" && *clock != "wall" {
+		return fmt.Errorf("replay: --clock must be virtual or wall, not %q", *clock)
+	}
+	eff, err := config.Load(config.LoadOptions{
+		Platform: config.CurrentPlatform(),
+		Path:     *configPath,
+		Flags:    cfgFlags.Values(),
+	})
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := setupLogging(eff.Config.Log); err != nil {
+		return err
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	opts := adapter.ReplayOptions{Virtual: *clock == "virtual"}
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		opts.Output = f
+	}
+	ad, err := adapter.NewReplay(in, opts)
+	if err != nil {
+		return fmt.Errorf("replay: %s: %w", fs.Arg(0), err)
+	}
+
+	eng := engine.New(eff.Config.EngineConfig(), ad)
+	eng.SetClock(ad.Clock())
+	ad.SetSync(eng.Sync)
+	var mu sync.Mutex
+	failOpens := make(map[flow.Key]flow.FailOpenReason)
+	eng.OnFailOpen(func(ev engine.FailOpenEvent) {
+		mu.Lock()
+		failOpens[ev.Key] = ev.Reason
+		mu.Unlock()
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := eng.Run(ctx); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("replay: %w", err)
+	}
+	if ctx.Err() != nil {
+		return errors.New("replay: interrupted")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return writeReplaySummary(stdout, ad.Flows(), failOpens, ad.Skipped())
+}
+
+func writeReplaySummary(stdout io.Writer, flows []adapter.ReplayFlow, failOpens map[flow.Key]flow.FailOpenReason, skipped int) error {
+	var split, failed int
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SRC\tDST\tSNI\tIN\tSENT\tDROPPED\tOUTCOME")
+	for _, f := range flows {
+		outcome := "passed"
+		if reason, ok := failOpens[f.Key]; ok {
+			outcome = "fail-open: " + reason.String()
+			failed++
+		} else if f.Dropped > 0 {
+			outcome = "split"
+			split++
+		}
+		sni := f.ServerName
+		if sni == "" {
+			sni = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			netip.AddrPortFrom(netip.AddrFrom4(f.Key.SrcIP), f.Key.SrcPort),
+			netip.AddrPortFrom(netip.AddrFrom4(f.Key.DstIP), f.Key.DstPort),
+			sni, f.Received, f.Sent, f.Dropped, outcome)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(stdout, "%d flows: %d split, %d failed open, %d passed; %d non-IPv4 packets skipped\n",
+		len(flows), split, failed, len(flows)-split-failed, skipped)
+	return err
+}
@@ -0,0 +1,118 @@
+//go:build linux || windows || freebsd
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fk-gov/internal/adapter"
+	"fk-gov/internal/pcapng"
+)
+
+// replayHello is a TLS ClientHello record naming name.
+func replayHello(name string) []byte {
+	n := len(name)
+	ext := []byte{0x00, 0x00, byte((n + 5) >> 8), byte(n + 5), byte((n + 3) >> 8), byte(n + 3), 0x00, byte(n >> 8), byte(n)}
+	ext = append(ext, name...)
+	body := append([]byte{0x03, 0x03}, make([]byte, 32)...)
+	body = append(body, 0, 0x00, 0x02, 0x13, 0x01, 0x01, 0x00, byte(len(ext)>>8), byte(len(ext)))
+	body = append(body, ext...)
+	hs := append([]byte{0x01, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{0x16, 0x03, 0x01, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+}
+
+func replaySegment(sport uint16, seq uint32, payload []byte) []byte {
+	b := make([]byte, 40, 40+len(payload))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:], uint16(40+len(payload)))
+	b[8], b[9] = 64, 6
+	copy(b[12:], []byte{10, 0, 0, 2})
+	copy(b[16:], []byte{203, 0, 113, 7})
+	binary.BigEndian.PutUint16(b[20:], sport)
+	binary.BigEndian.PutUint16(b[22:], 443)
+	binary.BigEndian.PutUint32(b[24:], seq)
+	b[32] = 5 << 4
+	b[33] = 0x18 // ACK|PSH
+	return append(b, payload...)
+}
+
+func TestRunReplay(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.pcapng")
+	var buf bytes.Buffer
+	w, err := pcapng.NewWriter(&buf, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddInterface("raw", pcapng.LinkTypeRaw, 0); err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1700000000, 0)
+	slow := replayHello("slow.example")
+	for _, p := range []struct {
+		at   time.Duration
+		data []byte
+	}{
+		{0, replaySegment(40000, 1000, replayHello("www.example.com"))},
+		{10 * time.Millisecond, replaySegment(40001, 5000, slow[:20])},
+		// The rest of the second hello comes after the 250ms collect timeout.
+		{time.Second, replaySegment(40001, 5020, slow[20:])},
+	} {
+		if err := w.WritePacket(0, t0.Add(p.at), p.data, len(p.data), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(in, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out.pcapng")
+	var stdout bytes.Buffer
+	if err := runReplay([]string{"--log-level", "warn", "--workers", "2", "--out", out, in}, &stdout); err != nil {
+		t.Fatalf("replay: %v\n%s", err, stdout.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("summary:\n%s", stdout.String())
+	}
+	for i, want := range []string{
+		"10.0.0.2:40000  203.0.113.7:443  www.example.com  1   2     1        split",
+		"10.0.0.2:40001  203.0.113.7:443  -                2   2     0        fail-open: collect-timeout",
+		"2 flows: 1 split, 1 failed open, 0 passed; 0 non-IPv4 packets skipped",
+	} {
+		if got := strings.TrimRight(lines[i+1], " "); got != want {
+			t.Errorf("line %d = %q, want %q", i+1, got, want)
+		}
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rd, err := pcapng.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent, dropped int
+	for {
+		p, err := rd.Next()
+		if err != nil {
+			break
+		}
+		if p.Interface == adapter.ReplayIfaceDropped {
+			dropped++
+		} else {
+			sent++
+		}
+	}
+	if sent != 4 || dropped != 1 {
+		t.Fatalf("output has %d sent and %d dropped packets, want 4 and 1", sent, dropped)
+	}
+}
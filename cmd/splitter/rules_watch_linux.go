@@ -0,0 +1,277 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"fk-gov/internal/logging"
+)
+
+// check reports how the live rules differ from what install would create.
+// An empty result means the rules are intact.
+func (r *ruleSet) check() ([]string, error) {
+	check, checkInbound := checkNftRules, checkNftInboundRules
+	if r.backend != "nft" {
+		check, checkInbound = checkIptablesRules, checkIptablesInboundRules
+	}
+	drift, err := check(r.path, r.opts)
+	if err != nil || !r.opts.Inbound {
+		return drift, err
+	}
+	inbound, err := checkInbound(r.path, r.opts)
+	return append(drift, inbound...), err
+}
+
+// reinstall removes whatever is left of the rules and installs them again.
+// Removing first is what moves an iptables jump back to position 1.
+func (r *ruleSet) reinstall() error {
+	if err := r.uninstall(); err != nil {
+		return err
+	}
+	return r.install()
+}
+
+// expectedRuleKinds lists the rules install creates, in chain order.
+func expectedRuleKinds(opts ruleOptions) []string {
+	var kinds []string
+	if opts.Mark != 0 {
+		kinds = append(kinds, fmt.Sprintf("mark %d", opts.Mark))
+	}
+	if opts.ExcludeLoopback {
+		kinds = append(kinds, "loopback")
+	}
+	if opts.NFLogGroup != 0 {
+		return append(kinds, fmt.Sprintf("nflog %d", opts.NFLogGroup))
+	}
+	return append(kinds, fmt.Sprintf("queue %d", opts.QueueNum))
+}
+
+// expectedInboundRuleKinds lists the rules of the input chain.
+func expectedInboundRuleKinds(opts ruleOptions) []string {
+	var kinds []string
+	if opts.ExcludeLoopback {
+		kinds = append(kinds, "loopback")
+	}
+	if opts.NFLogGroup != 0 {
+		return append(kinds, fmt.Sprintf("nflog %d", opts.NFLogGroup))
+	}
+	return append(kinds, fmt.Sprintf("queue %d", opts.QueueNum))
+}
+
+func checkNftRules(path string, opts ruleOptions) ([]string, error) {
+	out, err := runCommand(path, "-a", "list", "chain", "inet", "gov_pass", "output")
+	if err != nil {
+		lower := strings.ToLower(err.Error())
+		if strings.Contains(lower, "no such file") || strings.Contains(lower, "does not exist") {
+			return []string{"nft chain inet gov_pass output missing"}, nil
+		}
+		return nil, err
+	}
+	return diffRuleKinds(nftRuleKinds(out), expectedRuleKinds(opts)), nil
+}
+
+func checkNftInboundRules(path string, opts ruleOptions) ([]string, error) {
+	out, err := runCommand(path, "-a", "list", "chain", "inet", "gov_pass", "input")
+	if err != nil {
+		lower := strings.ToLower(err.Error())
+		if strings.Contains(lower, "no such file") || strings.Contains(lower, "does not exist") {
+			return []string{"nft chain inet gov_pass input missing"}, nil
+		}
+		return nil, err
+	}
+	return diffRuleKinds(nftRuleKinds(out), expectedInboundRuleKinds(opts)), nil
+}
+
+func nftRuleKinds(listing string) []string {
+	var kinds []string
+	scanner := bufio.NewScanner(strings.NewReader(listing))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, `comment "gov-pass"`) {
+			continue
+		}
+		kinds = append(kinds, classifyRule(strings.Fields(line)))
+	}
+	return kinds
+}
+
+func checkIptablesRules(path string, opts ruleOptions) ([]string, error) {
+	var drift []string
+
+	out, err := runCommand(path, "-t", "mangle", "-S", "OUTPUT")
+	if err != nil {
+		return nil, err
+	}
+	first := ""
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "-A OUTPUT ") {
+			first = strings.TrimSpace(line)
+			break
+		}
+	}
+	if first != "-A OUTPUT -j GOVPASS_OUTPUT" {
+		drift = append(drift, "OUTPUT jump to GOVPASS_OUTPUT missing or not first")
+	}
+
+	out, err = runCommand(path, "-t", "mangle", "-S", "GOVPASS_OUTPUT")
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "no chain") {
+			return append(drift, "iptables chain GOVPASS_OUTPUT missing"), nil
+		}
+		return nil, err
+	}
+	return append(drift, diffRuleKinds(iptablesRuleKinds(out, "GOVPASS_OUTPUT"), expectedRuleKinds(opts))...), nil
+}
+
+func checkIptablesInboundRules(path string, opts ruleOptions) ([]string, error) {
+	var drift []string
+
+	out, err := runCommand(path, "-t", "mangle", "-S", "INPUT")
+	if err != nil {
+		return nil, err
+	}
+	first := ""
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "-A INPUT ") {
+			first = strings.TrimSpace(line)
+			break
+		}
+	}
+	if first != "-A INPUT -j GOVPASS_INPUT" {
+		drift = append(drift, "INPUT jump to GOVPASS_INPUT missing or not first")
+	}
+
+	out, err = runCommand(path, "-t", "mangle", "-S", "GOVPASS_INPUT")
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "no chain") {
+			return append(drift, "iptables chain GOVPASS_INPUT missing"), nil
+		}
+		return nil, err
+	}
+	return append(drift, diffRuleKinds(iptablesRuleKinds(out, "GOVPASS_INPUT"), expectedInboundRuleKinds(opts))...), nil
+}
+
+func iptablesRuleKinds(listing, chain string) []string {
+	var kinds []string
+	for _, line := range strings.Split(listing, "\n") {
+		if !strings.HasPrefix(line, "-A "+chain+" ") {
+			continue
+		}
+		kinds = append(kinds, classifyRule(strings.Fields(line)))
+	}
+	return kinds
+}
+
+// classifyRule maps an nft or iptables rule listing to the kind names used by
+// expectedRuleKinds. Anything unrecognized is returned verbatim.
+func classifyRule(fields []string) string {
+	for i, f := range fields {
+		if i+1 >= len(fields) {
+			break
+		}
+		next := fields[i+1]
+		switch f {
+		case "num", "to", "--queue-num":
+			// nft prints "queue num N" or "queue flags bypass to N".
+			if v, err := strconv.ParseUint(next, 10, 16); err == nil {
+				return fmt.Sprintf("queue %d", v)
+			}
+		case "group", "--nflog-group":
+			// nft prints "log group N"; iptables "-j NFLOG --nflog-group N".
+			if v, err := strconv.ParseUint(next, 10, 16); err == nil {
+				return fmt.Sprintf("nflog %d", v)
+			}
+		case "&", "--mark":
+			// nft prints the mask in hex; iptables prints "value/mask".
+			value, _, _ := strings.Cut(next, "/")
+			if v, err := strconv.ParseUint(value, 0, 32); err == nil {
+				return fmt.Sprintf("mark %d", v)
+			}
+		case "oifname", "-o", "iifname", "-i":
+			if strings.Trim(next, `"`) == "lo" {
+				return "loopback"
+			}
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func diffRuleKinds(got, want []string) []string {
+	if len(got) == len(want) {
+		same := true
+		for i := range got {
+			if got[i] != want[i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("rules %q, want %q", got, want)}
+}
+
+// ruleWatchdog periodically verifies the installed rules and reinstalls them
+// when a firewall reload or flush removed or reordered them.
+type ruleWatchdog struct {
+	backend   string
+	interval  time.Duration
+	check     func() ([]string, error)
+	reinstall func() error
+
+	drifts atomic.Uint64
+}
+
+func newRuleWatchdog(rules *ruleSet, interval time.Duration) *ruleWatchdog {
+	return &ruleWatchdog{
+		backend:   rules.backend,
+		interval:  interval,
+		check:     rules.check,
+		reinstall: rules.reinstall,
+	}
+}
+
+// Drifts returns how many times the rules were found out of place.
+func (w *ruleWatchdog) Drifts() uint64 {
+	return w.drifts.Load()
+}
+
+func (w *ruleWatchdog) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.verify()
+		}
+	}
+}
+
+func (w *ruleWatchdog) verify() {
+	drift, err := w.check()
+	if err != nil {
+		slog.Warn("rule check failed", logging.Event(logging.EventRulesCheckFailed), "backend", w.backend, logging.Err(err))
+		return
+	}
+	if len(drift) == 0 {
+		return
+	}
+	n := w.drifts.Add(1)
+	slog.Warn("rule drift detected", logging.Event(logging.EventRulesDrift), "backend", w.backend, "drift", strings.Join(drift, "; "), "count", n)
+	if err := w.reinstall(); err != nil {
+		slog.Error("rule reinstall failed", logging.Event(logging.EventRulesReinstallFailed), "backend", w.backend, logging.Err(err))
+		return
+	}
+	slog.Info("auto rules reinstalled", logging.Event(logging.EventRulesReinstalled), "backend", w.backend)
+}
@@ -0,0 +1,162 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckNftRules(t *testing.T) {
+	intact := "table inet gov_pass {\n" +
+		"\tchain output { # handle 1\n" +
+		"\t\ttype filter hook output priority mangle; policy accept;\n" +
+		"\t\tmeta mark & 0x00000001 == 0x00000001 return comment \"gov-pass\" # handle 2\n" +
+		"\t\toifname \"lo\" return comment \"gov-pass\" # handle 3\n" +
+		"\t\tmeta nfproto ipv4 tcp dport 443 queue flags bypass to 100 comment \"gov-pass\" # handle 4\n" +
+		"\t}\n}\n"
+	t.Setenv("NFT_LIST_CHAIN_OUTPUT", intact)
+	t.Setenv("NFT_FAIL_LIST_CHAIN", "0")
+
+	cmd := writeExecScript(t, `
+if [ "${NFT_FAIL_LIST_CHAIN:-0}" = "1" ]; then
+  echo "Error: No such file or directory" >&2
+  exit 1
+fi
+printf "%s" "${NFT_LIST_CHAIN_OUTPUT:-}"
+`)
+
+	opts := ruleOptions{QueueNum: 100, Mark: 1, ExcludeLoopback: true}
+	drift, err := checkNftRules(cmd, opts)
+	if err != nil || len(drift) != 0 {
+		t.Fatalf("intact rules: drift=%v err=%v", drift, err)
+	}
+
+	// Older nft renders the queue statement as "queue num N bypass".
+	t.Setenv("NFT_LIST_CHAIN_OUTPUT", "tcp dport 443 queue num 100 bypass comment \"gov-pass\" # handle 4\n")
+	drift, err = checkNftRules(cmd, ruleOptions{QueueNum: 100})
+	if err != nil || len(drift) != 0 {
+		t.Fatalf("legacy queue syntax: drift=%v err=%v", drift, err)
+	}
+
+	drift, err = checkNftRules(cmd, ruleOptions{QueueNum: 200})
+	if err != nil || len(drift) != 1 {
+		t.Fatalf("queue number mismatch: drift=%v err=%v", drift, err)
+	}
+
+	t.Setenv("NFT_FAIL_LIST_CHAIN", "1")
+	drift, err = checkNftRules(cmd, opts)
+	if err != nil || len(drift) != 1 {
+		t.Fatalf("missing chain: drift=%v err=%v", drift, err)
+	}
+}
+
+func TestCheckIptablesRules(t *testing.T) {
+	t.Setenv("OUTPUT_RULES", "-P OUTPUT ACCEPT\n-A OUTPUT -j GOVPASS_OUTPUT\n-A OUTPUT -j DOCKER\n")
+	t.Setenv("CHAIN_MISSING", "0")
+
+	cmd := writeExecScript(t, `
+if [ "${4:-}" = "OUTPUT" ]; then
+  printf "%s" "$OUTPUT_RULES"
+  exit 0
+fi
+if [ "$CHAIN_MISSING" = "1" ]; then
+  echo "iptables: No chain/target/match by that name." >&2
+  exit 1
+fi
+printf "%s\n" "-N GOVPASS_OUTPUT" \
+  "-A GOVPASS_OUTPUT -m mark --mark 0x1/0x1 -j RETURN" \
+  "-A GOVPASS_OUTPUT -o lo -j RETURN" \
+  "-A GOVPASS_OUTPUT -p tcp -m tcp --dport 443 -j NFQUEUE --queue-num 100 --queue-bypass"
+`)
+
+	opts := ruleOptions{QueueNum: 100, Mark: 1, ExcludeLoopback: true}
+	drift, err := checkIptablesRules(cmd, opts)
+	if err != nil || len(drift) != 0 {
+		t.Fatalf("intact rules: drift=%v err=%v", drift, err)
+	}
+
+	t.Setenv("OUTPUT_RULES", "-P OUTPUT ACCEPT\n-A OUTPUT -j DOCKER\n-A OUTPUT -j GOVPASS_OUTPUT\n")
+	drift, err = checkIptablesRules(cmd, opts)
+	if err != nil || len(drift) != 1 {
+		t.Fatalf("jump not first: drift=%v err=%v", drift, err)
+	}
+
+	t.Setenv("OUTPUT_RULES", "-P OUTPUT ACCEPT\n")
+	t.Setenv("CHAIN_MISSING", "1")
+	drift, err = checkIptablesRules(cmd, opts)
+	if err != nil || len(drift) != 2 {
+		t.Fatalf("flushed table: drift=%v err=%v", drift, err)
+	}
+}
+
+func TestClassifyRule_NFLog(t *testing.T) {
+	opts := ruleOptions{QueueNum: 100, Mark: 1, NFLogGroup: 5}
+	nft := nftRuleKinds("meta mark & 0x00000001 == 0x00000001 return comment \"gov-pass\" # handle 2\n" +
+		"meta nfproto ipv4 tcp dport 443 log group 5 comment \"gov-pass\" # handle 3\n")
+	if drift := diffRuleKinds(nft, expectedRuleKinds(opts)); drift != nil {
+		t.Fatalf("nft: %v", drift)
+	}
+	ipt := iptablesRuleKinds("-A GOVPASS_OUTPUT -m mark --mark 0x1/0x1 -j RETURN\n"+
+		"-A GOVPASS_OUTPUT -p tcp -m tcp --dport 443 -j NFLOG --nflog-group 5\n", "GOVPASS_OUTPUT")
+	if drift := diffRuleKinds(ipt, expectedRuleKinds(opts)); drift != nil {
+		t.Fatalf("iptables: %v", drift)
+	}
+	// A log rule left behind by an NFLOG run is drift for NFQUEUE.
+	opts.NFLogGroup = 0
+	if drift := diffRuleKinds(nft, expectedRuleKinds(opts)); drift == nil {
+		t.Fatal("log rule accepted for NFQUEUE")
+	}
+}
+
+func TestClassifyRule_Inbound(t *testing.T) {
+	opts := ruleOptions{QueueNum: 100, Mark: 1, ExcludeLoopback: true, Inbound: true}
+	nft := nftRuleKinds("iifname \"lo\" return comment \"gov-pass\" # handle 7\n" +
+		"meta nfproto ipv4 tcp sport 443 ct reply packets < 16 queue flags bypass to 100 comment \"gov-pass\" # handle 8\n")
+	if drift := diffRuleKinds(nft, expectedInboundRuleKinds(opts)); drift != nil {
+		t.Fatalf("nft: %v", drift)
+	}
+	ipt := iptablesRuleKinds("-N GOVPASS_INPUT\n"+
+		"-A GOVPASS_INPUT -i lo -j RETURN\n"+
+		"-A GOVPASS_INPUT -p tcp -m tcp --sport 443 -m connbytes --connbytes 0:15 --connbytes-mode packets --connbytes-dir reply -j NFQUEUE --queue-num 100 --queue-bypass\n", "GOVPASS_INPUT")
+	if drift := diffRuleKinds(ipt, expectedInboundRuleKinds(opts)); drift != nil {
+		t.Fatalf("iptables: %v", drift)
+	}
+}
+
+func TestRuleWatchdogVerify(t *testing.T) {
+	var (
+		drift      []string
+		checkErr   error
+		reinstalls int
+	)
+	w := &ruleWatchdog{
+		backend: "nft",
+		check: func() ([]string, error) {
+			return drift, checkErr
+		},
+		reinstall: func() error {
+			reinstalls++
+			drift = nil
+			return nil
+		},
+	}
+
+	w.verify()
+	if w.Drifts() != 0 || reinstalls != 0 {
+		t.Fatalf("intact rules: drifts=%d reinstalls=%d", w.Drifts(), reinstalls)
+	}
+
+	drift = []string{"nft chain inet gov_pass output missing"}
+	w.verify()
+	w.verify()
+	if w.Drifts() != 1 || reinstalls != 1 {
+		t.Fatalf("after drift: drifts=%d reinstalls=%d", w.Drifts(), reinstalls)
+	}
+
+	checkErr = errors.New("nft not found")
+	w.verify()
+	if w.Drifts() != 1 || reinstalls != 1 {
+		t.Fatalf("check error must not count as drift: drifts=%d reinstalls=%d", w.Drifts(), reinstalls)
+	}
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// commandRunner executes external commands (nft, iptables, package managers).
+// Every system mutation the splitter performs through a command goes through
+// the package-level runner so it can be printed instead of executed.
+type commandRunner interface {
+	Run(env []string, name string, args ...string) (string, error)
+}
+
+var runner commandRunner = execRunner{}
+
+func runCommand(name string, args ...string) (string, error) {
+	return runner.Run(nil, name, args...)
+}
+
+func runCommandEnv(env []string, name string, args ...string) (string, error) {
+	return runner.Run(env, name, args...)
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(env []string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		trimmed := strings.TrimSpace(string(out))
+		if trimmed != "" {
+			return string(out), fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, trimmed)
+		}
+		return string(out), fmt.Errorf("%s %s failed: %w", name, strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// dryRunRunner prints mutating commands instead of running them. Read-only
+// queries still go to query so that the printed plan reflects the current
+// state of the host (an existing table is not added again, and so on).
+type dryRunRunner struct {
+	query commandRunner
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newDryRunRunner(out io.Writer) *dryRunRunner {
+	return &dryRunRunner{query: execRunner{}, out: out}
+}
+
+func (r *dryRunRunner) Run(env []string, name string, args ...string) (string, error) {
+	if isReadOnlyCommand(name, args) {
+		out, err := r.query.Run(env, name, args...)
+		if err != nil {
+			// The tool may not be installed yet or we may lack privileges.
+			// Treat the queried object as absent so the full install
+			// sequence is shown.
+			return out, fmt.Errorf("%w (dry-run: assuming it does not exist)", err)
+		}
+		return out, nil
+	}
+	r.printf("%s", formatCommand(env, name, args))
+	return "", nil
+}
+
+func (r *dryRunRunner) printf(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.out, "dry-run: "+format+"\n", args...)
+}
+
+// dryRunf prints a non-command step (offload change, journal write, adapter
+// open) when dry-run is active. It reports whether it did.
+func dryRunf(format string, args ...any) bool {
+	r, ok := runner.(*dryRunRunner)
+	if !ok {
+		return false
+	}
+	r.printf(format, args...)
+	return true
+}
+
+func isDryRun() bool {
+	_, ok := runner.(*dryRunRunner)
+	return ok
+}
+
+func isReadOnlyCommand(name string, args []string) bool {
+	switch filepath.Base(name) {
+	case "nft":
+		for _, a := range args {
+			if strings.HasPrefix(a, "-") {
+				continue
+			}
+			return a == "list"
+		}
+	case "iptables", "ip6tables":
+		for _, a := range args {
+			switch a {
+			case "-C", "-S", "-L", "--check", "--list", "--list-rules":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formatCommand(env []string, name string, args []string) string {
+	parts := make([]string, 0, len(env)+len(args)+1)
+	parts = append(parts, env...)
+	parts = append(parts, name)
+	for _, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\"'{};&|<>$") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		parts = append(parts, a)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,175 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordingRunner records every command and answers from a script keyed by
+// the formatted command line. Unscripted commands succeed with no output.
+type recordingRunner struct {
+	replies    map[string]recordedReply
+	transcript []string
+}
+
+type recordedReply struct {
+	out string
+	err error
+}
+
+func useRecordingRunner(t *testing.T, replies map[string]recordedReply) *recordingRunner {
+	t.Helper()
+	r := &recordingRunner{replies: replies}
+	prev := runner
+	runner = r
+	t.Cleanup(func() { runner = prev })
+	return r
+}
+
+func (r *recordingRunner) Run(env []string, name string, args ...string) (string, error) {
+	line := formatCommand(env, name, args)
+	r.transcript = append(r.transcript, line)
+	reply := r.replies[line]
+	return reply.out, reply.err
+}
+
+func assertTranscript(t *testing.T, got []string, want []string) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("transcript mismatch:\ngot:\n  %s\nwant:\n  %s", strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+	}
+}
+
+func TestInstallNftRulesTranscript(t *testing.T) {
+	missing := recordedReply{err: errors.New("Error: No such file or directory")}
+	r := useRecordingRunner(t, map[string]recordedReply{
+		"nft list table inet gov_pass":                  missing,
+		"nft list chain inet gov_pass output":           missing,
+		"nft -a list chain inet gov_pass output":        {out: "meta nfproto ipv4 tcp dport 443 queue num 100 bypass comment \"gov-pass\" # handle 3\n"},
+		"nft delete rule inet gov_pass output handle 3": {},
+	})
+
+	if err := installNftRules("nft", ruleOptions{QueueNum: 100, Mark: 1, ExcludeLoopback: true}); err != nil {
+		t.Fatalf("installNftRules: %v", err)
+	}
+	assertTranscript(t, r.transcript, []string{
+		"nft list table inet gov_pass",
+		"nft add table inet gov_pass",
+		"nft list chain inet gov_pass output",
+		"nft add chain inet gov_pass output '{' type filter hook output priority mangle ';' policy accept ';' '}'",
+		"nft -a list chain inet gov_pass output",
+		"nft delete rule inet gov_pass output handle 3",
+		"nft add rule inet gov_pass output meta mark '&' 1 == 1 return comment gov-pass",
+		"nft add rule inet gov_pass output oifname lo return comment gov-pass",
+		"nft add rule inet gov_pass output meta nfproto ipv4 tcp dport 443 queue num 100 bypass comment gov-pass",
+	})
+}
+
+func TestInstallIptablesRulesTranscript(t *testing.T) {
+	r := useRecordingRunner(t, map[string]recordedReply{
+		"iptables -t mangle -C OUTPUT -j GOVPASS_OUTPUT": {err: errors.New("Bad rule")},
+	})
+
+	if err := installIptablesRules("iptables", ruleOptions{QueueNum: 7}); err != nil {
+		t.Fatalf("installIptablesRules: %v", err)
+	}
+	assertTranscript(t, r.transcript, []string{
+		"iptables -t mangle -N GOVPASS_OUTPUT",
+		"iptables -t mangle -F GOVPASS_OUTPUT",
+		"iptables -t mangle -C OUTPUT -j GOVPASS_OUTPUT",
+		"iptables -t mangle -I OUTPUT 1 -j GOVPASS_OUTPUT",
+		"iptables -t mangle -A GOVPASS_OUTPUT -p tcp --dport 443 -j NFQUEUE --queue-num 7 --queue-bypass",
+	})
+}
+
+func TestInstallNftRulesTranscript_Inbound(t *testing.T) {
+	r := useRecordingRunner(t, map[string]recordedReply{
+		"nft list chain inet gov_pass input": {err: errors.New("Error: No such file or directory")},
+	})
+
+	if err := installNftRules("nft", ruleOptions{QueueNum: 100, ExcludeLoopback: true, Inbound: true}); err != nil {
+		t.Fatalf("installNftRules: %v", err)
+	}
+	assertTranscript(t, r.transcript, []string{
+		"nft list table inet gov_pass",
+		"nft list chain inet gov_pass output",
+		"nft -a list chain inet gov_pass output",
+		"nft add rule inet gov_pass output oifname lo return comment gov-pass",
+		"nft add rule inet gov_pass output meta nfproto ipv4 tcp dport 443 queue num 100 bypass comment gov-pass",
+		"nft list chain inet gov_pass input",
+		"nft add chain inet gov_pass input '{' type filter hook input priority mangle ';' policy accept ';' '}'",
+		"nft -a list chain inet gov_pass input",
+		"nft add rule inet gov_pass input iifname lo return comment gov-pass",
+		"nft add rule inet gov_pass input meta nfproto ipv4 tcp sport 443 ct reply packets '<' 16 queue num 100 bypass comment gov-pass",
+	})
+}
+
+func TestInstallLinuxPackagesTranscript(t *testing.T) {
+	r := useRecordingRunner(t, nil)
+
+	if err := installLinuxPackages("apt-get", "/usr/bin/apt-get", []string{"nftables"}); err != nil {
+		t.Fatalf("installLinuxPackages: %v", err)
+	}
+	assertTranscript(t, r.transcript, []string{
+		"DEBIAN_FRONTEND=noninteractive /usr/bin/apt-get update",
+		"DEBIAN_FRONTEND=noninteractive /usr/bin/apt-get install -y --no-install-recommends nftables",
+	})
+}
+
+func TestDryRunRunner(t *testing.T) {
+	var buf bytes.Buffer
+	dry := newDryRunRunner(&buf)
+	queries := &recordingRunner{replies: map[string]recordedReply{
+		"nft list table inet gov_pass": {err: errors.New("Operation not permitted")},
+	}}
+	dry.query = queries
+	prev := runner
+	runner = dry
+	defer func() { runner = prev }()
+
+	if err := installNftRules("nft", ruleOptions{QueueNum: 100}); err != nil {
+		t.Fatalf("installNftRules: %v", err)
+	}
+	if !isDryRun() || !dryRunf("open NFQUEUE queue=%d", 100) {
+		t.Fatalf("dry-run not active")
+	}
+
+	// Only queries reach the real runner.
+	for _, line := range queries.transcript {
+		if !strings.Contains(line, " list ") {
+			t.Fatalf("mutating command executed in dry-run: %q", line)
+		}
+	}
+	printed := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assertTranscript(t, printed, []string{
+		"dry-run: nft add table inet gov_pass",
+		"dry-run: nft add rule inet gov_pass output meta nfproto ipv4 tcp dport 443 queue num 100 bypass comment gov-pass",
+		"dry-run: open NFQUEUE queue=100",
+	})
+}
+
+func TestIsReadOnlyCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want bool
+	}{
+		{name: "nft", args: []string{"list", "table", "inet", "gov_pass"}, want: true},
+		{name: "/usr/sbin/nft", args: []string{"-a", "list", "chain", "inet", "gov_pass", "output"}, want: true},
+		{name: "nft", args: []string{"add", "table", "inet", "gov_pass"}, want: false},
+		{name: "iptables", args: []string{"-t", "mangle", "-C", "OUTPUT", "-j", "GOVPASS_OUTPUT"}, want: true},
+		{name: "iptables", args: []string{"-t", "mangle", "-S", "OUTPUT"}, want: true},
+		{name: "iptables", args: []string{"-t", "mangle", "-F", "GOVPASS_OUTPUT"}, want: false},
+		{name: "apt-get", args: []string{"update"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := isReadOnlyCommand(tt.name, tt.args); got != tt.want {
+			t.Fatalf("isReadOnlyCommand(%s %v) = %v, want %v", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
@@ -7,11 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/debug"
+
+	"fk-gov/internal/logging"
 )
 
 type serviceRunner func(ctx context.Context, reload <-chan struct{}) error
@@ -79,9 +82,12 @@ func setupServiceLogging(path string) (*os.File, error) {
 			return nil, fmt.Errorf("secure log file failed: %w", err)
 		}
 	}
+	// Until the configuration is loaded, records go through the standard
+	// logger; setupLogging then writes to the same file.
+	logOutput = f
 	log.SetOutput(f)
 	log.SetFlags(log.LstdFlags | log.LUTC)
-	log.Printf("service logging to %s", path)
+	slog.Info("service logging to file", logging.Event(logging.EventService), "path", path)
 	return f, nil
 }
 
@@ -122,22 +128,22 @@ func (s *splitterService) Execute(args []string, r <-chan svc.ChangeRequest, cha
 			case svc.Interrogate:
 				changes <- c.CurrentStatus
 			case svc.ParamChange:
-				log.Printf("service reload requested (paramchange)")
+				slog.Info("service reload requested (paramchange)", logging.Event(logging.EventServiceReload))
 				select {
 				case reloadCh <- struct{}{}:
 				default:
 				}
 			case svc.Stop, svc.Shutdown:
-				log.Printf("service stop requested (%v)", c.Cmd)
+				slog.Info("service stop requested", logging.Event(logging.EventService), "cmd", c.Cmd)
 				changes <- svc.Status{State: svc.StopPending}
 				cancel()
 				err := <-errCh
 				if err != nil && !errors.Is(err, context.Canceled) {
-					log.Printf("service stopped with error: %v", err)
+					slog.Error("service stopped with error", logging.Event(logging.EventService), logging.Err(err))
 					changes <- svc.Status{State: svc.Stopped}
 					return false, 1
 				}
-				log.Printf("service stopped")
+				slog.Info("service stopped", logging.Event(logging.EventService))
 				changes <- svc.Status{State: svc.Stopped}
 				return false, 0
 			default:
@@ -146,11 +152,11 @@ func (s *splitterService) Execute(args []string, r <-chan svc.ChangeRequest, cha
 		case err := <-errCh:
 			cancel()
 			if err != nil && !errors.Is(err, context.Canceled) {
-				log.Printf("service exited with error: %v", err)
+				slog.Error("service exited with error", logging.Event(logging.EventService), logging.Err(err))
 				changes <- svc.Status{State: svc.Stopped}
 				return false, 1
 			}
-			log.Printf("service exited")
+			slog.Info("service exited", logging.Event(logging.EventService))
 			changes <- svc.Status{State: svc.Stopped}
 			return false, 0
 		}
@@ -0,0 +1,56 @@
+//go:build linux || freebsd
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"fk-gov/internal/control"
+	"fk-gov/internal/engine"
+	"fk-gov/internal/logging"
+)
+
+// dumpTraceOnSignal writes the trace to trace.dump_path on traceDumpSignal
+// until ctx is done. SIGUSR1 and SIGUSR2 already pause and resume. The path
+// is read when the signal arrives, so a reload changes it for the next dump.
+func dumpTraceOnSignal(ctx context.Context, eng *engine.Engine, r *reloader) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, traceDumpSignal)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-sig:
+			path := r.Effective().Config.Trace.DumpPath
+			if path == "" {
+				slog.Warn("trace dump requested but trace.dump_path is not set", logging.Event(logging.EventTraceDumpFailed), "signal", traceDumpSignal.String())
+				continue
+			}
+			n, err := dumpTrace(path, control.NewTraceResult(eng.Trace(), 0))
+			if err != nil {
+				slog.Error("trace dump failed", logging.Event(logging.EventTraceDumpFailed), "path", path, logging.Err(err))
+				continue
+			}
+			slog.Info("trace dumped", logging.Event(logging.EventTraceDumped), "path", path, "events", n)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dumpTrace replaces path with the trace in the `splitter ctl trace` format
+// and returns the number of events written. The file is only readable by
+// the splitter's user: it names the hosts that were visited.
+func dumpTrace(path string, res control.TraceResult) (int, error) {
+	if !res.Enabled {
+		return 0, errors.New("tracing is off (set trace.enabled)")
+	}
+	var buf bytes.Buffer
+	writeTrace(&buf, res)
+	return len(res.Events), os.WriteFile(path, buf.Bytes(), 0o600)
+}
@@ -0,0 +1,42 @@
+//go:build linux || freebsd
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fk-gov/internal/control"
+)
+
+func TestDumpTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.txt")
+	if _, err := dumpTrace(path, control.NewTraceResult(nil, 0)); err == nil {
+		t.Fatalf("dump with tracing off succeeded")
+	}
+
+	res := control.TraceResult{Enabled: true, Overwritten: 2, Events: []control.TraceEvent{
+		{Time: time.Unix(1700000000, 0), Worker: 1, Src: "10.0.0.2:40000", Dst: "192.0.2.10:443", Kind: "created", Bytes: 517},
+		{Time: time.Unix(1700000001, 0), Worker: 1, Src: "10.0.0.2:40000", Dst: "192.0.2.10:443", Kind: "fail-open", Detail: "reason=not-tls"},
+	}}
+	n, err := dumpTrace(path, res)
+	if err != nil || n != 2 {
+		t.Fatalf("dump = %d, %v", n, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0] != "# 2 older events overwritten" ||
+		!strings.HasSuffix(lines[1], " worker=1 10.0.0.2:40000 -> 192.0.2.10:443 created bytes=517") ||
+		!strings.HasSuffix(lines[2], " fail-open reason=not-tls") {
+		t.Fatalf("unexpected dump:\n%s", data)
+	}
+	if st, err := os.Stat(path); err != nil || st.Mode().Perm() != 0o600 {
+		t.Fatalf("mode = %v, %v; want 0600", st.Mode(), err)
+	}
+}
@@ -5,12 +5,13 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"fk-gov/internal/driver"
+	"fk-gov/internal/logging"
 )
 
 func ensureWinDivertFiles(ctx context.Context, wc windowsRunConfig, exeDir string) (windowsRunConfig, string, error) {
@@ -34,7 +35,7 @@ func ensureWinDivertFiles(ctx context.Context, wc windowsRunConfig, exeDir strin
 		return wc, driverDir, fmt.Errorf("WinDivert files not found in %s (expected WinDivert.dll and WinDivert64.sys); install them or set --auto-download-windivert=true", driverDir)
 	}
 
-	log.Printf("WinDivert files missing in %s; downloading pinned WinDivert zip", driverDir)
+	slog.Info("WinDivert files missing; downloading pinned WinDivert zip", logging.Event(logging.EventDriverDownload), "dir", driverDir)
 	if err := driver.DownloadWinDivertX64(ctx, driverDir); err == nil {
 		return wc, driverDir, nil
 	} else if requested == "" && os.IsPermission(err) {
@@ -48,7 +49,7 @@ func ensureWinDivertFiles(ctx context.Context, wc windowsRunConfig, exeDir strin
 		if err := ensureSecureWindowsDir(fallback); err != nil {
 			return wc, fallback, fmt.Errorf("secure windivert dir failed: %w", err)
 		}
-		log.Printf("WinDivert download to %s failed (permission); retrying in %s", driverDir, fallback)
+		slog.Warn("WinDivert download failed (permission); retrying in ProgramData", logging.Event(logging.EventDriverDownload), "dir", driverDir, "fallback", fallback)
 		if err2 := driver.DownloadWinDivertX64(ctx, fallback); err2 != nil {
 			return wc, fallback, err2
 		}
@@ -3,7 +3,11 @@ package adapter
 import (
 	"context"
 	"errors"
+	"log/slog"
+	"net/netip"
+	"sync/atomic"
 
+	"fk-gov/internal/logging"
 	"fk-gov/internal/packet"
 )
 
@@ -22,6 +26,56 @@ type Adapter interface {
 
 var ErrNotImplemented = errors.New("adapter not implemented")
 
+// Counters are adapter-level totals since the adapter was opened.
+type Counters struct {
+	// OverflowAccepts counts captured packets passed through unprocessed
+	// because the recv buffer was full.
+	OverflowAccepts uint64
+	// SendErrors counts packets the adapter failed to send: raw socket
+	// injections on Linux, divert and WinDivert sends elsewhere.
+	SendErrors uint64
+	// FlushedPackets counts packets passed through by Flush or while the
+	// adapter was shutting down.
+	FlushedPackets uint64
+}
+
+// CounterSource is implemented by adapters that keep Counters.
+type CounterSource interface {
+	Counters() Counters
+}
+
+// counters implements CounterSource for the adapters that embed it.
+type counters struct {
+	overflowAccepts atomic.Uint64
+	sendErrors      atomic.Uint64
+	flushedPackets  atomic.Uint64
+}
+
+func (c *counters) Counters() Counters {
+	return Counters{
+		OverflowAccepts: c.overflowAccepts.Load(),
+		SendErrors:      c.sendErrors.Load(),
+		FlushedPackets:  c.flushedPackets.Load(),
+	}
+}
+
+// sendFailed counts and logs an IPv4 packet the adapter could not send.
+func (c *counters) sendFailed(data []byte, err error) {
+	c.sendErrors.Add(1)
+	attrs := []any{logging.Event(logging.EventAdapterSendError), logging.Err(err)}
+	if len(data) >= 20 {
+		attrs = append(attrs, slog.Any(logging.KeyDst, netip.AddrFrom4([4]byte(data[16:20]))))
+	}
+	slog.Warn("adapter send failed", attrs...)
+}
+
+// overflowed counts and logs a packet passed through unprocessed because
+// the recv buffer was full.
+func (c *counters) overflowed() {
+	c.overflowAccepts.Add(1)
+	slog.Warn("recv buffer full; packet passed through unsplit", logging.Event(logging.EventAdapterOverflow))
+}
+
 // WinDivertOptions holds optional queue parameters.
 type WinDivertOptions struct {
 	QueueLen  uint64
@@ -37,6 +91,12 @@ type NFQueueOptions struct {
 	Mark        uint32
 }
 
+// NFLogOptions holds NFLOG parameters for Linux.
+type NFLogOptions struct {
+	Group     uint16
+	CopyRange uint32
+}
+
 // StubAdapter is a placeholder until WinDivert integration lands.
 type StubAdapter struct{}
 
@@ -0,0 +1,468 @@
+// Package adaptertest provides Adapter, a scriptable in-memory
+// adapter.Adapter for testing the engine: packets are queued for Recv,
+// every call is recorded in order, faults make chosen calls fail, wait or
+// block, and the adapter follows either NFQUEUE verdict rules or WinDivert
+// reinjection rules so tests can check what would have reached the wire.
+package adaptertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"fk-gov/internal/adapter"
+	"fk-gov/internal/packet"
+)
+
+// Semantics selects how the adapter treats packets it handed to Recv.
+type Semantics uint8
+
+const (
+	// Reinject models WinDivert and divert sockets: a received packet has
+	// left the network stack and only reaches the wire when it is sent;
+	// Drop is a no-op.
+	Reinject Semantics = iota
+	// Verdict models NFQUEUE: a received packet waits in the kernel for
+	// exactly one verdict. Sending it accepts it, dropping it drops it, and
+	// a second verdict fails with ErrVerdict. Packets the engine built are
+	// injected on Send and ignored by Drop.
+	Verdict
+)
+
+func (s Semantics) String() string {
+	switch s {
+	case Reinject:
+		return "reinject"
+	case Verdict:
+		return "verdict"
+	default:
+		return fmt.Sprintf("Semantics(%d)", uint8(s))
+	}
+}
+
+// Op names an adapter method.
+type Op uint8
+
+const (
+	OpRecv Op = iota
+	OpSend
+	OpDrop
+	OpCalcChecksums
+	OpFlush
+	OpClose
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpRecv:
+		return "recv"
+	case OpSend:
+		return "send"
+	case OpDrop:
+		return "drop"
+	case OpCalcChecksums:
+		return "calc-checksums"
+	case OpFlush:
+		return "flush"
+	case OpClose:
+		return "close"
+	default:
+		return fmt.Sprintf("Op(%d)", uint8(o))
+	}
+}
+
+// ErrVerdict is returned under Verdict semantics for a second verdict on a
+// received packet.
+var ErrVerdict = errors.New("adaptertest: packet already has a verdict")
+
+// Call records one adapter call.
+type Call struct {
+	// Seq orders calls across all methods and goroutines, from 0.
+	Seq int
+	Op  Op
+	// Packet is the packet passed in, or returned by Recv; nil for Flush,
+	// Close and a failed Recv.
+	Packet *packet.Packet
+	// Deadline reports whether the call's context had a deadline.
+	Deadline bool
+	// Err is what the call returned.
+	Err error
+}
+
+// Fault changes what matching calls do. Delay is applied first, then
+// Block, then Err is returned; a fault with none of them only counts.
+type Fault struct {
+	Op Op
+	// Match, when set, limits the fault to calls whose packet it accepts.
+	Match func(*packet.Packet) bool
+	// After skips this many matching calls before the fault applies.
+	After int
+	// Count is how many times the fault applies; 0 means every matching
+	// call from then on.
+	Count int
+
+	Delay time.Duration
+	// Block makes the call wait for Release, or for its context to end,
+	// in which case the call returns the context's error.
+	Block bool
+	Err   error
+}
+
+type fault struct {
+	Fault
+	seen, fired int
+}
+
+// Fate is what became of a received packet.
+type Fate uint8
+
+const (
+	// FatePending: neither sent nor dropped yet.
+	FatePending Fate = iota
+	// FateSent: sent (accepted or reinjected), by the engine or by Flush.
+	FateSent
+	// FateDropped: dropped with a verdict (Verdict semantics only).
+	FateDropped
+)
+
+func (f Fate) String() string {
+	switch f {
+	case FatePending:
+		return "pending"
+	case FateSent:
+		return "sent"
+	case FateDropped:
+		return "dropped"
+	default:
+		return fmt.Sprintf("Fate(%d)", uint8(f))
+	}
+}
+
+// Adapter is an in-memory adapter.Adapter. It is safe for concurrent use.
+type Adapter struct {
+	sem Semantics
+
+	mu       sync.Mutex
+	queue    []*packet.Packet
+	inputEnd error
+	// ready is closed and replaced whenever Recv may have something new.
+	ready   chan struct{}
+	release chan struct{}
+	faults  []*fault
+	calls   []Call
+	fates   map[*packet.Packet]Fate
+	// received lists the packets Recv returned, in order.
+	received []*packet.Packet
+	wire     []*packet.Packet
+	closed   bool
+}
+
+var _ adapter.Adapter = (*Adapter)(nil)
+
+// New returns an empty adapter. Recv blocks until packets are queued.
+func New(s Semantics) *Adapter {
+	return &Adapter{
+		sem:     s,
+		ready:   make(chan struct{}),
+		release: make(chan struct{}),
+		fates:   make(map[*packet.Packet]Fate),
+	}
+}
+
+// Queue appends packets for Recv to return in order. Packets without a
+// Source are marked captured.
+func (a *Adapter) Queue(pkts ...*packet.Packet) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, pkt := range pkts {
+		if pkt.Source == packet.SourceUnknown {
+			pkt.Source = packet.SourceCaptured
+		}
+		a.queue = append(a.queue, pkt)
+	}
+	a.wake()
+}
+
+// EndInput makes Recv return err once the queue is empty instead of
+// blocking; a nil err means io.EOF.
+func (a *Adapter) EndInput(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inputEnd = err
+	a.wake()
+}
+
+// wake tells blocked Recv calls to look again. a.mu is held.
+func (a *Adapter) wake() {
+	close(a.ready)
+	a.ready = make(chan struct{})
+}
+
+// Inject adds a fault. Faults are checked in the order they were added and
+// the first one that applies wins.
+func (a *Adapter) Inject(f Fault) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.faults = append(a.faults, &fault{Fault: f})
+}
+
+// Release unblocks every call blocked by a fault, now and later.
+func (a *Adapter) Release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-a.release:
+	default:
+		close(a.release)
+	}
+}
+
+// fault returns the fault that applies to a call, counting it. a.mu is
+// held.
+func (a *Adapter) fault(op Op, pkt *packet.Packet) *Fault {
+	for _, f := range a.faults {
+		if f.Op != op || (f.Match != nil && (pkt == nil || !f.Match(pkt))) {
+			continue
+		}
+		f.seen++
+		if f.seen <= f.After || (f.Count > 0 && f.fired >= f.Count) {
+			continue
+		}
+		f.fired++
+		return &f.Fault
+	}
+	return nil
+}
+
+// apply runs a fault's delay and block and returns its error.
+func (a *Adapter) apply(ctx context.Context, f *Fault) error {
+	if f == nil {
+		return nil
+	}
+	a.mu.Lock()
+	release := a.release
+	a.mu.Unlock()
+	if f.Delay > 0 {
+		t := time.NewTimer(f.Delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+	if f.Block {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return f.Err
+}
+
+// record appends a call and returns err.
+func (a *Adapter) record(ctx context.Context, op Op, pkt *packet.Packet, err error) error {
+	_, deadline := ctx.Deadline()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = append(a.calls, Call{Seq: len(a.calls), Op: op, Packet: pkt, Deadline: deadline, Err: err})
+	return err
+}
+
+func (a *Adapter) Recv(ctx context.Context) (*packet.Packet, error) {
+	a.mu.Lock()
+	f := a.fault(OpRecv, nil)
+	a.mu.Unlock()
+	if err := a.apply(ctx, f); err != nil {
+		return nil, a.record(ctx, OpRecv, nil, err)
+	}
+	for {
+		a.mu.Lock()
+		if len(a.queue) > 0 {
+			pkt := a.queue[0]
+			a.queue = a.queue[1:]
+			a.fates[pkt] = FatePending
+			a.received = append(a.received, pkt)
+			a.mu.Unlock()
+			return pkt, a.record(ctx, OpRecv, pkt, nil)
+		}
+		end, ready := a.inputEnd, a.ready
+		a.mu.Unlock()
+		if end != nil {
+			return nil, a.record(ctx, OpRecv, nil, end)
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, a.record(ctx, OpRecv, nil, ctx.Err())
+		}
+	}
+}
+
+func (a *Adapter) Send(ctx context.Context, pkt *packet.Packet) error {
+	a.mu.Lock()
+	f := a.fault(OpSend, pkt)
+	a.mu.Unlock()
+	if err := a.apply(ctx, f); err != nil {
+		return a.record(ctx, OpSend, pkt, err)
+	}
+	a.mu.Lock()
+	err := a.verdict(pkt, FateSent)
+	if err == nil {
+		a.wire = append(a.wire, pkt)
+	}
+	a.mu.Unlock()
+	return a.record(ctx, OpSend, pkt, err)
+}
+
+func (a *Adapter) Drop(ctx context.Context, pkt *packet.Packet) error {
+	a.mu.Lock()
+	f := a.fault(OpDrop, pkt)
+	a.mu.Unlock()
+	if err := a.apply(ctx, f); err != nil {
+		return a.record(ctx, OpDrop, pkt, err)
+	}
+	a.mu.Lock()
+	var err error
+	if a.sem == Verdict {
+		err = a.verdict(pkt, FateDropped)
+	}
+	a.mu.Unlock()
+	return a.record(ctx, OpDrop, pkt, err)
+}
+
+// verdict settles a received packet. a.mu is held.
+func (a *Adapter) verdict(pkt *packet.Packet, fate Fate) error {
+	cur, received := a.fates[pkt]
+	if !received {
+		return nil
+	}
+	if a.sem == Verdict && cur != FatePending {
+		return ErrVerdict
+	}
+	if cur == FatePending {
+		a.fates[pkt] = fate
+	}
+	return nil
+}
+
+// CalcChecksums fills in the IPv4 and TCP checksums like the real adapters.
+func (a *Adapter) CalcChecksums(pkt *packet.Packet) error {
+	a.mu.Lock()
+	f := a.fault(OpCalcChecksums, pkt)
+	a.mu.Unlock()
+	ctx := context.Background()
+	if err := a.apply(ctx, f); err != nil {
+		return a.record(ctx, OpCalcChecksums, pkt, err)
+	}
+	if pkt != nil && len(pkt.Data) >= 20 {
+		ipHeaderLen := int(pkt.Data[0]&0x0f) * 4
+		if ipHeaderLen >= 20 && len(pkt.Data) >= ipHeaderLen+20 {
+			packet.SetIPv4ChecksumZero(pkt.Data)
+			packet.SetTCPChecksumZero(pkt.Data, ipHeaderLen)
+			packet.SetIPv4Checksum(pkt.Data, packet.IPv4Checksum(pkt.Data, ipHeaderLen))
+			packet.SetTCPChecksum(pkt.Data, ipHeaderLen, packet.TCPChecksumIPv4(pkt.Data, ipHeaderLen))
+		}
+	}
+	return a.record(ctx, OpCalcChecksums, pkt, nil)
+}
+
+// Flush passes the packets still queued for Recv through, as the real
+// adapters do with packets captured but not yet delivered.
+func (a *Adapter) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	f := a.fault(OpFlush, nil)
+	a.mu.Unlock()
+	if err := a.apply(ctx, f); err != nil {
+		return a.record(ctx, OpFlush, nil, err)
+	}
+	a.mu.Lock()
+	for _, pkt := range a.queue {
+		a.fates[pkt] = FateSent
+		a.wire = append(a.wire, pkt)
+	}
+	a.queue = nil
+	a.mu.Unlock()
+	return a.record(ctx, OpFlush, nil, nil)
+}
+
+func (a *Adapter) Close() error {
+	a.mu.Lock()
+	f := a.fault(OpClose, nil)
+	a.mu.Unlock()
+	ctx := context.Background()
+	if err := a.apply(ctx, f); err != nil {
+		return a.record(ctx, OpClose, nil, err)
+	}
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+	return a.record(ctx, OpClose, nil, nil)
+}
+
+// Calls returns every call so far, in order.
+func (a *Adapter) Calls() []Call {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Call(nil), a.calls...)
+}
+
+// Packets returns the packets passed to successful calls of op, in order.
+func (a *Adapter) Packets(op Op) []*packet.Packet {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []*packet.Packet
+	for _, c := range a.calls {
+		if c.Op == op && c.Err == nil && c.Packet != nil {
+			out = append(out, c.Packet)
+		}
+	}
+	return out
+}
+
+// Wire returns the packets that reached the network, in order: accepted or
+// reinjected originals, packets the engine built, and packets Flush passed
+// through.
+func (a *Adapter) Wire() []*packet.Packet {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*packet.Packet(nil), a.wire...)
+}
+
+// Fate reports what became of a packet Recv returned; ok is false for any
+// other packet.
+func (a *Adapter) Fate(pkt *packet.Packet) (fate Fate, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fate, ok = a.fates[pkt]
+	return fate, ok
+}
+
+// Pending returns the received packets that were neither sent nor dropped,
+// in the order they were received. Under Verdict semantics the kernel
+// would still hold them; under Reinject semantics they are lost.
+func (a *Adapter) Pending() []*packet.Packet {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []*packet.Packet
+	for _, pkt := range a.received {
+		if a.fates[pkt] == FatePending {
+			out = append(out, pkt)
+		}
+	}
+	return out
+}
+
+// Closed reports whether Close succeeded.
+func (a *Adapter) Closed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closed
+}
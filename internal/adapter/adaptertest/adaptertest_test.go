@@ -0,0 +1,180 @@
+package adaptertest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"fk-gov/internal/packet"
+)
+
+func recvAll(t *testing.T, a *Adapter, n int) []*packet.Packet {
+	t.Helper()
+	out := make([]*packet.Packet, n)
+	for i := range out {
+		pkt, err := a.Recv(context.Background())
+		if err != nil {
+			t.Fatalf("recv %d: %v", i, err)
+		}
+		out[i] = pkt
+	}
+	return out
+}
+
+func TestVerdictSemantics(t *testing.T) {
+	ctx := context.Background()
+	a := New(Verdict)
+	a.Queue(&packet.Packet{Data: []byte{1}}, &packet.Packet{Data: []byte{2}}, &packet.Packet{Data: []byte{3}})
+	got := recvAll(t, a, 3)
+	built := &packet.Packet{Data: []byte{9}, Source: packet.SourceInjected}
+
+	if err := a.Send(ctx, got[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Send(ctx, built); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Drop(ctx, got[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Send(ctx, got[1]); !errors.Is(err, ErrVerdict) {
+		t.Fatalf("second verdict: %v, want ErrVerdict", err)
+	}
+	if err := a.Drop(ctx, built); err != nil {
+		t.Fatalf("dropping a built packet: %v", err)
+	}
+
+	if fate, _ := a.Fate(got[0]); fate != FateSent {
+		t.Fatalf("first packet %v", fate)
+	}
+	if fate, _ := a.Fate(got[1]); fate != FateDropped {
+		t.Fatalf("second packet %v", fate)
+	}
+	if p := a.Pending(); len(p) != 1 || p[0] != got[2] {
+		t.Fatalf("pending = %v", p)
+	}
+	if w := a.Wire(); len(w) != 2 || w[0] != got[0] || w[1] != built {
+		t.Fatalf("wire = %v", w)
+	}
+	if _, ok := a.Fate(built); ok {
+		t.Fatal("built packet has a fate")
+	}
+}
+
+func TestReinjectSemantics(t *testing.T) {
+	ctx := context.Background()
+	a := New(Reinject)
+	a.Queue(&packet.Packet{Data: []byte{1}}, &packet.Packet{Data: []byte{2}})
+	got := recvAll(t, a, 2)
+
+	// Drop is a no-op: the packet stays pending, which here means lost.
+	if err := a.Drop(ctx, got[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Send(ctx, got[1]); err != nil {
+		t.Fatal(err)
+	}
+	// Reinjecting twice duplicates the packet on the wire.
+	if err := a.Send(ctx, got[1]); err != nil {
+		t.Fatal(err)
+	}
+	if p := a.Pending(); len(p) != 1 || p[0] != got[0] {
+		t.Fatalf("pending = %v", p)
+	}
+	if w := a.Wire(); len(w) != 2 || w[0] != got[1] || w[1] != got[1] {
+		t.Fatalf("wire = %v", w)
+	}
+	if got[0].Source != packet.SourceCaptured {
+		t.Fatalf("queued packet source = %v", got[0].Source)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	ctx := context.Background()
+	errBoom := errors.New("boom")
+	a := New(Reinject)
+	// Fail the second and third sends of packets starting with 7.
+	a.Inject(Fault{Op: OpSend, Match: func(p *packet.Packet) bool { return p.Data[0] == 7 }, After: 1, Count: 2, Err: errBoom})
+
+	var errs []error
+	for _, b := range []byte{7, 1, 7, 7, 7} {
+		errs = append(errs, a.Send(ctx, &packet.Packet{Data: []byte{b}}))
+	}
+	for i, want := range []error{nil, nil, errBoom, errBoom, nil} {
+		if !errors.Is(errs[i], want) {
+			t.Errorf("send %d: %v, want %v", i, errs[i], want)
+		}
+	}
+	if n := len(a.Packets(OpSend)); n != 3 {
+		t.Fatalf("successful sends = %d, want 3", n)
+	}
+	calls := a.Calls()
+	if len(calls) != 5 || calls[2].Seq != 2 || calls[2].Err != errBoom {
+		t.Fatalf("calls = %+v", calls)
+	}
+}
+
+func TestFaults_BlockAndDelay(t *testing.T) {
+	a := New(Verdict)
+	a.Inject(Fault{Op: OpFlush, Block: true})
+	a.Inject(Fault{Op: OpClose, Delay: 10 * time.Millisecond, Count: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := a.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("blocked flush: %v", err)
+	}
+	if c := a.Calls()[0]; c.Op != OpFlush || !c.Deadline {
+		t.Fatalf("call = %+v", c)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- a.Flush(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("flush returned before Release: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	a.Release()
+	if err := <-done; err != nil {
+		t.Fatalf("released flush: %v", err)
+	}
+
+	start := time.Now()
+	if err := a.Close(); err != nil || !a.Closed() {
+		t.Fatalf("close: %v", err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Fatalf("close took %v, want the injected delay", d)
+	}
+}
+
+func TestRecv_QueueFlushAndEnd(t *testing.T) {
+	a := New(Verdict)
+	done := make(chan *packet.Packet, 1)
+	go func() {
+		pkt, _ := a.Recv(context.Background())
+		done <- pkt
+	}()
+	want := &packet.Packet{Data: []byte{1}}
+	a.Queue(want)
+	if got := <-done; got != want {
+		t.Fatalf("blocked Recv got %v", got)
+	}
+
+	late := &packet.Packet{Data: []byte{2}}
+	a.Queue(late)
+	if err := a.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if w := a.Wire(); len(w) != 1 || w[0] != late {
+		t.Fatalf("flush wire = %v", w)
+	}
+
+	a.EndInput(nil)
+	if _, err := a.Recv(context.Background()); err != io.EOF {
+		t.Fatalf("Recv after EndInput: %v", err)
+	}
+}
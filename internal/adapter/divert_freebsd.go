@@ -30,6 +30,8 @@ type DivertAdapter struct {
 	port uint16
 
 	closeOnce sync.Once
+
+	counters
 }
 
 func NewDivert(opts DivertOptions) (*DivertAdapter, error) {
@@ -108,7 +110,11 @@ func (d *DivertAdapter) Send(ctx context.Context, pkt *packet.Packet) error {
 	if err != nil {
 		return err
 	}
-	return unix.Sendto(d.fd, pkt.Data, 0, to)
+	if err := unix.Sendto(d.fd, pkt.Data, 0, to); err != nil {
+		d.sendFailed(pkt.Data, err)
+		return err
+	}
+	return nil
 }
 
 func (d *DivertAdapter) Drop(ctx context.Context, pkt *packet.Packet) error {
@@ -171,8 +177,11 @@ func (d *DivertAdapter) Flush(ctx context.Context) error {
 			continue
 		}
 		if sendErr := unix.Sendto(d.fd, buf[:n], 0, from); sendErr != nil {
+			d.sendErrors.Add(1)
 			errs = append(errs, sendErr)
+			continue
 		}
+		d.flushedPackets.Add(1)
 	}
 
 	if len(errs) > 0 {
@@ -0,0 +1,221 @@
+//go:build linux
+
+package adapter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+
+	"fk-gov/internal/logging"
+	"fk-gov/internal/packet"
+)
+
+// nfnetlink_log message and attribute types, from
+// linux/netfilter/nfnetlink_log.h.
+const (
+	nfnlSubsysULOG = 4
+
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaPayload = 9
+
+	nfulaCfgCmd     = 1
+	nfulaCfgMode    = 2
+	nfulaCfgQThresh = 5
+
+	nfulnlCfgCmdBind = 1
+	nfulnlCopyPacket = 2
+)
+
+// NFLogAdapter receives copies of packets from an NFLOG group. The originals
+// have already gone on their way, so Send and Drop do nothing: it feeds an
+// engine running in shadow mode and never delays traffic.
+type NFLogAdapter struct {
+	conn  *netlink.Conn
+	group uint16
+	recv  chan *packet.Packet
+	errs  chan error
+	ctx   context.Context
+	stop  context.CancelFunc
+	done  chan struct{}
+
+	closeOnce sync.Once
+
+	counters
+}
+
+func NewNFLog(opts NFLogOptions) (*NFLogAdapter, error) {
+	copyRange := opts.CopyRange
+	if copyRange == 0 {
+		copyRange = nfqueueMaxPacket
+	}
+
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetOption(netlink.NoENOBUFS, true); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ad := &NFLogAdapter{
+		conn:  conn,
+		group: opts.Group,
+		recv:  make(chan *packet.Packet, 1024),
+		errs:  make(chan error, 1),
+		ctx:   ctx,
+		stop:  cancel,
+		done:  make(chan struct{}),
+	}
+
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode, copyRange)
+	mode[4] = nfulnlCopyPacket
+	qthresh := make([]byte, 4)
+	// Deliver every packet as it is logged instead of batching them, so
+	// the engine sees them with their real spacing.
+	binary.BigEndian.PutUint32(qthresh, 1)
+	for _, attrs := range [][]netlink.Attribute{
+		{{Type: nfulaCfgCmd, Data: []byte{nfulnlCfgCmdBind}}},
+		{{Type: nfulaCfgMode, Data: mode}, {Type: nfulaCfgQThresh, Data: qthresh}},
+	} {
+		if err := ad.configure(attrs); err != nil {
+			cancel()
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	go ad.readLoop()
+	return ad, nil
+}
+
+// configure sends a config message for the adapter's group.
+func (n *NFLogAdapter) configure(attrs []netlink.Attribute) error {
+	data, err := netlink.MarshalAttributes(attrs)
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(nfnlSubsysULOG<<8 | nfulnlMsgConfig),
+			Flags: netlink.Request | netlink.Acknowledge,
+		},
+		Data: append(nfgenHeader(unix.AF_INET, n.group), data...),
+	})
+	return err
+}
+
+// nfgenHeader is the nfgenmsg that starts every nfnetlink message.
+func nfgenHeader(family uint8, resID uint16) []byte {
+	return []byte{family, unix.NFNETLINK_V0, byte(resID >> 8), byte(resID)}
+}
+
+func (n *NFLogAdapter) readLoop() {
+	defer close(n.done)
+	for {
+		msgs, err := n.conn.Receive()
+		if err != nil {
+			if n.ctx.Err() != nil {
+				return
+			}
+			var opErr *netlink.OpError
+			if errors.As(err, &opErr) && opErr.Timeout() {
+				continue
+			}
+			slog.Error("NFLOG receive failed", logging.Event(logging.EventAdapterError), logging.Err(err))
+			select {
+			case n.errs <- err:
+			default:
+			}
+			return
+		}
+		for _, m := range msgs {
+			payload, ok := nflogPayload(m)
+			if !ok {
+				continue
+			}
+			pkt := &packet.Packet{
+				Data:   payload,
+				Source: packet.SourceCaptured,
+			}
+			select {
+			case n.recv <- pkt:
+			default:
+				// The original is already on the wire; only the copy is
+				// lost.
+				n.overflowed()
+			}
+		}
+	}
+}
+
+// nflogPayload returns a copy of the packet carried by an NFLOG packet
+// message.
+func nflogPayload(m netlink.Message) ([]byte, bool) {
+	if m.Header.Type != netlink.HeaderType(nfnlSubsysULOG<<8|nfulnlMsgPacket) || len(m.Data) < 4 {
+		return nil, false
+	}
+	ad, err := netlink.NewAttributeDecoder(m.Data[4:])
+	if err != nil {
+		return nil, false
+	}
+	for ad.Next() {
+		if ad.Type() == nfulaPayload {
+			return append([]byte(nil), ad.Bytes()...), true
+		}
+	}
+	return nil, false
+}
+
+func (n *NFLogAdapter) Recv(ctx context.Context) (*packet.Packet, error) {
+	select {
+	case pkt := <-n.recv:
+		return pkt, nil
+	case err := <-n.errs:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.ctx.Done():
+		return nil, n.ctx.Err()
+	}
+}
+
+// Send does nothing: the kernel passed the original on when it logged it.
+func (n *NFLogAdapter) Send(ctx context.Context, pkt *packet.Packet) error {
+	return nil
+}
+
+// Drop does nothing: a logged packet cannot be taken back.
+func (n *NFLogAdapter) Drop(ctx context.Context, pkt *packet.Packet) error {
+	return nil
+}
+
+func (n *NFLogAdapter) CalcChecksums(pkt *packet.Packet) error {
+	return nil
+}
+
+// Flush has nothing to release; it only stops taking new copies.
+func (n *NFLogAdapter) Flush(ctx context.Context) error {
+	n.stop()
+	return nil
+}
+
+func (n *NFLogAdapter) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		n.stop()
+		err = n.conn.Close()
+		<-n.done
+	})
+	return err
+}
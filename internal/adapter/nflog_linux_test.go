@@ -0,0 +1,39 @@
+//go:build linux
+
+package adapter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestNFLogPayload(t *testing.T) {
+	ip := []byte{0x45, 0, 0, 20, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	attrs, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: 1, Data: []byte{0x08, 0x00, 3, 0}}, // NFULA_PACKET_HDR
+		{Type: nfulaPayload, Data: ip},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := netlink.Message{
+		Header: netlink.Header{Type: netlink.HeaderType(nfnlSubsysULOG<<8 | nfulnlMsgPacket)},
+		Data:   append(nfgenHeader(unix.AF_INET, 5), attrs...),
+	}
+	got, ok := nflogPayload(m)
+	if !ok || !bytes.Equal(got, ip) {
+		t.Fatalf("payload = %x, %v; want %x", got, ok, ip)
+	}
+	got[0] = 0
+	if m.Data[len(m.Data)-len(ip)] != 0x45 {
+		t.Fatal("payload aliases the netlink buffer")
+	}
+
+	m.Header.Type = netlink.HeaderType(nfnlSubsysULOG<<8 | nfulnlMsgConfig)
+	if _, ok := nflogPayload(m); ok {
+		t.Fatal("config message decoded as a packet")
+	}
+}
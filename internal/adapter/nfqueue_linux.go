@@ -5,6 +5,7 @@ package adapter
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/mdlayher/netlink"
 	"golang.org/x/sys/unix"
 
+	"fk-gov/internal/logging"
 	"fk-gov/internal/packet"
 )
 
@@ -33,6 +35,8 @@ type NFQueueAdapter struct {
 
 	flushing atomic.Bool
 	inFlight atomic.Int32
+
+	counters
 }
 
 func NewNFQueue(opts NFQueueOptions) (*NFQueueAdapter, error) {
@@ -172,7 +176,9 @@ func (n *NFQueueAdapter) Flush(ctx context.Context) error {
 			}
 			if err := n.setVerdict(pkt.NFQID, nfqueue.NfAccept); err != nil {
 				errs = append(errs, err)
+				continue
 			}
+			n.flushedPackets.Add(1)
 		default:
 			if len(errs) > 0 {
 				return errors.Join(errs...)
@@ -212,7 +218,9 @@ func (n *NFQueueAdapter) onPacket(a nfqueue.Attribute) int {
 
 	// If we're flushing/shutting down, do not enqueue. Immediately fail-open.
 	if n.flushing.Load() || n.ctx.Err() != nil {
-		_ = n.setVerdict(id, nfqueue.NfAccept)
+		if n.setVerdict(id, nfqueue.NfAccept) == nil {
+			n.flushedPackets.Add(1)
+		}
 		return 0
 	}
 
@@ -228,6 +236,7 @@ func (n *NFQueueAdapter) onPacket(a nfqueue.Attribute) int {
 		return 0
 	default:
 		_ = n.setVerdict(id, nfqueue.NfAccept)
+		n.overflowed()
 		return 0
 	}
 }
@@ -238,6 +247,7 @@ func (n *NFQueueAdapter) onError(err error) int {
 			return 0
 		}
 	}
+	slog.Error("NFQUEUE receive failed", logging.Event(logging.EventAdapterError), logging.Err(err))
 	select {
 	case n.errs <- err:
 	default:
@@ -281,5 +291,9 @@ func (n *NFQueueAdapter) inject(pkt *packet.Packet) error {
 
 	var dst unix.SockaddrInet4
 	copy(dst.Addr[:], pkt.Data[16:20])
-	return unix.Sendto(n.rawFD, pkt.Data, 0, &dst)
+	if err := unix.Sendto(n.rawFD, pkt.Data, 0, &dst); err != nil {
+		n.sendFailed(pkt.Data, err)
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,323 @@
+package adapter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"fk-gov/internal/clock"
+	"fk-gov/internal/flow"
+	"fk-gov/internal/packet"
+	"fk-gov/internal/pcapng"
+	"fk-gov/internal/tls"
+)
+
+// Interfaces of a replay output file.
+const (
+	ReplayIfaceSent    = 0
+	ReplayIfaceDropped = 1
+)
+
+// ReplayOptions configures a ReplayAdapter.
+type ReplayOptions struct {
+	// Output receives a pcapng file of every sent and dropped packet; nil
+	// writes nothing.
+	Output io.Writer
+	// Virtual runs the adapter's Clock on the capture's timeline: it reads
+	// as the timestamp of the packet last returned by Recv, and timers fire
+	// as the capture reaches them. Pass Clock to Engine.SetClock so collect
+	// timeouts, idle expiry and GC replay as they were recorded, however
+	// fast the file is read.
+	Virtual bool
+}
+
+// ReplayAdapter feeds the IPv4 packets of a pcap or pcapng file to the
+// engine and records what it sends and drops, so the engine can run against
+// recorded traffic without privileges or a network. Recv returns io.EOF
+// after the last packet.
+type ReplayAdapter struct {
+	rd *pcapng.Reader
+	// ahead is the first packet, read early to start the virtual clock.
+	ahead *pcapng.Packet
+	// clock is nil unless the clock is virtual.
+	clock *clock.Manual
+	// barrier is set by SetSync.
+	barrier func(context.Context) error
+
+	mu        sync.Mutex
+	out       *pcapng.Writer
+	delivered int
+	skipped   int
+	flows     map[flow.Key]*ReplayFlow
+	order     []flow.Key
+}
+
+// ReplayFlow counts the packets of one port-443 flow. ServerName is only
+// known when a single packet carried the whole ClientHello.
+type ReplayFlow struct {
+	Key        flow.Key
+	Received   int
+	Sent       int
+	Dropped    int
+	ServerName string
+}
+
+func NewReplay(in io.Reader, opts ReplayOptions) (*ReplayAdapter, error) {
+	rd, err := pcapng.NewReader(in)
+	if err != nil {
+		return nil, err
+	}
+	r := &ReplayAdapter{rd: rd, flows: make(map[flow.Key]*ReplayFlow)}
+	if opts.Virtual {
+		var start time.Time
+		p, err := rd.Next()
+		switch {
+		case err == nil:
+			r.ahead, start = &p, p.Time
+		case !errors.Is(err, io.EOF):
+			return nil, err
+		}
+		r.clock = clock.NewManual(start)
+	}
+	if opts.Output != nil {
+		w, err := pcapng.NewWriter(opts.Output, "gov-pass splitter replay")
+		if err == nil {
+			err = w.AddInterface("sent", pcapng.LinkTypeRaw, 0)
+		}
+		if err == nil {
+			err = w.AddInterface("dropped", pcapng.LinkTypeRaw, 0)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.out = w
+	}
+	return r, nil
+}
+
+// SetSync sets a function Recv calls before returning each packet after the
+// first and before reporting the end of the input. Passing Engine.Sync makes
+// every packet see the effects of the ones before it, so the output does not
+// depend on how fast the workers run.
+func (r *ReplayAdapter) SetSync(fn func(context.Context) error) {
+	r.barrier = fn
+}
+
+// Clock is the replay's clock: the capture's timeline with a virtual clock,
+// the wall clock otherwise.
+func (r *ReplayAdapter) Clock() clock.Clock {
+	if r.clock == nil {
+		return clock.Wall{}
+	}
+	return r.clock
+}
+
+func (r *ReplayAdapter) next() (pcapng.Packet, error) {
+	if p := r.ahead; p != nil {
+		r.ahead = nil
+		return *p, nil
+	}
+	return r.rd.Next()
+}
+
+func (r *ReplayAdapter) Recv(ctx context.Context) (*packet.Packet, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p, err := r.next()
+		if errors.Is(err, io.EOF) {
+			if err := r.settle(ctx); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, ok := ipv4Packet(p.LinkType, p.Data)
+		if !ok {
+			r.mu.Lock()
+			r.skipped++
+			r.mu.Unlock()
+			continue
+		}
+		if r.delivered > 0 {
+			if err := r.settle(ctx); err != nil {
+				return nil, err
+			}
+		}
+		// Timers fire only once the packets before them were handled, and
+		// what they trigger is handled before this packet.
+		if r.clock != nil && p.Time.After(r.clock.Now()) {
+			r.clock.Set(p.Time)
+			if err := r.settle(ctx); err != nil {
+				return nil, err
+			}
+		}
+		pkt := &packet.Packet{Data: data, Source: packet.SourceCaptured}
+		r.mu.Lock()
+		r.delivered++
+		if f := r.flow(data); f != nil {
+			f.Received++
+		}
+		r.mu.Unlock()
+		return pkt, nil
+	}
+}
+
+func (r *ReplayAdapter) settle(ctx context.Context) error {
+	if r.barrier == nil {
+		return nil
+	}
+	return r.barrier(ctx)
+}
+
+func (r *ReplayAdapter) Send(ctx context.Context, pkt *packet.Packet) error {
+	return r.record(ReplayIfaceSent, pkt)
+}
+
+func (r *ReplayAdapter) Drop(ctx context.Context, pkt *packet.Packet) error {
+	return r.record(ReplayIfaceDropped, pkt)
+}
+
+func (r *ReplayAdapter) record(iface uint32, pkt *packet.Packet) error {
+	if pkt == nil {
+		return nil
+	}
+	ts := r.Clock().Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f := r.flow(pkt.Data); f != nil {
+		if iface == ReplayIfaceSent {
+			f.Sent++
+		} else {
+			f.Dropped++
+		}
+	}
+	if r.out == nil {
+		return nil
+	}
+	return r.out.WritePacket(iface, ts, pkt.Data, len(pkt.Data), "")
+}
+
+// flow returns the counters of the port-443 flow data belongs to, creating
+// them for a new flow, or nil for other packets. r.mu is held.
+func (r *ReplayAdapter) flow(data []byte) *ReplayFlow {
+	pkt := &packet.Packet{Data: data}
+	if packet.DecodeIPv4TCP(pkt) != nil || pkt.Meta.DstPort != 443 {
+		return nil
+	}
+	key := flow.KeyFromMeta(pkt.Meta)
+	f, ok := r.flows[key]
+	if !ok {
+		f = &ReplayFlow{Key: key}
+		r.flows[key] = f
+		r.order = append(r.order, key)
+	}
+	if f.ServerName == "" {
+		if name, ok := tls.ServerName(pkt.Payload()); ok {
+			f.ServerName = name
+		}
+	}
+	return f
+}
+
+// Flows returns the port-443 flows seen so far in the order they first
+// appeared.
+func (r *ReplayAdapter) Flows() []ReplayFlow {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]ReplayFlow, len(r.order))
+	for i, key := range r.order {
+		out[i] = *r.flows[key]
+	}
+	return out
+}
+
+// Skipped is the number of input packets that were not IPv4.
+func (r *ReplayAdapter) Skipped() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.skipped
+}
+
+func (r *ReplayAdapter) CalcChecksums(pkt *packet.Packet) error {
+	if pkt == nil || len(pkt.Data) < 20 {
+		return nil
+	}
+	ipHeaderLen := int(pkt.Data[0]&0x0f) * 4
+	if ipHeaderLen < 20 || len(pkt.Data) < ipHeaderLen+20 {
+		return nil
+	}
+	packet.SetIPv4ChecksumZero(pkt.Data)
+	packet.SetTCPChecksumZero(pkt.Data, ipHeaderLen)
+
+	ipSum := packet.IPv4Checksum(pkt.Data, ipHeaderLen)
+	tcpSum := packet.TCPChecksumIPv4(pkt.Data, ipHeaderLen)
+	packet.SetIPv4Checksum(pkt.Data, ipSum)
+	packet.SetTCPChecksum(pkt.Data, ipHeaderLen, tcpSum)
+	return nil
+}
+
+// Flush has nothing to release: every packet Recv returned is already the
+// engine's.
+func (r *ReplayAdapter) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close leaves the input and output to their owner.
+func (r *ReplayAdapter) Close() error {
+	return nil
+}
+
+// ipv4Packet strips the link-layer header of a captured frame, returning
+// the IPv4 packet it carries.
+func ipv4Packet(linkType uint16, data []byte) ([]byte, bool) {
+	switch linkType {
+	case pcapng.LinkTypeRaw, pcapng.LinkTypeIPv4:
+	case pcapng.LinkTypeNull, pcapng.LinkTypeLoop:
+		// A 4-byte address family in the writer's byte order (NULL) or
+		// network order (LOOP); AF_INET is 2 everywhere.
+		if len(data) < 4 || (binary.LittleEndian.Uint32(data) != 2 && binary.BigEndian.Uint32(data) != 2) {
+			return nil, false
+		}
+		data = data[4:]
+	case pcapng.LinkTypeEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType, off := binary.BigEndian.Uint16(data[12:]), 14
+		// Skip 802.1Q and 802.1ad tags.
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= off+4 {
+			etherType, off = binary.BigEndian.Uint16(data[off+2:]), off+4
+		}
+		if etherType != 0x0800 {
+			return nil, false
+		}
+		data = data[off:]
+	case pcapng.LinkTypeLinuxSLL:
+		if len(data) < 16 || binary.BigEndian.Uint16(data[14:]) != 0x0800 {
+			return nil, false
+		}
+		data = data[16:]
+	case pcapng.LinkTypeLinuxSLL2:
+		if len(data) < 20 || binary.BigEndian.Uint16(data) != 0x0800 {
+			return nil, false
+		}
+		data = data[20:]
+	default:
+		return nil, false
+	}
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return nil, false
+	}
+	// Drop link-layer padding after the IP packet.
+	if total := int(binary.BigEndian.Uint16(data[2:])); total >= 20 && total < len(data) {
+		data = data[:total]
+	}
+	return data, true
+}
@@ -0,0 +1,167 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"fk-gov/internal/packet"
+	"fk-gov/internal/pcapng"
+)
+
+var _ Adapter = (*ReplayAdapter)(nil)
+
+// tcpIPv4 is an IPv4/TCP packet from port sport to 443 with payload.
+func tcpIPv4(sport uint16, payload []byte) []byte {
+	b := make([]byte, 40, 40+len(payload))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:], uint16(40+len(payload)))
+	b[8], b[9] = 64, 6
+	copy(b[12:], []byte{10, 0, 0, 1})
+	copy(b[16:], []byte{203, 0, 113, 7})
+	binary.BigEndian.PutUint16(b[20:], sport)
+	binary.BigEndian.PutUint16(b[22:], 443)
+	b[32] = 5 << 4
+	b[33] = packet.TCPFlagACK | packet.TCPFlagPSH
+	return append(b, payload...)
+}
+
+func ethernet(ip []byte) []byte {
+	frame := make([]byte, 14, 14+len(ip)+4)
+	binary.BigEndian.PutUint16(frame[12:], 0x0800)
+	// Trailing padding must not reach the engine.
+	return append(append(frame, ip...), 0, 0, 0, 0)
+}
+
+func TestReplayAdapter(t *testing.T) {
+	var in bytes.Buffer
+	w, err := pcapng.NewWriter(&in, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddInterface("eth0", pcapng.LinkTypeEthernet, 0); err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1700000000, 0)
+	arp := make([]byte, 42)
+	binary.BigEndian.PutUint16(arp[12:], 0x0806)
+	for i, frame := range [][]byte{ethernet(tcpIPv4(40000, []byte("abc"))), arp, ethernet(tcpIPv4(40000, []byte("de")))} {
+		if err := w.WritePacket(0, t0.Add(time.Duration(i)*time.Second), frame, len(frame), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	r, err := NewReplay(&in, ReplayOptions{Output: &out, Virtual: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncs := 0
+	r.SetSync(func(context.Context) error { syncs++; return nil })
+	ctx := context.Background()
+
+	first, err := r.Recv(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Data, tcpIPv4(40000, []byte("abc"))) {
+		t.Fatalf("first packet = %x", first.Data)
+	}
+	if !r.Clock().Now().Equal(t0) {
+		t.Fatalf("Now = %v, want %v", r.Clock().Now(), t0)
+	}
+	timer := r.Clock().NewTimer(time.Second)
+	second, err := r.Recv(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Recv skips the ARP frame and leaves decoding to the engine.
+	if !bytes.Equal(second.Data, tcpIPv4(40000, []byte("de"))) || second.Meta.PayloadOffset != 0 {
+		t.Fatalf("second packet = %x, %+v", second.Data, second.Meta)
+	}
+	if !r.Clock().Now().Equal(t0.Add(2 * time.Second)) {
+		t.Fatalf("Now = %v, want the third packet's time", r.Clock().Now())
+	}
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("timer did not fire when the capture passed it")
+	}
+	if _, err := r.Recv(ctx); err != io.EOF {
+		t.Fatalf("Recv at end: %v, want io.EOF", err)
+	}
+	// One sync before the second packet, one after its time fired the
+	// timer, one at the end.
+	if syncs != 3 || r.Skipped() != 1 {
+		t.Fatalf("syncs = %d, skipped = %d; want 3 and 1", syncs, r.Skipped())
+	}
+
+	if err := r.Send(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Drop(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	flows := r.Flows()
+	if len(flows) != 1 || flows[0].Received != 2 || flows[0].Sent != 1 || flows[0].Dropped != 1 || flows[0].Key.SrcPort != 40000 {
+		t.Fatalf("flows = %+v", flows)
+	}
+
+	rd, err := pcapng.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range [][]byte{first.Data, second.Data} {
+		p, err := rd.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Interface != uint32(i) || p.LinkType != pcapng.LinkTypeRaw || !bytes.Equal(p.Data, want) || !p.Time.Equal(t0.Add(2*time.Second)) {
+			t.Fatalf("output packet %d = %+v", i, p)
+		}
+	}
+}
+
+func TestIPv4Packet(t *testing.T) {
+	ip := tcpIPv4(1, nil)
+	sll := append(make([]byte, 16), ip...)
+	binary.BigEndian.PutUint16(sll[14:], 0x0800)
+	sll2 := append(make([]byte, 20), ip...)
+	binary.BigEndian.PutUint16(sll2, 0x0800)
+	vlan := append(make([]byte, 18), ip...)
+	binary.BigEndian.PutUint16(vlan[12:], 0x8100)
+	binary.BigEndian.PutUint16(vlan[16:], 0x0800)
+	null := append([]byte{2, 0, 0, 0}, ip...)
+	loop := append([]byte{0, 0, 0, 2}, ip...)
+
+	for _, tc := range []struct {
+		name     string
+		linkType uint16
+		frame    []byte
+	}{
+		{"raw", pcapng.LinkTypeRaw, ip},
+		{"ipv4", pcapng.LinkTypeIPv4, ip},
+		{"ethernet", pcapng.LinkTypeEthernet, ethernet(ip)},
+		{"vlan", pcapng.LinkTypeEthernet, vlan},
+		{"sll", pcapng.LinkTypeLinuxSLL, sll},
+		{"sll2", pcapng.LinkTypeLinuxSLL2, sll2},
+		{"null", pcapng.LinkTypeNull, null},
+		{"loop", pcapng.LinkTypeLoop, loop},
+	} {
+		got, ok := ipv4Packet(tc.linkType, tc.frame)
+		if !ok || !bytes.Equal(got, ip) {
+			t.Errorf("%s: got %x, %v", tc.name, got, ok)
+		}
+	}
+	ipv6 := append(make([]byte, 14), 0x60)
+	binary.BigEndian.PutUint16(ipv6[12:], 0x86dd)
+	if _, ok := ipv4Packet(pcapng.LinkTypeEthernet, ipv6); ok {
+		t.Error("IPv6 frame accepted")
+	}
+	if _, ok := ipv4Packet(147, ip); ok {
+		t.Error("unknown link type accepted")
+	}
+}
@@ -33,6 +33,8 @@ type WinDivertAdapter struct {
 	done chan struct{}
 
 	closeOnce sync.Once
+
+	counters
 }
 
 var (
@@ -110,7 +112,9 @@ func (w *WinDivertAdapter) Send(ctx context.Context, pkt *packet.Packet) error {
 		uintptr(unsafe.Pointer(&pkt.Addr)),
 	)
 	if r1 == 0 {
-		return os.NewSyscallError("WinDivertSend", err)
+		err = os.NewSyscallError("WinDivertSend", err)
+		w.sendFailed(pkt.Data, err)
+		return err
 	}
 	return nil
 }
@@ -212,9 +216,13 @@ func (w *WinDivertAdapter) Flush(ctx context.Context) error {
 			if pkt == nil {
 				continue
 			}
-			if err := w.Send(context.Background(), pkt); err != nil && firstErr == nil {
-				firstErr = err
+			if err := w.Send(context.Background(), pkt); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
 			}
+			w.flushedPackets.Add(1)
 		default:
 			if firstErr == nil && ctx.Err() != nil {
 				return ctx.Err()
@@ -301,8 +309,10 @@ func (w *WinDivertAdapter) startRecvLoop() {
 					uintptr(unsafe.Pointer(&addr)),
 				)
 				if r2 == 0 {
+					w.sendErrors.Add(1)
 					return
 				}
+				w.flushedPackets.Add(1)
 				return
 			}
 
@@ -317,15 +327,18 @@ func (w *WinDivertAdapter) startRecvLoop() {
 					uintptr(unsafe.Pointer(&addr)),
 				)
 				if r2 == 0 {
+					err := os.NewSyscallError("WinDivertSend", sendErr)
+					w.sendFailed(buf[:recvLen], err)
 					if w.ctx.Err() != nil {
 						return
 					}
 					select {
-					case w.errs <- os.NewSyscallError("WinDivertSend", sendErr):
+					case w.errs <- err:
 					default:
 					}
 					return
 				}
+				w.overflowed()
 				continue
 			}
 
@@ -350,8 +363,10 @@ func (w *WinDivertAdapter) startRecvLoop() {
 					uintptr(unsafe.Pointer(&addr)),
 				)
 				if r2 == 0 {
+					w.sendErrors.Add(1)
 					return
 				}
+				w.flushedPackets.Add(1)
 				return
 			default:
 				// Channel filled after the check above; fail-open by reinjecting.
@@ -364,15 +379,18 @@ func (w *WinDivertAdapter) startRecvLoop() {
 					uintptr(unsafe.Pointer(&addr)),
 				)
 				if r2 == 0 {
+					err := os.NewSyscallError("WinDivertSend", sendErr)
+					w.sendFailed(payload, err)
 					if w.ctx.Err() != nil {
 						return
 					}
 					select {
-					case w.errs <- os.NewSyscallError("WinDivertSend", sendErr):
+					case w.errs <- err:
 					default:
 					}
 					return
 				}
+				w.overflowed()
 			}
 		}
 	}()
@@ -0,0 +1,168 @@
+// Package clock abstracts the time source and timers so code that measures
+// timeouts can be driven by a fake clock in tests and offline replay.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock reads the time and creates timers.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a timer that sends the time on its channel once d
+	// has passed.
+	NewTimer(d time.Duration) Timer
+	// AfterFunc calls f once d has passed. The returned timer has no
+	// channel.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the part of time.Timer the engine uses.
+type Timer interface {
+	// C is the channel the time is sent on, nil for AfterFunc timers.
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Wall is the real clock.
+type Wall struct{}
+
+func (Wall) Now() time.Time { return time.Now() }
+
+func (Wall) NewTimer(d time.Duration) Timer { return wallTimer{time.NewTimer(d)} }
+
+func (Wall) AfterFunc(d time.Duration, f func()) Timer {
+	return wallTimer{time.AfterFunc(d, f)}
+}
+
+type wallTimer struct{ t *time.Timer }
+
+func (w wallTimer) C() <-chan time.Time        { return w.t.C }
+func (w wallTimer) Stop() bool                 { return w.t.Stop() }
+func (w wallTimer) Reset(d time.Duration) bool { return w.t.Reset(d) }
+
+// Manual is a Clock that only moves when told to. Timers fire from Advance
+// and Set, in deadline order, on the caller's goroutine: channel timers get
+// a non-blocking send like time.Timer, AfterFunc callbacks run before
+// Advance returns.
+type Manual struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers map[*manualTimer]struct{}
+}
+
+// NewManual returns a Manual clock reading start.
+func NewManual(start time.Time) *Manual {
+	return &Manual{now: start, timers: make(map[*manualTimer]struct{})}
+}
+
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+// Advance moves the clock forward by d.
+func (m *Manual) Advance(d time.Duration) {
+	m.mu.Lock()
+	t := m.now.Add(d)
+	m.mu.Unlock()
+	m.Set(t)
+}
+
+// Set moves the clock to t and fires the timers that are due. A t before
+// the current time is ignored: the clock never goes backwards.
+func (m *Manual) Set(t time.Time) {
+	m.mu.Lock()
+	if t.After(m.now) {
+		m.now = t
+	}
+	now := m.now
+	var due []*manualTimer
+	for mt := range m.timers {
+		if !mt.when.After(now) {
+			due = append(due, mt)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].when.Equal(due[j].when) {
+			return due[i].when.Before(due[j].when)
+		}
+		return due[i].seq < due[j].seq
+	})
+	for _, mt := range due {
+		delete(m.timers, mt)
+	}
+	m.mu.Unlock()
+
+	for _, mt := range due {
+		if mt.f != nil {
+			mt.f()
+			continue
+		}
+		select {
+		case mt.c <- now:
+		default:
+		}
+	}
+}
+
+// Timers is the number of timers that have not fired or been stopped.
+func (m *Manual) Timers() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.timers)
+}
+
+func (m *Manual) NewTimer(d time.Duration) Timer {
+	return m.add(&manualTimer{m: m, c: make(chan time.Time, 1)}, d)
+}
+
+func (m *Manual) AfterFunc(d time.Duration, f func()) Timer {
+	return m.add(&manualTimer{m: m, f: f}, d)
+}
+
+func (m *Manual) add(mt *manualTimer, d time.Duration) *manualTimer {
+	mt.Reset(d)
+	return mt
+}
+
+type manualTimer struct {
+	m    *Manual
+	c    chan time.Time
+	f    func()
+	when time.Time
+	seq  uint64
+}
+
+func (mt *manualTimer) C() <-chan time.Time { return mt.c }
+
+func (mt *manualTimer) Stop() bool {
+	mt.m.mu.Lock()
+	defer mt.m.mu.Unlock()
+	_, active := mt.m.timers[mt]
+	delete(mt.m.timers, mt)
+	return active
+}
+
+// Reset rearms the timer d after the clock's current time. As with
+// time.Timer, a channel timer should be stopped and drained first.
+func (mt *manualTimer) Reset(d time.Duration) bool {
+	m := mt.m
+	m.mu.Lock()
+	_, active := m.timers[mt]
+	m.seq++
+	mt.when, mt.seq = m.now.Add(d), m.seq
+	m.timers[mt] = struct{}{}
+	m.mu.Unlock()
+	// A timer that is already due fires as time.Timer would, without
+	// waiting for the clock to move.
+	if d <= 0 {
+		m.Set(time.Time{})
+	}
+	return active
+}
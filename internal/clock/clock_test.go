@@ -0,0 +1,58 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func fired(t Timer) bool {
+	select {
+	case <-t.C():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestManual_Timers(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	m := NewManual(start)
+	var order []string
+	m.AfterFunc(2*time.Second, func() { order = append(order, "b") })
+	m.AfterFunc(time.Second, func() { order = append(order, "a") })
+	tm := m.NewTimer(3 * time.Second)
+	stopped := m.NewTimer(time.Second)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop should report an active timer once")
+	}
+
+	m.Advance(2 * time.Second)
+	if len(order) != 2 || order[0] != "a" || order[1] != "b" {
+		t.Fatalf("callbacks ran as %v, want [a b]", order)
+	}
+	if fired(tm) || fired(stopped) {
+		t.Fatal("timer fired early or after Stop")
+	}
+	m.Advance(time.Second)
+	if !fired(tm) {
+		t.Fatal("timer did not fire at its deadline")
+	}
+	if m.Timers() != 0 {
+		t.Fatalf("%d timers left", m.Timers())
+	}
+
+	// Reset arms the timer relative to the clock's time.
+	tm.Reset(time.Second)
+	m.Set(start)
+	if !m.Now().Equal(start.Add(3 * time.Second)) {
+		t.Fatalf("Set moved the clock back to %v", m.Now())
+	}
+	m.Advance(time.Second)
+	if !fired(tm) {
+		t.Fatal("reset timer did not fire")
+	}
+
+	if !fired(m.NewTimer(0)) {
+		t.Fatal("a zero timer should fire at once")
+	}
+}
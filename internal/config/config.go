@@ -0,0 +1,328 @@
+// Package config is the splitter configuration shared by all platforms: one
+// versioned JSON schema, a superset of the original Windows config.json,
+// layered as defaults < config file (+ conf.d drop-ins) < GOV_PASS_*
+// environment < command-line flags.
+package config
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"strings"
+	"time"
+
+	"fk-gov/internal/engine"
+	"fk-gov/internal/pcapng"
+	"fk-gov/internal/trace"
+)
+
+// CurrentVersion is the schema version written by this build. Version 0 is
+// the unversioned Windows config.json that predates the shared schema; it
+// uses the same keys and is still accepted.
+const CurrentVersion = 1
+
+const (
+	PlatformLinux   = "linux"
+	PlatformWindows = "windows"
+	PlatformFreeBSD = "freebsd"
+)
+
+// Config is the full schema. Only the engine section and the section of the
+// running platform are applied; the other sections are parsed and validated
+// so one file can be shared across a mixed fleet.
+type Config struct {
+	Version   int
+	Engine    Engine
+	Control   Control
+	Metrics   Metrics
+	Log       Log
+	Trace     Trace
+	Capture   Capture
+	NFQueue   NFQueue
+	WinDivert WinDivert
+	Divert    Divert
+}
+
+type Engine struct {
+	SplitMode                   string
+	SplitChunk                  int
+	CollectTimeout              time.Duration
+	MaxBufferBytes              int
+	MaxHeldPackets              int
+	MaxSegmentPayload           int
+	Workers                     int
+	WorkerQueueSize             int
+	FlowIdleTimeout             time.Duration
+	GCInterval                  time.Duration
+	MaxFlowsPerWorker           int
+	MaxReassemblyBytesPerWorker int
+	MaxHeldBytesPerWorker       int
+	ShutdownFailOpenTimeout     time.Duration
+	ShutdownFailOpenMaxPackets  int
+	AdapterFlushTimeout         time.Duration
+	ReloadPauseTimeout          time.Duration
+	Shadow                      bool
+	CanaryPercent               int
+	CanarySalt                  string
+	TrackHandshakes             bool
+	HandshakeTimeout            time.Duration
+}
+
+// Control is the local control API section. An empty Socket means the
+// platform default.
+type Control struct {
+	Enabled bool
+	Socket  string
+	Group   string
+	// SignalPause ends a SIGUSR1 pause automatically; 0 waits for SIGUSR2.
+	SignalPause time.Duration
+}
+
+// Metrics is the metrics exporter section. An empty Listen turns it off.
+type Metrics struct {
+	Listen string
+	// AllowRemote permits a Listen address other than loopback; the
+	// endpoint has no authentication.
+	AllowRemote bool
+}
+
+// Loopback reports whether Listen only accepts local connections. An empty
+// host listens on every interface.
+func (m Metrics) Loopback() bool {
+	host, _, err := net.SplitHostPort(m.Listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Log is the logging section.
+type Log struct {
+	// Level is debug, info, warn or error.
+	Level string
+	// Format is text or json.
+	Format string
+	// Redact hashes IP addresses and server names in log records.
+	Redact bool
+	// RateLimit caps records per second for each event; 0 is unlimited.
+	RateLimit int
+}
+
+// Trace is the per-flow event trace section. Dst and SNI are
+// comma-separated lists; when both are empty every flow is traced.
+type Trace struct {
+	Enabled bool
+	// Size is the number of events kept; older ones are overwritten.
+	Size int
+	Dst  string
+	SNI  string
+	// DumpPath is the file the trace is written to on SIGPWR (Linux) or
+	// SIGINFO (FreeBSD); empty ignores the signal.
+	DumpPath string
+}
+
+// Capture is the pcapng packet capture section. An empty Path turns it
+// off; Hosts is a comma-separated list of IPv4 addresses or prefixes.
+type Capture struct {
+	Path string
+	// MaxBytes rotates the file once it grows past this size; 0 never
+	// rotates.
+	MaxBytes int
+	// MaxFiles is how many rotated files are kept.
+	MaxFiles int
+	Snaplen  int
+	Hosts    string
+}
+
+// NFQueue is the Linux section.
+type NFQueue struct {
+	QueueNum           int
+	QueueMaxLen        int
+	CopyRange          int
+	Mark               int
+	AutoRules          bool
+	AutoOffload        bool
+	AutoOffloadRestore bool
+	AutoInstallTools   bool
+	Iface              string
+	WatchEgress        bool
+	RulesCheckInterval time.Duration
+	StateDir           string
+	NoLoopback         bool
+	// NFLogGroup, when set, observes through this NFLOG group instead of
+	// queueing packets.
+	NFLogGroup int
+}
+
+// WinDivert is the Windows section.
+type WinDivert struct {
+	Filter              string
+	QueueLen            uint64
+	QueueTimeMs         uint64
+	QueueSizeBytes      uint64
+	WinDivertDir        string
+	WinDivertSys        string
+	AutoInstallDriver   bool
+	AutoUninstallDriver bool
+	AutoDownloadFiles   bool
+}
+
+// Divert is the FreeBSD (pf divert-to) section.
+type Divert struct {
+	Port int
+}
+
+// Defaults returns the built-in configuration.
+func Defaults() Config {
+	ec := engine.DefaultConfig()
+	return Config{
+		Version: CurrentVersion,
+		Engine: Engine{
+			SplitMode:                   ec.SplitMode.String(),
+			SplitChunk:                  ec.SplitChunk,
+			CollectTimeout:              ec.CollectTimeout,
+			MaxBufferBytes:              ec.MaxBufferBytes,
+			MaxHeldPackets:              ec.MaxHeldPackets,
+			MaxSegmentPayload:           ec.MaxSegmentPayload,
+			Workers:                     ec.WorkerCount,
+			WorkerQueueSize:             ec.WorkerQueueSize,
+			FlowIdleTimeout:             ec.FlowIdleTimeout,
+			GCInterval:                  ec.GCInterval,
+			MaxFlowsPerWorker:           ec.MaxFlowsPerWorker,
+			MaxReassemblyBytesPerWorker: ec.MaxReassemblyBytesPerWorker,
+			MaxHeldBytesPerWorker:       ec.MaxHeldBytesPerWorker,
+			ShutdownFailOpenTimeout:     ec.ShutdownFailOpenTimeout,
+			ShutdownFailOpenMaxPackets:  ec.ShutdownFailOpenMaxPackets,
+			AdapterFlushTimeout:         ec.AdapterFlushTimeout,
+			ReloadPauseTimeout:          ec.ReloadPauseTimeout,
+			Shadow:                      ec.Shadow,
+			CanaryPercent:               100 - ec.ControlPercent,
+			CanarySalt:                  ec.CanarySalt,
+			TrackHandshakes:             ec.TrackHandshakes,
+			HandshakeTimeout:            ec.HandshakeTimeout,
+		},
+		Control: Control{
+			Enabled: true,
+			Group:   "gov-pass",
+		},
+		Log: Log{
+			Level:     "info",
+			Format:    "text",
+			RateLimit: 10,
+		},
+		Trace: Trace{
+			Size: 4096,
+		},
+		Capture: Capture{
+			MaxBytes: 64 << 20,
+			MaxFiles: 4,
+		},
+		NFQueue: NFQueue{
+			QueueNum:           100,
+			QueueMaxLen:        4096,
+			CopyRange:          0xffff,
+			Mark:               1,
+			AutoRules:          true,
+			AutoOffload:        true,
+			AutoOffloadRestore: true,
+			AutoInstallTools:   true,
+			WatchEgress:        true,
+			RulesCheckInterval: 10 * time.Second,
+			StateDir:           "/run/gov-pass",
+		},
+		WinDivert: WinDivert{
+			Filter:              "outbound and ip and tcp.DstPort == 443",
+			QueueLen:            4096,
+			QueueTimeMs:         2000,
+			QueueSizeBytes:      32 * 1024 * 1024,
+			AutoInstallDriver:   true,
+			AutoUninstallDriver: true,
+			AutoDownloadFiles:   true,
+		},
+		Divert: Divert{
+			Port: 10000,
+		},
+	}
+}
+
+// CurrentPlatform is the platform of the running binary. Systems without a
+// section of their own apply only the engine section.
+func CurrentPlatform() string {
+	return runtime.GOOS
+}
+
+// ParseSplitMode parses the engine.split_mode value.
+func ParseSplitMode(value string) (engine.SplitMode, error) {
+	switch strings.ToLower(value) {
+	case "immediate":
+		return engine.SplitModeImmediate, nil
+	case "tls-hello":
+		return engine.SplitModeTLSHello, nil
+	default:
+		return engine.SplitModeTLSHello, errors.New("expected tls-hello or immediate")
+	}
+}
+
+// TraceRing is the ring for the trace section, nil when tracing is off. c
+// must have passed Validate.
+func (c Config) TraceRing() *trace.Ring {
+	if !c.Trace.Enabled {
+		return nil
+	}
+	f, _ := trace.ParseFilter(c.Trace.Dst, c.Trace.SNI)
+	return trace.New(c.Trace.Size, f)
+}
+
+// TraceRingChanged reports whether c traces differently from prev, so the
+// ring has to be replaced. The dump path only matters when one is written.
+func (c Config) TraceRingChanged(prev Config) bool {
+	t, p := c.Trace, prev.Trace
+	t.DumpPath, p.DumpPath = "", ""
+	return t != p
+}
+
+// CaptureOptions converts the capture section. c must have passed
+// Validate.
+func (c Config) CaptureOptions() pcapng.Options {
+	hosts, _ := trace.ParsePrefixes(c.Capture.Hosts)
+	return pcapng.Options{
+		Path:     c.Capture.Path,
+		MaxBytes: int64(c.Capture.MaxBytes),
+		MaxFiles: c.Capture.MaxFiles,
+		Snaplen:  c.Capture.Snaplen,
+		Hosts:    hosts,
+	}
+}
+
+// EngineConfig converts the engine section. c must have passed Validate.
+func (c Config) EngineConfig() engine.Config {
+	ec := engine.DefaultConfig()
+	ec.SplitMode, _ = ParseSplitMode(c.Engine.SplitMode)
+	ec.SplitChunk = c.Engine.SplitChunk
+	ec.CollectTimeout = c.Engine.CollectTimeout
+	ec.MaxBufferBytes = c.Engine.MaxBufferBytes
+	ec.MaxHeldPackets = c.Engine.MaxHeldPackets
+	ec.MaxSegmentPayload = c.Engine.MaxSegmentPayload
+	ec.WorkerCount = c.Engine.Workers
+	ec.WorkerQueueSize = c.Engine.WorkerQueueSize
+	ec.FlowIdleTimeout = c.Engine.FlowIdleTimeout
+	ec.GCInterval = c.Engine.GCInterval
+	ec.MaxFlowsPerWorker = c.Engine.MaxFlowsPerWorker
+	ec.MaxReassemblyBytesPerWorker = c.Engine.MaxReassemblyBytesPerWorker
+	ec.MaxHeldBytesPerWorker = c.Engine.MaxHeldBytesPerWorker
+	ec.ShutdownFailOpenTimeout = c.Engine.ShutdownFailOpenTimeout
+	ec.ShutdownFailOpenMaxPackets = c.Engine.ShutdownFailOpenMaxPackets
+	ec.AdapterFlushTimeout = c.Engine.AdapterFlushTimeout
+	ec.ReloadPauseTimeout = c.Engine.ReloadPauseTimeout
+	// Packets copied by NFLOG cannot be held or split.
+	ec.Shadow = c.Engine.Shadow || c.NFQueue.NFLogGroup > 0
+	ec.ControlPercent = 100 - c.Engine.CanaryPercent
+	ec.CanarySalt = c.Engine.CanarySalt
+	ec.TrackHandshakes = c.Engine.TrackHandshakes
+	ec.HandshakeTimeout = c.Engine.HandshakeTimeout
+	return ec
+}
@@ -0,0 +1,462 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"fk-gov/internal/engine"
+)
+
+func TestParseSplitMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    engine.SplitMode
+		wantErr bool
+	}{
+		{name: "tls-hello", input: "tls-hello", want: engine.SplitModeTLSHello},
+		{name: "immediate", input: "immediate", want: engine.SplitModeImmediate},
+		{name: "case-insensitive", input: "TLS-HELLO", want: engine.SplitModeTLSHello},
+		{name: "invalid", input: "bad", want: engine.SplitModeTLSHello, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSplitMode(tt.input)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("mode mismatch: got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultsMatchEngine(t *testing.T) {
+	c := Defaults()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("defaults invalid: %v", err)
+	}
+	if got, want := c.EngineConfig(), engine.DefaultConfig(); got != want {
+		t.Fatalf("engine config mismatch:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestEngineConfig_NFLogForcesShadow(t *testing.T) {
+	c := Defaults()
+	c.NFQueue.NFLogGroup = 5
+	if !c.EngineConfig().Shadow {
+		t.Fatal("an NFLOG group should run the engine in shadow mode")
+	}
+}
+
+func TestFieldRegistryUnique(t *testing.T) {
+	keys := make(map[string]bool)
+	flagsByPlatform := make(map[string]map[string]bool)
+	for _, f := range Fields() {
+		if keys[f.Key] {
+			t.Fatalf("duplicate key %s", f.Key)
+		}
+		keys[f.Key] = true
+		for _, p := range []string{PlatformLinux, PlatformWindows, PlatformFreeBSD} {
+			if !f.AppliesTo(p) {
+				continue
+			}
+			if flagsByPlatform[p] == nil {
+				flagsByPlatform[p] = make(map[string]bool)
+			}
+			if flagsByPlatform[p][f.Flag] {
+				t.Fatalf("duplicate flag --%s on %s", f.Flag, p)
+			}
+			flagsByPlatform[p][f.Flag] = true
+		}
+	}
+	if f, _ := LookupField("nfqueue.queue_num"); f.Env() != "GOV_PASS_QUEUE_NUM" {
+		t.Fatalf("env name: got %s", f.Env())
+	}
+}
+
+func TestParse(t *testing.T) {
+	body := `{
+  "$schema": "./config.schema.json",
+  "version": 1,
+  "engine": {
+    "split_mode": "immediate",
+    "collect_timeout": "500ms",
+    "workers": 2
+  },
+  "nfqueue": {
+    "mark": 4096,
+    "auto_offload": false,
+    "iface": "eth0"
+  }
+}
+`
+	f, err := Parse("config.json", []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Version != 1 || f.VersionLine != 3 || len(f.Unknown) != 0 {
+		t.Fatalf("version %d on line %d, unknown %v", f.Version, f.VersionLine, f.Unknown)
+	}
+	eff := &Effective{Config: Defaults(), Origins: make(map[string]Origin)}
+	eff.applyFile(f)
+	c := eff.Config
+	if c.Engine.SplitMode != "immediate" || c.Engine.CollectTimeout != 500*time.Millisecond || c.Engine.Workers != 2 {
+		t.Fatalf("engine mismatch: %+v", c.Engine)
+	}
+	if c.NFQueue.Mark != 4096 || c.NFQueue.AutoOffload || c.NFQueue.Iface != "eth0" {
+		t.Fatalf("nfqueue mismatch: %+v", c.NFQueue)
+	}
+	if got := eff.Origins["engine.workers"].Location; got != "config.json:7" {
+		t.Fatalf("workers origin %q", got)
+	}
+}
+
+func TestParseErrorsCarryLines(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		line int
+		msg  string
+	}{
+		{name: "c.json", body: "{\n  \"engine\": {\n    \"workers\": \"2\"\n  }\n}\n", line: 3, msg: "expected an unquoted integer"},
+		{name: "c.json", body: "{\n  \"engine\": {\n    \"workers\": 2,\n  }\n}\n", line: 3, msg: "invalid character"},
+		{name: "c.json", body: "{\n  \"nfqueue\": {\n    \"auto_rules\": \"yes\"\n  }\n}\n", line: 3, msg: "expected true or false"},
+		{name: "c.json", body: "{\n  \"version\": 9\n}\n", line: 2, msg: "unsupported schema version 9"},
+		{name: "c.json", body: "{\n  \"engine\": 1\n}\n", line: 2, msg: "engine must be a section"},
+		{name: "c.json", body: "{\n  \"engine\": {\n    \"collect_timeout\": \"soon\"\n  }\n}\n", line: 3, msg: "expected a duration"},
+		{name: "c.json", body: "{\n  \"engine\": {\n    \"workers\": {}\n  }\n}\n", line: 3, msg: "expected a scalar value"},
+		{name: "c.yaml", body: "engine:\n  workers: 2\n", line: 0, msg: "YAML and TOML are not supported"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.name, []byte(tt.body))
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Fatalf("%s %q: expected SyntaxError, got %v", tt.name, tt.body, err)
+		}
+		if se.Line != tt.line || !strings.Contains(se.Msg, tt.msg) {
+			t.Fatalf("%s %q: got line %d %q, want line %d %q", tt.name, tt.body, se.Line, se.Msg, tt.line, tt.msg)
+		}
+	}
+}
+
+func TestParseSkipsUnknownKeys(t *testing.T) {
+	body := `{
+  "engine": {
+    "workers": 2,
+    "split_chunkk": 3,
+    "future": {"a": [1, 2]}
+  },
+  "gui": {"theme": "dark"},
+  "comment": null
+}
+`
+	f, err := Parse("config.json", []byte(body))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var got []string
+	for _, e := range f.Unknown {
+		got = append(got, fmt.Sprintf("%s:%d", e.Key, e.Line))
+	}
+	if want := []string{"engine.split_chunkk:4", "engine.future:5", "gui:7", "comment:8"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("unknown = %v, want %v", got, want)
+	}
+	if len(f.Entries) != 1 || f.Entries[0].Key != "engine.workers" {
+		t.Fatalf("entries = %+v", f.Entries)
+	}
+}
+
+func TestLegacyWindowsConfig(t *testing.T) {
+	// The unversioned file written by older Windows builds.
+	body := `{
+  "engine": {
+    "split_mode": "tls-hello",
+    "collect_timeout": "",
+    "workers": 4
+  },
+  "windivert": {
+    "filter": "outbound and tcp.DstPort == 443",
+    "queue_time_ms": 1000,
+    "auto_download_files": false
+  },
+  "tray": {
+    "start_minimized": true
+  }
+}`
+	f, err := Parse("config.json", []byte(body))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if f.Version != 0 || f.VersionLine != 0 {
+		t.Fatalf("expected version 0, got %d", f.Version)
+	}
+	if len(f.Unknown) != 1 || f.Unknown[0].Key != "tray" {
+		t.Fatalf("unknown sections must be skipped, got %+v", f.Unknown)
+	}
+	eff := &Effective{Config: Defaults(), Origins: make(map[string]Origin)}
+	eff.applyFile(f)
+	if eff.Config.Engine.CollectTimeout != Defaults().Engine.CollectTimeout {
+		t.Fatalf("empty string must keep the default")
+	}
+	if eff.Config.WinDivert.QueueTimeMs != 1000 || eff.Config.WinDivert.AutoDownloadFiles {
+		t.Fatalf("windivert mismatch: %+v", eff.Config.WinDivert)
+	}
+}
+
+func TestLoadLayering(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("config", "{\n  \"version\": 1,\n  \"engine\": {\"workers\": 2, \"split_chunk\": 3},\n  \"nfqueue\": {\"queue_num\": 7, \"mark\": 2}\n}\n")
+	write("conf.d/10-chunk.json", "{\n  \"engine\": {\n    \"split_chunk\": 5\n  }\n}\n")
+	write("conf.d/20-ignored.yaml", "not a drop-in")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fv := RegisterFlags(fs, PlatformLinux)
+	if err := fs.Parse([]string{"--mark=9", "--auto-offload=false"}); err != nil {
+		t.Fatalf("flag parse: %v", err)
+	}
+
+	eff, err := Load(LoadOptions{
+		Platform: PlatformLinux,
+		Dir:      dir,
+		Env:      []string{"GOV_PASS_QUEUE_NUM=8", "GOV_PASS_MARK=3", "GOV_PASS_IFACE=", "PATH=/bin"},
+		Flags:    fv.Values(),
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	c := eff.Config
+	if c.Engine.Workers != 2 || c.Engine.SplitChunk != 5 || c.NFQueue.QueueNum != 8 || c.NFQueue.Mark != 9 || c.NFQueue.AutoOffload {
+		t.Fatalf("layering mismatch: engine=%+v nfqueue=%+v", c.Engine, c.NFQueue)
+	}
+	wantOrigins := map[string]Origin{
+		"engine.workers":       {Source: SourceFile, Location: filepath.Join(dir, "config") + ":3"},
+		"engine.split_chunk":   {Source: SourceFile, Location: filepath.Join(dir, "conf.d", "10-chunk.json") + ":3"},
+		"nfqueue.queue_num":    {Source: SourceEnv, Location: "GOV_PASS_QUEUE_NUM"},
+		"nfqueue.mark":         {Source: SourceFlag, Location: "--mark"},
+		"nfqueue.iface":        {Source: SourceDefault},
+		"engine.gc_interval":   {Source: SourceDefault},
+		"nfqueue.auto_offload": {Source: SourceFlag, Location: "--auto-offload"},
+	}
+	for key, want := range wantOrigins {
+		if got := eff.Origins[key]; got != want {
+			t.Fatalf("origin %s: got %+v want %+v", key, got, want)
+		}
+	}
+	if len(eff.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(eff.Files))
+	}
+}
+
+func TestLoadExplicitPathSkipsNeighbourDropIns(t *testing.T) {
+	tmp, dir := t.TempDir(), t.TempDir()
+	path := filepath.Join(tmp, "x.json")
+	for p, body := range map[string]string{
+		path: "{\n  \"engine\": {\"split_chunk\": 3}\n}\n",
+		filepath.Join(tmp, "conf.d", "10-stray.json"): "{\n  \"engine\": {\"split_chunk\": 9}\n}\n",
+		filepath.Join(dir, "conf.d", "10-site.json"):  "{\n  \"engine\": {\"workers\": 2}\n}\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	eff, err := Load(LoadOptions{Platform: PlatformLinux, Path: path, Dir: dir})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if eff.Config.Engine.SplitChunk != 3 || eff.Config.Engine.Workers != 2 {
+		t.Fatalf("engine = %+v, want split_chunk from %s and workers from the config directory", eff.Config.Engine, path)
+	}
+	if len(eff.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(eff.Files))
+	}
+
+	eff, err = Load(LoadOptions{Platform: PlatformLinux, Path: path})
+	if err != nil {
+		t.Fatalf("Load without Dir: %v", err)
+	}
+	if len(eff.Files) != 1 {
+		t.Fatalf("expected only %s, got %d files", path, len(eff.Files))
+	}
+}
+
+func TestLoadValidationNamesOrigin(t *testing.T) {
+	_, err := Load(LoadOptions{
+		Platform: PlatformLinux,
+		Env:      []string{"GOV_PASS_MARK=0", "GOV_PASS_WORKERS=0"},
+	})
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+	for _, want := range []string{
+		"engine.workers (--workers) must be >= 1 (set by env GOV_PASS_WORKERS)",
+		"nfqueue.mark (--mark) must be > 0 when nfqueue.auto_rules is enabled",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error %q does not contain %q", err, want)
+		}
+	}
+
+	// Sections of other platforms ignore env and flags.
+	if _, err := Load(LoadOptions{Platform: PlatformWindows, Env: []string{"GOV_PASS_MARK=0"}}); err != nil {
+		t.Fatalf("windows load: %v", err)
+	}
+}
+
+func TestValidateMetricsListen(t *testing.T) {
+	tests := []struct {
+		listen      string
+		allowRemote bool
+		ok          bool
+	}{
+		{"127.0.0.1:9469", false, true},
+		{"[::1]:9469", false, true},
+		{"localhost:9469", false, true},
+		{":9469", false, false},
+		{"0.0.0.0:9469", false, false},
+		{"192.0.2.10:9469", false, false},
+		{"0.0.0.0:9469", true, true},
+		{"9469", true, false},
+	}
+	for _, tt := range tests {
+		c := Defaults()
+		c.Metrics = Metrics{Listen: tt.listen, AllowRemote: tt.allowRemote}
+		err := c.Validate()
+		if (err == nil) != tt.ok {
+			t.Fatalf("listen %q allow_remote=%v: got %v", tt.listen, tt.allowRemote, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "metrics.listen") {
+			t.Fatalf("listen %q: error %q does not name metrics.listen", tt.listen, err)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	c := Defaults()
+	c.Engine.SplitMode = "immediate"
+	c.NFQueue.Iface = "wlan0 #1"
+	c.WinDivert.Filter = `outbound and tcp.DstPort == 443 and ip.DstAddr != "10.0.0.1"`
+	data := Encode(c)
+	f, err := Parse("config.json", data)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	eff := &Effective{Config: Defaults(), Origins: make(map[string]Origin)}
+	eff.applyFile(f)
+	if eff.Config != c {
+		t.Fatalf("round trip mismatch:\n%s", data)
+	}
+}
+
+func TestCheckFileReportsValueLines(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.json")
+	body := "{\n  \"version\": 1,\n  \"engine\": {\n    \"workers\": 0,\n    \"split_chunk\": 0\n  },\n  \"nfqueue\": {\"mark\": 0}\n}\n"
+	if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := CheckFile(p)
+	if err == nil {
+		t.Fatalf("expected errors")
+	}
+	want := []string{
+		p + ":5: engine.split_chunk (--split-chunk) must be >= 1",
+		p + ":4: engine.workers (--workers) must be >= 1",
+		p + ":7: nfqueue.mark (--mark) must be > 0 when nfqueue.auto_rules is enabled",
+	}
+	for _, w := range want {
+		if !strings.Contains(err.Error(), w) {
+			t.Fatalf("error %q does not contain %q", err, w)
+		}
+	}
+}
+
+func TestSchemaCoversFields(t *testing.T) {
+	var doc struct {
+		AdditionalProperties bool `json:"additionalProperties"`
+		Properties           map[string]struct {
+			AdditionalProperties bool                       `json:"additionalProperties"`
+			Properties           map[string]json.RawMessage `json:"properties"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(Schema(), &doc); err != nil {
+		t.Fatalf("schema is not JSON: %v", err)
+	}
+	if doc.AdditionalProperties {
+		t.Fatalf("top level must reject unknown keys")
+	}
+	for _, f := range Fields() {
+		sec, ok := doc.Properties[f.Section()]
+		if !ok || sec.AdditionalProperties {
+			t.Fatalf("section %s missing or open", f.Section())
+		}
+		if _, ok := sec.Properties[f.Name()]; !ok {
+			t.Fatalf("schema lacks %s", f.Key)
+		}
+	}
+	re := regexp.MustCompile(durationPattern)
+	for _, d := range []string{"250ms", "1h2m3.5s", "0"} {
+		if !re.MatchString(d) {
+			t.Fatalf("duration pattern rejects %q", d)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "config.json",
+			body: "{\n    \"engine\": {\n        \"workers\": 2\n    }\n}\n",
+			want: "{\n    \"version\": 1,\n    \"engine\": {\n        \"workers\": 2\n    }\n}\n",
+		},
+		{name: "empty.json", body: "{}", want: "{\n  \"version\": 1\n}"},
+		{name: "versioned.json", body: "{\n  \"version\": 0,\n  \"engine\": {\"workers\": 2}\n}\n", want: "{\n  \"version\": 1,\n  \"engine\": {\"workers\": 2}\n}\n"},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.name, []byte(tt.body))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, changed, err := Migrate(f, []byte(tt.body))
+		if err != nil || !changed {
+			t.Fatalf("%s: changed=%v err=%v", tt.name, changed, err)
+		}
+		if string(got) != tt.want {
+			t.Fatalf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		f2, _ := Parse(tt.name, got)
+		if _, changed, _ := Migrate(f2, got); changed {
+			t.Fatalf("%s: second migration changed the file", tt.name)
+		}
+	}
+}
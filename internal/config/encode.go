@@ -0,0 +1,59 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Encode writes c as a JSON config file, restricted to the named sections
+// (all sections when none are given). Empty strings are omitted since they
+// mean "use the default" in every layer.
+func Encode(c Config, sections ...string) []byte {
+	if len(sections) == 0 {
+		sections = Sections()
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "{\n  \"version\": %d", c.Version)
+	for _, s := range sections {
+		fmt.Fprintf(&b, ",\n  %q: {", s)
+		first := true
+		for _, f := range sectionFields(s) {
+			v := f.Get(&c)
+			if v == "" {
+				continue
+			}
+			if !first {
+				b.WriteByte(',')
+			}
+			first = false
+			fmt.Fprintf(&b, "\n    %q: %s", f.Name(), encodeScalar(f.Kind(), v))
+		}
+		if first {
+			b.WriteString("}")
+		} else {
+			b.WriteString("\n  }")
+		}
+	}
+	b.WriteString("\n}\n")
+	return b.Bytes()
+}
+
+func sectionFields(section string) []Field {
+	var out []Field
+	for _, f := range fields {
+		if f.Section() == section {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func encodeScalar(kind Kind, v string) string {
+	switch kind {
+	case KindInt, KindUint, KindBool:
+		return v
+	}
+	q, _ := json.Marshal(v)
+	return string(q)
+}
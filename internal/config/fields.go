@@ -0,0 +1,322 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the value type of a field.
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindInt
+	KindUint
+	KindBool
+	KindDuration
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindInt:
+		return "integer"
+	case KindUint:
+		return "unsigned integer"
+	case KindBool:
+		return "boolean"
+	case KindDuration:
+		return "duration"
+	default:
+		return "string"
+	}
+}
+
+// Field describes one setting: its key in the file ("section.name"), its
+// command-line flag and its environment variable (derived from the flag).
+type Field struct {
+	Key   string
+	Flag  string
+	Usage string
+	ptr   func(*Config) interface{}
+}
+
+// sectionPlatforms maps each file section to the platform that applies it.
+// The engine, control, metrics, log, trace and capture sections apply
+// everywhere.
+var sectionPlatforms = map[string]string{
+	"engine":    "",
+	"control":   "",
+	"metrics":   "",
+	"log":       "",
+	"trace":     "",
+	"capture":   "",
+	"nfqueue":   PlatformLinux,
+	"windivert": PlatformWindows,
+	"divert":    PlatformFreeBSD,
+}
+
+// Sections lists the file sections in schema order.
+func Sections() []string {
+	return []string{"engine", "control", "metrics", "log", "trace", "capture", "nfqueue", "windivert", "divert"}
+}
+
+var fields = []Field{
+	{Key: "engine.split_mode", Flag: "split-mode", Usage: "split trigger: tls-hello or immediate",
+		ptr: func(c *Config) interface{} { return &c.Engine.SplitMode }},
+	{Key: "engine.split_chunk", Flag: "split-chunk", Usage: "first split size in bytes",
+		ptr: func(c *Config) interface{} { return &c.Engine.SplitChunk }},
+	{Key: "engine.collect_timeout", Flag: "collect-timeout", Usage: "reassembly collect timeout",
+		ptr: func(c *Config) interface{} { return &c.Engine.CollectTimeout }},
+	{Key: "engine.max_buffer_bytes", Flag: "max-buffer", Usage: "max reassembly buffer size in bytes",
+		ptr: func(c *Config) interface{} { return &c.Engine.MaxBufferBytes }},
+	{Key: "engine.max_held_packets", Flag: "max-held-pkts", Usage: "max held packets per flow",
+		ptr: func(c *Config) interface{} { return &c.Engine.MaxHeldPackets }},
+	{Key: "engine.max_segment_payload", Flag: "max-seg-payload", Usage: "max segment payload size (0=unlimited)",
+		ptr: func(c *Config) interface{} { return &c.Engine.MaxSegmentPayload }},
+	{Key: "engine.workers", Flag: "workers", Usage: "worker count for sharded processing",
+		ptr: func(c *Config) interface{} { return &c.Engine.Workers }},
+	{Key: "engine.worker_queue_size", Flag: "worker-queue-size", Usage: "per-worker packet queue length",
+		ptr: func(c *Config) interface{} { return &c.Engine.WorkerQueueSize }},
+	{Key: "engine.flow_idle_timeout", Flag: "flow-timeout", Usage: "idle timeout for flow cleanup",
+		ptr: func(c *Config) interface{} { return &c.Engine.FlowIdleTimeout }},
+	{Key: "engine.gc_interval", Flag: "gc-interval", Usage: "flow GC interval",
+		ptr: func(c *Config) interface{} { return &c.Engine.GCInterval }},
+	{Key: "engine.max_flows_per_worker", Flag: "max-flows-per-worker", Usage: "max tracked flows per worker (0=unlimited)",
+		ptr: func(c *Config) interface{} { return &c.Engine.MaxFlowsPerWorker }},
+	{Key: "engine.max_reassembly_bytes_per_worker", Flag: "max-reassembly-bytes-per-worker", Usage: "max total reassembly bytes per worker (0=unlimited)",
+		ptr: func(c *Config) interface{} { return &c.Engine.MaxReassemblyBytesPerWorker }},
+	{Key: "engine.max_held_bytes_per_worker", Flag: "max-held-bytes-per-worker", Usage: "max total held packet bytes per worker (0=unlimited)",
+		ptr: func(c *Config) interface{} { return &c.Engine.MaxHeldBytesPerWorker }},
+	{Key: "engine.shutdown_fail_open_timeout", Flag: "shutdown-fail-open-timeout", Usage: "shutdown fail-open drain timeout per worker (0=use default)",
+		ptr: func(c *Config) interface{} { return &c.Engine.ShutdownFailOpenTimeout }},
+	{Key: "engine.shutdown_fail_open_max_packets", Flag: "shutdown-fail-open-max-pkts", Usage: "shutdown fail-open max packets per worker (0=use default)",
+		ptr: func(c *Config) interface{} { return &c.Engine.ShutdownFailOpenMaxPackets }},
+	{Key: "engine.adapter_flush_timeout", Flag: "adapter-flush-timeout", Usage: "adapter flush timeout on shutdown (0=use default)",
+		ptr: func(c *Config) interface{} { return &c.Engine.AdapterFlushTimeout }},
+	{Key: "engine.reload_pause_timeout", Flag: "reload-pause-timeout", Usage: "max packet dispatch pause when a reload changes workers or worker-queue-size (0=use default)",
+		ptr: func(c *Config) interface{} { return &c.Engine.ReloadPauseTimeout }},
+	{Key: "engine.shadow", Flag: "shadow", Usage: "observe only: pass every packet through at once and record what would have been split",
+		ptr: func(c *Config) interface{} { return &c.Engine.Shadow }},
+	{Key: "engine.canary_percent", Flag: "canary-percent", Usage: "split only this percentage of new flows, picked by a salted hash of the flow; the rest pass through untouched",
+		ptr: func(c *Config) interface{} { return &c.Engine.CanaryPercent }},
+	{Key: "engine.canary_salt", Flag: "canary-salt", Usage: "salt for the canary flow hash; hosts with the same salt pick the same flows",
+		ptr: func(c *Config) interface{} { return &c.Engine.CanarySalt }},
+	{Key: "engine.track_handshakes", Flag: "track-handshakes", Usage: "also capture the first inbound packets from port 443 and classify how servers answer each flow's ClientHello",
+		ptr: func(c *Config) interface{} { return &c.Engine.TrackHandshakes }},
+	{Key: "engine.handshake_timeout", Flag: "handshake-timeout", Usage: "count a tracked flow as timed out when the server has not answered within this time",
+		ptr: func(c *Config) interface{} { return &c.Engine.HandshakeTimeout }},
+
+	{Key: "control.enabled", Flag: "control", Usage: "serve the local control API (splitter ctl)",
+		ptr: func(c *Config) interface{} { return &c.Control.Enabled }},
+	{Key: "control.socket", Flag: "control-socket", Usage: "control API Unix socket or Windows named pipe (default: platform path)",
+		ptr: func(c *Config) interface{} { return &c.Control.Socket }},
+	{Key: "control.group", Flag: "control-group", Usage: "group allowed to use the control socket besides root (ignored on Windows)",
+		ptr: func(c *Config) interface{} { return &c.Control.Group }},
+	{Key: "control.signal_pause", Flag: "signal-pause", Usage: "resume this long after a SIGUSR1 pause (0 = until SIGUSR2; ignored on Windows)",
+		ptr: func(c *Config) interface{} { return &c.Control.SignalPause }},
+	{Key: "metrics.listen", Flag: "metrics-listen", Usage: "serve Prometheus/OpenMetrics metrics over HTTP on this address, e.g. 127.0.0.1:9469 (empty = off)",
+		ptr: func(c *Config) interface{} { return &c.Metrics.Listen }},
+	{Key: "metrics.allow_remote", Flag: "metrics-allow-remote", Usage: "allow a metrics-listen address other than loopback; the endpoint has no authentication",
+		ptr: func(c *Config) interface{} { return &c.Metrics.AllowRemote }},
+	{Key: "log.level", Flag: "log-level", Usage: "log level: debug, info, warn or error",
+		ptr: func(c *Config) interface{} { return &c.Log.Level }},
+	{Key: "log.format", Flag: "log-format", Usage: "log format: text or json",
+		ptr: func(c *Config) interface{} { return &c.Log.Format }},
+	{Key: "log.redact", Flag: "log-redact", Usage: "replace IP addresses and server names in logs with a per-process hash",
+		ptr: func(c *Config) interface{} { return &c.Log.Redact }},
+	{Key: "log.rate_limit", Flag: "log-rate-limit", Usage: "max log records per second for each event (0 = unlimited)",
+		ptr: func(c *Config) interface{} { return &c.Log.RateLimit }},
+	{Key: "trace.enabled", Flag: "trace", Usage: "record per-flow events, read with splitter ctl trace",
+		ptr: func(c *Config) interface{} { return &c.Trace.Enabled }},
+	{Key: "trace.size", Flag: "trace-size", Usage: "number of trace events kept; older ones are overwritten",
+		ptr: func(c *Config) interface{} { return &c.Trace.Size }},
+	{Key: "trace.dst", Flag: "trace-dst", Usage: "only trace flows to these comma-separated IPv4 addresses or prefixes",
+		ptr: func(c *Config) interface{} { return &c.Trace.Dst }},
+	{Key: "trace.sni", Flag: "trace-sni", Usage: "only trace flows to these comma-separated server names and their subdomains",
+		ptr: func(c *Config) interface{} { return &c.Trace.SNI }},
+	{Key: "trace.dump_path", Flag: "trace-dump", Usage: "write the trace to this file on SIGPWR (Linux) or SIGINFO (FreeBSD) (empty = off)",
+		ptr: func(c *Config) interface{} { return &c.Trace.DumpPath }},
+	{Key: "capture.path", Flag: "capture", Usage: "write captured, sent and dropped packets with the splitter's decisions to this pcapng file (empty = off)",
+		ptr: func(c *Config) interface{} { return &c.Capture.Path }},
+	{Key: "capture.max_bytes", Flag: "capture-max-bytes", Usage: "rotate the capture file once it grows past this size in bytes (0 = never)",
+		ptr: func(c *Config) interface{} { return &c.Capture.MaxBytes }},
+	{Key: "capture.max_files", Flag: "capture-max-files", Usage: "rotated capture files kept besides the current one",
+		ptr: func(c *Config) interface{} { return &c.Capture.MaxFiles }},
+	{Key: "capture.snaplen", Flag: "capture-snaplen", Usage: "capture at most this many bytes of each packet (0 = whole packet)",
+		ptr: func(c *Config) interface{} { return &c.Capture.Snaplen }},
+	{Key: "capture.hosts", Flag: "capture-hosts", Usage: "only capture packets from or to these comma-separated IPv4 addresses or prefixes",
+		ptr: func(c *Config) interface{} { return &c.Capture.Hosts }},
+
+	{Key: "nfqueue.queue_num", Flag: "queue-num", Usage: "NFQUEUE number",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.QueueNum }},
+	{Key: "nfqueue.queue_maxlen", Flag: "queue-maxlen", Usage: "NFQUEUE maxlen (0=kernel default)",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.QueueMaxLen }},
+	{Key: "nfqueue.copy_range", Flag: "copy-range", Usage: "NFQUEUE copy range in bytes (0=full packet)",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.CopyRange }},
+	{Key: "nfqueue.mark", Flag: "mark", Usage: "SO_MARK for reinjected packets",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.Mark }},
+	{Key: "nfqueue.auto_rules", Flag: "auto-rules", Usage: "auto install/uninstall NFQUEUE rules (nft or iptables)",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.AutoRules }},
+	{Key: "nfqueue.auto_offload", Flag: "auto-offload", Usage: "auto disable GRO/GSO/TSO (ethtool netlink)",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.AutoOffload }},
+	{Key: "nfqueue.auto_offload_restore", Flag: "auto-offload-restore", Usage: "restore GRO/GSO/TSO settings on exit when auto-offload is enabled",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.AutoOffloadRestore }},
+	{Key: "nfqueue.auto_install_tools", Flag: "auto-install-tools", Usage: "auto install missing system tools (nft/iptables) when auto-rules is enabled",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.AutoInstallTools }},
+	{Key: "nfqueue.iface", Flag: "iface", Usage: "egress interface for offload disable (default: auto-detect)",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.Iface }},
+	{Key: "nfqueue.watch_egress", Flag: "watch-egress", Usage: "follow egress interface changes (roaming, VPN, tethering) and move offload handling with them; ignored with --iface",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.WatchEgress }},
+	{Key: "nfqueue.rules_check_interval", Flag: "rules-check-interval", Usage: "verify auto rules at this interval and reinstall them after a firewall reload (0=disabled)",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.RulesCheckInterval }},
+	{Key: "nfqueue.state_dir", Flag: "state-dir", Usage: "directory for the crash-recovery state journal (see `splitter cleanup`)",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.StateDir }},
+	{Key: "nfqueue.no_loopback", Flag: "no-loopback", Usage: "do not exclude loopback from NFQUEUE rules",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.NoLoopback }},
+	{Key: "nfqueue.nflog_group", Flag: "nflog-group", Usage: "observe only, through this NFLOG group instead of NFQUEUE: nothing is held and the engine runs in shadow mode (0=off)",
+		ptr: func(c *Config) interface{} { return &c.NFQueue.NFLogGroup }},
+
+	{Key: "windivert.filter", Flag: "filter", Usage: "WinDivert filter",
+		ptr: func(c *Config) interface{} { return &c.WinDivert.Filter }},
+	{Key: "windivert.queue_len", Flag: "queue-len", Usage: "WinDivert queue length (0=driver default)",
+		ptr: func(c *Config) interface{} { return &c.WinDivert.QueueLen }},
+	{Key: "windivert.queue_time_ms", Flag: "queue-time", Usage: "WinDivert queue time in ms (0=driver default)",
+		ptr: func(c *Config) interface{} { return &c.WinDivert.QueueTimeMs }},
+	{Key: "windivert.queue_size_bytes", Flag: "queue-size", Usage: "WinDivert queue size in bytes (0=driver default)",
+		ptr: func(c *Config) interface{} { return &c.WinDivert.QueueSizeBytes }},
+	{Key: "windivert.windivert_dir", Flag: "windivert-dir", Usage: "directory containing WinDivert.dll/.sys/.cat (default: exe dir)",
+		ptr: func(c *Config) interface{} { return &c.WinDivert.WinDivertDir }},
+	{Key: "windivert.windivert_sys", Flag: "windivert-sys", Usage: "driver sys filename (default: WinDivert64.sys or WinDivert.sys)",
+		ptr: func(c *Config) interface{} { return &c.WinDivert.WinDivertSys }},
+	{Key: "windivert.auto_install_driver", Flag: "auto-install", Usage: "auto install/start WinDivert driver",
+		ptr: func(c *Config) interface{} { return &c.WinDivert.AutoInstallDriver }},
+	{Key: "windivert.auto_uninstall_driver", Flag: "auto-uninstall", Usage: "auto uninstall if installed by this run (always off in service mode)",
+		ptr: func(c *Config) interface{} { return &c.WinDivert.AutoUninstallDriver }},
+	{Key: "windivert.auto_download_files", Flag: "auto-download-windivert", Usage: "auto download pinned WinDivert zip if required files are missing",
+		ptr: func(c *Config) interface{} { return &c.WinDivert.AutoDownloadFiles }},
+
+	{Key: "divert.port", Flag: "divert-port", Usage: "pf divert-to port",
+		ptr: func(c *Config) interface{} { return &c.Divert.Port }},
+}
+
+// Fields returns the schema fields in order.
+func Fields() []Field {
+	return append([]Field(nil), fields...)
+}
+
+// PlatformFields returns the fields applied on platform: the engine section
+// plus the platform's own section. An empty platform selects every field.
+func PlatformFields(platform string) []Field {
+	var out []Field
+	for _, f := range fields {
+		if f.AppliesTo(platform) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// LookupField finds a field by its file key.
+func LookupField(key string) (Field, bool) {
+	for _, f := range fields {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Section is the file section holding the field.
+func (f Field) Section() string {
+	section, _, _ := strings.Cut(f.Key, ".")
+	return section
+}
+
+// Name is the key within its section.
+func (f Field) Name() string {
+	_, name, _ := strings.Cut(f.Key, ".")
+	return name
+}
+
+// AppliesTo reports whether the field is used on platform. Every field
+// applies to the empty platform.
+func (f Field) AppliesTo(platform string) bool {
+	p := sectionPlatforms[f.Section()]
+	return platform == "" || p == "" || p == platform
+}
+
+// Env is the environment variable that sets the field, e.g. GOV_PASS_QUEUE_NUM.
+func (f Field) Env() string {
+	return "GOV_PASS_" + strings.ToUpper(strings.ReplaceAll(f.Flag, "-", "_"))
+}
+
+func (f Field) Kind() Kind {
+	switch f.ptr(&Config{}).(type) {
+	case *int:
+		return KindInt
+	case *uint64:
+		return KindUint
+	case *bool:
+		return KindBool
+	case *time.Duration:
+		return KindDuration
+	default:
+		return KindString
+	}
+}
+
+// Get formats the field's value in c.
+func (f Field) Get(c *Config) string {
+	switch p := f.ptr(c).(type) {
+	case *int:
+		return strconv.Itoa(*p)
+	case *uint64:
+		return strconv.FormatUint(*p, 10)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *time.Duration:
+		return p.String()
+	case *string:
+		return *p
+	default:
+		panic(fmt.Sprintf("config: unsupported field type %T", p))
+	}
+}
+
+// Set parses raw and stores it in c.
+func (f Field) Set(c *Config, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch p := f.ptr(c).(type) {
+	case *int:
+		v, err := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 0, strconv.IntSize)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", raw)
+		}
+		*p = int(v)
+	case *uint64:
+		v, err := strconv.ParseUint(strings.ReplaceAll(raw, "_", ""), 0, 64)
+		if err != nil {
+			return fmt.Errorf("expected an unsigned integer, got %q", raw)
+		}
+		*p = v
+	case *bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", raw)
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("expected a duration such as 250ms or 2m, got %q", raw)
+		}
+		*p = v
+	case *string:
+		*p = raw
+	default:
+		panic(fmt.Sprintf("config: unsupported field type %T", p))
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"flag"
+	"time"
+)
+
+// FlagValues collects the config flags that were set explicitly, so they can
+// be layered on top of the file and environment by Load.
+type FlagValues struct {
+	fs     *flag.FlagSet
+	fields []Field
+}
+
+// RegisterFlags defines a flag for every field applied on platform, using the
+// built-in defaults. The values only reach the configuration through Load.
+func RegisterFlags(fs *flag.FlagSet, platform string) *FlagValues {
+	fv := &FlagValues{fs: fs, fields: PlatformFields(platform)}
+	defaults := Defaults()
+	for _, f := range fv.fields {
+		switch p := f.ptr(&defaults).(type) {
+		case *int:
+			fs.Int(f.Flag, *p, f.Usage)
+		case *uint64:
+			fs.Uint64(f.Flag, *p, f.Usage)
+		case *bool:
+			fs.Bool(f.Flag, *p, f.Usage)
+		case *time.Duration:
+			fs.Duration(f.Flag, *p, f.Usage)
+		case *string:
+			fs.String(f.Flag, *p, f.Usage)
+		}
+	}
+	return fv
+}
+
+// Values returns the flags given on the command line keyed by flag name. It
+// must be called after the FlagSet was parsed.
+func (fv *FlagValues) Values() map[string]string {
+	registered := make(map[string]bool, len(fv.fields))
+	for _, f := range fv.fields {
+		registered[f.Flag] = true
+	}
+	out := make(map[string]string)
+	fv.fs.Visit(func(fl *flag.Flag) {
+		if registered[fl.Name] {
+			out[fl.Name] = fl.Value.String()
+		}
+	})
+	return out
+}
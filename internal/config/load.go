@@ -0,0 +1,243 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConfigEnv names the environment variable that points at the config file
+// when no path is given on the command line.
+const ConfigEnv = "GOV_PASS_CONFIG"
+
+// configNames are the file names searched in the default directory.
+var configNames = []string{"config", "config.json"}
+
+// Source is the layer a value came from.
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+	// SourceControl marks values set at runtime through the control API.
+	SourceControl Source = "control"
+)
+
+// Origin records where an effective value was set: "path:line" for files,
+// the variable name for env, "--flag" for flags.
+type Origin struct {
+	Source   Source
+	Location string
+}
+
+func (o Origin) String() string {
+	if o.Location == "" {
+		return string(o.Source)
+	}
+	return string(o.Source) + " " + o.Location
+}
+
+// Effective is a loaded configuration together with the origin of every
+// field and the files that were read, in the order they were applied.
+type Effective struct {
+	Config  Config
+	Origins map[string]Origin
+	Files   []*File
+}
+
+// LoadOptions selects the layers to merge.
+type LoadOptions struct {
+	Platform string
+	// Path is an explicit config file; it must exist. When empty, $GOV_PASS_CONFIG
+	// and then Dir are consulted.
+	Path string
+	// Dir is searched for config or config.json when Path is empty, and its
+	// conf.d drop-ins are applied either way. Drop-ins are never read from
+	// next to an explicit Path, so a one-off file such as /tmp/x.json does
+	// not pick up /tmp/conf.d. Empty disables both.
+	Dir string
+	// Env is the environment in os.Environ form.
+	Env []string
+	// Flags maps flag names to the raw values given on the command line.
+	Flags map[string]string
+}
+
+// DefaultDir is the platform's config directory.
+func DefaultDir(platform string) string {
+	switch platform {
+	case PlatformWindows:
+		base := os.Getenv("ProgramData")
+		if base == "" {
+			base = `C:\ProgramData`
+		}
+		return filepath.Join(base, "gov-pass")
+	case PlatformFreeBSD:
+		return "/usr/local/etc/gov-pass"
+	default:
+		return "/etc/gov-pass"
+	}
+}
+
+// FindFile returns the first config file in dir, or "" when there is none.
+func FindFile(dir string) string {
+	for _, name := range configNames {
+		p := filepath.Join(dir, name)
+		if st, err := os.Stat(p); err == nil && st.Mode().IsRegular() {
+			return p
+		}
+	}
+	return ""
+}
+
+// DropIns lists the conf.d drop-ins that belong to a config directory, in the
+// order they are applied (lexical, like systemd).
+func DropIns(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, "conf.d"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".conf", ".json":
+			out = append(out, filepath.Join(dir, "conf.d", name))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// ReadFile reads and parses one config file.
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, data)
+}
+
+// Load merges defaults, the config file and its drop-ins, the environment and
+// flags, then validates the result.
+func Load(opts LoadOptions) (*Effective, error) {
+	eff := &Effective{
+		Config:  Defaults(),
+		Origins: make(map[string]Origin),
+	}
+	for _, f := range fields {
+		eff.Origins[f.Key] = Origin{Source: SourceDefault}
+	}
+
+	env := envMap(opts.Env)
+	path := opts.Path
+	if path == "" {
+		path = env[ConfigEnv]
+	}
+	dir := opts.Dir
+	if path == "" && dir != "" {
+		path = FindFile(dir)
+	}
+
+	var paths []string
+	if path != "" {
+		paths = append(paths, path)
+	}
+	if dir != "" {
+		dropIns, err := DropIns(dir)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, dropIns...)
+	}
+	for _, p := range paths {
+		f, err := ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		eff.applyFile(f)
+	}
+
+	for _, f := range PlatformFields(opts.Platform) {
+		// Empty variables are ignored so unit files can declare them blank.
+		raw := env[f.Env()]
+		if raw == "" {
+			continue
+		}
+		if err := f.Set(&eff.Config, raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Env(), err)
+		}
+		eff.Origins[f.Key] = Origin{Source: SourceEnv, Location: f.Env()}
+	}
+
+	for _, f := range PlatformFields(opts.Platform) {
+		raw, ok := opts.Flags[f.Flag]
+		if !ok {
+			continue
+		}
+		if err := f.Set(&eff.Config, raw); err != nil {
+			return nil, fmt.Errorf("--%s: %w", f.Flag, err)
+		}
+		eff.Origins[f.Key] = Origin{Source: SourceFlag, Location: "--" + f.Flag}
+	}
+
+	if err := eff.Config.Validate(); err != nil {
+		return nil, eff.annotate(err)
+	}
+	return eff, nil
+}
+
+// applyFile layers a parsed file. Empty strings leave the lower layer in
+// place, matching the original Windows config.json behaviour.
+func (eff *Effective) applyFile(f *File) {
+	for _, e := range f.Entries {
+		if e.Value == "" {
+			continue
+		}
+		field, _ := LookupField(e.Key)
+		// Parse already checked the value.
+		_ = field.Set(&eff.Config, e.Value)
+		eff.Origins[e.Key] = Origin{Source: SourceFile, Location: fmt.Sprintf("%s:%d", f.Path, e.Line)}
+	}
+	eff.Files = append(eff.Files, f)
+}
+
+// annotate adds the origin of each invalid value to validation errors.
+func (eff *Effective) annotate(err error) error {
+	var errs []error
+	for _, e := range unwrapAll(err) {
+		var fe *FieldError
+		if errors.As(e, &fe) {
+			fe.Origin = eff.Origins[fe.Key]
+		}
+		errs = append(errs, e)
+	}
+	return errors.Join(errs...)
+}
+
+func unwrapAll(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		return j.Unwrap()
+	}
+	return []error{err}
+}
+
+func envMap(env []string) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, "GOV_PASS_") {
+			m[k] = v
+		}
+	}
+	return m
+}
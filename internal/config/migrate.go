@@ -0,0 +1,81 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// migrations[v] rewrites a version v file into version v+1. Steps edit the
+// text in place so comments and layout survive.
+var migrations = []func(f *File, data []byte) ([]byte, error){
+	// 0 -> 1: the shared schema kept every key of the original Windows
+	// config.json, so only the version stamp is new.
+	0: func(f *File, data []byte) ([]byte, error) {
+		return setVersion(f, data, 1)
+	},
+}
+
+// Migrate upgrades a parsed file to CurrentVersion and returns the new
+// content. The second result is false when the file was already current.
+func Migrate(f *File, data []byte) ([]byte, bool, error) {
+	if f.Version >= CurrentVersion {
+		return data, false, nil
+	}
+	for f.Version < CurrentVersion {
+		from := f.Version
+		out, err := migrations[from](f, data)
+		if err != nil {
+			return nil, false, fmt.Errorf("migrate %s from version %d: %w", f.Path, from, err)
+		}
+		next, err := Parse(f.Path, out)
+		if err != nil {
+			return nil, false, fmt.Errorf("migrate %s from version %d produced an invalid file: %w", f.Path, from, err)
+		}
+		if next.Version != from+1 {
+			return nil, false, fmt.Errorf("migrate %s from version %d: got version %d", f.Path, from, next.Version)
+		}
+		f, data = next, out
+	}
+	return data, true, nil
+}
+
+var versionValue = regexp.MustCompile(`^(\s*"version"\s*:\s*)\d+`)
+
+// setVersion rewrites the version key, or inserts one at the top of the
+// document when the file has none.
+func setVersion(f *File, data []byte, version int) ([]byte, error) {
+	lines := strings.SplitAfter(string(data), "\n")
+	if f.VersionLine > 0 {
+		i := f.VersionLine - 1
+		if !versionValue.MatchString(lines[i]) {
+			return nil, fmt.Errorf("line %d: cannot rewrite version", f.VersionLine)
+		}
+		lines[i] = versionValue.ReplaceAllString(lines[i], fmt.Sprintf("${1}%d", version))
+		return []byte(strings.Join(lines, "")), nil
+	}
+
+	open := bytes.IndexByte(data, '{')
+	if open < 0 {
+		return nil, fmt.Errorf("no top-level object")
+	}
+	rest := data[open+1:]
+	indent := "  "
+	sep := ","
+	trimmed := bytes.TrimLeft(rest, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '}' {
+		sep = ""
+	} else if nl := bytes.IndexByte(rest, '\n'); nl >= 0 {
+		line := rest[nl+1:]
+		indent = string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+	}
+	var b bytes.Buffer
+	b.Write(data[:open+1])
+	fmt.Fprintf(&b, "\n%s\"version\": %d%s", indent, version, sep)
+	if sep == "" {
+		b.WriteString("\n")
+	}
+	b.Write(rest)
+	return b.Bytes(), nil
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// scalarType is the JSON type of a parsed value.
+type scalarType int
+
+const (
+	scalarString scalarType = iota
+	scalarNumber
+	scalarBool
+)
+
+// Entry is one key/value pair read from a file, with its 1-based line.
+type Entry struct {
+	Key   string
+	Value string
+	Line  int
+	typ   scalarType
+}
+
+// File is a parsed config file. Entries are in file order; the version key is
+// stored separately.
+type File struct {
+	Path    string
+	Version int
+	// VersionLine is 0 when the file has no version key.
+	VersionLine int
+	Entries     []Entry
+	// Unknown are keys the schema does not know, with any value. They are
+	// skipped like the original Windows loader did, so a file written for
+	// a newer build still loads; callers report them as warnings.
+	Unknown []Entry
+}
+
+// SyntaxError is a parse or schema error tied to a line of a file.
+type SyntaxError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+// Parse reads a JSON config file and checks every key and value type against
+// the schema. Sections of other platforms are checked too, so one file can be
+// shared between machines.
+func Parse(path string, data []byte) (*File, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".toml":
+		return nil, &SyntaxError{Path: path, Msg: "config files are JSON; YAML and TOML are not supported"}
+	}
+	entries, unknown, err := parseJSON(data)
+	if err != nil {
+		var se *SyntaxError
+		if errors.As(err, &se) {
+			se.Path = path
+		}
+		return nil, err
+	}
+
+	f := &File{Path: path, Unknown: unknown}
+	seen := make(map[string]int)
+	for _, e := range entries {
+		if prev, ok := seen[e.Key]; ok {
+			return nil, &SyntaxError{Path: path, Line: e.Line, Msg: fmt.Sprintf("duplicate key %s (first set on line %d)", e.Key, prev)}
+		}
+		seen[e.Key] = e.Line
+
+		if e.Key == "$schema" && e.typ != scalarNumber && e.typ != scalarBool {
+			// Editor hint pointing at the output of `splitter config schema`.
+			continue
+		}
+		if e.Key == "version" {
+			v, err := strconv.Atoi(e.Value)
+			if err != nil || e.typ == scalarString || e.typ == scalarBool {
+				return nil, &SyntaxError{Path: path, Line: e.Line, Msg: fmt.Sprintf("version: expected an integer, got %q", e.Value)}
+			}
+			if v < 0 || v > CurrentVersion {
+				return nil, &SyntaxError{Path: path, Line: e.Line, Msg: fmt.Sprintf("unsupported schema version %d (this build reads 0..%d)", v, CurrentVersion)}
+			}
+			f.Version = v
+			f.VersionLine = e.Line
+			continue
+		}
+		field, ok := LookupField(e.Key)
+		if !ok {
+			// parseJSON only returns schema keys and section names.
+			return nil, &SyntaxError{Path: path, Line: e.Line, Msg: fmt.Sprintf("%s must be a section", e.Key)}
+		}
+		if err := checkScalarType(field.Kind(), e.typ); err != nil {
+			return nil, &SyntaxError{Path: path, Line: e.Line, Msg: fmt.Sprintf("%s: %v", e.Key, err)}
+		}
+		if e.Value != "" {
+			var scratch Config
+			if err := field.Set(&scratch, e.Value); err != nil {
+				return nil, &SyntaxError{Path: path, Line: e.Line, Msg: fmt.Sprintf("%s: %v", e.Key, err)}
+			}
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	return f, nil
+}
+
+func checkScalarType(kind Kind, typ scalarType) error {
+	switch kind {
+	case KindInt, KindUint:
+		if typ != scalarNumber {
+			return fmt.Errorf("expected an unquoted %s", kind)
+		}
+	case KindBool:
+		if typ != scalarBool {
+			return errors.New("expected true or false")
+		}
+	default:
+		if typ != scalarString {
+			return fmt.Errorf("expected a quoted %s", kind)
+		}
+	}
+	return nil
+}
+
+// lineAt converts a byte offset to a 1-based line number.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// knownKey reports whether key, prefixed with its section when it has one,
+// is part of the schema.
+func knownKey(section, key string) bool {
+	if section != "" {
+		_, ok := LookupField(key)
+		return ok
+	}
+	_, ok := sectionPlatforms[key]
+	return ok || key == "version" || key == "$schema"
+}
+
+// parseJSON walks the token stream so every entry keeps its line number.
+// Keys outside the schema are skipped whatever their value and returned
+// separately.
+func parseJSON(data []byte) ([]Entry, []Entry, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	syntaxErr := func(err error) error {
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			return &SyntaxError{Line: lineAt(data, se.Offset), Msg: se.Error()}
+		}
+		if errors.Is(err, io.EOF) {
+			return &SyntaxError{Line: lineAt(data, int64(len(data))), Msg: "unexpected end of JSON input"}
+		}
+		return &SyntaxError{Line: lineAt(data, dec.InputOffset()), Msg: err.Error()}
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, syntaxErr(err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, nil, &SyntaxError{Line: 1, Msg: "expected a JSON object"}
+	}
+
+	var entries, unknown []Entry
+	var walk func(prefix string) error
+	walk = func(prefix string) error {
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return syntaxErr(err)
+			}
+			key := tok.(string)
+			line := lineAt(data, dec.InputOffset())
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if !knownKey(prefix, key) {
+				var skip json.RawMessage
+				if err := dec.Decode(&skip); err != nil {
+					return syntaxErr(err)
+				}
+				unknown = append(unknown, Entry{Key: key, Line: line})
+				continue
+			}
+			tok, err = dec.Token()
+			if err != nil {
+				return syntaxErr(err)
+			}
+			e := Entry{Key: key, Line: line}
+			switch v := tok.(type) {
+			case json.Delim:
+				if v != '{' || prefix != "" || key == "version" || key == "$schema" {
+					return &SyntaxError{Line: line, Msg: key + ": expected a scalar value"}
+				}
+				if err := walk(key); err != nil {
+					return err
+				}
+				if _, err := dec.Token(); err != nil {
+					return syntaxErr(err)
+				}
+				continue
+			case string:
+				e.Value, e.typ = v, scalarString
+			case json.Number:
+				e.Value, e.typ = v.String(), scalarNumber
+			case bool:
+				e.Value, e.typ = strconv.FormatBool(v), scalarBool
+			case nil:
+				return &SyntaxError{Line: line, Msg: key + ": null is not allowed; remove the key to use the default"}
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	}
+	if err := walk(""); err != nil {
+		return nil, nil, err
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, syntaxErr(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, nil, &SyntaxError{Line: lineAt(data, dec.InputOffset()), Msg: "unexpected data after the top-level object"}
+	}
+	return entries, unknown, nil
+}
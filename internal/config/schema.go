@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// durationPattern matches the time.ParseDuration syntax accepted for
+// duration fields.
+const durationPattern = `^[-+]?(0|([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+$`
+
+// Schema returns a JSON Schema (draft 2020-12) describing the file format.
+// It covers keys, types and defaults; range checks and cross-field rules are
+// left to `splitter config validate`. The splitter itself only warns about
+// unknown keys; the schema rejects them so editors flag typos.
+func Schema() []byte {
+	defaults := Defaults()
+	props := map[string]interface{}{
+		"$schema": map[string]interface{}{
+			"type":        "string",
+			"description": "Optional editor hint; ignored by the splitter.",
+		},
+		"version": map[string]interface{}{
+			"type":        "integer",
+			"minimum":     0,
+			"maximum":     CurrentVersion,
+			"description": fmt.Sprintf("Schema version. Omitted means 0, the original Windows config.json; run `splitter config migrate` to upgrade to %d.", CurrentVersion),
+		},
+	}
+	for _, s := range Sections() {
+		sectionProps := make(map[string]interface{})
+		for _, f := range sectionFields(s) {
+			sectionProps[f.Name()] = fieldSchema(f, f.Get(&defaults))
+		}
+		desc := "Settings applied on every platform."
+		if p := sectionPlatforms[s]; p != "" {
+			desc = fmt.Sprintf("Settings applied on %s only; checked but ignored elsewhere.", p)
+		}
+		props[s] = map[string]interface{}{
+			"type":                 "object",
+			"description":          desc,
+			"additionalProperties": false,
+			"properties":           sectionProps,
+		}
+	}
+	doc := map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "gov-pass splitter configuration",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           props,
+	}
+	b, _ := json.MarshalIndent(doc, "", "  ")
+	return append(b, '\n')
+}
+
+func fieldSchema(f Field, def string) map[string]interface{} {
+	s := map[string]interface{}{
+		"description": fmt.Sprintf("%s (flag --%s, env %s)", f.Usage, f.Flag, f.Env()),
+	}
+	switch f.Kind() {
+	case KindInt:
+		s["type"] = "integer"
+		s["default"], _ = strconv.ParseInt(def, 10, 64)
+	case KindUint:
+		s["type"] = "integer"
+		s["minimum"] = 0
+		s["default"], _ = strconv.ParseUint(def, 10, 64)
+	case KindBool:
+		s["type"] = "boolean"
+		s["default"] = def == "true"
+	case KindDuration:
+		s["type"] = "string"
+		s["pattern"] = durationPattern
+		if d, err := time.ParseDuration(def); err == nil && d != 0 {
+			s["default"] = def
+		}
+	default:
+		s["type"] = "string"
+		if def != "" {
+			s["default"] = def
+		}
+	}
+	if f.Key == "engine.split_mode" {
+		s["enum"] = []string{"tls-hello", "immediate"}
+	}
+	return s
+}
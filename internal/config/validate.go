@@ -0,0 +1,142 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"fk-gov/internal/logging"
+	"fk-gov/internal/trace"
+)
+
+// FieldError is a validation failure of one field. Origin is filled in by
+// Load so the message points at the layer that set the bad value.
+type FieldError struct {
+	Key    string
+	Msg    string
+	Origin Origin
+}
+
+func (e *FieldError) Error() string {
+	msg := e.describe()
+	if e.Origin.Source != "" && e.Origin.Source != SourceDefault {
+		msg += " (set by " + e.Origin.String() + ")"
+	}
+	return msg
+}
+
+func (e *FieldError) describe() string {
+	if f, ok := LookupField(e.Key); ok {
+		return fmt.Sprintf("%s (--%s) %s", e.Key, f.Flag, e.Msg)
+	}
+	return e.Key + " " + e.Msg
+}
+
+// Validate checks every section, including those of other platforms, and
+// returns all problems joined.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, msg string) {
+		if !ok {
+			errs = append(errs, &FieldError{Key: key, Msg: msg})
+		}
+	}
+
+	e := c.Engine
+	if _, err := ParseSplitMode(e.SplitMode); err != nil {
+		check(false, "engine.split_mode", err.Error())
+	}
+	check(e.SplitChunk >= 1, "engine.split_chunk", "must be >= 1")
+	check(e.CollectTimeout >= time.Millisecond, "engine.collect_timeout", "must be >= 1ms")
+	check(e.MaxBufferBytes >= 1, "engine.max_buffer_bytes", "must be >= 1")
+	check(e.MaxHeldPackets >= 1, "engine.max_held_packets", "must be >= 1")
+	check(e.MaxSegmentPayload >= 0, "engine.max_segment_payload", "must be >= 0")
+	check(e.Workers >= 1, "engine.workers", "must be >= 1")
+	check(e.WorkerQueueSize >= 1, "engine.worker_queue_size", "must be >= 1")
+	check(e.FlowIdleTimeout >= time.Millisecond, "engine.flow_idle_timeout", "must be >= 1ms")
+	check(e.GCInterval >= time.Millisecond, "engine.gc_interval", "must be >= 1ms")
+	check(e.MaxFlowsPerWorker >= 0, "engine.max_flows_per_worker", "must be >= 0")
+	check(e.MaxReassemblyBytesPerWorker >= 0, "engine.max_reassembly_bytes_per_worker", "must be >= 0")
+	check(e.MaxHeldBytesPerWorker >= 0, "engine.max_held_bytes_per_worker", "must be >= 0")
+	check(e.ShutdownFailOpenTimeout >= 0, "engine.shutdown_fail_open_timeout", "must be >= 0")
+	check(e.ShutdownFailOpenMaxPackets >= 0, "engine.shutdown_fail_open_max_packets", "must be >= 0")
+	check(e.AdapterFlushTimeout >= 0, "engine.adapter_flush_timeout", "must be >= 0")
+	check(e.ReloadPauseTimeout >= 0, "engine.reload_pause_timeout", "must be >= 0")
+	check(e.CanaryPercent >= 0 && e.CanaryPercent <= 100, "engine.canary_percent", "must be in 0..100")
+	check(e.HandshakeTimeout >= time.Millisecond, "engine.handshake_timeout", "must be >= 1ms")
+
+	q := c.NFQueue
+	check(q.QueueNum >= 0 && q.QueueNum <= 65535, "nfqueue.queue_num", "must be in 0..65535")
+	check(q.QueueMaxLen >= 0, "nfqueue.queue_maxlen", "must be >= 0")
+	check(q.CopyRange >= 0 && q.CopyRange <= 0xffff, "nfqueue.copy_range", "must be in 0..65535")
+	check(q.Mark >= 0 && int64(q.Mark) <= 0xffffffff, "nfqueue.mark", "must be in 0..4294967295")
+	check(q.NFLogGroup >= 0 && q.NFLogGroup <= 65535, "nfqueue.nflog_group", "must be in 0..65535")
+	check(q.RulesCheckInterval >= 0, "nfqueue.rules_check_interval", "must be >= 0")
+	check(strings.TrimSpace(q.StateDir) != "", "nfqueue.state_dir", "must not be empty")
+	if q.AutoRules && q.Mark == 0 {
+		check(false, "nfqueue.mark", "must be > 0 when nfqueue.auto_rules is enabled (reinjected packets bypass the queue by mark)")
+	}
+
+	check(c.Control.SignalPause >= 0, "control.signal_pause", "must be >= 0")
+	if c.Metrics.Listen != "" {
+		_, port, err := net.SplitHostPort(c.Metrics.Listen)
+		check(err == nil && port != "", "metrics.listen", "must be host:port")
+		check(err != nil || c.Metrics.AllowRemote || c.Metrics.Loopback(), "metrics.listen",
+			"must be a loopback address unless metrics.allow_remote is set (the endpoint has no authentication)")
+	}
+
+	_, err := logging.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level", "must be debug, info, warn or error")
+	check(c.Log.Format == logging.FormatText || c.Log.Format == logging.FormatJSON, "log.format", "must be text or json")
+	check(c.Log.RateLimit >= 0, "log.rate_limit", "must be >= 0")
+
+	check(c.Trace.Size >= 1, "trace.size", "must be >= 1")
+	if _, err := trace.ParseFilter(c.Trace.Dst, ""); err != nil {
+		check(false, "trace.dst", err.Error())
+	}
+
+	check(c.Capture.MaxBytes >= 0, "capture.max_bytes", "must be >= 0")
+	check(c.Capture.MaxFiles >= 0, "capture.max_files", "must be >= 0")
+	check(c.Capture.Snaplen >= 0, "capture.snaplen", "must be >= 0")
+	if _, err := trace.ParsePrefixes(c.Capture.Hosts); err != nil {
+		check(false, "capture.hosts", err.Error())
+	}
+
+	check(strings.TrimSpace(c.WinDivert.Filter) != "", "windivert.filter", "must not be empty")
+
+	check(c.Divert.Port >= 1 && c.Divert.Port <= 65535, "divert.port", "must be in 1..65535")
+
+	return errors.Join(errs...)
+}
+
+// CheckFile validates one file on its own, on top of the built-in defaults.
+// Every problem is reported as a *SyntaxError carrying the line that set the
+// offending value, so provisioning can reject a file before it ships.
+func CheckFile(path string) (*File, error) {
+	f, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := make(map[string]int, len(f.Entries))
+	for _, e := range f.Entries {
+		lines[e.Key] = e.Line
+	}
+
+	eff := &Effective{Config: Defaults(), Origins: make(map[string]Origin)}
+	eff.applyFile(f)
+	verr := eff.Config.Validate()
+	if verr == nil {
+		return f, nil
+	}
+	var errs []error
+	for _, e := range unwrapAll(verr) {
+		var fe *FieldError
+		if errors.As(e, &fe) {
+			e = &SyntaxError{Path: path, Line: lines[fe.Key], Msg: fe.describe()}
+		}
+		errs = append(errs, e)
+	}
+	return f, errors.Join(errs...)
+}
@@ -0,0 +1,573 @@
+// Package control serves the splitter's local control API and is also its
+// client. Each connection carries one JSON Request and one JSON Response;
+// the transport is a Unix socket on Linux and FreeBSD and a named pipe on
+// Windows, both restricted to privileged callers.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"fk-gov/internal/config"
+	"fk-gov/internal/engine"
+	"fk-gov/internal/flow"
+	"fk-gov/internal/logging"
+	"fk-gov/internal/trace"
+)
+
+// Endpoints.
+const (
+	EndpointStatus       = "status"
+	EndpointConfig       = "config"
+	EndpointConfigApply  = "config.apply"
+	EndpointConfigReload = "config.reload"
+	EndpointPause        = "pause"
+	EndpointResume       = "resume"
+	EndpointStats        = "stats"
+	EndpointFlows        = "flows"
+	EndpointLogLevel     = "log-level"
+	EndpointTrace        = "trace"
+	EndpointHandshakes   = "handshakes"
+)
+
+const (
+	maxRequestBytes = 1 << 20
+	connTimeout     = 10 * time.Second
+)
+
+// DefaultPath is the control socket (or pipe) of the given platform.
+func DefaultPath(platform string) string {
+	switch platform {
+	case config.PlatformWindows:
+		return `\\.\pipe\gov-pass`
+	case config.PlatformFreeBSD:
+		return "/var/run/gov-pass/control.sock"
+	default:
+		return "/run/gov-pass/control.sock"
+	}
+}
+
+type Request struct {
+	Endpoint string `json:"endpoint"`
+	// Values holds config keys to apply for config.apply.
+	Values map[string]string `json:"values,omitempty"`
+	// Level sets the log level for log-level; empty reads it.
+	Level string `json:"level,omitempty"`
+	// Limit caps the number of flows returned by flows, or the number of
+	// most recent events returned by trace (0 = all).
+	Limit int `json:"limit,omitempty"`
+	// Duration ends a pause automatically, in time.ParseDuration syntax;
+	// empty pauses until resume.
+	Duration string `json:"duration,omitempty"`
+}
+
+type Response struct {
+	Error string `json:"error,omitempty"`
+	// Denied marks an Error sent to a caller that failed authorization.
+	Denied bool            `json:"denied,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+type Status struct {
+	Platform string    `json:"platform"`
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Uptime   string    `json:"uptime"`
+	Paused   bool      `json:"paused"`
+	// ResumeAt is set while a timed pause is in effect.
+	ResumeAt *time.Time `json:"resume_at,omitempty"`
+	// Shadow is set while engine.shadow only observes traffic.
+	Shadow  bool `json:"shadow,omitempty"`
+	Workers int  `json:"workers"`
+	// RuleDrifts counts the auto rules found removed or reordered and
+	// reinstalled; unset when no rule watchdog runs.
+	RuleDrifts  *uint64  `json:"rule_drifts,omitempty"`
+	Flows       int      `json:"flows"`
+	ConfigFiles []string `json:"config_files"`
+	LogLevel    string   `json:"log_level"`
+}
+
+// ConfigValue is one effective setting and where it came from.
+type ConfigValue struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Source   string `json:"source"`
+	Location string `json:"location,omitempty"`
+}
+
+type ConfigResult struct {
+	Platform string        `json:"platform"`
+	Files    []string      `json:"files"`
+	Values   []ConfigValue `json:"values"`
+}
+
+// Change is a setting that differs in a reloaded configuration but needs a
+// restart to take effect.
+type Change struct {
+	Key    string `json:"key"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+type ApplyResult struct {
+	RestartRequired []Change `json:"restart_required"`
+}
+
+type PauseResult struct {
+	Paused   bool       `json:"paused"`
+	ResumeAt *time.Time `json:"resume_at,omitempty"`
+	// Warning reports a pause that took effect but could not release every
+	// held flow up front.
+	Warning string `json:"warning,omitempty"`
+}
+
+type WorkerStats struct {
+	ID              int   `json:"id"`
+	Flows           int   `json:"flows"`
+	Queued          int   `json:"queued"`
+	QueueCap        int   `json:"queue_cap"`
+	HeldBytes       int64 `json:"held_bytes"`
+	ReassemblyBytes int64 `json:"reassembly_bytes"`
+}
+
+type Stats struct {
+	Paused    bool          `json:"paused"`
+	Received  uint64        `json:"received"`
+	Bypassed  uint64        `json:"bypassed"`
+	Splits    uint64        `json:"splits"`
+	FailOpens uint64        `json:"fail_opens"`
+	Workers   []WorkerStats `json:"workers"`
+
+	// FailOpensByReason omits reasons that never fired.
+	FailOpensByReason map[string]uint64 `json:"fail_opens_by_reason,omitempty"`
+
+	// Shadow mirrors engine.shadow; ShadowSplits and ShadowFailOpens count
+	// what shadow flows would have done.
+	Shadow          bool   `json:"shadow,omitempty"`
+	ShadowSplits    uint64 `json:"shadow_splits,omitempty"`
+	ShadowFailOpens uint64 `json:"shadow_fail_opens,omitempty"`
+
+	// CanaryPercent is engine.canary_percent; ControlFlows counts the flows
+	// it left unsplit.
+	CanaryPercent int    `json:"canary_percent"`
+	ControlFlows  uint64 `json:"control_flows,omitempty"`
+}
+
+type Flow struct {
+	Worker        int    `json:"worker"`
+	Src           string `json:"src"`
+	Dst           string `json:"dst"`
+	State         string `json:"state"`
+	HeldPackets   int    `json:"held_packets"`
+	BufferedBytes int    `json:"buffered_bytes"`
+	Idle          string `json:"idle"`
+
+	// FailOpenReason is set once the flow passed through unsplit.
+	FailOpenReason string `json:"fail_open_reason,omitempty"`
+}
+
+type LogLevelResult struct {
+	Level string `json:"level"`
+}
+
+type TraceResult struct {
+	Enabled bool `json:"enabled"`
+	// Overwritten counts older events the ring no longer holds.
+	Overwritten uint64       `json:"overwritten"`
+	Events      []TraceEvent `json:"events"`
+}
+
+type HandshakesResult struct {
+	// Enabled mirrors engine.track_handshakes; the counts stay after it is
+	// turned off.
+	Enabled    bool        `json:"enabled"`
+	ByStrategy []Handshake `json:"by_strategy"`
+	// ByDestination is sorted by flows, most first.
+	ByDestination []Handshake `json:"by_destination"`
+}
+
+// Handshake counts the servers' answers to the flows of one strategy, to
+// one destination in ByDestination. Dst "0.0.0.0" collects destinations
+// past the engine's table limit.
+type Handshake struct {
+	Dst      string            `json:"dst,omitempty"`
+	Strategy string            `json:"strategy"`
+	Flows    uint64            `json:"flows"`
+	Outcomes map[string]uint64 `json:"outcomes"`
+}
+
+type TraceEvent struct {
+	Time   time.Time `json:"time"`
+	Worker int       `json:"worker"`
+	Src    string    `json:"src"`
+	Dst    string    `json:"dst"`
+	Kind   string    `json:"kind"`
+	Bytes  int       `json:"bytes,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// NewTraceResult converts the events of ring, only the most recent limit of
+// them when limit > 0. A nil ring means tracing is off.
+func NewTraceResult(ring *trace.Ring, limit int) TraceResult {
+	if ring == nil {
+		return TraceResult{Events: []TraceEvent{}}
+	}
+	events, overwritten := ring.Events()
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	res := TraceResult{Enabled: true, Overwritten: overwritten, Events: make([]TraceEvent, 0, len(events))}
+	for _, ev := range events {
+		res.Events = append(res.Events, TraceEvent{
+			Time:   ev.Time,
+			Worker: ev.Worker,
+			Src:    ev.Src.String(),
+			Dst:    ev.Dst.String(),
+			Kind:   ev.Kind.String(),
+			Bytes:  ev.Bytes,
+			Detail: ev.Detail,
+		})
+	}
+	return res
+}
+
+// Configurator reads and reapplies the running configuration.
+type Configurator interface {
+	Effective() *config.Effective
+	// Apply reloads the configuration with values (config keys) layered over
+	// the command line, applies what it can in place and reports the rest.
+	Apply(values map[string]string) ([]Change, error)
+}
+
+// ConfigValues lists the effective value and origin of every key that
+// applies to platform.
+func ConfigValues(eff *config.Effective, platform string) ConfigResult {
+	res := ConfigResult{Platform: platform, Files: []string{}}
+	for _, f := range eff.Files {
+		res.Files = append(res.Files, f.Path)
+	}
+	for _, f := range config.PlatformFields(platform) {
+		o := eff.Origins[f.Key]
+		res.Values = append(res.Values, ConfigValue{Key: f.Key, Value: f.Get(&eff.Config), Source: string(o.Source), Location: o.Location})
+	}
+	return res
+}
+
+// Server answers requests against a running engine.
+type Server struct {
+	Engine   *engine.Engine
+	Config   Configurator
+	Level    *slog.LevelVar
+	Platform string
+	Started  time.Time
+	// RuleDrifts, when set, reports the rule watchdog's drift count.
+	RuleDrifts func() uint64
+}
+
+// Serve accepts connections until ctx is done or the listener fails.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var denied *DeniedError
+			if errors.As(err, &denied) {
+				slog.Warn("control: connection refused", logging.Event(logging.EventControlDenied), "peer", denied.Peer, "reason", denied.Reason)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(ctx, conn)
+	}
+}
+
+// DeniedError is returned by Accept for a caller that failed authorization.
+// The listener has already answered and closed the connection.
+type DeniedError struct {
+	Peer   string
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("denied %s: %s", e.Peer, e.Reason)
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	// Pipes on Windows do not support deadlines; the error is expected there.
+	_ = conn.SetDeadline(time.Now().Add(connTimeout))
+
+	var req Request
+	var resp Response
+	if err := json.NewDecoder(io.LimitReader(conn, maxRequestBytes)).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("bad request: %v", err)
+	} else {
+		slog.Debug("control: request", logging.Event(logging.EventControlRequest), "endpoint", req.Endpoint)
+		ctx, cancel := context.WithTimeout(ctx, connTimeout)
+		result, err := s.Handle(ctx, req)
+		cancel()
+		if err != nil {
+			resp.Error = err.Error()
+		} else if resp.Result, err = json.Marshal(result); err != nil {
+			resp.Error = err.Error()
+		}
+	}
+	_ = json.NewEncoder(conn).Encode(resp)
+}
+
+// Handle runs one request.
+func (s *Server) Handle(ctx context.Context, req Request) (interface{}, error) {
+	switch req.Endpoint {
+	case EndpointStatus:
+		st, err := s.Engine.Stats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res := Status{
+			Platform:    s.Platform,
+			PID:         os.Getpid(),
+			Started:     s.Started,
+			Uptime:      time.Since(s.Started).Truncate(time.Second).String(),
+			Paused:      st.Paused,
+			ResumeAt:    resumeAt(st),
+			Shadow:      st.Shadow,
+			Workers:     len(st.Workers),
+			ConfigFiles: []string{},
+			LogLevel:    logging.FormatLevel(s.Level.Level()),
+		}
+		for _, w := range st.Workers {
+			res.Flows += w.Flows
+		}
+		if s.RuleDrifts != nil {
+			n := s.RuleDrifts()
+			res.RuleDrifts = &n
+		}
+		for _, f := range s.Config.Effective().Files {
+			res.ConfigFiles = append(res.ConfigFiles, f.Path)
+		}
+		return res, nil
+	case EndpointConfig:
+		return ConfigValues(s.Config.Effective(), s.Platform), nil
+	case EndpointConfigApply, EndpointConfigReload:
+		values := req.Values
+		if req.Endpoint == EndpointConfigReload {
+			values = nil
+		} else if len(values) == 0 {
+			return nil, errors.New("config.apply: no values")
+		}
+		changes, err := s.Config.Apply(values)
+		if err != nil {
+			return nil, err
+		}
+		if changes == nil {
+			changes = []Change{}
+		}
+		return ApplyResult{RestartRequired: changes}, nil
+	case EndpointPause:
+		var d time.Duration
+		if req.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+				return nil, fmt.Errorf("pause: invalid duration %q", req.Duration)
+			}
+		}
+		res := PauseResult{Paused: true}
+		if err := Pause(s.Engine, d, "control"); err != nil {
+			res.Warning = err.Error()
+		}
+		if st, err := s.Engine.Stats(ctx); err == nil {
+			res.ResumeAt = resumeAt(st)
+		}
+		return res, nil
+	case EndpointResume:
+		Resume(s.Engine, "control")
+		return PauseResult{Paused: false}, nil
+	case EndpointStats:
+		st, err := s.Engine.Stats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res := Stats{
+			Paused:    st.Paused,
+			Received:  st.Received,
+			Bypassed:  st.Bypassed,
+			Splits:    st.Splits,
+			FailOpens: st.FailOpens,
+			Workers:   make([]WorkerStats, 0, len(st.Workers)),
+
+			Shadow:          st.Shadow,
+			ShadowSplits:    st.ShadowDecisions.Splits,
+			ShadowFailOpens: st.ShadowDecisions.FailOpens,
+
+			CanaryPercent: st.CanaryPercent,
+			ControlFlows:  st.FlowsByCohort[engine.CohortControl],
+		}
+		for r, n := range st.FailOpensByReason {
+			if n == 0 {
+				continue
+			}
+			if res.FailOpensByReason == nil {
+				res.FailOpensByReason = make(map[string]uint64)
+			}
+			res.FailOpensByReason[r.String()] = n
+		}
+		for _, w := range st.Workers {
+			res.Workers = append(res.Workers, WorkerStats(w))
+		}
+		return res, nil
+	case EndpointFlows:
+		flows, err := s.Engine.Flows(ctx, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		res := make([]Flow, 0, len(flows))
+		for _, f := range flows {
+			fl := Flow{
+				Worker:        f.Worker,
+				Src:           fmt.Sprintf("%s:%d", net.IP(f.Key.SrcIP[:]), f.Key.SrcPort),
+				Dst:           fmt.Sprintf("%s:%d", net.IP(f.Key.DstIP[:]), f.Key.DstPort),
+				State:         f.State.String(),
+				HeldPackets:   f.HeldPackets,
+				BufferedBytes: f.BufferedBytes,
+				Idle:          now.Sub(f.LastActive).Truncate(time.Millisecond).String(),
+			}
+			if f.FailOpenReason != flow.FailOpenNone {
+				fl.FailOpenReason = f.FailOpenReason.String()
+			}
+			res = append(res, fl)
+		}
+		return res, nil
+	case EndpointLogLevel:
+		if req.Level != "" {
+			l, err := logging.ParseLevel(req.Level)
+			if err != nil {
+				return nil, err
+			}
+			s.Level.Set(l)
+			slog.Info("control: log level changed", logging.Event(logging.EventLogLevel), "level", logging.FormatLevel(l))
+		}
+		return LogLevelResult{Level: logging.FormatLevel(s.Level.Level())}, nil
+	case EndpointTrace:
+		return NewTraceResult(s.Engine.Trace(), req.Limit), nil
+	case EndpointHandshakes:
+		st, err := s.Engine.Stats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handshakesResult(st, req.Limit), nil
+	default:
+		return nil, fmt.Errorf("unknown endpoint %q", req.Endpoint)
+	}
+}
+
+// handshakesResult converts the handshake counts; limit bounds
+// ByDestination when > 0.
+func handshakesResult(st engine.Stats, limit int) HandshakesResult {
+	convert := func(dst, strategy string, outcomes map[flow.HandshakeOutcome]uint64) Handshake {
+		h := Handshake{Dst: dst, Strategy: strategy, Outcomes: make(map[string]uint64, len(outcomes))}
+		for o, n := range outcomes {
+			h.Flows += n
+			h.Outcomes[o.String()] = n
+		}
+		return h
+	}
+	res := HandshakesResult{Enabled: st.TrackHandshakes, ByStrategy: []Handshake{}, ByDestination: make([]Handshake, 0, len(st.Handshakes))}
+	for strategy, outcomes := range st.HandshakesByStrategy() {
+		res.ByStrategy = append(res.ByStrategy, convert("", strategy, outcomes))
+	}
+	sort.Slice(res.ByStrategy, func(i, j int) bool { return res.ByStrategy[i].Strategy < res.ByStrategy[j].Strategy })
+	for _, hc := range st.Handshakes {
+		res.ByDestination = append(res.ByDestination, convert(hc.Dst.String(), hc.Strategy, hc.Outcomes))
+	}
+	sort.SliceStable(res.ByDestination, func(i, j int) bool { return res.ByDestination[i].Flows > res.ByDestination[j].Flows })
+	if limit > 0 && len(res.ByDestination) > limit {
+		res.ByDestination = res.ByDestination[:limit]
+	}
+	return res
+}
+
+// Pause pauses eng for d (until Resume if d is 0) and logs it on behalf of
+// by. The engine is paused even when an error is returned.
+func Pause(eng *engine.Engine, d time.Duration, by string) error {
+	err := eng.Pause(d)
+	if d > 0 {
+		slog.Info("paused; packets pass through unchanged", logging.Event(logging.EventPaused), "by", by, "for", d)
+	} else {
+		slog.Info("paused; packets pass through unchanged until resumed", logging.Event(logging.EventPaused), "by", by)
+	}
+	if err != nil {
+		slog.Warn("pause did not release every held flow", logging.Event(logging.EventPauseIncomplete), "by", by, logging.Err(err))
+	}
+	return err
+}
+
+// Resume undoes Pause and logs it on behalf of by.
+func Resume(eng *engine.Engine, by string) {
+	eng.Resume()
+	slog.Info("resumed", logging.Event(logging.EventResumed), "by", by)
+}
+
+func resumeAt(st engine.Stats) *time.Time {
+	if !st.Paused || st.ResumeAt.IsZero() {
+		return nil
+	}
+	t := st.ResumeAt
+	return &t
+}
+
+// Call sends req to the server at path and decodes the result into out. A
+// caller the server does not accept gets an error matching os.ErrPermission.
+func Call(ctx context.Context, path string, req Request, out interface{}) error {
+	conn, err := Dial(ctx, path)
+	if err != nil {
+		return fmt.Errorf("connect %s: %w", path, err)
+	}
+	defer conn.Close()
+	if d, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(d)
+	}
+	// A server that refuses the caller answers and closes before reading,
+	// so a failed write may still be followed by a readable response.
+	writeErr := json.NewEncoder(conn).Encode(req)
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		if writeErr != nil {
+			return writeErr
+		}
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.Denied {
+		return fmt.Errorf("%w: %s", os.ErrPermission, resp.Error)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if out == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, out)
+}
+
+// deny answers a rejected caller before its connection is closed.
+func deny(conn net.Conn, peer, reason string) error {
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	_ = json.NewEncoder(conn).Encode(Response{Error: reason, Denied: true})
+	_ = conn.Close()
+	return &DeniedError{Peer: peer, Reason: reason}
+}
@@ -0,0 +1,207 @@
+//go:build linux || freebsd
+
+package control
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fk-gov/internal/adapter"
+	"fk-gov/internal/config"
+	"fk-gov/internal/engine"
+	"fk-gov/internal/flow"
+	"fk-gov/internal/trace"
+)
+
+type fakeConfigurator struct {
+	eff     *config.Effective
+	applied []map[string]string
+}
+
+func (f *fakeConfigurator) Effective() *config.Effective { return f.eff }
+
+func (f *fakeConfigurator) Apply(values map[string]string) ([]Change, error) {
+	f.applied = append(f.applied, values)
+	if values["engine.workers"] == "0" {
+		return nil, &config.FieldError{Key: "engine.workers", Msg: "must be > 0"}
+	}
+	return []Change{{Key: "nfqueue.mark", From: "1", To: "2", Reason: "restart"}}, nil
+}
+
+func TestServer(t *testing.T) {
+	eff, err := config.Load(config.LoadOptions{Platform: config.PlatformLinux})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgr := &fakeConfigurator{eff: eff}
+	var level slog.LevelVar
+	var drifts atomic.Uint64
+	srv := &Server{
+		Engine:     engine.New(eff.Config.EngineConfig(), adapter.NewStub()),
+		Config:     cfgr,
+		Level:      &level,
+		Platform:   config.PlatformLinux,
+		Started:    time.Now(),
+		RuleDrifts: drifts.Load,
+	}
+
+	path := filepath.Join(t.TempDir(), "run", "control.sock")
+	ln, err := Listen(path, "")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ctx, ln) }()
+
+	if _, err := Listen(path, ""); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("second listener on a live socket: %v", err)
+	}
+
+	call := func(req Request, out interface{}) error {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		return Call(ctx, path, req, out)
+	}
+
+	var st Status
+	if err := call(Request{Endpoint: EndpointStatus}, &st); err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if st.Paused || st.Workers != eff.Config.Engine.Workers || st.LogLevel != "info" || st.RuleDrifts == nil || *st.RuleDrifts != 0 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	drifts.Store(3)
+	if err := call(Request{Endpoint: EndpointStatus}, &st); err != nil || st.RuleDrifts == nil || *st.RuleDrifts != 3 {
+		t.Fatalf("status with a rule watchdog: %+v (%v)", st, err)
+	}
+
+	var applied ApplyResult
+	if err := call(Request{Endpoint: EndpointConfigApply, Values: map[string]string{"engine.split_chunk": "3"}}, &applied); err != nil {
+		t.Fatalf("config.apply: %v", err)
+	}
+	if len(applied.RestartRequired) != 1 || applied.RestartRequired[0].Key != "nfqueue.mark" {
+		t.Fatalf("unexpected apply result: %+v", applied)
+	}
+	if err := call(Request{Endpoint: EndpointConfigApply, Values: map[string]string{"engine.workers": "0"}}, nil); err == nil || !strings.Contains(err.Error(), "must be > 0") {
+		t.Fatalf("invalid apply: %v", err)
+	}
+	if err := call(Request{Endpoint: EndpointConfigReload}, nil); err != nil {
+		t.Fatalf("config.reload: %v", err)
+	}
+	if len(cfgr.applied) != 3 || cfgr.applied[0]["engine.split_chunk"] != "3" || cfgr.applied[2] != nil {
+		t.Fatalf("configurator calls: %+v", cfgr.applied)
+	}
+
+	var res ConfigResult
+	if err := call(Request{Endpoint: EndpointConfig}, &res); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	for _, v := range res.Values {
+		if strings.HasPrefix(v.Key, "windivert.") {
+			t.Fatalf("windows key listed for linux: %s", v.Key)
+		}
+	}
+
+	if err := call(Request{Endpoint: EndpointPause, Duration: "soon"}, nil); err == nil || !strings.Contains(err.Error(), "invalid duration") {
+		t.Fatalf("pause with bad duration: %v", err)
+	}
+	var paused PauseResult
+	if err := call(Request{Endpoint: EndpointPause, Duration: "15m"}, &paused); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	if !paused.Paused || paused.ResumeAt == nil || time.Until(*paused.ResumeAt) <= 14*time.Minute {
+		t.Fatalf("unexpected pause result: %+v", paused)
+	}
+	if err := call(Request{Endpoint: EndpointStatus}, &st); err != nil || !st.Paused || st.ResumeAt == nil {
+		t.Fatalf("status while paused: %+v (%v)", st, err)
+	}
+	var untilResumed PauseResult
+	if err := call(Request{Endpoint: EndpointPause}, &untilResumed); err != nil || !untilResumed.Paused || untilResumed.ResumeAt != nil {
+		t.Fatalf("pause until resumed: %+v (%v)", untilResumed, err)
+	}
+	var stats Stats
+	if err := call(Request{Endpoint: EndpointStats}, &stats); err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if !stats.Paused || len(stats.Workers) != eff.Config.Engine.Workers || stats.FailOpensByReason != nil {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	var lr LogLevelResult
+	if err := call(Request{Endpoint: EndpointLogLevel, Level: "DEBUG"}, &lr); err != nil || lr.Level != "debug" || level.Level() != slog.LevelDebug {
+		t.Fatalf("log-level: %+v %v", lr, err)
+	}
+	if err := call(Request{Endpoint: EndpointLogLevel, Level: "trace"}, nil); err == nil {
+		t.Fatalf("expected invalid log level to fail")
+	}
+
+	var tr TraceResult
+	if err := call(Request{Endpoint: EndpointTrace}, &tr); err != nil || tr.Enabled || len(tr.Events) != 0 {
+		t.Fatalf("trace while off: %+v (%v)", tr, err)
+	}
+	srv.Engine.SetTrace(trace.New(4, trace.Filter{}))
+	if err := call(Request{Endpoint: EndpointTrace}, &tr); err != nil || !tr.Enabled {
+		t.Fatalf("trace: %+v (%v)", tr, err)
+	}
+
+	var hs HandshakesResult
+	if err := call(Request{Endpoint: EndpointHandshakes}, &hs); err != nil || hs.Enabled || len(hs.ByStrategy) != 0 || len(hs.ByDestination) != 0 {
+		t.Fatalf("handshakes while off: %+v (%v)", hs, err)
+	}
+
+	if err := call(Request{Endpoint: "reboot"}, nil); err == nil || !strings.Contains(err.Error(), "unknown endpoint") {
+		t.Fatalf("unknown endpoint: %v", err)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+}
+
+func TestHandshakesResult(t *testing.T) {
+	st := engine.Stats{TrackHandshakes: true, Handshakes: []engine.HandshakeCount{
+		{Dst: netip.MustParseAddr("1.1.1.1"), Strategy: "tls-hello/5", Outcomes: map[flow.HandshakeOutcome]uint64{flow.HandshakeServerHello: 2}},
+		{Dst: netip.MustParseAddr("2.2.2.2"), Strategy: "tls-hello/5", Outcomes: map[flow.HandshakeOutcome]uint64{flow.HandshakeServerHello: 1, flow.HandshakeRST: 4}},
+		{Dst: netip.MustParseAddr("2.2.2.2"), Strategy: "control", Outcomes: map[flow.HandshakeOutcome]uint64{flow.HandshakeTimeout: 1}},
+	}}
+	res := handshakesResult(st, 2)
+	if !res.Enabled || len(res.ByStrategy) != 2 || len(res.ByDestination) != 2 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if s := res.ByStrategy[1]; s.Strategy != "tls-hello/5" || s.Flows != 7 || s.Outcomes["server-hello"] != 3 || s.Outcomes["rst"] != 4 {
+		t.Fatalf("by strategy: %+v", res.ByStrategy)
+	}
+	if d := res.ByDestination; d[0].Dst != "2.2.2.2" || d[0].Flows != 5 || d[1].Dst != "1.1.1.1" {
+		t.Fatalf("by destination: %+v", d)
+	}
+}
+
+func TestAuthListenerAllowed(t *testing.T) {
+	l := &authListener{gid: 77}
+	cases := []struct {
+		peer peer
+		want bool
+	}{
+		{peer{uid: 0}, true},
+		{peer{uid: 12345, groups: []int{5, 77}}, true},
+		{peer{uid: 12345, groups: []int{5}}, false},
+	}
+	for _, c := range cases {
+		if got := l.allowed(c.peer); got != c.want {
+			t.Fatalf("allowed(%+v) = %v, want %v", c.peer, got, c.want)
+		}
+	}
+	noGroup := &authListener{gid: -1}
+	if noGroup.allowed(peer{uid: 12345, groups: []int{77}}) {
+		t.Fatalf("group match without a control group")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !freebsd && !windows
+
+package control
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errUnsupported = errors.New("control API is not supported on this platform")
+
+func Listen(path, group string) (net.Listener, error) {
+	return nil, errUnsupported
+}
+
+func Dial(ctx context.Context, path string) (net.Conn, error) {
+	return nil, errUnsupported
+}
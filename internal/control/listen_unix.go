@@ -0,0 +1,154 @@
+//go:build linux || freebsd
+
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"fk-gov/internal/logging"
+)
+
+// Listen creates the control socket at path. Callers must be root, the
+// splitter's own user, or in group (when non-empty); the socket is made
+// group-accessible to match.
+func Listen(path, group string) (net.Listener, error) {
+	gid := -1
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			slog.Warn("control: group not found; only root can use the socket", logging.Event(logging.EventControlRestricted), "group", group, "path", path)
+		} else if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, fmt.Errorf("control: group %q: bad gid %q", group, g.Gid)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(true)
+	mode := os.FileMode(0o600)
+	if gid >= 0 {
+		if err := os.Chown(path, -1, gid); err != nil {
+			slog.Warn("control: socket chown failed; only root can connect", logging.Event(logging.EventControlRestricted), "group", group, "path", path, logging.Err(err))
+			gid = -1
+		} else {
+			mode = 0o660
+		}
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return &authListener{UnixListener: ln, gid: gid}, nil
+}
+
+// removeStaleSocket deletes a socket left behind by a previous run but
+// refuses to touch other files or a socket another process still serves.
+func removeStaleSocket(path string) error {
+	st, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if st.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("control: %s exists and is not a socket", path)
+	}
+	if c, err := net.Dial("unix", path); err == nil {
+		_ = c.Close()
+		return fmt.Errorf("control: %s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+type authListener struct {
+	*net.UnixListener
+	gid int
+}
+
+func (l *authListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptUnix()
+	if err != nil {
+		return nil, err
+	}
+	cred, err := peerCred(conn)
+	if err != nil {
+		return nil, deny(conn, "unknown peer", err.Error())
+	}
+	if !l.allowed(cred) {
+		return nil, deny(conn, cred.String(), "not root or a member of the control group")
+	}
+	return conn, nil
+}
+
+func (l *authListener) allowed(c peer) bool {
+	if c.uid == 0 || c.uid == os.Geteuid() {
+		return true
+	}
+	if l.gid < 0 {
+		return false
+	}
+	for _, g := range c.groups {
+		if g == l.gid {
+			return true
+		}
+	}
+	return false
+}
+
+// peer is the identity of a connected process. pid is 0 where the kernel
+// does not report it.
+type peer struct {
+	pid    int
+	uid    int
+	groups []int
+}
+
+func (p peer) String() string {
+	if p.pid > 0 {
+		return fmt.Sprintf("pid %d uid %d", p.pid, p.uid)
+	}
+	return fmt.Sprintf("uid %d", p.uid)
+}
+
+func peerCred(conn *net.UnixConn) (peer, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return peer{}, err
+	}
+	var p peer
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		p, credErr = socketPeer(int(fd))
+	}); err != nil {
+		return peer{}, err
+	}
+	return p, credErr
+}
+
+// Dial connects to the control socket at path.
+func Dial(ctx context.Context, path string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if errors.Is(err, syscall.EACCES) {
+		return nil, fmt.Errorf("%w (run as root or a member of the control group)", err)
+	}
+	return conn, err
+}
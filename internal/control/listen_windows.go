@@ -0,0 +1,167 @@
+//go:build windows
+
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// pipeSDDL grants full access to LocalSystem and elevated Administrators
+// only; the protected DACL drops inherited entries.
+const pipeSDDL = "D:P(A;;GA;;;SY)(A;;GA;;;BA)"
+
+const pipeBufferSize = 64 << 10
+
+// Listen creates the named pipe at path. group is ignored: access is limited
+// by the pipe's ACL, and remote clients are rejected.
+func Listen(path, group string) (net.Listener, error) {
+	sd, err := windows.SecurityDescriptorFromString(pipeSDDL)
+	if err != nil {
+		return nil, fmt.Errorf("control: pipe security descriptor: %w", err)
+	}
+	l := &pipeListener{
+		path: path,
+		sa: &windows.SecurityAttributes{
+			Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+			SecurityDescriptor: sd,
+		},
+	}
+	// The first instance fails if another process already owns the name.
+	h, err := l.newInstance(windows.FILE_FLAG_FIRST_PIPE_INSTANCE)
+	if err != nil {
+		return nil, fmt.Errorf("control: create pipe %s: %w", path, err)
+	}
+	l.next = h
+	return l, nil
+}
+
+type pipeListener struct {
+	path string
+	sa   *windows.SecurityAttributes
+
+	mu     sync.Mutex
+	next   windows.Handle
+	closed bool
+}
+
+func (l *pipeListener) newInstance(extra uint32) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(l.path)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+	return windows.CreateNamedPipe(name,
+		windows.PIPE_ACCESS_DUPLEX|extra,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES, pipeBufferSize, pipeBufferSize, 0, l.sa)
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	h := l.next
+	closed := l.closed
+	l.mu.Unlock()
+	if closed {
+		return nil, net.ErrClosed
+	}
+
+	err := windows.ConnectNamedPipe(h, nil)
+	if err != nil && !errors.Is(err, windows.ERROR_PIPE_CONNECTED) {
+		return nil, fmt.Errorf("control: connect pipe: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		// Close connected to us to unblock ConnectNamedPipe.
+		_ = windows.CloseHandle(h)
+		return nil, net.ErrClosed
+	}
+	// Keep an instance waiting so clients never see the pipe missing.
+	next, err := l.newInstance(0)
+	if err != nil {
+		_ = windows.CloseHandle(h)
+		l.closed = true
+		return nil, fmt.Errorf("control: create pipe %s: %w", l.path, err)
+	}
+	l.next = next
+	return &pipeConn{File: os.NewFile(uintptr(h), l.path), addr: pipeAddr(l.path)}, nil
+}
+
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+	// A blocking ConnectNamedPipe only returns once a client connects.
+	if c, err := dialPipe(l.path); err == nil {
+		_ = c.Close()
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.path)
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is a synchronous pipe handle. Deadlines are not supported.
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+// Dial connects to the named pipe at path, waiting while every instance is
+// busy.
+func Dial(ctx context.Context, path string) (net.Conn, error) {
+	for {
+		c, err := dialPipe(path)
+		if err == nil {
+			return c, nil
+		}
+		switch {
+		case errors.Is(err, windows.ERROR_PIPE_BUSY):
+		case errors.Is(err, windows.ERROR_FILE_NOT_FOUND):
+			return nil, fmt.Errorf("%w (is the splitter running?)", err)
+		case errors.Is(err, windows.ERROR_ACCESS_DENIED):
+			return nil, fmt.Errorf("%w (run from an elevated prompt)", err)
+		default:
+			return nil, err
+		}
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func dialPipe(path string) (net.Conn, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeConn{File: os.NewFile(uintptr(h), path), addr: pipeAddr(path)}, nil
+}
@@ -0,0 +1,24 @@
+package control
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketPeer reads LOCAL_PEERCRED, which carries the caller's groups.
+func socketPeer(fd int) (peer, error) {
+	cred, err := unix.GetsockoptXucred(fd, unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	if err != nil {
+		return peer{}, fmt.Errorf("LOCAL_PEERCRED: %w", err)
+	}
+	p := peer{uid: int(cred.Uid)}
+	n := int(cred.Ngroups)
+	if n > len(cred.Groups) {
+		n = len(cred.Groups)
+	}
+	for _, g := range cred.Groups[:n] {
+		p.groups = append(p.groups, int(g))
+	}
+	return p, nil
+}
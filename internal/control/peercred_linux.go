@@ -0,0 +1,50 @@
+package control
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketPeer reads SO_PEERCRED. The kernel reports only the primary group,
+// so supplementary groups come from /proc/<pid>/status.
+func socketPeer(fd int) (peer, error) {
+	cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return peer{}, fmt.Errorf("SO_PEERCRED: %w", err)
+	}
+	p := peer{pid: int(cred.Pid), uid: int(cred.Uid), groups: []int{int(cred.Gid)}}
+	extra, err := procGroups(int(cred.Pid))
+	if err != nil {
+		return p, nil
+	}
+	p.groups = append(p.groups, extra...)
+	return p, nil
+}
+
+func procGroups(pid int) ([]int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		rest, ok := strings.CutPrefix(sc.Text(), "Groups:")
+		if !ok {
+			continue
+		}
+		var groups []int
+		for _, s := range strings.Fields(rest) {
+			if g, err := strconv.Atoi(s); err == nil {
+				groups = append(groups, g)
+			}
+		}
+		return groups, nil
+	}
+	return nil, sc.Err()
+}
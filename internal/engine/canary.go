@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+
+	"fk-gov/internal/flow"
+)
+
+// Cohort is the canary group a flow was put in when it was created.
+type Cohort uint8
+
+const (
+	// CohortCanary: the flow is split as configured.
+	CohortCanary Cohort = iota
+	// CohortControl: the flow passes through untouched.
+	CohortControl
+)
+
+const numCohorts = int(CohortControl) + 1
+
+func (c Cohort) String() string {
+	switch c {
+	case CohortCanary:
+		return "canary"
+	case CohortControl:
+		return "control"
+	default:
+		return fmt.Sprintf("Cohort(%d)", uint8(c))
+	}
+}
+
+// cohortOf places a new flow. The salted FNV-1a hash of the key is mapped
+// onto [0, 100) and compared with the canary share, so a flow stays in the
+// canary cohort while ControlPercent is only lowered.
+func cohortOf(cfg *Config, key flow.Key) Cohort {
+	canary := 100 - cfg.ControlPercent
+	switch {
+	case canary >= 100:
+		return CohortCanary
+	case canary <= 0:
+		return CohortControl
+	}
+	var b [12]byte
+	copy(b[0:4], key.SrcIP[:])
+	copy(b[4:8], key.DstIP[:])
+	binary.BigEndian.PutUint16(b[8:10], key.SrcPort)
+	binary.BigEndian.PutUint16(b[10:12], key.DstPort)
+	h := fnv.New64a()
+	h.Write([]byte(cfg.CanarySalt))
+	h.Write(b[:])
+	if h.Sum64()%100 < uint64(canary) {
+		return CohortCanary
+	}
+	return CohortControl
+}
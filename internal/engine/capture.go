@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"context"
+
+	"fk-gov/internal/packet"
+	"fk-gov/internal/pcapng"
+)
+
+// SetCapture starts recording packets to c, or stops when c is nil, and
+// returns the capture it replaces so the caller can close it.
+func (e *Engine) SetCapture(c *pcapng.Capture) *pcapng.Capture {
+	return e.capture.Swap(c)
+}
+
+// send passes pkt to the adapter, recording it with note when a capture
+// is running. Shadow copies go nowhere.
+func (e *Engine) send(ctx context.Context, pkt *packet.Packet, note string) error {
+	if pkt.Source == packet.SourceShadow {
+		return nil
+	}
+	e.capture.Load().Record(pcapng.IfaceSent, pkt.Data, note)
+	return e.adapter.Send(ctx, pkt)
+}
+
+// capturing returns the running capture when it wants pkt, so callers only
+// format a note for packets that get recorded.
+func (w *worker) capturing(pkt *packet.Packet) *pcapng.Capture {
+	if w.capture == nil {
+		return nil
+	}
+	if c := w.capture.Load(); c.Wants(pkt.Data) {
+		return c
+	}
+	return nil
+}
+
+// send passes pkt to the adapter, recording it with note when a capture
+// is running. Shadow copies go nowhere.
+func (w *worker) send(ctx context.Context, pkt *packet.Packet, note string) error {
+	if pkt.Source == packet.SourceShadow {
+		return nil
+	}
+	if c := w.capturing(pkt); c != nil {
+		c.Record(pcapng.IfaceSent, pkt.Data, note)
+	}
+	return w.adapter.Send(ctx, pkt)
+}
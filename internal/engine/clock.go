@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"context"
+
+	"fk-gov/internal/clock"
+)
+
+// SetClock makes the engine read the time and run its timers on c, or on
+// the wall clock when c is nil. Flow activity, the collect timeout, idle
+// expiry, hold times, the GC interval and the pause timer all follow it;
+// the timeouts that bound waiting for workers during reload, pause and
+// shutdown stay on the wall clock. It is meant to be called before Run, with
+// a clock.Manual in tests or an adapter's clock when replaying recorded
+// traffic.
+func (e *Engine) SetClock(c clock.Clock) {
+	if c == nil {
+		c = clock.Wall{}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clock = c
+	for _, w := range e.workers {
+		w.clock = c
+	}
+}
+
+// Sync waits until the workers have handled every packet dispatched before
+// the call. An adapter replaying recorded traffic calls it from Recv so each
+// packet sees the effects of the ones before it, whatever the worker count.
+// It returns at once when the engine is not running.
+func (e *Engine) Sync(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.run == nil || e.stopped {
+		return nil
+	}
+	for _, w := range e.workers {
+		done := make(chan struct{})
+		select {
+		case w.barrier <- done:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.run.ctx.Done():
+			return e.run.ctx.Err()
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.run.ctx.Done():
+			return e.run.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// drain handles the touches and packets queued so far, then the handshake
+// outcomes.
+func (w *worker) drain(ctx context.Context) error {
+	if err := w.drainPackets(ctx); err != nil {
+		return err
+	}
+	for n := len(w.handshake); n > 0; n-- {
+		w.handshakeSeen(ctx, <-w.handshake)
+	}
+	return nil
+}
+
+func (w *worker) drainPackets(ctx context.Context) error {
+	for n := len(w.touch); n > 0; n-- {
+		w.touched(<-w.touch)
+	}
+	for n := len(w.in); n > 0; n-- {
+		pkt, ok := <-w.in
+		if !ok {
+			return nil
+		}
+		if err := w.handlePacket(ctx, pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
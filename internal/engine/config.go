@@ -13,6 +13,9 @@ const (
 	SplitModeTLSHello
 )
 
+// numSplitModes bounds SplitMode values, for arrays indexed by mode.
+const numSplitModes = int(SplitModeTLSHello) + 1
+
 func (m SplitMode) String() string {
 	switch m {
 	case SplitModeImmediate:
@@ -48,6 +51,30 @@ type Config struct {
 	// AdapterFlushTimeout bounds the time spent draining adapter-level pending
 	// packets on shutdown.
 	AdapterFlushTimeout time.Duration
+	// ReloadPauseTimeout bounds how long Reload waits for every worker to
+	// pause when WorkerCount or WorkerQueueSize changes. 0 means use a safe
+	// default.
+	ReloadPauseTimeout time.Duration
+
+	// Shadow passes every packet through as soon as it is received and runs
+	// the pipeline on a copy, recording what it would have done.
+	Shadow bool
+
+	// ControlPercent is the share of new flows, 0 to 100, that pass
+	// through untouched as the control cohort; the rest are split. It is
+	// the complement of engine.canary_percent so the zero value splits
+	// every flow. Flows are picked by a hash of their key salted with
+	// CanarySalt, so the same flows are picked on every host with the same
+	// salt, and lowering the percentage only adds flows to the sample.
+	ControlPercent int
+	CanarySalt     string
+
+	// TrackHandshakes classifies how the server answers each flow's
+	// ClientHello from inbound packets with source port 443, which the
+	// adapter must capture as well. A flow with no answer within
+	// HandshakeTimeout counts as a timeout.
+	TrackHandshakes  bool
+	HandshakeTimeout time.Duration
 }
 
 func DefaultConfig() Config {
@@ -69,5 +96,8 @@ func DefaultConfig() Config {
 		ShutdownFailOpenTimeout:    5 * time.Second,
 		ShutdownFailOpenMaxPackets: 200000,
 		AdapterFlushTimeout:        2 * time.Second,
+		ReloadPauseTimeout:         500 * time.Millisecond,
+
+		HandshakeTimeout: 10 * time.Second,
 	}
 }
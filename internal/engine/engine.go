@@ -4,104 +4,267 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"fk-gov/internal/adapter"
+	"fk-gov/internal/clock"
 	"fk-gov/internal/flow"
+	"fk-gov/internal/logging"
 	"fk-gov/internal/packet"
+	"fk-gov/internal/pcapng"
+	"fk-gov/internal/trace"
 )
 
 type Engine struct {
+	// mu serializes Reload with itself and with shutdown, and guards cfg,
+	// run and stopped.
+	mu      sync.Mutex
 	cfg     Config
 	adapter adapter.Adapter
-	sharder *flow.Sharder
-	workers []*worker
+	run     *runState
+	stopped bool
+	// clock is set by SetClock and shared by the workers.
+	clock clock.Clock
+
+	// dispatchMu is held by recvLoop while it hands a packet to a worker, so
+	// holding it pauses dispatch without interrupting a blocked Recv. sharder
+	// and workers only change with both mu and dispatchMu held.
+	dispatchMu sync.Mutex
+	sharder    *flow.Sharder
+	workers    []*worker
+
+	// paused makes workers pass packets through; bypassing, set once they
+	// have released held flows, makes recvLoop skip them entirely.
+	paused    atomic.Bool
+	bypassing atomic.Bool
+	// shadow, trackHandshakes and flowIdleTimeout mirror cfg for recvLoop.
+	shadow          atomic.Bool
+	trackHandshakes atomic.Bool
+	flowIdleTimeout atomic.Int64
+	// pausedFlows holds the connections recvLoop passed through while
+	// paused, with when they were last seen, so their later packets are not
+	// taken for the start of a new flow after Resume. Guarded by dispatchMu.
+	pausedFlows map[flow.Key]time.Time
+	// resumeTimer ends a timed pause; resumeGen tells a stale timer apart
+	// from the current one. Both are guarded by mu.
+	resumeTimer clock.Timer
+	resumeAt    time.Time
+	resumeGen   uint64
+
+	// onFailOpen is the hook set by OnFailOpen.
+	onFailOpen atomic.Pointer[func(FailOpenEvent)]
+	// trace is the ring set by SetTrace.
+	trace atomic.Pointer[trace.Ring]
+	// capture is the packet capture set by SetCapture.
+	capture atomic.Pointer[pcapng.Capture]
+
+	received atomic.Uint64
+	// bypassed counts packets recvLoop passed through while paused.
+	bypassed atomic.Uint64
+	// retired keeps the counters of workers replaced by Reload.
+	retired counters
+	// workerFlushes and adapterFlushes count shutdown flushes by result.
+	workerFlushes  [numFlushResults]atomic.Uint64
+	adapterFlushes [numFlushResults]atomic.Uint64
+}
+
+// runState tracks the worker goroutines of a running engine so workers added
+// by Reload get the same exit handling as the initial set.
+type runState struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	errCh  chan error
 }
 
 func New(cfg Config, ad adapter.Adapter) *Engine {
+	e := &Engine{
+		cfg:     cfg,
+		adapter: ad,
+		clock:   clock.Wall{},
+	}
+	e.sharder, e.workers = e.newWorkers(cfg)
+	e.shadow.Store(cfg.Shadow)
+	e.trackHandshakes.Store(cfg.TrackHandshakes)
+	e.flowIdleTimeout.Store(int64(cfg.FlowIdleTimeout))
+	return e
+}
+
+func (e *Engine) newWorkers(cfg Config) (*flow.Sharder, []*worker) {
 	sharder := flow.NewSharder(cfg.WorkerCount)
 	workers := make([]*worker, sharder.Workers())
 	for i := range workers {
-		workers[i] = newWorker(i, cfg, ad)
-	}
-	return &Engine{
-		cfg:     cfg,
-		adapter: ad,
-		sharder: sharder,
-		workers: workers,
+		workers[i] = newWorker(i, cfg, e.adapter)
+		workers[i].bypass = &e.paused
+		workers[i].onFailOpen = &e.onFailOpen
+		workers[i].tracer = &e.trace
+		workers[i].capture = &e.capture
+		workers[i].clock = e.clock
 	}
+	return sharder, workers
 }
 
 // Reload updates the engine configuration in-place without stopping packet
-// processing. Only settings that do not change the sharding/queue topology are
-// supported; otherwise a full restart is required.
+// processing. A change of WorkerCount or WorkerQueueSize rebuilds the worker
+// set: dispatch pauses, every worker parks after its current packet, flows
+// and queued packets move to the worker the new Sharder picks for them, and
+// dispatch resumes. If a worker does not park within ReloadPauseTimeout the
+// old workers resume and nothing is changed.
 func (e *Engine) Reload(cfg Config) error {
-	if cfg.WorkerCount != len(e.workers) {
-		return fmt.Errorf("reload requires restart: workers %d -> %d", len(e.workers), cfg.WorkerCount)
-	}
-	if cfg.WorkerQueueSize > 0 {
-		for _, w := range e.workers {
-			if cap(w.in) != cfg.WorkerQueueSize {
-				return fmt.Errorf("reload requires restart: worker queue size %d -> %d", cap(w.in), cfg.WorkerQueueSize)
-			}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.topologyChanged(cfg) {
+		if e.stopped {
+			return errors.New("reload: engine stopped")
 		}
+		return e.resize(cfg)
 	}
 	e.cfg = cfg
+	e.shadow.Store(cfg.Shadow)
+	e.trackHandshakes.Store(cfg.TrackHandshakes)
+	e.flowIdleTimeout.Store(int64(cfg.FlowIdleTimeout))
 	for _, w := range e.workers {
 		w.setConfig(cfg)
 	}
 	return nil
 }
 
-func (e *Engine) Run(ctx context.Context) error {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+func (e *Engine) topologyChanged(cfg Config) bool {
+	if flow.NewSharder(cfg.WorkerCount).Workers() != len(e.workers) {
+		return true
+	}
+	return cfg.WorkerQueueSize > 0 && cap(e.workers[0].in) != cfg.WorkerQueueSize
+}
 
-	workerErrCh := make(chan error, 1)
-	var wg sync.WaitGroup
-	for _, w := range e.workers {
-		wg.Add(1)
-		go func(w *worker) {
-			defer wg.Done()
-			err := w.run(ctx)
-			// On worker error, cancel the engine before flushing so recvLoop stops
-			// enqueueing packets while we fail-open.
-			if err != nil && !errors.Is(err, context.Canceled) {
-				cancel()
-			}
+// resize swaps in a worker set built from cfg. It fails without changing
+// anything if the workers cannot be paused; once the swap is done, the only
+// error left is a failed pass-through of a packet that could not be
+// re-queued during shutdown.
+func (e *Engine) resize(cfg Config) error {
+	timeout := 500 * time.Millisecond
+	if cfg.ReloadPauseTimeout > 0 {
+		timeout = cfg.ReloadPauseTimeout
+	}
 
-			// Best-effort fail-open on worker exit so we don't leave packets held in
-			// WinDivert/NFQUEUE/divert paths during shutdown or error handling.
-			flushTimeout := 5 * time.Second
-			if cfg := w.cfg.Load(); cfg != nil && cfg.ShutdownFailOpenTimeout > 0 {
-				flushTimeout = cfg.ShutdownFailOpenTimeout
-			}
-			flushCtx, flushCancel := context.WithTimeout(context.Background(), flushTimeout)
-			flushErr := w.shutdownFailOpen(flushCtx)
-			flushCancel()
-			// Shutdown flushing is bounded. Do not fail the overall stop just because
-			// we hit the guardrails during a normal shutdown.
-			if errors.Is(err, context.Canceled) && (errors.Is(flushErr, context.DeadlineExceeded) || errors.Is(flushErr, ErrShutdownFailOpenLimitReached)) {
-				flushErr = nil
+	e.dispatchMu.Lock()
+	defer e.dispatchMu.Unlock()
+
+	old := e.workers
+	var resumes []chan bool
+	if e.run != nil {
+		var err error
+		resumes, err = e.parkWorkers(old, timeout)
+		if err != nil {
+			for _, resume := range resumes {
+				resume <- false
 			}
-			if flushErr != nil {
-				if err == nil || errors.Is(err, context.Canceled) {
-					err = flushErr
-				} else {
-					err = errors.Join(err, flushErr)
+			return err
+		}
+	}
+
+	sharder, workers := e.newWorkers(cfg)
+	now := e.clock.Now()
+	for _, w := range old {
+		e.retired.add(&w.counters)
+		w.flows.Range(func(key flow.Key, st *flow.FlowState) {
+			workers[sharder.Index(key)].adopt(key, st)
+		})
+	drainTouch:
+		for {
+			select {
+			case key := <-w.touch:
+				if st, ok := workers[sharder.Index(key)].flows.Get(key); ok {
+					st.LastActive = now
 				}
-				cancel()
+			default:
+				break drainTouch
 			}
+		}
+	drainHandshake:
+		for {
+			select {
+			case ev := <-w.handshake:
+				workers[sharder.Index(ev.key)].handshakeSeen(context.Background(), ev)
+			default:
+				break drainHandshake
+			}
+		}
+	}
+	for _, resume := range resumes {
+		resume <- true
+	}
+	e.cfg, e.sharder, e.workers = cfg, sharder, workers
+	e.shadow.Store(cfg.Shadow)
+	e.trackHandshakes.Store(cfg.TrackHandshakes)
+	e.flowIdleTimeout.Store(int64(cfg.FlowIdleTimeout))
+	if e.run == nil {
+		return nil
+	}
 
-			if err != nil && !errors.Is(err, context.Canceled) {
-				select {
-				case workerErrCh <- err:
-				default:
+	for _, w := range workers {
+		e.startWorker(w)
+	}
+	slog.Info("worker set rebuilt", logging.Event(logging.EventWorkersRebuilt), "workers", len(workers), "previous", len(old), "worker_queue_size", cfg.WorkerQueueSize)
+	// Packets still queued on the old workers keep their order: each flow had a
+	// single owner, and its packets are re-queued behind the adopted state
+	// before dispatch resumes.
+	var firstErr error
+	for _, w := range old {
+	drainQueue:
+		for {
+			select {
+			case pkt := <-w.in:
+				if err := workers[sharder.Index(flow.KeyFromMeta(pkt.Meta))].enqueue(e.run.ctx, pkt); err != nil {
+					if sendErr := e.send(context.Background(), pkt, "passed through: reload"); sendErr != nil && firstErr == nil {
+						firstErr = fmt.Errorf("reload: pass through queued packet: %w", sendErr)
+					}
 				}
+			default:
+				break drainQueue
 			}
-		}(w)
+		}
+	}
+	return firstErr
+}
+
+// parkWorkers asks each worker to pause after its current packet. It returns
+// the resume channels of the workers that did, so the caller can release
+// them either way.
+func (e *Engine) parkWorkers(workers []*worker, timeout time.Duration) ([]chan bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	resumes := make([]chan bool, 0, len(workers))
+	for _, w := range workers {
+		resume := make(chan bool, 1)
+		select {
+		case w.park <- resume:
+			resumes = append(resumes, resume)
+		case <-timer.C:
+			return resumes, fmt.Errorf("reload: worker %d did not pause within %s; workers unchanged", w.id, timeout)
+		case <-e.run.ctx.Done():
+			return resumes, e.run.ctx.Err()
+		}
+	}
+	return resumes, nil
+}
+
+func (e *Engine) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rs := &runState{ctx: ctx, cancel: cancel, errCh: make(chan error, 1)}
+	e.mu.Lock()
+	e.run = rs
+	for _, w := range e.workers {
+		e.startWorker(w)
 	}
+	slog.Info("engine started", logging.Event(logging.EventEngineStarted), "workers", len(e.workers), "split_mode", e.cfg.SplitMode)
+	e.mu.Unlock()
 
 	recvErrCh := make(chan error, 1)
 	go func() {
@@ -111,7 +274,7 @@ func (e *Engine) Run(ctx context.Context) error {
 	var err error
 	recvDrained := false
 	select {
-	case err = <-workerErrCh:
+	case err = <-rs.errCh:
 		cancel()
 		// Ensure recvLoop has stopped sending into worker queues before we close them.
 		<-recvErrCh
@@ -121,19 +284,25 @@ func (e *Engine) Run(ctx context.Context) error {
 		cancel()
 	}
 
-	for _, w := range e.workers {
-		w.close()
-	}
-	wg.Wait()
-
-	// Fail-open any adapter-level pending packets before closing the handle.
+	e.mu.Lock()
+	e.stopped = true
+	workers := e.workers
 	adapterFlushTimeout := 2 * time.Second
 	if e.cfg.AdapterFlushTimeout > 0 {
 		adapterFlushTimeout = e.cfg.AdapterFlushTimeout
 	}
+	e.mu.Unlock()
+
+	for _, w := range workers {
+		w.close()
+	}
+	rs.wg.Wait()
+
+	// Fail-open any adapter-level pending packets before closing the handle.
 	flushCtx, flushCancel := context.WithTimeout(context.Background(), adapterFlushTimeout)
 	flushErr := e.adapter.Flush(flushCtx)
 	flushCancel()
+	e.recordFlush(&e.adapterFlushes, "adapter", -1, flushErr)
 	if errors.Is(err, context.Canceled) && errors.Is(flushErr, context.DeadlineExceeded) {
 		flushErr = nil
 	}
@@ -155,18 +324,85 @@ func (e *Engine) Run(ctx context.Context) error {
 	// (including shutdown flush errors).
 	if errors.Is(err, context.Canceled) {
 		select {
-		case werr := <-workerErrCh:
+		case werr := <-rs.errCh:
 			err = werr
 		default:
 		}
 	}
 
+	slog.Info("engine stopped", logging.Event(logging.EventEngineStopped))
 	if errors.Is(err, context.Canceled) {
 		return nil
 	}
 	return err
 }
 
+// recordFlush counts a shutdown flush of stage and warns when it did not
+// finish cleanly; worker is -1 for the adapter.
+func (e *Engine) recordFlush(counts *[numFlushResults]atomic.Uint64, stage string, worker int, err error) {
+	res := flushResultOf(err)
+	counts[res].Add(1)
+	if res == FlushOK {
+		return
+	}
+	attrs := []any{logging.Event(logging.EventShutdownFlush), "stage", stage, "result", res.String(), logging.Err(err)}
+	if worker >= 0 {
+		attrs = append(attrs, "worker", worker)
+	}
+	slog.Warn("shutdown flush incomplete; some packets may have been dropped", attrs...)
+}
+
+// startWorker runs w until it exits, then fails open whatever it still holds
+// unless it was retired by Reload. Callers hold e.mu.
+func (e *Engine) startWorker(w *worker) {
+	rs := e.run
+	rs.wg.Add(1)
+	go func() {
+		defer rs.wg.Done()
+		err := w.run(rs.ctx)
+		if errors.Is(err, errWorkerRetired) {
+			return
+		}
+		// On worker error, cancel the engine before flushing so recvLoop stops
+		// enqueueing packets while we fail-open.
+		if err != nil && !errors.Is(err, context.Canceled) {
+			rs.cancel()
+		}
+
+		// Best-effort fail-open on worker exit so we don't leave packets held in
+		// WinDivert/NFQUEUE/divert paths during shutdown or error handling.
+		flushTimeout := 5 * time.Second
+		if cfg := w.cfg.Load(); cfg != nil && cfg.ShutdownFailOpenTimeout > 0 {
+			flushTimeout = cfg.ShutdownFailOpenTimeout
+		}
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), flushTimeout)
+		flushErr := w.shutdownFailOpen(flushCtx)
+		flushCancel()
+		e.recordFlush(&e.workerFlushes, "worker", w.id, flushErr)
+		// Shutdown flushing is bounded. Do not fail the overall stop just because
+		// we hit the guardrails during a normal shutdown.
+		if errors.Is(err, context.Canceled) && (errors.Is(flushErr, context.DeadlineExceeded) || errors.Is(flushErr, ErrShutdownFailOpenLimitReached)) {
+			flushErr = nil
+		}
+		if flushErr != nil {
+			if err == nil || errors.Is(err, context.Canceled) {
+				err = flushErr
+			} else {
+				err = errors.Join(err, flushErr)
+			}
+			rs.cancel()
+		}
+
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("worker failed; stopping the engine", logging.Event(logging.EventWorkerFailed), "worker", w.id, logging.Err(err))
+			select {
+			case rs.errCh <- err:
+			default:
+			}
+		}
+	}()
+}
+
 func (e *Engine) recvLoop(ctx context.Context) error {
 	for {
 		pkt, err := e.adapter.Recv(ctx)
@@ -176,62 +412,95 @@ func (e *Engine) recvLoop(ctx context.Context) error {
 		if pkt == nil {
 			continue
 		}
+		e.received.Add(1)
+		e.capture.Load().Record(pcapng.IfaceCaptured, pkt.Data, "")
 
 		if err := packet.DecodeIPv4TCP(pkt); err != nil {
-			if sendErr := e.adapter.Send(ctx, pkt); sendErr != nil {
+			if sendErr := e.send(ctx, pkt, "passed through: not IPv4 TCP"); sendErr != nil {
 				return sendErr
 			}
 			continue
 		}
 
-		if pkt.Meta.DstPort != 443 {
-			if sendErr := e.adapter.Send(ctx, pkt); sendErr != nil {
+		if pkt.Meta.SrcPort == 443 && pkt.Meta.DstPort != 443 && e.trackHandshakes.Load() {
+			// The server's side of a flow: classify it before the adapter
+			// may reuse the buffer, and never hold it.
+			outcome, ok := classifyInbound(pkt)
+			key := outboundKey(pkt.Meta)
+			if sendErr := e.send(ctx, pkt, "passed through: inbound"); sendErr != nil {
 				return sendErr
 			}
+			if ok {
+				e.observeInbound(key, outcome)
+			}
 			continue
 		}
 
-		payload := pkt.Payload()
-		key := flow.KeyFromMeta(pkt.Meta)
-		if len(payload) == 0 {
-			// FIN/RST should go through the worker so flow state is cleaned up
-			// promptly (ACK-only fast-path would otherwise keep the flow alive).
-			if pkt.HasFlag(packet.TCPFlagFIN) || pkt.HasFlag(packet.TCPFlagRST) {
-				idx := e.sharder.Index(key)
-				if err := e.workers[idx].enqueue(ctx, pkt); err != nil {
-					if errors.Is(err, context.Canceled) {
-						if sendErr := e.adapter.Send(context.Background(), pkt); sendErr != nil {
-							return sendErr
-						}
-						continue
-					}
-					return err
-				}
-				continue
+		if pkt.Meta.DstPort != 443 {
+			if sendErr := e.send(ctx, pkt, "passed through: not port 443"); sendErr != nil {
+				return sendErr
 			}
+			continue
+		}
 
-			// Avoid enqueueing ACK-only packets through the worker queue. Instead,
-			// pass-through immediately and best-effort "touch" the flow so GC does
-			// not evict active connections and accidentally re-process them later.
-			idx := e.sharder.Index(key)
-			e.workers[idx].touchFlow(key)
-			if sendErr := e.adapter.Send(ctx, pkt); sendErr != nil {
+		if e.shadow.Load() {
+			// Copy before the verdict: the adapter may reuse the buffer
+			// as soon as it has one.
+			cp := shadowCopy(pkt)
+			if sendErr := e.send(ctx, pkt, "passed through: shadow"); sendErr != nil {
 				return sendErr
 			}
-			continue
+			pkt = cp
 		}
 
-		idx := e.sharder.Index(key)
-		if err := e.workers[idx].enqueue(ctx, pkt); err != nil {
-			if errors.Is(err, context.Canceled) {
-				// During shutdown, fail-open by passing through any packets we
-				// already captured instead of leaving them held.
-				if sendErr := e.adapter.Send(context.Background(), pkt); sendErr != nil {
-					return sendErr
-				}
-				continue
+		e.dispatchMu.Lock()
+		if e.bypassing.Load() {
+			// Checked under dispatchMu so nothing overtakes packets that were
+			// queued to a worker before the pause.
+			e.notePausedFlow(pkt)
+			e.dispatchMu.Unlock()
+			e.bypassed.Add(1)
+			if sendErr := e.send(ctx, pkt, "passed through: paused"); sendErr != nil {
+				return sendErr
 			}
+			continue
+		}
+		err = e.dispatch(ctx, pkt)
+		e.dispatchMu.Unlock()
+		if err != nil {
 			return err
 		}
 	}
 }
+
+// dispatch hands a decoded port-443 packet to the worker that owns its flow.
+// Callers hold e.dispatchMu.
+func (e *Engine) dispatch(ctx context.Context, pkt *packet.Packet) error {
+	if e.pausedFlow(pkt) {
+		return e.send(ctx, pkt, "passed through: began while paused")
+	}
+	payload := pkt.Payload()
+	key := flow.KeyFromMeta(pkt.Meta)
+	w := e.workers[e.sharder.Index(key)]
+	if len(payload) == 0 {
+		// FIN/RST should go through the worker so flow state is cleaned up
+		// promptly (ACK-only fast-path would otherwise keep the flow alive).
+		if !pkt.HasFlag(packet.TCPFlagFIN) && !pkt.HasFlag(packet.TCPFlagRST) {
+			// Avoid enqueueing ACK-only packets through the worker queue. Instead,
+			// pass-through immediately and best-effort "touch" the flow so GC does
+			// not evict active connections and accidentally re-process them later.
+			w.touchFlow(key)
+			return e.send(ctx, pkt, "passed through: ACK only")
+		}
+	}
+
+	if err := w.enqueue(ctx, pkt); err != nil {
+		if errors.Is(err, context.Canceled) {
+			// During shutdown, fail-open by passing through any packets we
+			// already captured instead of leaving them held.
+			return e.send(context.Background(), pkt, "passed through: shutting down")
+		}
+		return err
+	}
+	return nil
+}
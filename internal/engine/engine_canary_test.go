@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"fk-gov/internal/adapter/adaptertest"
+	"fk-gov/internal/flow"
+	"fk-gov/internal/packet"
+)
+
+func TestCohortOf(t *testing.T) {
+	cfg := DefaultConfig()
+	keys := make([]flow.Key, 10000)
+	for i := range keys {
+		keys[i] = flow.Key{SrcIP: [4]byte{10, 0, byte(i >> 8), byte(i)}, DstIP: [4]byte{192, 0, 2, 1}, SrcPort: uint16(40000 + i%1000), DstPort: 443, Proto: 6}
+	}
+	count := func(pct int, salt string) (canary int, in map[flow.Key]bool) {
+		cfg.ControlPercent, cfg.CanarySalt = 100-pct, salt
+		in = make(map[flow.Key]bool)
+		for _, k := range keys {
+			if cohortOf(&cfg, k) == CohortCanary {
+				canary++
+				in[k] = true
+			}
+		}
+		return canary, in
+	}
+
+	if n, _ := count(0, ""); n != 0 {
+		t.Fatalf("0%%: %d canary flows", n)
+	}
+	if n, _ := count(100, ""); n != len(keys) {
+		t.Fatalf("100%%: %d canary flows", n)
+	}
+	n10, in10 := count(10, "a")
+	if n10 < 800 || n10 > 1200 {
+		t.Fatalf("10%%: %d of %d canary flows", n10, len(keys))
+	}
+	// Ramping up keeps every flow already in the sample.
+	_, in20 := count(20, "a")
+	for k := range in10 {
+		if !in20[k] {
+			t.Fatalf("%v left the canary cohort when the percentage went up", k)
+		}
+	}
+	_, other := count(10, "b")
+	same := 0
+	for k := range in10 {
+		if other[k] {
+			same++
+		}
+	}
+	if same > n10/2 {
+		t.Fatalf("a new salt kept %d of %d canary flows", same, n10)
+	}
+}
+
+func TestCohortOfZeroConfigSplitsEveryFlow(t *testing.T) {
+	var cfg Config
+	key := flow.Key{SrcIP: [4]byte{10, 0, 0, 1}, DstIP: [4]byte{192, 0, 2, 1}, SrcPort: 40000, DstPort: 443, Proto: 6}
+	if c := cohortOf(&cfg, key); c != CohortCanary {
+		t.Fatalf("zero Config put a flow in %v, want canary", c)
+	}
+}
+
+func TestEngineCanary_ControlPassesThrough(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	cfg.ControlPercent = 100
+	ad := adaptertest.New(adaptertest.Verdict)
+	eng := New(cfg, ad)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	hello := helloWithSNI("www.example.com")
+	orig := tcpPacket(40000, 1000, hello)
+	ad.Queue(orig)
+	waitFor(t, "the control flow", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.FlowsByCohort[CohortControl] == 1
+	})
+
+	// Ramping up applies to new flows only.
+	cfg.ControlPercent = 0
+	if err := eng.Reload(cfg); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	ad.Queue(tcpPacket(40000, 1000+uint32(len(hello)), []byte("more")))
+	ad.Queue(tcpPacket(40001, 1000, hello))
+	waitFor(t, "the canary flow to be split", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.Splits == 1
+	})
+	st, err := eng.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if st.FlowsByCohort[CohortCanary] != 1 || st.FlowsByCohort[CohortControl] != 1 || st.FailOpens != 0 || st.CanaryPercent != 100 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if fate, _ := ad.Fate(orig); fate != adaptertest.FateSent {
+		t.Fatalf("control flow's hello: %v, want sent", fate)
+	}
+	// The control flow's two packets, then the canary flow's two segments.
+	if n := len(ad.Wire()); n != 4 {
+		t.Fatalf("wire has %d packets, want 4", n)
+	}
+}
+
+func TestEngineCanary_CountsFlowEndsByCohort(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	cfg.ControlPercent = 100
+	ad := adaptertest.New(adaptertest.Verdict)
+	eng := New(cfg, ad)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	hello := helloWithSNI("www.example.com")
+	ad.Queue(tcpPacket(40000, 1000, hello))
+	waitFor(t, "the control flow", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.FlowsByCohort[CohortControl] == 1
+	})
+	cfg.ControlPercent = 0
+	if err := eng.Reload(cfg); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	ad.Queue(tcpPacket(40001, 1000, hello))
+	waitFor(t, "the canary flow to be split", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.Splits == 1
+	})
+
+	rst := tcpPacket(40000, 1000+uint32(len(hello)), nil)
+	rst.Data[33] = packet.TCPFlagRST | packet.TCPFlagACK
+	fin := tcpPacket(40001, 1000+uint32(len(hello)), nil)
+	fin.Data[33] = packet.TCPFlagFIN | packet.TCPFlagACK
+	ad.Queue(rst)
+	ad.Queue(fin)
+	var st Stats
+	waitFor(t, "both flows to end", func() bool {
+		var err error
+		st, err = eng.Stats(ctx)
+		return err == nil && st.FlowsEnded[CohortControl][EndRST]+st.FlowsEnded[CohortCanary][EndFIN] == 2
+	})
+	if st.FlowsEnded[CohortControl][EndFIN] != 0 || st.FlowsEnded[CohortCanary][EndRST] != 0 {
+		t.Fatalf("unexpected flow ends: %v", st.FlowsEnded)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
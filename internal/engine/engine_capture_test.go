@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fk-gov/internal/packet"
+	"fk-gov/internal/pcapng"
+)
+
+func TestEngineCapture_RecordsSplit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	ad := &chanAdapter{in: make(chan *packet.Packet, 4)}
+	eng := New(cfg, ad)
+	path := filepath.Join(t.TempDir(), "cap.pcapng")
+	c, err := pcapng.Open(pcapng.Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old := eng.SetCapture(c); old != nil {
+		t.Fatal("capture set before SetCapture")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	ad.in <- tcpPacket(40000, 1000, helloWithSNI("www.example.com"))
+	waitFor(t, "the flow to be split", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.Splits == 1
+	})
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if err := eng.SetCapture(nil).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The comments are stored verbatim, so a substring search is enough
+	// without parsing the blocks.
+	for _, want := range []string{"split segment 1/2, seq +0", "split segment 2/2, seq +5", "dropped: replaced by split segments"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("capture has no %q comment", want)
+		}
+	}
+}
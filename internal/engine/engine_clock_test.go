@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"fk-gov/internal/clock"
+	"fk-gov/internal/flow"
+	"fk-gov/internal/packet"
+)
+
+func TestEngineClock_CollectTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	ad := &chanAdapter{in: make(chan *packet.Packet, 4)}
+	eng := New(cfg, ad)
+	start := time.Unix(1700000000, 0)
+	clk := clock.NewManual(start)
+	eng.SetClock(clk)
+	if err := eng.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync before Run: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	hello := helloWithSNI("www.example.com")
+	ad.in <- tcpPacket(40000, 1000, hello[:10])
+	waitFor(t, "the first half to be held", func() bool {
+		flows, err := eng.Flows(ctx, 0)
+		return err == nil && len(flows) == 1 && flows[0].HeldPackets == 1
+	})
+	flows, err := eng.Flows(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flows[0].CollectStart.Equal(start) {
+		t.Fatalf("collect start = %v, want the clock's %v", flows[0].CollectStart, start)
+	}
+
+	// Only the engine's clock moves past the collect timeout.
+	clk.Advance(time.Second)
+	ad.in <- tcpPacket(40000, 1010, hello[10:])
+	waitFor(t, "the flow to fail open", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.FailOpensByReason[flow.FailOpenCollectTimeout] == 1
+	})
+	if err := eng.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(ad.drops) != 0 {
+		t.Fatalf("dropped %d packets; the flow should not have been split", len(ad.drops))
+	}
+}
+
+func TestEngineClock_IdleExpiry(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	cfg.GCInterval = time.Second
+	cfg.FlowIdleTimeout = 10 * time.Second
+	ad := &chanAdapter{in: make(chan *packet.Packet, 4)}
+	eng := New(cfg, ad)
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	eng.SetClock(clk)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	}()
+
+	ad.in <- tcpPacket(40000, 1000, helloWithSNI("www.example.com"))
+	waitFor(t, "the flow to be split", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.Splits == 1
+	})
+	evicted := func() uint64 {
+		t.Helper()
+		// Sync runs a GC the clock made due before returning.
+		if err := eng.Sync(ctx); err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+		st, err := eng.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.FlowsEvicted
+	}
+	if n := evicted(); n != 0 {
+		t.Fatalf("evicted = %d, want 0", n)
+	}
+
+	clk.Advance(5 * time.Second)
+	if n := evicted(); n != 0 {
+		t.Fatalf("evicted after 5s = %d, want 0", n)
+	}
+	clk.Advance(6 * time.Second)
+	if n := evicted(); n != 1 {
+		t.Fatalf("evicted after 11s idle = %d, want 1", n)
+	}
+}
+
+func TestEngineClock_PauseTimer(t *testing.T) {
+	eng := New(DefaultConfig(), &chanAdapter{in: make(chan *packet.Packet)})
+	start := time.Unix(1700000000, 0)
+	clk := clock.NewManual(start)
+	eng.SetClock(clk)
+
+	if err := eng.Pause(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if paused, at := eng.pauseState(); !paused || !at.Equal(start.Add(time.Minute)) {
+		t.Fatalf("pause state = %v, %v; want paused until %v", paused, at, start.Add(time.Minute))
+	}
+	clk.Advance(59 * time.Second)
+	if !eng.Paused() {
+		t.Fatal("resumed before the timer")
+	}
+	clk.Advance(time.Second)
+	if eng.Paused() {
+		t.Fatal("still paused after the timer")
+	}
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"fk-gov/internal/clock"
+	"fk-gov/internal/flow"
+	"fk-gov/internal/packet"
+)
+
+// serverPacket builds a captured IPv4/TCP packet from 1.1.1.1:443 back to
+// 10.0.0.2:dstPort.
+func serverPacket(dstPort uint16, flags uint8, payload []byte) *packet.Packet {
+	pkt := tcpPacket(443, 5000, payload)
+	copy(pkt.Data[12:16], []byte{1, 1, 1, 1})
+	copy(pkt.Data[16:20], []byte{10, 0, 0, 2})
+	binary.BigEndian.PutUint16(pkt.Data[22:24], dstPort)
+	pkt.Data[33] = flags
+	return pkt
+}
+
+func TestClassifyInbound(t *testing.T) {
+	cases := []struct {
+		name  string
+		pkt   *packet.Packet
+		want  flow.HandshakeOutcome
+		known bool
+	}{
+		{"server hello", serverPacket(40000, packet.TCPFlagACK, []byte{0x16, 3, 3, 0, 80, 0x02, 0, 0, 76}), flow.HandshakeServerHello, true},
+		{"alert", serverPacket(40000, packet.TCPFlagACK, []byte{0x15, 3, 3, 0, 2, 2, 40}), flow.HandshakeAlert, true},
+		{"rst", serverPacket(40000, packet.TCPFlagRST|packet.TCPFlagACK, nil), flow.HandshakeRST, true},
+		{"syn-ack", serverPacket(40000, packet.TCPFlagSYN|packet.TCPFlagACK, nil), flow.HandshakePending, false},
+		{"other record", serverPacket(40000, packet.TCPFlagACK, []byte{0x17, 3, 3, 0, 1, 0}), flow.HandshakePending, false},
+	}
+	for _, tc := range cases {
+		if err := packet.DecodeIPv4TCP(tc.pkt); err != nil {
+			t.Fatalf("%s: decode: %v", tc.name, err)
+		}
+		got, known := classifyInbound(tc.pkt)
+		if got != tc.want || known != tc.known {
+			t.Fatalf("%s: got %v, %v; want %v, %v", tc.name, got, known, tc.want, tc.known)
+		}
+	}
+}
+
+func TestEngineHandshake_ClassifiesOutcomes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 2
+	cfg.GCInterval = time.Second
+	cfg.HandshakeTimeout = 5 * time.Second
+	cfg.TrackHandshakes = true
+	ad := &chanAdapter{in: make(chan *packet.Packet, 16)}
+	eng := New(cfg, ad)
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	eng.SetClock(clk)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	}()
+
+	hello := helloWithSNI("www.example.com")
+	for port := uint16(40000); port < 40003; port++ {
+		ad.in <- tcpPacket(port, 1000, hello)
+	}
+	ad.in <- serverPacket(40000, packet.TCPFlagACK, []byte{0x16, 3, 3, 0, 80, 0x02, 0, 0, 76})
+	ad.in <- serverPacket(40001, packet.TCPFlagRST|packet.TCPFlagACK, nil)
+	// A second answer does not change a classified flow.
+	ad.in <- serverPacket(40000, packet.TCPFlagRST|packet.TCPFlagACK, nil)
+
+	strategy := splitStrategy(&cfg)
+	byStrategy := func() map[flow.HandshakeOutcome]uint64 {
+		t.Helper()
+		st, err := eng.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.HandshakesByStrategy()[strategy]
+	}
+	waitFor(t, "the answers to be classified", func() bool {
+		if err := eng.Sync(ctx); err != nil {
+			return false
+		}
+		m := byStrategy()
+		return m[flow.HandshakeServerHello] == 1 && m[flow.HandshakeRST] == 1
+	})
+
+	clk.Advance(6 * time.Second)
+	if err := eng.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	st, err := eng.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Handshakes) != 1 {
+		t.Fatalf("handshake rows = %+v, want one", st.Handshakes)
+	}
+	row := st.Handshakes[0]
+	want := map[flow.HandshakeOutcome]uint64{
+		flow.HandshakeServerHello: 1,
+		flow.HandshakeRST:         1,
+		flow.HandshakeAlert:       0,
+		flow.HandshakeTimeout:     1,
+	}
+	if row.Dst != netip.MustParseAddr("1.1.1.1") || row.Strategy != strategy || len(row.Outcomes) != len(want) {
+		t.Fatalf("row = %+v", row)
+	}
+	for o, n := range want {
+		if row.Outcomes[o] != n {
+			t.Fatalf("%s = %d, want %d (row %+v)", o, row.Outcomes[o], n, row)
+		}
+	}
+	// Inbound packets are passed through, never held or dropped.
+	ad.mu.Lock()
+	drops := len(ad.drops)
+	ad.mu.Unlock()
+	if drops != 3 {
+		t.Fatalf("dropped %d packets, want the 3 split hellos", drops)
+	}
+}
+
+func TestHandshakeTable_Overflow(t *testing.T) {
+	var tbl handshakeTable
+	for i := 0; i < maxHandshakeRows+10; i++ {
+		tbl.addN(handshakeKey{dst: [4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)}, strategy: "tls-hello/5"}, flow.HandshakeServerHello, 1)
+	}
+	rows := tbl.snapshot()
+	if len(rows) != maxHandshakeRows+1 {
+		t.Fatalf("rows = %d, want %d", len(rows), maxHandshakeRows+1)
+	}
+	if !rows[0].Dst.IsUnspecified() || rows[0].Outcomes[flow.HandshakeServerHello] != 10 {
+		t.Fatalf("overflow row = %+v", rows[0])
+	}
+}
@@ -1,36 +1,224 @@
 package engine
 
 import (
+	"context"
+	"encoding/binary"
+	"sync"
 	"testing"
+	"time"
 
 	"fk-gov/internal/adapter"
+	"fk-gov/internal/adapter/adaptertest"
+	"fk-gov/internal/flow"
+	"fk-gov/internal/packet"
 )
 
-func TestEngineReload_RejectsWorkerCountChange(t *testing.T) {
+func TestEngineReload_ResizesIdleEngine(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.WorkerCount = 2
 
 	eng := New(cfg, adapter.NewStub())
+	key := flow.Key{SrcPort: 1234, DstPort: 443, Proto: 6}
+	st := eng.workers[eng.sharder.Index(key)].flows.GetOrCreate(key, time.Now())
 
 	next := cfg
 	next.WorkerCount = 3
+	next.WorkerQueueSize = cfg.WorkerQueueSize + 1
+	if err := eng.Reload(next); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if len(eng.workers) != 3 || eng.sharder.Workers() != 3 {
+		t.Fatalf("workers: got %d (sharder %d), want 3", len(eng.workers), eng.sharder.Workers())
+	}
+	for i, w := range eng.workers {
+		if cap(w.in) != next.WorkerQueueSize {
+			t.Fatalf("worker %d queue size: got %d want %d", i, cap(w.in), next.WorkerQueueSize)
+		}
+	}
+	if got, ok := eng.workers[eng.sharder.Index(key)].flows.Get(key); !ok || got != st {
+		t.Fatalf("flow not moved to its new shard")
+	}
+}
+
+func TestEngineReload_RejectsResizeAfterStop(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
 
+	eng := New(cfg, adaptertest.New(adaptertest.Verdict))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := eng.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	next := cfg
+	next.WorkerCount = 2
 	if err := eng.Reload(next); err == nil {
-		t.Fatalf("expected reload to fail when workers change")
+		t.Fatalf("expected reload to fail once the engine stopped")
+	}
+}
+
+// chanAdapter feeds Recv from a channel and records what the engine sends
+// and drops. Send blocks while block is non-nil and open.
+type chanAdapter struct {
+	in    chan *packet.Packet
+	mu    sync.Mutex
+	sends []*packet.Packet
+	drops []*packet.Packet
+	block chan struct{}
+}
+
+func (a *chanAdapter) Recv(ctx context.Context) (*packet.Packet, error) {
+	select {
+	case pkt := <-a.in:
+		return pkt, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (a *chanAdapter) Send(ctx context.Context, pkt *packet.Packet) error {
+	if a.block != nil {
+		<-a.block
+	}
+	a.mu.Lock()
+	a.sends = append(a.sends, pkt)
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *chanAdapter) Drop(ctx context.Context, pkt *packet.Packet) error {
+	a.mu.Lock()
+	a.drops = append(a.drops, pkt)
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *chanAdapter) CalcChecksums(pkt *packet.Packet) error { return nil }
+func (a *chanAdapter) Flush(ctx context.Context) error        { return nil }
+func (a *chanAdapter) Close() error                           { return nil }
+
+func (a *chanAdapter) counts() (int, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.sends), len(a.drops)
+}
+
+// tcpPacket builds a captured IPv4/TCP packet from 10.0.0.2:srcPort to
+// 1.1.1.1:443.
+func tcpPacket(srcPort uint16, seq uint32, payload []byte) *packet.Packet {
+	buf := make([]byte, 40+len(payload))
+	buf[0] = 0x45
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+	buf[8] = 64
+	buf[9] = 6
+	copy(buf[12:16], []byte{10, 0, 0, 2})
+	copy(buf[16:20], []byte{1, 1, 1, 1})
+	binary.BigEndian.PutUint16(buf[20:22], srcPort)
+	binary.BigEndian.PutUint16(buf[22:24], 443)
+	binary.BigEndian.PutUint32(buf[24:28], seq)
+	buf[32] = 5 << 4
+	buf[33] = packet.TCPFlagACK | packet.TCPFlagPSH
+	copy(buf[40:], payload)
+	return &packet.Packet{Data: buf, Source: packet.SourceCaptured}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
 	}
 }
 
-func TestEngineReload_RejectsWorkerQueueSizeChange(t *testing.T) {
+func TestEngineReload_MovesFlowsWhileRunning(t *testing.T) {
 	cfg := DefaultConfig()
-	cfg.WorkerCount = 2
+	cfg.WorkerCount = 1
+	cfg.CollectTimeout = time.Minute
+	ad := &chanAdapter{in: make(chan *packet.Packet, 64)}
+	eng := New(cfg, ad)
 
-	eng := New(cfg, adapter.NewStub())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	// A 25-byte ClientHello record per flow, split across two packets so every
+	// flow is still collecting when the worker set changes.
+	hello := append([]byte{0x16, 0x03, 0x01, 0x00, 20, 0x01}, make([]byte, 19)...)
+	const flows = 8
+	for i := 0; i < flows; i++ {
+		ad.in <- tcpPacket(uint16(40000+i), 1000, hello[:10])
+	}
+	waitFor(t, "first packets to be dispatched", func() bool { return len(ad.in) == 0 })
 
 	next := cfg
-	next.WorkerQueueSize = cfg.WorkerQueueSize + 1
+	next.WorkerCount = 4
+	next.WorkerQueueSize = 16
+	if err := eng.Reload(next); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if len(eng.workers) != 4 {
+		t.Fatalf("workers: got %d want 4", len(eng.workers))
+	}
+
+	for i := 0; i < flows; i++ {
+		ad.in <- tcpPacket(uint16(40000+i), 1010, hello[10:])
+	}
+	// Each flow injects 5+15+5 bytes and drops its two held originals.
+	waitFor(t, "split segments", func() bool {
+		sends, drops := ad.counts()
+		return sends == flows*3 && drops == flows*2
+	})
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	for _, pkt := range ad.sends {
+		if pkt.Source != packet.SourceInjected {
+			t.Fatalf("held packet failed open across reload: %+v", pkt.Meta)
+		}
+	}
+}
+
+func TestEngineReload_KeepsWorkersWhenPauseTimesOut(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	ad := &chanAdapter{in: make(chan *packet.Packet, 4), block: make(chan struct{})}
+	eng := New(cfg, ad)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
 
+	// Not a ClientHello: the worker fails open and blocks in Send.
+	ad.in <- tcpPacket(40000, 1000, []byte("GET / HTTP/1.1\r\n"))
+	waitFor(t, "packet to be dispatched", func() bool { return len(ad.in) == 0 })
+
+	next := cfg
+	next.WorkerCount = 2
+	next.ReloadPauseTimeout = 20 * time.Millisecond
 	if err := eng.Reload(next); err == nil {
-		t.Fatalf("expected reload to fail when worker queue size changes")
+		t.Fatalf("expected reload to time out while the worker is busy")
+	}
+	if len(eng.workers) != 1 {
+		t.Fatalf("workers changed after failed reload: %d", len(eng.workers))
+	}
+
+	close(ad.block)
+	waitFor(t, "blocked send", func() bool {
+		sends, _ := ad.counts()
+		return sends == 1
+	})
+	if err := eng.Reload(next); err != nil {
+		t.Fatalf("reload after the worker unblocked: %v", err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
 	}
 }
 
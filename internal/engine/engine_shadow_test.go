@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"fk-gov/internal/adapter/adaptertest"
+	"fk-gov/internal/flow"
+	"fk-gov/internal/packet"
+)
+
+func TestEngineShadow_PassesThrough(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	cfg.Shadow = true
+	ad := adaptertest.New(adaptertest.Verdict)
+	eng := New(cfg, ad)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	orig := tcpPacket(40000, 1000, helloWithSNI("www.example.com"))
+	ad.Queue(orig)
+	waitFor(t, "the shadow split", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.ShadowDecisions.Splits == 1
+	})
+	st, err := eng.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if !st.Shadow || st.Splits != 0 || st.ShadowDecisions.Segments != 2 || st.ShadowDecisions.HoldTime.Count != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if fate, _ := ad.Fate(orig); fate != adaptertest.FateSent {
+		t.Fatalf("original: %v, want sent", fate)
+	}
+	if w := ad.Wire(); len(w) != 1 || w[0] != orig {
+		t.Fatalf("wire has %d packets, want only the original", len(w))
+	}
+	if n := len(ad.Packets(adaptertest.OpDrop)); n != 0 {
+		t.Fatalf("%d drops in shadow mode", n)
+	}
+}
+
+// recyclingAdapter clears a packet's buffer once it is sent, like adapters
+// that reuse their receive buffers after a verdict.
+type recyclingAdapter struct {
+	chanAdapter
+}
+
+func (a *recyclingAdapter) Send(ctx context.Context, pkt *packet.Packet) error {
+	err := a.chanAdapter.Send(ctx, pkt)
+	for i := range pkt.Data {
+		pkt.Data[i] = 0
+	}
+	return err
+}
+
+func TestEngineShadow_CopiesBeforeSend(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	cfg.Shadow = true
+	ad := &recyclingAdapter{chanAdapter{in: make(chan *packet.Packet, 1)}}
+	eng := New(cfg, ad)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	}()
+
+	ad.in <- tcpPacket(40000, 1000, helloWithSNI("www.example.com"))
+	waitFor(t, "the shadow decision", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.ShadowDecisions.Splits+st.ShadowDecisions.FailOpens == 1
+	})
+	st, err := eng.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if st.ShadowDecisions.Splits != 1 {
+		t.Fatalf("shadow decisions = %+v, want the split of the intact hello", st.ShadowDecisions)
+	}
+}
+
+func TestEngineShadow_ReloadReleasesCollecting(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	ad := adaptertest.New(adaptertest.Verdict)
+	eng := New(cfg, ad)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	hello := helloWithSNI("www.example.com")
+	first := tcpPacket(40000, 1000, hello[:10])
+	ad.Queue(first)
+	waitFor(t, "the first packet to be held", func() bool {
+		flows, err := eng.Flows(ctx, 0)
+		return err == nil && len(flows) == 1 && flows[0].HeldPackets == 1
+	})
+
+	cfg.Shadow = true
+	if err := eng.Reload(cfg); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	second := tcpPacket(40000, 1010, hello[10:])
+	ad.Queue(second)
+	waitFor(t, "the flow to fail open", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.FailOpensByReason[flow.FailOpenModeChange] == 1
+	})
+	st, err := eng.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if st.Splits != 0 || st.ShadowDecisions.Splits+st.ShadowDecisions.FailOpens != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	for i, pkt := range []*packet.Packet{first, second} {
+		if fate, _ := ad.Fate(pkt); fate != adaptertest.FateSent {
+			t.Fatalf("packet %d: %v, want sent", i, fate)
+		}
+	}
+	if n := len(ad.Wire()); n != 2 {
+		t.Fatalf("wire has %d packets, want 2", n)
+	}
+}
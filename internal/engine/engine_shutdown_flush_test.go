@@ -3,71 +3,30 @@ package engine
 import (
 	"context"
 	"errors"
-	"sync/atomic"
 	"testing"
 	"time"
 
-	"fk-gov/internal/packet"
+	"fk-gov/internal/adapter/adaptertest"
 )
 
-type flushBlockingAdapter struct {
-	flushCalled       atomic.Bool
-	flushHasDeadline  atomic.Bool
-	closeCalled       atomic.Bool
-	closeBeforeFlush  atomic.Bool
-	recvErr           error
-	sendCount         atomic.Int64
-	flushWaitForCtx   bool
-	closeWaitForFlush bool
-}
-
-func (a *flushBlockingAdapter) Recv(ctx context.Context) (*packet.Packet, error) {
-	if a.recvErr != nil {
-		return nil, a.recvErr
-	}
-	<-ctx.Done()
-	return nil, ctx.Err()
-}
-
-func (a *flushBlockingAdapter) Send(ctx context.Context, pkt *packet.Packet) error {
-	a.sendCount.Add(1)
-	return nil
-}
-
-func (a *flushBlockingAdapter) Drop(ctx context.Context, pkt *packet.Packet) error {
-	return nil
-}
-
-func (a *flushBlockingAdapter) CalcChecksums(pkt *packet.Packet) error {
-	return nil
-}
-
-func (a *flushBlockingAdapter) Flush(ctx context.Context) error {
-	a.flushCalled.Store(true)
-	if _, ok := ctx.Deadline(); ok {
-		a.flushHasDeadline.Store(true)
-	}
-	if a.flushWaitForCtx {
-		<-ctx.Done()
-		return ctx.Err()
+// flushAndClose returns the adapter's Flush call and whether Close came
+// after it.
+func flushAndClose(ad *adaptertest.Adapter) (flush adaptertest.Call, closedAfter bool) {
+	flushed := false
+	for _, c := range ad.Calls() {
+		switch c.Op {
+		case adaptertest.OpFlush:
+			flush, flushed = c, true
+		case adaptertest.OpClose:
+			closedAfter = flushed
+		}
 	}
-	return nil
-}
-
-func (a *flushBlockingAdapter) Close() error {
-	if !a.flushCalled.Load() {
-		a.closeBeforeFlush.Store(true)
-	}
-	a.closeCalled.Store(true)
-	if a.closeWaitForFlush {
-		// Best-effort small wait so Close doesn't race Flush assertions in tests
-		time.Sleep(5 * time.Millisecond)
-	}
-	return nil
+	return flush, closedAfter
 }
 
 func TestEngineRun_IgnoresAdapterFlushDeadlineOnCancel(t *testing.T) {
-	ad := &flushBlockingAdapter{flushWaitForCtx: true}
+	ad := adaptertest.New(adaptertest.Verdict)
+	ad.Inject(adaptertest.Fault{Op: adaptertest.OpFlush, Block: true})
 	cfg := DefaultConfig()
 	cfg.WorkerCount = 1
 	cfg.AdapterFlushTimeout = 20 * time.Millisecond
@@ -85,23 +44,26 @@ func TestEngineRun_IgnoresAdapterFlushDeadlineOnCancel(t *testing.T) {
 	if d := time.Since(start); d > time.Second {
 		t.Fatalf("engine stop took too long: %s", d)
 	}
-	if !ad.flushCalled.Load() {
+	flush, closedAfter := flushAndClose(ad)
+	if flush.Op != adaptertest.OpFlush {
 		t.Fatalf("expected adapter.Flush to be called")
 	}
-	if !ad.flushHasDeadline.Load() {
+	if !flush.Deadline {
 		t.Fatalf("expected adapter.Flush ctx to have a deadline")
 	}
-	if !ad.closeCalled.Load() {
+	if !ad.Closed() {
 		t.Fatalf("expected adapter.Close to be called")
 	}
-	if ad.closeBeforeFlush.Load() {
+	if !closedAfter {
 		t.Fatalf("expected Flush to be called before Close")
 	}
 }
 
 func TestEngineRun_ReturnsFlushErrorOnNonCancelStop(t *testing.T) {
 	errBoom := errors.New("boom")
-	ad := &flushBlockingAdapter{recvErr: errBoom, flushWaitForCtx: true}
+	ad := adaptertest.New(adaptertest.Verdict)
+	ad.Inject(adaptertest.Fault{Op: adaptertest.OpRecv, Err: errBoom})
+	ad.Inject(adaptertest.Fault{Op: adaptertest.OpFlush, Block: true})
 	cfg := DefaultConfig()
 	cfg.WorkerCount = 1
 	cfg.AdapterFlushTimeout = 10 * time.Millisecond
@@ -118,5 +80,12 @@ func TestEngineRun_ReturnsFlushErrorOnNonCancelStop(t *testing.T) {
 	if !errors.Is(err, context.DeadlineExceeded) {
 		t.Fatalf("expected error to include flush deadline exceeded, got %v", err)
 	}
+	st := eng.ShutdownStats()
+	if st.Adapter[FlushTimeout] != 1 || st.Adapter[FlushOK] != 0 {
+		t.Fatalf("adapter flush outcomes: %v", st.Adapter)
+	}
+	if st.Workers[FlushOK] != 1 {
+		t.Fatalf("worker flush outcomes: %v", st.Workers)
+	}
 }
 
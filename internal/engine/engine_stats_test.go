@@ -0,0 +1,264 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"fk-gov/internal/flow"
+	"fk-gov/internal/packet"
+)
+
+func TestEnginePause_ReleasesHeldFlowInOrder(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 2
+	cfg.CollectTimeout = time.Minute
+	ad := &chanAdapter{in: make(chan *packet.Packet, 4)}
+	eng := New(cfg, ad)
+	events := make(chan FailOpenEvent, 4)
+	eng.OnFailOpen(func(ev FailOpenEvent) { events <- ev })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	hello := append([]byte{0x16, 0x03, 0x01, 0x00, 20, 0x01}, make([]byte, 19)...)
+	ad.in <- tcpPacket(40000, 1000, hello[:10])
+	waitFor(t, "first packet to be held", func() bool {
+		flows, err := eng.Flows(ctx, 0)
+		return err == nil && len(flows) == 1 && flows[0].HeldPackets == 1
+	})
+	flows, err := eng.Flows(ctx, 0)
+	if err != nil {
+		t.Fatalf("flows: %v", err)
+	}
+	if f := flows[0]; f.State != flow.StateCollecting || f.Key.SrcPort != 40000 || f.BufferedBytes != 10 {
+		t.Fatalf("unexpected flow: %+v", f)
+	}
+
+	if err := eng.Pause(0); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	// The held packet is released by Pause itself, before any new packet.
+	if sends, _ := ad.counts(); sends != 1 {
+		t.Fatalf("sends after pause = %d, want 1", sends)
+	}
+	ad.in <- tcpPacket(40000, 1010, hello[10:])
+	waitFor(t, "paused packets to pass through", func() bool {
+		sends, _ := ad.counts()
+		return sends == 2
+	})
+	ad.mu.Lock()
+	for i, pkt := range ad.sends {
+		if pkt.Source != packet.SourceCaptured || pkt.Meta.Seq != uint32(1000+10*i) {
+			t.Fatalf("send %d: source %v seq %d", i, pkt.Source, pkt.Meta.Seq)
+		}
+	}
+	ad.mu.Unlock()
+
+	st, err := eng.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if !st.Paused || st.Received != 2 || st.Bypassed != 1 || st.FailOpens != 1 || st.Splits != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if len(st.Workers) != 2 || st.Workers[0].HeldBytes+st.Workers[1].HeldBytes != 0 {
+		t.Fatalf("unexpected worker stats: %+v", st.Workers)
+	}
+	if st.FailOpensByReason[flow.FailOpenPaused] != 1 || len(st.FailOpensByReason) != flow.NumFailOpenReasons-1 {
+		t.Fatalf("fail-opens by reason: %v", st.FailOpensByReason)
+	}
+	if st.FlowsCreated != 1 || st.FlowsEvicted != 0 {
+		t.Fatalf("flows created/evicted = %d/%d, want 1/0", st.FlowsCreated, st.FlowsEvicted)
+	}
+	if h := st.HoldTime; h.Count != 1 || len(h.Counts) != len(h.Bounds)+1 || h.Sum <= 0 {
+		t.Fatalf("hold time: %+v", h)
+	}
+	flows, err = eng.Flows(ctx, 0)
+	if err != nil || len(flows) != 1 || flows[0].State != flow.StatePassThrough || flows[0].HeldPackets != 0 || flows[0].FailOpenReason != flow.FailOpenPaused {
+		t.Fatalf("flow after pause: %+v (%v)", flows, err)
+	}
+	select {
+	case ev := <-events:
+		if ev.Reason != flow.FailOpenPaused || ev.HeldPackets != 1 || ev.Key.SrcPort != 40000 || ev.Held <= 0 {
+			t.Fatalf("unexpected fail-open event: %+v", ev)
+		}
+	default:
+		t.Fatal("no fail-open event")
+	}
+
+	// Counters survive a worker set change.
+	next := cfg
+	next.WorkerCount = 3
+	if err := eng.Reload(next); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if st, err := eng.Stats(ctx); err != nil || st.Bypassed != 1 || st.FailOpens != 1 || st.FailOpensByReason[flow.FailOpenPaused] != 1 || st.HoldTime.Count != 1 || len(st.Workers) != 3 {
+		t.Fatalf("stats after reload: %+v (%v)", st, err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if _, err := eng.Stats(context.Background()); err == nil {
+		t.Fatalf("expected stats to fail once the engine stopped")
+	}
+}
+
+func TestEngineResume_PassesFlowsSeenWhilePaused(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	cfg.SplitMode = SplitModeImmediate
+	ad := &chanAdapter{in: make(chan *packet.Packet, 4)}
+	eng := New(cfg, ad)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	}()
+
+	if err := eng.Pause(0); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	payload := []byte("0123456789abcdef")
+	ad.in <- tcpPacket(40000, 1000, payload)
+	waitFor(t, "the paused packet", func() bool {
+		sends, _ := ad.counts()
+		return sends == 1
+	})
+	eng.Resume()
+
+	// The same connection mid-stream, then one that starts after Resume.
+	ad.in <- tcpPacket(40000, 1000+uint32(len(payload)), payload)
+	ad.in <- tcpPacket(40001, 1000, payload)
+	waitFor(t, "the new flow to be split", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.Splits == 1
+	})
+	st, err := eng.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if st.Splits != 1 || st.FlowsCreated != 1 {
+		t.Fatalf("splits/flows created = %d/%d, want 1/1", st.Splits, st.FlowsCreated)
+	}
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	var unsplit int
+	for _, pkt := range ad.sends {
+		if pkt.Meta.SrcPort == 40000 {
+			if len(pkt.Payload()) != len(payload) {
+				t.Fatalf("paused connection sent %d payload bytes in one packet, want %d unsplit", len(pkt.Payload()), len(payload))
+			}
+			unsplit++
+		}
+	}
+	if unsplit != 2 {
+		t.Fatalf("paused connection: %d packets sent, want 2", unsplit)
+	}
+}
+
+func TestEngineFailOpen_CountsUntrackedPassThroughs(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	cfg.CollectTimeout = time.Minute
+	cfg.MaxFlowsPerWorker = 1
+	ad := &chanAdapter{in: make(chan *packet.Packet, 4)}
+	eng := New(cfg, ad)
+	events := make(chan FailOpenEvent, 4)
+	eng.OnFailOpen(func(ev FailOpenEvent) { events <- ev })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	}()
+
+	hello := append([]byte{0x16, 0x03, 0x01, 0x00, 20, 0x01}, make([]byte, 19)...)
+	// The first flow is held; the second finds the worker at its limit.
+	ad.in <- tcpPacket(40000, 1000, hello[:10])
+	ad.in <- tcpPacket(40001, 1000, hello[:10])
+	ev := <-events
+	if ev.Reason != flow.FailOpenFlowLimit || ev.Key.SrcPort != 40001 || ev.HeldPackets != 0 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	// A reassembly budget smaller than the first payload.
+	next := cfg
+	next.MaxFlowsPerWorker = 0
+	next.MaxReassemblyBytesPerWorker = 12
+	if err := eng.Reload(next); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	ad.in <- tcpPacket(40002, 1000, hello[:5])
+	if ev := <-events; ev.Reason != flow.FailOpenReassemblyBudget || ev.Key.SrcPort != 40002 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	st, err := eng.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if st.FailOpens != 2 || st.FailOpensByReason[flow.FailOpenFlowLimit] != 1 || st.FailOpensByReason[flow.FailOpenReassemblyBudget] != 1 {
+		t.Fatalf("fail-opens = %d by reason %v", st.FailOpens, st.FailOpensByReason)
+	}
+	if st.FlowsCreated != 1 {
+		t.Fatalf("flows created = %d, want 1", st.FlowsCreated)
+	}
+	if sends, _ := ad.counts(); sends != 2 {
+		t.Fatalf("sends = %d, want the two untracked packets", sends)
+	}
+}
+
+func TestEnginePause_TimedResume(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	ad := &chanAdapter{in: make(chan *packet.Packet, 4)}
+	eng := New(cfg, ad)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	if err := eng.Pause(time.Hour); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	st, err := eng.Stats(ctx)
+	if err != nil || !st.Paused || st.ResumeAt.IsZero() {
+		t.Fatalf("stats after timed pause: %+v (%v)", st, err)
+	}
+
+	// Pausing again replaces the timer; the hour-long one must not fire.
+	if err := eng.Pause(20 * time.Millisecond); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	waitFor(t, "auto-resume", func() bool { return !eng.Paused() })
+	st, err = eng.Stats(ctx)
+	if err != nil || st.Paused || !st.ResumeAt.IsZero() {
+		t.Fatalf("stats after resume: %+v (%v)", st, err)
+	}
+
+	// New flows are tracked again right away.
+	hello := append([]byte{0x16, 0x03, 0x01, 0x00, 20, 0x01}, make([]byte, 19)...)
+	ad.in <- tcpPacket(40000, 1000, hello[:10])
+	waitFor(t, "flow to be held after resume", func() bool {
+		flows, err := eng.Flows(ctx, 0)
+		return err == nil && len(flows) == 1 && flows[0].HeldPackets == 1
+	})
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"fk-gov/internal/packet"
+	"fk-gov/internal/trace"
+)
+
+// helloWithSNI is a ClientHello record carrying a server_name extension.
+func helloWithSNI(name string) []byte {
+	n := len(name)
+	ext := []byte{0x00, 0x00, byte((n + 5) >> 8), byte(n + 5), byte((n + 3) >> 8), byte(n + 3), 0x00, byte(n >> 8), byte(n)}
+	ext = append(ext, name...)
+	body := append([]byte{0x03, 0x03}, make([]byte, 32)...)
+	body = append(body, 0, 0x00, 0x02, 0x13, 0x01, 0x01, 0x00, byte(len(ext)>>8), byte(len(ext)))
+	body = append(body, ext...)
+	hs := append([]byte{0x01, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{0x16, 0x03, 0x01, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+}
+
+func TestEngineTrace_RecordsMatchingFlows(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	ad := &chanAdapter{in: make(chan *packet.Packet, 4)}
+	eng := New(cfg, ad)
+	f, err := trace.ParseFilter("", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring := trace.New(64, f)
+	eng.SetTrace(ring)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	ad.in <- tcpPacket(40000, 1000, helloWithSNI("www.example.com"))
+	ad.in <- tcpPacket(40001, 5000, helloWithSNI("other.org"))
+	waitFor(t, "both flows to be split", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.Splits == 2
+	})
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	events, overwritten := ring.Events()
+	if overwritten != 0 {
+		t.Fatalf("overwritten = %d", overwritten)
+	}
+	var kinds []string
+	for _, ev := range events {
+		if ev.Src.Port() != 40000 {
+			t.Fatalf("unfiltered flow traced: %+v", ev)
+		}
+		kinds = append(kinds, ev.Kind.String())
+	}
+	want := "created,collected,detected,split-plan,sent"
+	if got := strings.Join(kinds, ","); got != want {
+		t.Fatalf("events = %s, want %s", got, want)
+	}
+	if d := events[2].Detail; !strings.Contains(d, `sni="www.example.com"`) {
+		t.Fatalf("detected detail = %q", d)
+	}
+	if d := events[3].Detail; !strings.Contains(d, "chunk=5") || !strings.Contains(d, "segments=5,") {
+		t.Fatalf("split plan detail = %q", d)
+	}
+
+	// Turning tracing off leaves new flows untraced.
+	eng.SetTrace(nil)
+	if eng.Trace() != nil {
+		t.Fatal("trace still set")
+	}
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"fk-gov/internal/adapter/adaptertest"
+)
+
+func TestEngineSplit_VerdictAdapter(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkerCount = 1
+	ad := adaptertest.New(adaptertest.Verdict)
+	eng := New(cfg, ad)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- eng.Run(ctx) }()
+
+	orig := tcpPacket(40000, 1000, helloWithSNI("www.example.com"))
+	ad.Queue(orig)
+	waitFor(t, "the flow to be split", func() bool {
+		st, err := eng.Stats(ctx)
+		return err == nil && st.Splits == 1
+	})
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	// A verdict adapter holds the original until it is dropped; the
+	// segments replace it on the wire.
+	if fate, _ := ad.Fate(orig); fate != adaptertest.FateDropped {
+		t.Fatalf("original: %v, want dropped", fate)
+	}
+	if p := ad.Pending(); len(p) != 0 {
+		t.Fatalf("%d packets left without a verdict", len(p))
+	}
+	if n := len(ad.Wire()); n != 2 {
+		t.Fatalf("wire has %d packets, want 2 segments", n)
+	}
+	if !ad.Closed() {
+		t.Fatal("adapter not closed")
+	}
+}
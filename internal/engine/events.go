@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"time"
+
+	"fk-gov/internal/flow"
+)
+
+// FailOpenEvent describes a flow that was passed through unsplit.
+type FailOpenEvent struct {
+	Worker int
+	Key    flow.Key
+	Reason flow.FailOpenReason
+	// HeldPackets were released in order; Held is how long the first of
+	// them waited.
+	HeldPackets int
+	Held        time.Duration
+}
+
+// OnFailOpen sets fn to be called for every fail-open, replacing any earlier
+// hook; nil removes it. fn runs on the worker goroutine that made the
+// decision, so it must not block. Flows seen in shadow mode do not call it.
+func (e *Engine) OnFailOpen(fn func(FailOpenEvent)) {
+	if fn == nil {
+		e.onFailOpen.Store(nil)
+		return
+	}
+	e.onFailOpen.Store(&fn)
+}
@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"strconv"
+
+	"fk-gov/internal/flow"
+	"fk-gov/internal/logging"
+	"fk-gov/internal/packet"
+	"fk-gov/internal/trace"
+)
+
+// HandshakeCount is what the servers behind one destination answered to
+// the flows sent with one strategy.
+type HandshakeCount struct {
+	// Dst is the server address. The unspecified address collects the
+	// destinations past the table limit.
+	Dst netip.Addr
+	// Strategy is the split the flows were sent with, e.g. "tls-hello/5",
+	// or "unsplit", "control", "shadow" or "pending".
+	Strategy string
+	Outcomes map[flow.HandshakeOutcome]uint64
+}
+
+// HandshakesByStrategy sums Handshakes over destinations.
+func (s Stats) HandshakesByStrategy() map[string]map[flow.HandshakeOutcome]uint64 {
+	out := make(map[string]map[flow.HandshakeOutcome]uint64)
+	for _, hc := range s.Handshakes {
+		m := out[hc.Strategy]
+		if m == nil {
+			m = make(map[flow.HandshakeOutcome]uint64, flow.NumHandshakeOutcomes)
+			out[hc.Strategy] = m
+		}
+		for o, n := range hc.Outcomes {
+			m[o] += n
+		}
+	}
+	return out
+}
+
+// maxHandshakeRows bounds the destination and strategy pairs a handshake
+// table keeps; the rest are counted under the unspecified address.
+const maxHandshakeRows = 4096
+
+type handshakeKey struct {
+	dst      [4]byte
+	strategy string
+}
+
+// handshakeTable counts outcomes by destination and strategy. Unlike the
+// rest of counters it is a plain map: it is only written on the worker's
+// goroutine and read through Engine.inspect, or under Engine.mu once the
+// worker stopped.
+type handshakeTable struct {
+	counts map[handshakeKey]*[flow.NumHandshakeOutcomes]uint64
+}
+
+func (t *handshakeTable) addN(k handshakeKey, o flow.HandshakeOutcome, n uint64) {
+	if t.counts == nil {
+		t.counts = make(map[handshakeKey]*[flow.NumHandshakeOutcomes]uint64)
+	}
+	row, ok := t.counts[k]
+	if !ok {
+		if len(t.counts) >= maxHandshakeRows {
+			k.dst = [4]byte{}
+			row = t.counts[k]
+		}
+		if row == nil {
+			row = new([flow.NumHandshakeOutcomes]uint64)
+			t.counts[k] = row
+		}
+	}
+	row[o] += n
+}
+
+func (t *handshakeTable) add(o *handshakeTable) {
+	for k, row := range o.counts {
+		for i, n := range row {
+			if n > 0 {
+				t.addN(k, flow.HandshakeOutcome(i), n)
+			}
+		}
+	}
+}
+
+func (t *handshakeTable) snapshot() []HandshakeCount {
+	out := make([]HandshakeCount, 0, len(t.counts))
+	for k, row := range t.counts {
+		hc := HandshakeCount{
+			Dst:      netip.AddrFrom4(k.dst),
+			Strategy: k.strategy,
+			Outcomes: make(map[flow.HandshakeOutcome]uint64, flow.NumHandshakeOutcomes),
+		}
+		for i, n := range row {
+			if flow.HandshakeOutcome(i) != flow.HandshakePending {
+				hc.Outcomes[flow.HandshakeOutcome(i)] = n
+			}
+		}
+		out = append(out, hc)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if c := bytes.Compare(out[i].Dst.AsSlice(), out[j].Dst.AsSlice()); c != 0 {
+			return c < 0
+		}
+		return out[i].Strategy < out[j].Strategy
+	})
+	return out
+}
+
+// handshakeEvent carries an inbound packet's classification to the worker
+// that owns the flow.
+type handshakeEvent struct {
+	key     flow.Key
+	outcome flow.HandshakeOutcome
+}
+
+// classifyInbound tells what a packet from the server says about the
+// handshake. Packets that say nothing, such as the SYN-ACK or bare ACKs,
+// return false.
+func classifyInbound(pkt *packet.Packet) (flow.HandshakeOutcome, bool) {
+	if pkt.HasFlag(packet.TCPFlagRST) {
+		return flow.HandshakeRST, true
+	}
+	payload := pkt.Payload()
+	switch {
+	case len(payload) >= 6 && payload[0] == 0x16 && payload[5] == 0x02:
+		return flow.HandshakeServerHello, true
+	case len(payload) >= 1 && payload[0] == 0x15:
+		return flow.HandshakeAlert, true
+	}
+	return flow.HandshakePending, false
+}
+
+// outboundKey is the key of the flow an inbound packet answers.
+func outboundKey(m packet.Meta) flow.Key {
+	return flow.Key{
+		SrcIP:   m.DstIP,
+		DstIP:   m.SrcIP,
+		SrcPort: m.DstPort,
+		DstPort: m.SrcPort,
+		Proto:   m.Proto,
+	}
+}
+
+// observeInbound hands an inbound classification to the flow's worker.
+func (e *Engine) observeInbound(key flow.Key, outcome flow.HandshakeOutcome) {
+	e.dispatchMu.Lock()
+	e.workers[e.sharder.Index(key)].observeHandshake(handshakeEvent{key: key, outcome: outcome})
+	e.dispatchMu.Unlock()
+}
+
+// observeHandshake queues ev without blocking; like touchFlow, it is best
+// effort and a full queue drops the event.
+func (w *worker) observeHandshake(ev handshakeEvent) {
+	select {
+	case w.handshake <- ev:
+	default:
+	}
+}
+
+// handshakeSeen records ev on a tracked flow still waiting for an answer.
+func (w *worker) handshakeSeen(ctx context.Context, ev handshakeEvent) {
+	st, ok := w.flows.Get(ev.key)
+	if !ok || st.HandshakeStart.IsZero() || st.Handshake != flow.HandshakePending {
+		return
+	}
+	w.recordHandshake(ctx, ev.key, st, ev.outcome)
+}
+
+// handshakeStrategy is the strategy label a flow's outcome is counted under.
+func handshakeStrategy(st *flow.FlowState) string {
+	switch {
+	case st.Control:
+		return "control"
+	case st.Shadow:
+		return "shadow"
+	case st.Strategy != "":
+		return st.Strategy
+	case st.State == flow.StatePassThrough:
+		return "unsplit"
+	default:
+		return "pending"
+	}
+}
+
+func splitStrategy(cfg *Config) string {
+	return cfg.SplitMode.String() + "/" + strconv.Itoa(cfg.SplitChunk)
+}
+
+func (w *worker) recordHandshake(ctx context.Context, key flow.Key, st *flow.FlowState, outcome flow.HandshakeOutcome) {
+	st.Handshake = outcome
+	strategy := handshakeStrategy(st)
+	w.handshakes.addN(handshakeKey{dst: key.DstIP, strategy: strategy}, outcome, 1)
+	elapsed := w.clock.Now().Sub(st.HandshakeStart)
+	if st.Trace != nil {
+		st.Trace.Add(trace.KindHandshake, 0, "outcome="+outcome.String()+" strategy="+strategy+" after="+elapsed.String())
+	}
+	logger := slog.Default()
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	src, dst := keyAddrs(key)
+	logger.LogAttrs(ctx, slog.LevelDebug, "handshake outcome", logging.Event(logging.EventHandshake),
+		slog.Int("worker", w.id),
+		slog.Any(logging.KeySrc, src),
+		slog.Any(logging.KeyDst, dst),
+		slog.String("outcome", outcome.String()),
+		slog.String("strategy", strategy),
+		slog.Duration("after", elapsed),
+	)
+}
@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"fk-gov/internal/flow"
+	"fk-gov/internal/packet"
+)
+
+// maxPausedFlows bounds Engine.pausedFlows. Connections past it are not
+// remembered and may be split mid-stream after Resume.
+const maxPausedFlows = 1 << 16
+
+// Pause switches the engine to bypass: every packet passes through unchanged
+// and no flow state is created, while adapter handles and rules stay in
+// place. Dispatch stops while each worker passes its queue through and fails
+// open its held flows, so no packet overtakes one held before the pause;
+// after that recvLoop sends packets straight back to the adapter.
+//
+// A positive d resumes automatically after d. Pausing again replaces the
+// timer. Workers that do not release within ReloadPauseTimeout return an
+// error, but the engine stays paused: they pass traffic through and release
+// held flows as packets arrive.
+func (e *Engine) Pause(d time.Duration) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.setResumeTimer(d)
+	if e.bypassing.Load() {
+		return nil
+	}
+
+	e.dispatchMu.Lock()
+	defer e.dispatchMu.Unlock()
+	e.prunePausedFlows()
+	e.paused.Store(true)
+	if e.run != nil && !e.stopped {
+		if err := e.releaseWorkers(); err != nil {
+			return err
+		}
+	}
+	e.bypassing.Store(true)
+	return nil
+}
+
+// Resume starts splitting new flows again. Connections seen while paused
+// keep passing through until they close or go idle for FlowIdleTimeout;
+// their next packet is not the start of the stream.
+func (e *Engine) Resume() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.resumeLocked()
+}
+
+func (e *Engine) resumeLocked() {
+	e.setResumeTimer(0)
+	e.bypassing.Store(false)
+	e.paused.Store(false)
+}
+
+func (e *Engine) Paused() bool {
+	return e.paused.Load()
+}
+
+func (e *Engine) pauseState() (bool, time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.paused.Load(), e.resumeAt
+}
+
+// notePausedFlow remembers the connection of a packet passed through while
+// paused. A bare SYN is not remembered: the connection has not sent
+// anything yet, so its first payload after Resume is the real start.
+// Callers hold e.dispatchMu.
+func (e *Engine) notePausedFlow(pkt *packet.Packet) {
+	key := flow.KeyFromMeta(pkt.Meta)
+	if pkt.HasFlag(packet.TCPFlagFIN) || pkt.HasFlag(packet.TCPFlagRST) ||
+		(pkt.HasFlag(packet.TCPFlagSYN) && !pkt.HasFlag(packet.TCPFlagACK)) {
+		delete(e.pausedFlows, key)
+		return
+	}
+	if _, ok := e.pausedFlows[key]; !ok && len(e.pausedFlows) >= maxPausedFlows {
+		return
+	}
+	if e.pausedFlows == nil {
+		e.pausedFlows = make(map[flow.Key]time.Time)
+	}
+	e.pausedFlows[key] = e.clock.Now()
+}
+
+// pausedFlow reports whether pkt belongs to a connection seen while paused
+// that is still active, and keeps it remembered. Callers hold e.dispatchMu.
+func (e *Engine) pausedFlow(pkt *packet.Packet) bool {
+	if len(e.pausedFlows) == 0 {
+		return false
+	}
+	key := flow.KeyFromMeta(pkt.Meta)
+	seen, ok := e.pausedFlows[key]
+	if !ok {
+		return false
+	}
+	now := e.clock.Now()
+	if idle := time.Duration(e.flowIdleTimeout.Load()); idle > 0 && now.Sub(seen) > idle {
+		delete(e.pausedFlows, key)
+		return false
+	}
+	if pkt.HasFlag(packet.TCPFlagFIN) || pkt.HasFlag(packet.TCPFlagRST) {
+		delete(e.pausedFlows, key)
+	} else {
+		e.pausedFlows[key] = now
+	}
+	return true
+}
+
+// prunePausedFlows forgets connections idle for longer than FlowIdleTimeout.
+// Callers hold e.dispatchMu.
+func (e *Engine) prunePausedFlows() {
+	idle := time.Duration(e.flowIdleTimeout.Load())
+	if idle <= 0 {
+		return
+	}
+	now := e.clock.Now()
+	for key, seen := range e.pausedFlows {
+		if now.Sub(seen) > idle {
+			delete(e.pausedFlows, key)
+		}
+	}
+}
+
+// setResumeTimer replaces any pending auto-resume with one after d (none if
+// d <= 0). Callers hold e.mu.
+func (e *Engine) setResumeTimer(d time.Duration) {
+	e.resumeGen++
+	if e.resumeTimer != nil {
+		e.resumeTimer.Stop()
+		e.resumeTimer = nil
+	}
+	e.resumeAt = time.Time{}
+	if d <= 0 {
+		return
+	}
+	gen := e.resumeGen
+	e.resumeAt = e.clock.Now().Add(d)
+	e.resumeTimer = e.clock.AfterFunc(d, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		// A timer that already fired when it was replaced must not resume.
+		if e.resumeGen == gen {
+			e.resumeLocked()
+		}
+	})
+}
+
+// releaseWorkers has every worker pass its queue through and fail open its
+// held flows. Callers hold e.mu and e.dispatchMu.
+func (e *Engine) releaseWorkers() error {
+	timeout := 500 * time.Millisecond
+	if e.cfg.ReloadPauseTimeout > 0 {
+		timeout = e.cfg.ReloadPauseTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	pending := make([]chan error, 0, len(e.workers))
+	for _, w := range e.workers {
+		done := make(chan error, 1)
+		select {
+		case w.release <- done:
+			pending = append(pending, done)
+		case <-timer.C:
+			return fmt.Errorf("pause: worker %d did not release its flows within %s; held flows fail open as packets arrive", w.id, timeout)
+		case <-e.run.ctx.Done():
+			return e.run.ctx.Err()
+		}
+	}
+	for i, done := range pending {
+		select {
+		case err := <-done:
+			if err != nil {
+				return fmt.Errorf("pause: worker %d: %w", e.workers[i].id, err)
+			}
+		case <-timer.C:
+			return fmt.Errorf("pause: worker %d did not release its flows within %s; held flows fail open as packets arrive", e.workers[i].id, timeout)
+		case <-e.run.ctx.Done():
+			return e.run.ctx.Err()
+		}
+	}
+	return nil
+}
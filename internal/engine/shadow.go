@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"fk-gov/internal/flow"
+	"fk-gov/internal/logging"
+	"fk-gov/internal/packet"
+	"fk-gov/internal/tls"
+)
+
+// ShadowStats counts the decisions made in shadow mode. Nothing they
+// describe reached the wire.
+type ShadowStats struct {
+	Splits    uint64
+	FailOpens uint64
+	// SplitsByMode and FailOpensByReason break Splits and FailOpens down.
+	SplitsByMode      map[SplitMode]uint64
+	FailOpensByReason map[flow.FailOpenReason]uint64
+	// Segments counts the segments the splits would have sent.
+	Segments uint64
+	// HoldTime is how long the copies were held before a decision; the
+	// originals were not held at all.
+	HoldTime Histogram
+}
+
+// shadowCopy is what a worker sees of a packet passed through in shadow
+// mode. The adapter may reuse the original's buffer once it has a verdict,
+// so the data is copied before the original is sent.
+func shadowCopy(pkt *packet.Packet) *packet.Packet {
+	cp := *pkt
+	cp.Data = append([]byte(nil), pkt.Data...)
+	cp.Source = packet.SourceShadow
+	return &cp
+}
+
+// shadowSplit records the split a shadow flow would have made.
+func (w *worker) shadowSplit(ctx context.Context, key flow.Key, st *flow.FlowState, mode SplitMode, window []byte, segments [][]byte, held time.Duration) {
+	w.shadowSplits[mode].Add(1)
+	w.shadowSegments.Add(uint64(len(segments)))
+	w.shadowHoldTime.observe(held)
+
+	logger := slog.Default()
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	offsets := make([]string, 0, len(segments)-1)
+	off := 0
+	for _, s := range segments[:len(segments)-1] {
+		off += len(s)
+		offsets = append(offsets, strconv.Itoa(off))
+	}
+	src, dst := keyAddrs(key)
+	attrs := []slog.Attr{
+		logging.Event(logging.EventShadowSplit),
+		slog.Int("worker", w.id),
+		slog.Any(logging.KeySrc, src),
+		slog.Any(logging.KeyDst, dst),
+		slog.String("mode", mode.String()),
+		slog.Int("window", len(window)),
+		slog.Int("segments", len(segments)),
+		slog.String("split_at", strings.Join(offsets, ",")),
+		slog.Duration("held_for", held),
+	}
+	if name, ok := tls.ServerName(window); ok {
+		attrs = append(attrs, slog.String(logging.KeySNI, name))
+	}
+	logger.LogAttrs(ctx, slog.LevelDebug, "shadow flow would have been split", attrs...)
+}
+
+// shadowFailOpen records a shadow flow that would have passed through
+// unsplit. The OnFailOpen hook is not called for it.
+func (w *worker) shadowFailOpen(ctx context.Context, ev FailOpenEvent) {
+	w.shadowFailOpens[ev.Reason].Add(1)
+	if ev.HeldPackets > 0 {
+		w.shadowHoldTime.observe(ev.Held)
+	}
+	logger := slog.Default()
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	src, dst := keyAddrs(ev.Key)
+	logger.LogAttrs(ctx, slog.LevelDebug, "shadow flow would have failed open", logging.Event(logging.EventShadowFailOpen),
+		slog.Int("worker", ev.Worker),
+		slog.Any(logging.KeySrc, src),
+		slog.Any(logging.KeyDst, dst),
+		slog.String("reason", ev.Reason.String()),
+		slog.Int("held_packets", ev.HeldPackets),
+		slog.Duration("held_for", ev.Held),
+	)
+}
+
+func (c *counters) shadowStats() ShadowStats {
+	st := ShadowStats{
+		SplitsByMode:      make(map[SplitMode]uint64, numSplitModes),
+		FailOpensByReason: make(map[flow.FailOpenReason]uint64, flow.NumFailOpenReasons),
+		Segments:          c.shadowSegments.Load(),
+		HoldTime:          c.shadowHoldTime.snapshot(),
+	}
+	for i := range c.shadowSplits {
+		n := c.shadowSplits[i].Load()
+		st.SplitsByMode[SplitMode(i)] = n
+		st.Splits += n
+	}
+	for i := range c.shadowFailOpens {
+		if flow.FailOpenReason(i) == flow.FailOpenNone {
+			continue
+		}
+		n := c.shadowFailOpens[i].Load()
+		st.FailOpensByReason[flow.FailOpenReason(i)] = n
+		st.FailOpens += n
+	}
+	return st
+}
@@ -0,0 +1,431 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"fk-gov/internal/flow"
+)
+
+// Stats is a point-in-time view of the engine. Counters are totals since the
+// engine was created and survive worker set changes.
+type Stats struct {
+	Paused bool
+	// ResumeAt is when a timed pause ends; zero when not paused or paused
+	// until Resume.
+	ResumeAt time.Time
+	// Received counts packets read from the adapter.
+	Received uint64
+	// Bypassed counts packets passed through unchanged while paused.
+	Bypassed  uint64
+	Splits    uint64
+	FailOpens uint64
+	// SplitsByMode and FailOpensByReason break Splits and FailOpens down.
+	SplitsByMode      map[SplitMode]uint64
+	FailOpensByReason map[flow.FailOpenReason]uint64
+	// FlowsCreated counts flows that started tracking; FlowsEvicted those
+	// removed by the idle GC.
+	FlowsCreated uint64
+	FlowsEvicted uint64
+	// CanaryPercent is engine.canary_percent, 100 - ControlPercent;
+	// FlowsByCohort splits FlowsCreated by the cohort flows were put in.
+	CanaryPercent int
+	FlowsByCohort map[Cohort]uint64
+	// FlowsEnded counts flows whose state was removed, by cohort and why,
+	// so the cohorts can be compared without handshake tracking.
+	FlowsEnded map[Cohort]map[EndCause]uint64
+	// HoldTime is how long flows held packets before they were split or
+	// failed open.
+	HoldTime Histogram
+	// Shadow reports engine.shadow; ShadowDecisions counts what shadow
+	// flows would have done.
+	Shadow          bool
+	ShadowDecisions ShadowStats
+	// TrackHandshakes reports engine.track_handshakes; Handshakes counts
+	// the classified outcomes by destination and strategy.
+	TrackHandshakes bool
+	Handshakes      []HandshakeCount
+	Workers         []WorkerStats
+}
+
+// Histogram is a snapshot of a duration histogram. Counts[i] counts
+// observations in (Bounds[i-1], Bounds[i]]; the last element counts those
+// above every bound.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+type WorkerStats struct {
+	ID              int
+	Flows           int
+	Queued          int
+	QueueCap        int
+	HeldBytes       int64
+	ReassemblyBytes int64
+}
+
+// FlowInfo describes one tracked flow.
+type FlowInfo struct {
+	Worker        int
+	Key           flow.Key
+	State         flow.State
+	HeldPackets   int
+	BufferedBytes int
+	LastActive    time.Time
+	CollectStart  time.Time
+	// FailOpenReason is set once the flow passed through unsplit.
+	FailOpenReason flow.FailOpenReason
+}
+
+// EndCause is why a flow's state was removed.
+type EndCause uint8
+
+const (
+	EndFIN EndCause = iota
+	EndRST
+	// EndIdle: the idle GC evicted the flow.
+	EndIdle
+)
+
+const numEndCauses = int(EndIdle) + 1
+
+func (c EndCause) String() string {
+	switch c {
+	case EndFIN:
+		return "fin"
+	case EndRST:
+		return "rst"
+	case EndIdle:
+		return "idle"
+	default:
+		return fmt.Sprintf("EndCause(%d)", uint8(c))
+	}
+}
+
+// FlushResult is the outcome of a fail-open flush when a worker or the
+// adapter shuts down.
+type FlushResult uint8
+
+const (
+	FlushOK FlushResult = iota
+	FlushTimeout
+	// FlushLimit: engine.shutdown_fail_open_max_packets was reached.
+	FlushLimit
+	FlushError
+)
+
+const numFlushResults = int(FlushError) + 1
+
+func (r FlushResult) String() string {
+	switch r {
+	case FlushOK:
+		return "ok"
+	case FlushTimeout:
+		return "timeout"
+	case FlushLimit:
+		return "limit"
+	case FlushError:
+		return "error"
+	default:
+		return fmt.Sprintf("FlushResult(%d)", uint8(r))
+	}
+}
+
+func flushResultOf(err error) FlushResult {
+	switch {
+	case err == nil:
+		return FlushOK
+	case errors.Is(err, ErrShutdownFailOpenLimitReached):
+		return FlushLimit
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return FlushTimeout
+	default:
+		return FlushError
+	}
+}
+
+// ShutdownStats counts the fail-open flushes run when workers exit and when
+// Run closes the adapter.
+type ShutdownStats struct {
+	Workers map[FlushResult]uint64
+	// WorkerPackets counts held and queued packets workers passed through
+	// while flushing.
+	WorkerPackets uint64
+	Adapter       map[FlushResult]uint64
+}
+
+// ShutdownStats is readable after the engine stopped, unlike Stats.
+func (e *Engine) ShutdownStats() ShutdownStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := ShutdownStats{
+		Workers:       make(map[FlushResult]uint64, numFlushResults),
+		WorkerPackets: e.retired.shutdownFlushed.Load(),
+		Adapter:       make(map[FlushResult]uint64, numFlushResults),
+	}
+	for _, w := range e.workers {
+		st.WorkerPackets += w.shutdownFlushed.Load()
+	}
+	for i := 0; i < numFlushResults; i++ {
+		st.Workers[FlushResult(i)] = e.workerFlushes[i].Load()
+		st.Adapter[FlushResult(i)] = e.adapterFlushes[i].Load()
+	}
+	return st
+}
+
+// counters are kept per worker and summed when read.
+type counters struct {
+	splits          [numSplitModes]atomic.Uint64
+	failOpens       [flow.NumFailOpenReasons]atomic.Uint64
+	bypassed        atomic.Uint64
+	flowsCreated    atomic.Uint64
+	flowsEvicted    atomic.Uint64
+	cohortFlows     [numCohorts]atomic.Uint64
+	flowsEnded      [numCohorts][numEndCauses]atomic.Uint64
+	shutdownFlushed atomic.Uint64
+	holdTime        histogram
+
+	shadowSplits    [numSplitModes]atomic.Uint64
+	shadowFailOpens [flow.NumFailOpenReasons]atomic.Uint64
+	shadowSegments  atomic.Uint64
+	shadowHoldTime  histogram
+
+	handshakes handshakeTable
+}
+
+func (c *counters) add(o *counters) {
+	for i := range c.splits {
+		c.splits[i].Add(o.splits[i].Load())
+	}
+	for i := range c.failOpens {
+		c.failOpens[i].Add(o.failOpens[i].Load())
+	}
+	c.bypassed.Add(o.bypassed.Load())
+	c.flowsCreated.Add(o.flowsCreated.Load())
+	c.flowsEvicted.Add(o.flowsEvicted.Load())
+	for i := range c.cohortFlows {
+		c.cohortFlows[i].Add(o.cohortFlows[i].Load())
+	}
+	for i := range c.flowsEnded {
+		for j := range c.flowsEnded[i] {
+			c.flowsEnded[i][j].Add(o.flowsEnded[i][j].Load())
+		}
+	}
+	c.shutdownFlushed.Add(o.shutdownFlushed.Load())
+	c.holdTime.add(&o.holdTime)
+	for i := range c.shadowSplits {
+		c.shadowSplits[i].Add(o.shadowSplits[i].Load())
+	}
+	for i := range c.shadowFailOpens {
+		c.shadowFailOpens[i].Add(o.shadowFailOpens[i].Load())
+	}
+	c.shadowSegments.Add(o.shadowSegments.Load())
+	c.shadowHoldTime.add(&o.shadowHoldTime)
+	c.handshakes.add(&o.handshakes)
+}
+
+// holdBuckets are the upper bounds of the hold time histogram, spanning the
+// default 250ms collect timeout.
+var holdBuckets = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+type histogram struct {
+	counts [len(holdBuckets) + 1]atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(holdBuckets) && d > holdBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) add(o *histogram) {
+	for i := range h.counts {
+		h.counts[i].Add(o.counts[i].Load())
+	}
+	h.sum.Add(o.sum.Load())
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{
+		Bounds: append([]time.Duration(nil), holdBuckets[:]...),
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    time.Duration(h.sum.Load()),
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+// Config returns the configuration last applied by New or Reload.
+func (e *Engine) Config() Config {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.cfg
+}
+
+// Stats collects counters and per-worker gauges. Gauges are read on each
+// worker's goroutine between packets.
+func (e *Engine) Stats(ctx context.Context) (Stats, error) {
+	var total counters
+	var workers []WorkerStats
+	err := e.inspect(ctx, func(ws []*worker) {
+		workers = make([]WorkerStats, len(ws))
+		// Under e.mu, so a concurrent resize cannot change it.
+		total.add(&e.retired)
+	}, func(i int, w *worker) {
+		total.add(&w.counters)
+		workers[i] = WorkerStats{
+			ID:              w.id,
+			Flows:           w.flows.Len(),
+			Queued:          len(w.in),
+			QueueCap:        cap(w.in),
+			HeldBytes:       w.heldBytes,
+			ReassemblyBytes: w.reassemblyBytes,
+		}
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	paused, resumeAt := e.pauseState()
+	cfg := e.Config()
+	st := Stats{
+		Paused:            paused,
+		ResumeAt:          resumeAt,
+		Received:          e.received.Load(),
+		Bypassed:          total.bypassed.Load() + e.bypassed.Load(),
+		SplitsByMode:      make(map[SplitMode]uint64, numSplitModes),
+		FailOpensByReason: make(map[flow.FailOpenReason]uint64, flow.NumFailOpenReasons),
+		FlowsCreated:      total.flowsCreated.Load(),
+		FlowsEvicted:      total.flowsEvicted.Load(),
+		CanaryPercent:     100 - cfg.ControlPercent,
+		FlowsByCohort:     make(map[Cohort]uint64, numCohorts),
+		FlowsEnded:        make(map[Cohort]map[EndCause]uint64, numCohorts),
+		HoldTime:          total.holdTime.snapshot(),
+		Shadow:            e.shadow.Load(),
+		ShadowDecisions:   total.shadowStats(),
+		TrackHandshakes:   cfg.TrackHandshakes,
+		Handshakes:        total.handshakes.snapshot(),
+		Workers:           workers,
+	}
+	for i := range total.cohortFlows {
+		st.FlowsByCohort[Cohort(i)] = total.cohortFlows[i].Load()
+		ended := make(map[EndCause]uint64, numEndCauses)
+		for j := range total.flowsEnded[i] {
+			ended[EndCause(j)] = total.flowsEnded[i][j].Load()
+		}
+		st.FlowsEnded[Cohort(i)] = ended
+	}
+	for i := range total.splits {
+		n := total.splits[i].Load()
+		st.SplitsByMode[SplitMode(i)] = n
+		st.Splits += n
+	}
+	for i := range total.failOpens {
+		if flow.FailOpenReason(i) == flow.FailOpenNone {
+			continue
+		}
+		n := total.failOpens[i].Load()
+		st.FailOpensByReason[flow.FailOpenReason(i)] = n
+		st.FailOpens += n
+	}
+	return st, nil
+}
+
+// Flows lists up to limit tracked flows (all when limit <= 0), in worker
+// order.
+func (e *Engine) Flows(ctx context.Context, limit int) ([]FlowInfo, error) {
+	var perWorker [][]FlowInfo
+	err := e.inspect(ctx, func(ws []*worker) {
+		perWorker = make([][]FlowInfo, len(ws))
+	}, func(i int, w *worker) {
+		var out []FlowInfo
+		w.flows.Range(func(key flow.Key, st *flow.FlowState) {
+			if limit > 0 && len(out) >= limit {
+				return
+			}
+			info := FlowInfo{
+				Worker:         w.id,
+				Key:            key,
+				State:          st.State,
+				HeldPackets:    len(st.HeldPackets),
+				LastActive:     st.LastActive,
+				CollectStart:   st.CollectStart,
+				FailOpenReason: st.FailOpenReason,
+			}
+			if st.Reassembler != nil {
+				info.BufferedBytes = int(st.Reassembler.TotalBytes())
+			}
+			out = append(out, info)
+		})
+		perWorker[i] = out
+	})
+	if err != nil {
+		return nil, err
+	}
+	var flows []FlowInfo
+	for _, fs := range perWorker {
+		for _, f := range fs {
+			if limit > 0 && len(flows) >= limit {
+				return flows, nil
+			}
+			flows = append(flows, f)
+		}
+	}
+	return flows, nil
+}
+
+// inspect calls begin with the current worker set and then fn for each
+// worker, on the worker's goroutine when the engine is running.
+func (e *Engine) inspect(ctx context.Context, begin func([]*worker), fn func(int, *worker)) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	begin(e.workers)
+	if e.stopped {
+		return errors.New("engine stopped")
+	}
+	if e.run == nil {
+		for i, w := range e.workers {
+			fn(i, w)
+		}
+		return nil
+	}
+	for i, w := range e.workers {
+		done := make(chan struct{})
+		i, w := i, w
+		select {
+		case w.inspect <- func() { fn(i, w); close(done) }:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.run.ctx.Done():
+			return e.run.ctx.Err()
+		}
+		// The worker runs fn right away; waiting keeps callers from reading
+		// results it is still writing.
+		<-done
+	}
+	return nil
+}
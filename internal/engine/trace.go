@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"fk-gov/internal/flow"
+	"fk-gov/internal/trace"
+)
+
+// SetTrace starts recording flow events into r; nil stops it. Flows already
+// being traced keep writing to the ring they started with until they end.
+func (e *Engine) SetTrace(r *trace.Ring) {
+	e.trace.Store(r)
+}
+
+// Trace is the ring set by SetTrace, nil when tracing is off.
+func (e *Engine) Trace() *trace.Ring {
+	return e.trace.Load()
+}
+
+// beginTrace attaches a trace to a flow just created when tracing is on.
+func (w *worker) beginTrace(key flow.Key, st *flow.FlowState, payloadLen int) {
+	if w.tracer == nil {
+		return
+	}
+	ring := w.tracer.Load()
+	if ring == nil {
+		return
+	}
+	src, dst := keyAddrs(key)
+	st.Trace = ring.Begin(w.clock, w.id, src, dst)
+	note := ""
+	if st.Control {
+		note = "cohort=control"
+	}
+	st.Trace.Add(trace.KindCreated, payloadLen, note)
+}
+
+func traceCollected(st *flow.FlowState, payloadLen int) {
+	if st.Trace == nil {
+		return
+	}
+	st.Trace.Add(trace.KindCollected, payloadLen, fmt.Sprintf("held=%d buffered=%d", len(st.HeldPackets), st.Reassembler.TotalBytes()))
+}
+
+func traceSplitPlan(st *flow.FlowState, windowLen, chunk, maxPayload int, segments [][]byte, remainder int, trim bool) {
+	if st.Trace == nil {
+		return
+	}
+	sizes := make([]string, len(segments))
+	for i, s := range segments {
+		sizes[i] = strconv.Itoa(len(s))
+	}
+	st.Trace.Add(trace.KindSplitPlan, windowLen, fmt.Sprintf("chunk=%d max_payload=%d segments=%s remainder=%d trim=%v",
+		chunk, maxPayload, strings.Join(sizes, ","), remainder, trim))
+}
+
+// deleteFlow removes a flow's state, counting why by cohort and recording
+// it when the flow is traced.
+func (w *worker) deleteFlow(key flow.Key, st *flow.FlowState, cause EndCause) {
+	if st != nil {
+		cohort := CohortCanary
+		if st.Control {
+			cohort = CohortControl
+		}
+		w.flowsEnded[cohort][cause].Add(1)
+		st.Trace.Add(trace.KindClosed, 0, "cause="+cause.String())
+	}
+	w.flows.Delete(key)
+}
+
+func keyAddrs(key flow.Key) (src, dst netip.AddrPort) {
+	return netip.AddrPortFrom(netip.AddrFrom4(key.SrcIP), key.SrcPort),
+		netip.AddrPortFrom(netip.AddrFrom4(key.DstIP), key.DstPort)
+}
@@ -3,27 +3,62 @@ package engine
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
 	"sync/atomic"
 	"time"
 
 	"fk-gov/internal/adapter"
+	"fk-gov/internal/clock"
 	"fk-gov/internal/flow"
+	"fk-gov/internal/logging"
 	"fk-gov/internal/packet"
+	"fk-gov/internal/pcapng"
 	"fk-gov/internal/reassembly"
 	"fk-gov/internal/tls"
+	"fk-gov/internal/trace"
 )
 
 const maxIPv4TotalLen = 0xffff
 
 var ErrShutdownFailOpenLimitReached = errors.New("shutdown fail-open packet limit reached")
 
+// errWorkerRetired ends a worker whose flows were handed to a new worker set.
+var errWorkerRetired = errors.New("worker retired")
+
 type worker struct {
 	id      int
 	cfg     atomic.Pointer[Config]
 	adapter adapter.Adapter
 	in      chan *packet.Packet
 	touch   chan flow.Key
-	flows   *flow.Table
+	// handshake carries inbound classifications; see observeHandshake.
+	handshake chan handshakeEvent
+	flows     *flow.Table
+	// park receives a channel from Engine.resize. The worker then blocks until
+	// it reads true (retire) or false (resume) from it; while it waits, the
+	// engine owns its flow table and queues.
+	park chan chan bool
+	// inspect runs read-only snapshots on the worker goroutine.
+	inspect chan func()
+	// release asks the worker to pass its queue through and fail open every
+	// held flow; the result is sent back on the channel.
+	release chan chan error
+	// barrier asks the worker to handle everything queued so far, then
+	// close the channel; see Engine.Sync.
+	barrier chan chan struct{}
+	clock   clock.Clock
+	// bypass points at the engine's pause switch; nil means never paused.
+	bypass *atomic.Bool
+	// onFailOpen points at the engine's fail-open hook; nil means none.
+	onFailOpen *atomic.Pointer[func(FailOpenEvent)]
+	// tracer points at the engine's trace ring; nil means never traced.
+	tracer *atomic.Pointer[trace.Ring]
+	// capture points at the engine's packet capture; nil means none.
+	capture *atomic.Pointer[pcapng.Capture]
+
+	counters
 
 	heldBytes       int64
 	reassemblyBytes int64
@@ -49,11 +84,17 @@ func newWorker(id int, cfg Config, ad adapter.Adapter) *worker {
 		cfg.MaxHeldBytesPerWorker = 0
 	}
 	w := &worker{
-		id:      id,
-		adapter: ad,
-		in:      make(chan *packet.Packet, cfg.WorkerQueueSize),
-		touch:   make(chan flow.Key, cfg.WorkerQueueSize),
-		flows:   flow.NewTable(),
+		id:        id,
+		adapter:   ad,
+		in:        make(chan *packet.Packet, cfg.WorkerQueueSize),
+		touch:     make(chan flow.Key, cfg.WorkerQueueSize),
+		handshake: make(chan handshakeEvent, cfg.WorkerQueueSize),
+		flows:     flow.NewTable(),
+		park:      make(chan chan bool),
+		inspect:   make(chan func()),
+		release:   make(chan chan error),
+		barrier:   make(chan chan struct{}),
+		clock:     clock.Wall{},
 	}
 	cfgCopy := cfg
 	w.cfg.Store(&cfgCopy)
@@ -86,9 +127,25 @@ func (w *worker) setConfig(cfg Config) {
 	w.cfg.Store(&cfgCopy)
 }
 
+// adopt takes over a flow from another worker, including its share of the
+// held and reassembly byte budgets.
+func (w *worker) adopt(key flow.Key, st *flow.FlowState) {
+	w.flows.Put(key, st)
+	st.Trace.SetWorker(w.id)
+	for _, pkt := range st.HeldPackets {
+		if pkt != nil {
+			w.heldBytes += int64(len(pkt.Data))
+		}
+	}
+	if st.Reassembler != nil {
+		w.reassemblyBytes += int64(st.Reassembler.TotalBytes())
+	}
+}
+
 func (w *worker) close() {
 	close(w.in)
 	close(w.touch)
+	close(w.handshake)
 }
 
 func (w *worker) run(ctx context.Context) (err error) {
@@ -96,7 +153,7 @@ func (w *worker) run(ctx context.Context) (err error) {
 	if cfg := w.cfg.Load(); cfg != nil && cfg.GCInterval > 0 {
 		interval = cfg.GCInterval
 	}
-	timer := time.NewTimer(interval)
+	timer := w.clock.NewTimer(interval)
 	defer timer.Stop()
 
 	for {
@@ -113,58 +170,132 @@ func (w *worker) run(ctx context.Context) (err error) {
 				w.touch = nil
 				continue
 			}
-			// Fast-path for ACK-only packets: keep existing flows alive without
-			// enqueueing the full packet through the worker queue.
-			if st, ok := w.flows.Get(key); ok {
-				st.LastActive = time.Now()
+			w.touched(key)
+		case ev, ok := <-w.handshake:
+			if !ok {
+				w.handshake = nil
+				continue
+			}
+			// The packets dispatched before the answer go first, so a
+			// shadow copy still queued has created its flow.
+			if err := w.drainPackets(ctx); err != nil {
+				return err
+			}
+			w.handshakeSeen(ctx, ev)
+		case fn := <-w.inspect:
+			fn()
+		case done := <-w.barrier:
+			err := w.drain(ctx)
+			// A GC the clock made due before the barrier runs now, so a
+			// replay sees it at the same point whatever the scheduling.
+			if err == nil {
+				select {
+				case <-timer.C():
+					err = w.gcAndReset(ctx, timer)
+				default:
+				}
+			}
+			close(done)
+			if err != nil {
+				return err
 			}
-		case <-timer.C:
-			if err := w.gc(ctx); err != nil {
+		case done := <-w.release:
+			err := w.releaseAll(ctx)
+			done <- err
+			if err != nil {
 				return err
 			}
-			next := 5 * time.Second
-			if cfg := w.cfg.Load(); cfg != nil && cfg.GCInterval > 0 {
-				next = cfg.GCInterval
+		case resume := <-w.park:
+			if <-resume {
+				return errWorkerRetired
+			}
+		case <-timer.C():
+			if err := w.gcAndReset(ctx, timer); err != nil {
+				return err
 			}
-			timer.Reset(next)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
 
+// gcAndReset runs a GC pass for a fired timer and rearms it.
+func (w *worker) gcAndReset(ctx context.Context, timer clock.Timer) error {
+	if err := w.gc(ctx); err != nil {
+		return err
+	}
+	next := 5 * time.Second
+	if cfg := w.cfg.Load(); cfg != nil && cfg.GCInterval > 0 {
+		next = cfg.GCInterval
+	}
+	timer.Reset(next)
+	return nil
+}
+
+// touched keeps a flow alive for an ACK-only packet that was passed
+// through without being queued.
+func (w *worker) touched(key flow.Key) {
+	if st, ok := w.flows.Get(key); ok {
+		st.LastActive = w.clock.Now()
+	}
+}
+
 func (w *worker) handlePacket(ctx context.Context, pkt *packet.Packet) error {
 	cfg := w.cfg.Load()
 	if cfg == nil {
 		return errors.New("worker config is nil")
 	}
 
-	now := time.Now()
+	now := w.clock.Now()
 	key := flow.KeyFromMeta(pkt.Meta)
 	payload := pkt.Payload()
+	if w.bypass != nil && w.bypass.Load() {
+		// Paused: release anything held for the flow in order, then pass
+		// packets through without creating state.
+		if st, ok := w.flows.Get(key); ok && len(st.HeldPackets) > 0 {
+			if err := w.failOpen(ctx, key, st, flow.FailOpenPaused); err != nil {
+				return err
+			}
+		}
+		w.bypassed.Add(1)
+		return w.send(ctx, pkt, "passed through: paused")
+	}
 	if st, ok := w.flows.Get(key); ok {
 		st.LastActive = now
+		if st.Shadow != (pkt.Source == packet.SourceShadow) && st.State == flow.StateCollecting {
+			// engine.shadow changed under the flow: release what it holds
+			// the way it was captured and leave the rest alone.
+			if err := w.failOpen(ctx, key, st, flow.FailOpenModeChange); err != nil {
+				return err
+			}
+		}
 
 		// FIN/RST often have no payload; ensure they still clean up flow state
 		// promptly even when payloadless packets are fast-pathed.
 		if len(payload) == 0 && (pkt.HasFlag(packet.TCPFlagRST) || pkt.HasFlag(packet.TCPFlagFIN)) {
 			if st.State == flow.StateCollecting {
-				if err := w.failOpen(ctx, key, st); err != nil {
+				if err := w.failOpen(ctx, key, st, rstOrFIN(pkt)); err != nil {
 					return err
 				}
 			}
-			if err := w.adapter.Send(ctx, pkt); err != nil {
+			if err := w.send(ctx, pkt, "passed through: flow closed"); err != nil {
 				return err
 			}
-			w.flows.Delete(key)
+			w.deleteFlow(key, st, endOf(pkt))
 			return nil
 		}
 
-		if st.State == flow.StateInjected || st.State == flow.StatePassThrough {
-			return w.adapter.Send(ctx, pkt)
+		if st.State == flow.StateInjected {
+			return w.send(ctx, pkt, "passed through: flow already split")
+		}
+		if st.State == flow.StatePassThrough {
+			if st.Control {
+				return w.send(ctx, pkt, "passed through: control cohort")
+			}
+			return w.send(ctx, pkt, "passed through: flow failed open")
 		}
 		if len(payload) == 0 {
-			return w.adapter.Send(ctx, pkt)
+			return w.send(ctx, pkt, "passed through: no payload")
 		}
 
 		if st.State == flow.StateNew {
@@ -182,22 +313,22 @@ func (w *worker) handlePacket(ctx context.Context, pkt *packet.Packet) error {
 			need := int64(len(pkt.Data))
 			limit := int64(cfg.MaxHeldBytesPerWorker)
 			if w.heldBytes+need > limit {
-				if err := w.failOpen(ctx, key, st); err != nil {
+				if err := w.failOpen(ctx, key, st, flow.FailOpenHeldBudget); err != nil {
 					return err
 				}
-				return w.adapter.Send(ctx, pkt)
+				return w.send(ctx, pkt, "fail-open: held-budget")
 			}
 		}
 		st.HeldPackets = append(st.HeldPackets, pkt)
 		w.heldBytes += int64(len(pkt.Data))
 		if len(st.HeldPackets) >= cfg.MaxHeldPackets {
-			return w.failOpen(ctx, key, st)
+			return w.failOpen(ctx, key, st, flow.FailOpenHeldPackets)
 		}
 		if now.Sub(st.CollectStart) > cfg.CollectTimeout {
-			return w.failOpen(ctx, key, st)
+			return w.failOpen(ctx, key, st, flow.FailOpenCollectTimeout)
 		}
 		if st.Reassembler == nil {
-			return w.failOpen(ctx, key, st)
+			return w.failOpen(ctx, key, st, flow.FailOpenReassembly)
 		}
 		before := int64(st.Reassembler.TotalBytes())
 		err := st.Reassembler.Push(pkt.Meta.Seq, payload)
@@ -207,29 +338,30 @@ func (w *worker) handlePacket(ctx context.Context, pkt *packet.Packet) error {
 			w.reassemblyBytes = 0
 		}
 		if err != nil {
-			return w.failOpen(ctx, key, st)
+			return w.failOpen(ctx, key, st, flow.FailOpenReassembly)
 		}
+		traceCollected(st, len(payload))
 		if cfg.MaxReassemblyBytesPerWorker > 0 && w.reassemblyBytes > int64(cfg.MaxReassemblyBytesPerWorker) {
-			return w.failOpen(ctx, key, st)
+			return w.failOpen(ctx, key, st, flow.FailOpenReassemblyBudget)
 		}
 
 		if pkt.HasFlag(packet.TCPFlagSYN) {
-			return w.failOpen(ctx, key, st)
+			return w.failOpen(ctx, key, st, flow.FailOpenSYN)
 		}
 
 		if pkt.HasFlag(packet.TCPFlagRST) {
-			if err := w.failOpen(ctx, key, st); err != nil {
+			if err := w.failOpen(ctx, key, st, flow.FailOpenRST); err != nil {
 				return err
 			}
-			w.flows.Delete(key)
+			w.deleteFlow(key, st, EndRST)
 			return nil
 		}
 
 		if pkt.HasFlag(packet.TCPFlagFIN) {
-			if err := w.failOpen(ctx, key, st); err != nil {
+			if err := w.failOpen(ctx, key, st, flow.FailOpenFIN); err != nil {
 				return err
 			}
-			w.flows.Delete(key)
+			w.deleteFlow(key, st, EndFIN)
 			return nil
 		}
 
@@ -246,12 +378,12 @@ func (w *worker) handlePacket(ctx context.Context, pkt *packet.Packet) error {
 
 	// No existing state: fail-open for payloadless packets (no flow creation).
 	if len(payload) == 0 {
-		return w.adapter.Send(ctx, pkt)
+		return w.send(ctx, pkt, "passed through: no payload")
 	}
 
 	// DoS guard: bound the number of tracked flows per worker.
 	if cfg.MaxFlowsPerWorker > 0 && w.flows.Len() >= cfg.MaxFlowsPerWorker {
-		return w.adapter.Send(ctx, pkt)
+		return w.passUntracked(ctx, key, pkt, flow.FailOpenFlowLimit)
 	}
 
 	// Best-effort budget checks before creating per-flow state.
@@ -259,19 +391,32 @@ func (w *worker) handlePacket(ctx context.Context, pkt *packet.Packet) error {
 		need := int64(len(pkt.Data))
 		limit := int64(cfg.MaxHeldBytesPerWorker)
 		if w.heldBytes+need > limit {
-			return w.adapter.Send(ctx, pkt)
+			return w.passUntracked(ctx, key, pkt, flow.FailOpenHeldBudget)
 		}
 	}
 	if cfg.MaxReassemblyBytesPerWorker > 0 {
 		need := int64(len(payload))
 		limit := int64(cfg.MaxReassemblyBytesPerWorker)
 		if w.reassemblyBytes+need > limit {
-			return w.adapter.Send(ctx, pkt)
+			return w.passUntracked(ctx, key, pkt, flow.FailOpenReassemblyBudget)
 		}
 	}
 
 	st := w.flows.GetOrCreate(key, now)
 	st.LastActive = now
+	st.Shadow = pkt.Source == packet.SourceShadow
+	cohort := cohortOf(cfg, key)
+	st.Control = cohort == CohortControl
+	if cfg.TrackHandshakes {
+		st.HandshakeStart = now
+	}
+	w.flowsCreated.Add(1)
+	w.cohortFlows[cohort].Add(1)
+	w.beginTrace(key, st, len(payload))
+	if st.Control {
+		st.State = flow.StatePassThrough
+		return w.send(ctx, pkt, "passed through: control cohort")
+	}
 
 	if st.State == flow.StateNew {
 		st.BaseSeq = pkt.Meta.Seq
@@ -288,19 +433,19 @@ func (w *worker) handlePacket(ctx context.Context, pkt *packet.Packet) error {
 		need := int64(len(pkt.Data))
 		limit := int64(cfg.MaxHeldBytesPerWorker)
 		if w.heldBytes+need > limit {
-			if err := w.failOpen(ctx, key, st); err != nil {
+			if err := w.failOpen(ctx, key, st, flow.FailOpenHeldBudget); err != nil {
 				return err
 			}
-			return w.adapter.Send(ctx, pkt)
+			return w.send(ctx, pkt, "fail-open: held-budget")
 		}
 	}
 	st.HeldPackets = append(st.HeldPackets, pkt)
 	w.heldBytes += int64(len(pkt.Data))
 	if len(st.HeldPackets) >= cfg.MaxHeldPackets {
-		return w.failOpen(ctx, key, st)
+		return w.failOpen(ctx, key, st, flow.FailOpenHeldPackets)
 	}
 	if now.Sub(st.CollectStart) > cfg.CollectTimeout {
-		return w.failOpen(ctx, key, st)
+		return w.failOpen(ctx, key, st, flow.FailOpenCollectTimeout)
 	}
 	before := int64(st.Reassembler.TotalBytes())
 	err := st.Reassembler.Push(pkt.Meta.Seq, payload)
@@ -310,27 +455,28 @@ func (w *worker) handlePacket(ctx context.Context, pkt *packet.Packet) error {
 		w.reassemblyBytes = 0
 	}
 	if err != nil {
-		return w.failOpen(ctx, key, st)
+		return w.failOpen(ctx, key, st, flow.FailOpenReassembly)
 	}
+	traceCollected(st, len(payload))
 	if cfg.MaxReassemblyBytesPerWorker > 0 && w.reassemblyBytes > int64(cfg.MaxReassemblyBytesPerWorker) {
-		return w.failOpen(ctx, key, st)
+		return w.failOpen(ctx, key, st, flow.FailOpenReassemblyBudget)
 	}
 
 	if pkt.HasFlag(packet.TCPFlagSYN) || pkt.HasFlag(packet.TCPFlagRST) {
-		if err := w.failOpen(ctx, key, st); err != nil {
+		if err := w.failOpen(ctx, key, st, synOrRST(pkt)); err != nil {
 			return err
 		}
 		if pkt.HasFlag(packet.TCPFlagRST) {
-			w.flows.Delete(key)
+			w.deleteFlow(key, st, EndRST)
 		}
 		return nil
 	}
 
 	if pkt.HasFlag(packet.TCPFlagFIN) {
-		if err := w.failOpen(ctx, key, st); err != nil {
+		if err := w.failOpen(ctx, key, st, flow.FailOpenFIN); err != nil {
 			return err
 		}
-		w.flows.Delete(key)
+		w.deleteFlow(key, st, EndFIN)
 		return nil
 	}
 
@@ -350,12 +496,16 @@ func (w *worker) trySplitImmediate(ctx context.Context, key flow.Key, st *flow.F
 		return nil
 	}
 	if st.Reassembler == nil {
-		return w.failOpen(ctx, key, st)
+		return w.failOpen(ctx, key, st, flow.FailOpenReassembly)
 	}
 	contig := st.Reassembler.Contiguous()
 	if len(contig) < st.FirstPayloadLen {
 		return nil
 	}
+	if st.Trace != nil {
+		st.Trace = st.Trace.Match("")
+		st.Trace.Add(trace.KindDetected, st.FirstPayloadLen, "result=immediate")
+	}
 	return w.injectWindow(ctx, key, st, st.FirstPayloadLen)
 }
 
@@ -366,7 +516,7 @@ func (w *worker) trySplitTLSHello(ctx context.Context, key flow.Key, st *flow.Fl
 	}
 
 	if st.Reassembler == nil {
-		return w.failOpen(ctx, key, st)
+		return w.failOpen(ctx, key, st, flow.FailOpenReassembly)
 	}
 	contig := st.Reassembler.Contiguous()
 	recordLen, result := tls.DetectClientHelloRecord(contig)
@@ -374,16 +524,29 @@ func (w *worker) trySplitTLSHello(ctx context.Context, key flow.Key, st *flow.Fl
 		return nil
 	}
 	if result == tls.ResultMismatch {
-		return w.failOpen(ctx, key, st)
+		if st.Trace != nil {
+			st.Trace = st.Trace.Match("")
+			st.Trace.Add(trace.KindDetected, len(contig), "result=not-tls")
+		}
+		return w.failOpen(ctx, key, st, flow.FailOpenNotTLS)
 	}
 
 	need := 5 + int(recordLen)
 	if need > cfg.MaxBufferBytes {
-		return w.failOpen(ctx, key, st)
+		if st.Trace != nil {
+			st.Trace = st.Trace.Match("")
+			st.Trace.Add(trace.KindDetected, len(contig), fmt.Sprintf("result=tls-hello record=%d too_large=true", need))
+		}
+		return w.failOpen(ctx, key, st, flow.FailOpenRecordTooLarge)
 	}
 	if len(contig) < need {
 		return nil
 	}
+	if st.Trace != nil {
+		sni, _ := tls.ServerName(contig[:need])
+		st.Trace = st.Trace.Match(sni)
+		st.Trace.Add(trace.KindDetected, need, fmt.Sprintf("result=tls-hello record=%d sni=%q", need, sni))
+	}
 
 	return w.injectWindow(ctx, key, st, need)
 }
@@ -395,7 +558,7 @@ func (w *worker) injectWindow(ctx context.Context, key flow.Key, st *flow.FlowSt
 	}
 
 	if windowLen < 1 {
-		return w.failOpen(ctx, key, st)
+		return w.failOpen(ctx, key, st, flow.FailOpenUnsplittable)
 	}
 	contig := st.Reassembler.Contiguous()
 	if len(contig) < windowLen {
@@ -403,13 +566,13 @@ func (w *worker) injectWindow(ctx context.Context, key flow.Key, st *flow.FlowSt
 	}
 	tpl := st.Template
 	if tpl == nil {
-		return w.failOpen(ctx, key, st)
+		return w.failOpen(ctx, key, st, flow.FailOpenUnsplittable)
 	}
 	maxPayload := len(tpl.Payload())
 	headerLen := tpl.Meta.IPHeaderLen + tpl.Meta.TCPHeaderLen
 	maxPayload = clampSegmentPayload(maxPayload, headerLen, cfg.MaxSegmentPayload)
 	if maxPayload < 1 {
-		return w.failOpen(ctx, key, st)
+		return w.failOpen(ctx, key, st, flow.FailOpenUnsplittable)
 	}
 
 	window := contig[:windowLen]
@@ -417,9 +580,12 @@ func (w *worker) injectWindow(ctx context.Context, key flow.Key, st *flow.FlowSt
 
 	splitSegs := splitFirst(window, cfg.SplitChunk, maxPayload)
 	if len(splitSegs) < 2 {
-		return w.failOpen(ctx, key, st)
+		return w.failOpen(ctx, key, st, flow.FailOpenUnsplittable)
 	}
 
+	trim := len(remainder) > 0 && w.canTrimRemainder(st)
+	traceSplitPlan(st, windowLen, cfg.SplitChunk, maxPayload, splitSegs, len(remainder), trim)
+
 	flags := tpl.Meta.Flags
 	flagsNoPshFin := flags &^ (packet.TCPFlagPSH | packet.TCPFlagFIN)
 	splitLastFlags := flags
@@ -428,20 +594,24 @@ func (w *worker) injectWindow(ctx context.Context, key flow.Key, st *flow.FlowSt
 	}
 
 	ipid := packet.IPv4ID(tpl.Data)
-	if err := w.sendSegments(ctx, tpl, st.BaseSeq, splitSegs, flagsNoPshFin, splitLastFlags, &ipid); err != nil {
-		return w.failOpen(ctx, key, st)
+	if err := w.sendSegments(ctx, tpl, st.BaseSeq, splitSegs, flagsNoPshFin, splitLastFlags, &ipid, "split segment"); err != nil {
+		return w.failOpen(ctx, key, st, flow.FailOpenSendError)
 	}
+	st.Trace.Add(trace.KindSent, windowLen, "segments="+strconv.Itoa(len(splitSegs)))
 
 	if len(remainder) > 0 {
-		if w.canTrimRemainder(st) {
-			if err := w.reinjectTrimmed(ctx, st, uint32(windowLen), &ipid); err != nil {
-				return w.failOpen(ctx, key, st)
+		if trim {
+			n, err := w.reinjectTrimmed(ctx, st, uint32(windowLen), &ipid)
+			if err != nil {
+				return w.failOpen(ctx, key, st, flow.FailOpenSendError)
 			}
+			st.Trace.Add(trace.KindTrimmed, len(remainder), "packets="+strconv.Itoa(n))
 		} else {
 			remSegs := chunkPayload(remainder, maxPayload)
-			if err := w.sendSegments(ctx, tpl, st.BaseSeq+uint32(windowLen), remSegs, flagsNoPshFin, flags, &ipid); err != nil {
-				return w.failOpen(ctx, key, st)
+			if err := w.sendSegments(ctx, tpl, st.BaseSeq+uint32(windowLen), remSegs, flagsNoPshFin, flags, &ipid, "remainder segment"); err != nil {
+				return w.failOpen(ctx, key, st, flow.FailOpenSendError)
 			}
+			st.Trace.Add(trace.KindSent, len(remainder), "segments="+strconv.Itoa(len(remSegs))+" remainder=true")
 		}
 	}
 
@@ -449,13 +619,23 @@ func (w *worker) injectWindow(ctx context.Context, key flow.Key, st *flow.FlowSt
 		return err
 	}
 
+	held := w.clock.Now().Sub(st.CollectStart)
+	if st.Shadow {
+		w.shadowSplit(ctx, key, st, cfg.SplitMode, window, splitSegs, held)
+	} else {
+		w.holdTime.observe(held)
+		w.splits[cfg.SplitMode].Add(1)
+		st.Strategy = splitStrategy(cfg)
+	}
 	st.State = flow.StateInjected
 	w.clearCollectingState(st)
 	st.Processed = true
 	return nil
 }
 
-func (w *worker) sendSegments(ctx context.Context, tpl *packet.Packet, baseSeq uint32, segments [][]byte, flags uint8, lastFlags uint8, ipid *uint16) error {
+// sendSegments builds and sends one packet per segment; kind names them in
+// the packet capture.
+func (w *worker) sendSegments(ctx context.Context, tpl *packet.Packet, baseSeq uint32, segments [][]byte, flags uint8, lastFlags uint8, ipid *uint16, kind string) error {
 	offset := 0
 	for i, segPayload := range segments {
 		if len(segPayload) == 0 {
@@ -472,7 +652,11 @@ func (w *worker) sendSegments(ctx context.Context, tpl *packet.Packet, baseSeq u
 		if err := w.adapter.CalcChecksums(newPkt); err != nil {
 			return err
 		}
-		if err := w.adapter.Send(ctx, newPkt); err != nil {
+		note := ""
+		if w.capturing(newPkt) != nil {
+			note = fmt.Sprintf("%s %d/%d, seq +%d", kind, i+1, len(segments), offset)
+		}
+		if err := w.send(ctx, newPkt, note); err != nil {
 			return err
 		}
 		offset += len(segPayload)
@@ -506,10 +690,14 @@ func buildPacket(tpl *packet.Packet, seq uint32, payload []byte, flags uint8, ip
 	packet.SetIPv4ChecksumZero(buf)
 	packet.SetTCPChecksumZero(buf, ipHeaderLen)
 
+	source := packet.SourceInjected
+	if tpl.Source == packet.SourceShadow {
+		source = packet.SourceShadow
+	}
 	return &packet.Packet{
 		Data:   buf,
 		Addr:   tpl.Addr,
-		Source: packet.SourceInjected,
+		Source: source,
 	}, nil
 }
 
@@ -520,7 +708,10 @@ func (w *worker) canTrimRemainder(st *flow.FlowState) bool {
 	return !st.Reassembler.HadOutOfOrder() && !st.Reassembler.HadOverlap()
 }
 
-func (w *worker) reinjectTrimmed(ctx context.Context, st *flow.FlowState, windowLen uint32, ipid *uint16) error {
+// reinjectTrimmed resends the held packets that extend past the window with
+// the window bytes cut off, returning how many it sent.
+func (w *worker) reinjectTrimmed(ctx context.Context, st *flow.FlowState, windowLen uint32, ipid *uint16) (int, error) {
+	sent := 0
 	for _, pkt := range st.HeldPackets {
 		payload := pkt.Payload()
 		if len(payload) == 0 {
@@ -545,16 +736,17 @@ func (w *worker) reinjectTrimmed(ctx context.Context, st *flow.FlowState, window
 
 		newPkt, err := buildPacket(pkt, newSeq, newPayload, pkt.Meta.Flags, ipid)
 		if err != nil {
-			return err
+			return sent, err
 		}
 		if err := w.adapter.CalcChecksums(newPkt); err != nil {
-			return err
+			return sent, err
 		}
-		if err := w.adapter.Send(ctx, newPkt); err != nil {
-			return err
+		if err := w.send(ctx, newPkt, "trimmed reinjection"); err != nil {
+			return sent, err
 		}
+		sent++
 	}
-	return nil
+	return sent, nil
 }
 
 func splitFirst(payload []byte, firstLen int, maxPayload int) [][]byte {
@@ -623,19 +815,158 @@ func chunkPayload(payload []byte, maxPayload int) [][]byte {
 	return segments
 }
 
-func (w *worker) failOpen(ctx context.Context, key flow.Key, st *flow.FlowState) error {
+func (w *worker) failOpen(ctx context.Context, key flow.Key, st *flow.FlowState, reason flow.FailOpenReason) error {
 	for _, pkt := range st.HeldPackets {
-		if err := w.adapter.Send(ctx, pkt); err != nil {
+		note := ""
+		if w.capturing(pkt) != nil {
+			note = "fail-open: " + reason.String()
+		}
+		if err := w.send(ctx, pkt, note); err != nil {
 			return err
 		}
 	}
+	ev := FailOpenEvent{Worker: w.id, Key: key, Reason: reason, HeldPackets: len(st.HeldPackets)}
+	if ev.HeldPackets > 0 {
+		ev.Held = w.clock.Now().Sub(st.CollectStart)
+	}
+	if st.Trace != nil {
+		st.Trace.Add(trace.KindFailOpen, 0, fmt.Sprintf("reason=%s held_packets=%d held_for=%s", reason, ev.HeldPackets, ev.Held))
+	}
 	st.State = flow.StatePassThrough
+	st.FailOpenReason = reason
 	w.clearCollectingState(st)
+	if st.Shadow {
+		w.shadowFailOpen(ctx, ev)
+		return nil
+	}
+	if ev.HeldPackets > 0 {
+		w.holdTime.observe(ev.Held)
+	}
+	w.failOpens[reason].Add(1)
+	w.logFailOpen(ctx, ev)
+	if w.onFailOpen != nil {
+		if fn := w.onFailOpen.Load(); fn != nil {
+			(*fn)(ev)
+		}
+	}
+	return nil
+}
+
+// passUntracked passes the first packet of a flow through without creating
+// state because the worker is at one of its limits, and counts and logs it
+// as a fail-open for reason.
+func (w *worker) passUntracked(ctx context.Context, key flow.Key, pkt *packet.Packet, reason flow.FailOpenReason) error {
+	note := ""
+	if w.capturing(pkt) != nil {
+		note = "fail-open: " + reason.String()
+	}
+	if err := w.send(ctx, pkt, note); err != nil {
+		return err
+	}
+	ev := FailOpenEvent{Worker: w.id, Key: key, Reason: reason}
+	if pkt.Source == packet.SourceShadow {
+		w.shadowFailOpen(ctx, ev)
+		return nil
+	}
+	w.failOpens[reason].Add(1)
+	w.logFailOpen(ctx, ev)
+	if w.onFailOpen != nil {
+		if fn := w.onFailOpen.Load(); fn != nil {
+			(*fn)(ev)
+		}
+	}
 	return nil
 }
 
+// logFailOpen logs every fail-open at debug level and warns when one was
+// forced by an exhausted worker budget.
+func (w *worker) logFailOpen(ctx context.Context, ev FailOpenEvent) {
+	level := slog.LevelDebug
+	event := logging.EventFailOpen
+	msg := "flow failed open"
+	switch ev.Reason {
+	case flow.FailOpenHeldBudget, flow.FailOpenReassemblyBudget:
+		level, event = slog.LevelWarn, logging.EventBudgetExhausted
+		msg = "worker budget exhausted; flow failed open (raise the per-worker memory limits if this persists)"
+	case flow.FailOpenFlowLimit:
+		level, event = slog.LevelWarn, logging.EventBudgetExhausted
+		msg = "worker flow limit reached; flow passed through untracked (raise engine.max_flows_per_worker if this persists)"
+	}
+	logger := slog.Default()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	src, dst := keyAddrs(ev.Key)
+	logger.LogAttrs(ctx, level, msg, logging.Event(event),
+		slog.Int("worker", ev.Worker),
+		slog.Any(logging.KeySrc, src),
+		slog.Any(logging.KeyDst, dst),
+		slog.String("reason", ev.Reason.String()),
+		slog.Int("held_packets", ev.HeldPackets),
+		slog.Duration("held_for", ev.Held),
+	)
+}
+
+// rstOrFIN is the fail-open reason of a payloadless RST or FIN.
+func rstOrFIN(pkt *packet.Packet) flow.FailOpenReason {
+	if pkt.HasFlag(packet.TCPFlagRST) {
+		return flow.FailOpenRST
+	}
+	return flow.FailOpenFIN
+}
+
+// endOf is why a flow closed by pkt's RST or FIN ended.
+func endOf(pkt *packet.Packet) EndCause {
+	if pkt.HasFlag(packet.TCPFlagRST) {
+		return EndRST
+	}
+	return EndFIN
+}
+
+// synOrRST is the fail-open reason of a new flow's first packet carrying SYN
+// or RST.
+func synOrRST(pkt *packet.Packet) flow.FailOpenReason {
+	if pkt.HasFlag(packet.TCPFlagSYN) {
+		return flow.FailOpenSYN
+	}
+	return flow.FailOpenRST
+}
+
+// releaseAll handles every queued packet (which passes through while the
+// engine is paused) and then fails open the flows still holding packets.
+func (w *worker) releaseAll(ctx context.Context) error {
+drainQueue:
+	for {
+		select {
+		case pkt, ok := <-w.in:
+			if !ok {
+				return nil
+			}
+			if err := w.handlePacket(ctx, pkt); err != nil {
+				return err
+			}
+		default:
+			break drainQueue
+		}
+	}
+	var firstErr error
+	w.flows.Range(func(key flow.Key, st *flow.FlowState) {
+		if firstErr != nil || len(st.HeldPackets) == 0 {
+			return
+		}
+		firstErr = w.failOpen(ctx, key, st, flow.FailOpenPaused)
+	})
+	return firstErr
+}
+
 func (w *worker) dropHeld(ctx context.Context, st *flow.FlowState) error {
+	if st.Shadow {
+		return nil
+	}
 	for _, pkt := range st.HeldPackets {
+		if c := w.capturing(pkt); c != nil {
+			c.Record(pcapng.IfaceDropped, pkt.Data, "dropped: replaced by split segments")
+		}
 		if err := w.adapter.Drop(ctx, pkt); err != nil {
 			return err
 		}
@@ -669,25 +1000,38 @@ func (w *worker) clearCollectingState(st *flow.FlowState) {
 
 func (w *worker) gc(ctx context.Context) error {
 	idle := 30 * time.Second
-	if cfg := w.cfg.Load(); cfg != nil && cfg.FlowIdleTimeout > 0 {
-		idle = cfg.FlowIdleTimeout
+	var handshakeTimeout time.Duration
+	if cfg := w.cfg.Load(); cfg != nil {
+		if cfg.FlowIdleTimeout > 0 {
+			idle = cfg.FlowIdleTimeout
+		}
+		if cfg.TrackHandshakes {
+			handshakeTimeout = cfg.HandshakeTimeout
+			if handshakeTimeout <= 0 {
+				handshakeTimeout = 10 * time.Second
+			}
+		}
 	}
 
-	now := time.Now()
+	now := w.clock.Now()
 	var firstErr error
 	w.flows.Range(func(key flow.Key, st *flow.FlowState) {
+		if handshakeTimeout > 0 && !st.HandshakeStart.IsZero() && st.Handshake == flow.HandshakePending && now.Sub(st.HandshakeStart) > handshakeTimeout {
+			w.recordHandshake(ctx, key, st, flow.HandshakeTimeout)
+		}
 		if now.Sub(st.LastActive) <= idle {
 			return
 		}
 		if st.State == flow.StateCollecting && len(st.HeldPackets) > 0 {
-			if err := w.failOpen(ctx, key, st); err != nil {
+			if err := w.failOpen(ctx, key, st, flow.FailOpenIdle); err != nil {
 				if firstErr == nil {
 					firstErr = err
 				}
 				return
 			}
 		}
-		w.flows.Delete(key)
+		w.deleteFlow(key, st, EndIdle)
+		w.flowsEvicted.Add(1)
 	})
 	return firstErr
 }
@@ -710,10 +1054,11 @@ func (w *worker) shutdownFailOpen(ctx context.Context) error {
 		if maxPackets > 0 && flushed >= maxPackets {
 			return ErrShutdownFailOpenLimitReached
 		}
-		if err := w.adapter.Send(ctx, pkt); err != nil {
+		if err := w.send(ctx, pkt, "fail-open: shutdown"); err != nil {
 			return err
 		}
 		flushed++
+		w.shutdownFlushed.Add(1)
 		return nil
 	}
 
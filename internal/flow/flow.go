@@ -1,10 +1,12 @@
 package flow
 
 import (
+	"fmt"
 	"time"
 
 	"fk-gov/internal/packet"
 	"fk-gov/internal/reassembly"
+	"fk-gov/internal/trace"
 )
 
 type Key struct {
@@ -36,6 +38,151 @@ const (
 	StateClosed
 )
 
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateCollecting:
+		return "collecting"
+	case StateSplitReady:
+		return "split-ready"
+	case StateInjected:
+		return "injected"
+	case StatePassThrough:
+		return "pass-through"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("State(%d)", uint8(s))
+	}
+}
+
+// FailOpenReason says why a flow's held packets were released unsplit. The
+// budget reasons and FailOpenFlowLimit are also used for packets that passed
+// through before a flow could be created.
+type FailOpenReason uint8
+
+const (
+	FailOpenNone FailOpenReason = iota
+	// FailOpenHeldPackets: engine.max_held_packets was reached.
+	FailOpenHeldPackets
+	// FailOpenCollectTimeout: engine.collect_timeout passed before a split.
+	FailOpenCollectTimeout
+	// FailOpenReassembly: a segment did not fit the reassembly buffer.
+	FailOpenReassembly
+	// FailOpenHeldBudget: the worker's held-bytes budget was exhausted.
+	FailOpenHeldBudget
+	// FailOpenReassemblyBudget: the worker's reassembly budget was exhausted.
+	FailOpenReassemblyBudget
+	// FailOpenFlowLimit: the worker already tracked engine.max_flows_per_worker
+	// flows, so the packet passed through without flow state.
+	FailOpenFlowLimit
+	// FailOpenNotTLS: the first bytes are not a TLS ClientHello record.
+	FailOpenNotTLS
+	// FailOpenRecordTooLarge: the ClientHello record exceeds
+	// engine.max_buffer_bytes.
+	FailOpenRecordTooLarge
+	// FailOpenUnsplittable: the window or template cannot be split.
+	FailOpenUnsplittable
+	// FailOpenSendError: sending the split segments failed.
+	FailOpenSendError
+	// FailOpenSYN, FailOpenRST and FailOpenFIN: a control flag arrived while
+	// collecting.
+	FailOpenSYN
+	FailOpenRST
+	FailOpenFIN
+	// FailOpenIdle: the flow went idle while collecting.
+	FailOpenIdle
+	// FailOpenPaused: the engine was paused.
+	FailOpenPaused
+	// FailOpenModeChange: engine.shadow was switched while the flow was
+	// collecting.
+	FailOpenModeChange
+)
+
+// NumFailOpenReasons bounds FailOpenReason values, for arrays indexed by
+// reason.
+const NumFailOpenReasons = int(FailOpenModeChange) + 1
+
+func (r FailOpenReason) String() string {
+	switch r {
+	case FailOpenNone:
+		return "none"
+	case FailOpenHeldPackets:
+		return "held-packets"
+	case FailOpenCollectTimeout:
+		return "collect-timeout"
+	case FailOpenReassembly:
+		return "reassembly"
+	case FailOpenHeldBudget:
+		return "held-budget"
+	case FailOpenReassemblyBudget:
+		return "reassembly-budget"
+	case FailOpenFlowLimit:
+		return "flow-limit"
+	case FailOpenNotTLS:
+		return "not-tls"
+	case FailOpenRecordTooLarge:
+		return "record-too-large"
+	case FailOpenUnsplittable:
+		return "unsplittable"
+	case FailOpenSendError:
+		return "send-error"
+	case FailOpenSYN:
+		return "syn"
+	case FailOpenRST:
+		return "rst"
+	case FailOpenFIN:
+		return "fin"
+	case FailOpenIdle:
+		return "idle"
+	case FailOpenPaused:
+		return "paused"
+	case FailOpenModeChange:
+		return "mode-change"
+	default:
+		return fmt.Sprintf("FailOpenReason(%d)", uint8(r))
+	}
+}
+
+// HandshakeOutcome is how the server answered a flow's ClientHello, as seen
+// on inbound packets.
+type HandshakeOutcome uint8
+
+const (
+	// HandshakePending: no answer has been classified yet.
+	HandshakePending HandshakeOutcome = iota
+	// HandshakeServerHello: a ServerHello record arrived.
+	HandshakeServerHello
+	// HandshakeRST: the connection was reset.
+	HandshakeRST
+	// HandshakeAlert: a TLS alert record arrived.
+	HandshakeAlert
+	// HandshakeTimeout: nothing arrived within engine.handshake_timeout.
+	HandshakeTimeout
+)
+
+// NumHandshakeOutcomes bounds HandshakeOutcome values, for arrays indexed by
+// outcome.
+const NumHandshakeOutcomes = int(HandshakeTimeout) + 1
+
+func (o HandshakeOutcome) String() string {
+	switch o {
+	case HandshakePending:
+		return "pending"
+	case HandshakeServerHello:
+		return "server-hello"
+	case HandshakeRST:
+		return "rst"
+	case HandshakeAlert:
+		return "alert"
+	case HandshakeTimeout:
+		return "timeout"
+	default:
+		return fmt.Sprintf("HandshakeOutcome(%d)", uint8(o))
+	}
+}
+
 type FlowState struct {
 	State           State
 	BaseSeq         uint32
@@ -46,6 +193,25 @@ type FlowState struct {
 	HeldPackets     []*packet.Packet
 	Reassembler     *reassembly.Buffer
 	Processed       bool
+	// FailOpenReason records why the flow passed through unsplit;
+	// FailOpenNone until it does.
+	FailOpenReason FailOpenReason
+	// Trace records the flow's events while tracing is on; nil otherwise.
+	Trace *trace.Flow
+	// Shadow is set for flows created from shadow copies: their decisions
+	// are recorded but nothing is sent or dropped.
+	Shadow bool
+	// Control is set for flows the canary sample left out: they pass
+	// through untouched.
+	Control bool
+	// Strategy names the split that was sent, e.g. "tls-hello/5"; empty
+	// until the flow is split.
+	Strategy string
+	// HandshakeStart is when the flow was created with handshake tracking
+	// on; zero when it is not tracked. Handshake is the server's answer,
+	// once classified.
+	HandshakeStart time.Time
+	Handshake      HandshakeOutcome
 }
 
 type Table struct {
@@ -77,6 +243,12 @@ func (t *Table) GetOrCreate(key Key, now time.Time) *FlowState {
 	return st
 }
 
+// Put stores st under key, replacing any existing state. It is used to move
+// flows between tables.
+func (t *Table) Put(key Key, st *FlowState) {
+	t.items[key] = st
+}
+
 func (t *Table) Delete(key Key) {
 	delete(t.items, key)
 }
@@ -0,0 +1,3 @@
+// Package hostnet reads and changes host network settings (interface offloads,
+// routing lookups) through kernel interfaces instead of external tools.
+package hostnet
@@ -0,0 +1,103 @@
+//go:build linux
+
+package hostnet
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Legacy ethtool "value" commands, used when the ethtool netlink family is
+// not available.
+const (
+	ethtoolGTSO = 0x0000001e
+	ethtoolSTSO = 0x0000001f
+	ethtoolGGSO = 0x00000023
+	ethtoolSGSO = 0x00000024
+	ethtoolGGRO = 0x0000002b
+	ethtoolSGRO = 0x0000002c
+)
+
+type ethtoolValue struct {
+	cmd  uint32
+	data uint32
+}
+
+type ifreqData struct {
+	name [unix.IFNAMSIZ]byte
+	data uintptr
+	_    [16]byte
+}
+
+func readOffloadIoctl(iface string) (OffloadState, error) {
+	var st OffloadState
+	var err error
+	if st.GRO, err = ethtoolGetValue(iface, ethtoolGGRO); err != nil {
+		return OffloadState{}, err
+	}
+	if st.GSO, err = ethtoolGetValue(iface, ethtoolGGSO); err != nil {
+		return OffloadState{}, err
+	}
+	// The legacy TSO value covers every TCP segmentation feature at once.
+	if st.TSO, err = ethtoolGetValue(iface, ethtoolGTSO); err != nil {
+		return OffloadState{}, err
+	}
+	st.TSOECN, st.TSOMangleID, st.TSO6 = st.TSO, st.TSO, st.TSO
+	return st, nil
+}
+
+func setOffloadIoctl(iface string, st OffloadState) error {
+	if err := ethtoolSetValue(iface, ethtoolSGRO, st.GRO); err != nil {
+		return err
+	}
+	if err := ethtoolSetValue(iface, ethtoolSGSO, st.GSO); err != nil {
+		return err
+	}
+	if err := ethtoolSetValue(iface, ethtoolSTSO, st.TSO || st.TSOECN || st.TSOMangleID || st.TSO6); err != nil {
+		return err
+	}
+	return nil
+}
+
+func ethtoolGetValue(iface string, cmd uint32) (bool, error) {
+	v := &ethtoolValue{cmd: cmd}
+	if err := ethtoolIoctl(iface, v); err != nil {
+		return false, fmt.Errorf("SIOCETHTOOL 0x%x on %s: %w", cmd, iface, err)
+	}
+	return v.data != 0, nil
+}
+
+func ethtoolSetValue(iface string, cmd uint32, on bool) error {
+	v := &ethtoolValue{cmd: cmd}
+	if on {
+		v.data = 1
+	}
+	if err := ethtoolIoctl(iface, v); err != nil {
+		return fmt.Errorf("SIOCETHTOOL 0x%x on %s: %w", cmd, iface, err)
+	}
+	return nil
+}
+
+func ethtoolIoctl(iface string, v *ethtoolValue) error {
+	if len(iface) >= unix.IFNAMSIZ {
+		return fmt.Errorf("interface name too long: %q", iface)
+	}
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	var ifr ifreqData
+	copy(ifr.name[:], iface)
+	ifr.data = uintptr(unsafe.Pointer(v))
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr)))
+	runtime.KeepAlive(v)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+//go:build linux
+
+package hostnet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const ethtoolFamilyName = "ethtool"
+
+var errGenlFamilyNotFound = errors.New("generic netlink family not found")
+
+// featureSet is the decoded subset of an ETHTOOL_MSG_FEATURES_GET reply.
+// hw lists features the driver allows to change; active lists features
+// currently enabled.
+type featureSet struct {
+	hw     map[string]bool
+	active map[string]bool
+}
+
+func getFeatures(iface string) (featureSet, error) {
+	c, err := netlink.Dial(unix.NETLINK_GENERIC, nil)
+	if err != nil {
+		return featureSet{}, err
+	}
+	defer c.Close()
+
+	family, err := resolveGenlFamily(c, ethtoolFamilyName)
+	if err != nil {
+		return featureSet{}, err
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	encodeEthtoolHeader(ae, unix.ETHTOOL_A_FEATURES_HEADER, iface, 0)
+	attrs, err := ae.Encode()
+	if err != nil {
+		return featureSet{}, err
+	}
+
+	msgs, err := c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(family),
+			Flags: netlink.Request,
+		},
+		Data: append(genlHeader(unix.ETHTOOL_MSG_FEATURES_GET, unix.ETHTOOL_GENL_VERSION), attrs...),
+	})
+	if err != nil {
+		return featureSet{}, err
+	}
+	for _, m := range msgs {
+		if len(m.Data) < unix.GENL_HDRLEN || m.Data[0] != unix.ETHTOOL_MSG_FEATURES_GET_REPLY {
+			continue
+		}
+		return decodeFeaturesReply(m.Data[unix.GENL_HDRLEN:])
+	}
+	return featureSet{}, errors.New("no features reply")
+}
+
+func setFeatures(iface string, changes map[string]bool) error {
+	c, err := netlink.Dial(unix.NETLINK_GENERIC, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	family, err := resolveGenlFamily(c, ethtoolFamilyName)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := encodeFeaturesSet(iface, changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(family),
+			Flags: netlink.Request | netlink.Acknowledge,
+		},
+		Data: append(genlHeader(unix.ETHTOOL_MSG_FEATURES_SET, unix.ETHTOOL_GENL_VERSION), attrs...),
+	})
+	return err
+}
+
+func encodeFeaturesSet(iface string, changes map[string]bool) ([]byte, error) {
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ae := netlink.NewAttributeEncoder()
+	encodeEthtoolHeader(ae, unix.ETHTOOL_A_FEATURES_HEADER, iface, unix.ETHTOOL_FLAG_OMIT_REPLY)
+	// A verbose bitset without NOMASK: listed bits are changed (set when the
+	// VALUE flag is present, cleared otherwise); unlisted bits are kept.
+	ae.Nested(unix.ETHTOOL_A_FEATURES_WANTED, func(bs *netlink.AttributeEncoder) error {
+		bs.Nested(unix.ETHTOOL_A_BITSET_BITS, func(bits *netlink.AttributeEncoder) error {
+			for _, name := range names {
+				on := changes[name]
+				bits.Nested(unix.ETHTOOL_A_BITSET_BITS_BIT, func(bit *netlink.AttributeEncoder) error {
+					bit.String(unix.ETHTOOL_A_BITSET_BIT_NAME, name)
+					bit.Flag(unix.ETHTOOL_A_BITSET_BIT_VALUE, on)
+					return nil
+				})
+			}
+			return nil
+		})
+		return nil
+	})
+	return ae.Encode()
+}
+
+func decodeFeaturesReply(b []byte) (featureSet, error) {
+	fs := featureSet{
+		hw:     make(map[string]bool),
+		active: make(map[string]bool),
+	}
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return featureSet{}, err
+	}
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.ETHTOOL_A_FEATURES_HW:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				return decodeBitset(nad, fs.hw)
+			})
+		case unix.ETHTOOL_A_FEATURES_ACTIVE:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				return decodeBitset(nad, fs.active)
+			})
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return featureSet{}, err
+	}
+	return fs, nil
+}
+
+// decodeBitset decodes a verbose ethtool bitset into out, keyed by bit name.
+// In list form (NOMASK) every listed bit is set; otherwise a bit is set only
+// when it carries the VALUE flag.
+func decodeBitset(ad *netlink.AttributeDecoder, out map[string]bool) error {
+	type bit struct {
+		name  string
+		value bool
+	}
+	var (
+		nomask bool
+		bits   []bit
+	)
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.ETHTOOL_A_BITSET_NOMASK:
+			nomask = true
+		case unix.ETHTOOL_A_BITSET_BITS:
+			ad.Nested(func(bad *netlink.AttributeDecoder) error {
+				for bad.Next() {
+					if bad.Type() != unix.ETHTOOL_A_BITSET_BITS_BIT {
+						continue
+					}
+					var b bit
+					bad.Nested(func(nad *netlink.AttributeDecoder) error {
+						for nad.Next() {
+							switch nad.Type() {
+							case unix.ETHTOOL_A_BITSET_BIT_NAME:
+								b.name = nad.String()
+							case unix.ETHTOOL_A_BITSET_BIT_VALUE:
+								b.value = true
+							}
+						}
+						return nil
+					})
+					bits = append(bits, b)
+				}
+				return nil
+			})
+		}
+	}
+	for _, b := range bits {
+		if b.name == "" {
+			continue
+		}
+		out[b.name] = nomask || b.value
+	}
+	return nil
+}
+
+func encodeEthtoolHeader(ae *netlink.AttributeEncoder, typ uint16, iface string, flags uint32) {
+	ae.Nested(typ, func(nae *netlink.AttributeEncoder) error {
+		nae.String(unix.ETHTOOL_A_HEADER_DEV_NAME, iface)
+		if flags != 0 {
+			nae.Uint32(unix.ETHTOOL_A_HEADER_FLAGS, flags)
+		}
+		return nil
+	})
+}
+
+// resolveGenlFamily looks up the numeric id of a generic netlink family.
+func resolveGenlFamily(c *netlink.Conn, name string) (uint16, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.CTRL_ATTR_FAMILY_NAME, name)
+	attrs, err := ae.Encode()
+	if err != nil {
+		return 0, err
+	}
+
+	msgs, err := c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(unix.GENL_ID_CTRL),
+			Flags: netlink.Request,
+		},
+		Data: append(genlHeader(unix.CTRL_CMD_GETFAMILY, 1), attrs...),
+	})
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return 0, errGenlFamilyNotFound
+		}
+		return 0, fmt.Errorf("resolve genl family %s: %w", name, err)
+	}
+	for _, m := range msgs {
+		if id, ok := decodeGenlFamilyID(m.Data); ok {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("resolve genl family %s: no family id in reply", name)
+}
+
+func decodeGenlFamilyID(b []byte) (uint16, bool) {
+	if len(b) < unix.GENL_HDRLEN {
+		return 0, false
+	}
+	ad, err := netlink.NewAttributeDecoder(b[unix.GENL_HDRLEN:])
+	if err != nil {
+		return 0, false
+	}
+	for ad.Next() {
+		if ad.Type() == unix.CTRL_ATTR_FAMILY_ID {
+			id := ad.Uint16()
+			return id, ad.Err() == nil && id != 0
+		}
+	}
+	return 0, false
+}
+
+func genlHeader(cmd uint8, version uint8) []byte {
+	return []byte{cmd, version, 0, 0}
+}
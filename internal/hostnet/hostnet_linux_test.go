@@ -0,0 +1,213 @@
+//go:build linux
+
+package hostnet
+
+import (
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func encodeBitset(t *testing.T, nomask bool, bits map[string]bool) func(*netlink.AttributeEncoder) error {
+	t.Helper()
+	return func(bs *netlink.AttributeEncoder) error {
+		bs.Flag(unix.ETHTOOL_A_BITSET_NOMASK, nomask)
+		bs.Uint32(unix.ETHTOOL_A_BITSET_SIZE, 64)
+		bs.Nested(unix.ETHTOOL_A_BITSET_BITS, func(list *netlink.AttributeEncoder) error {
+			i := uint32(0)
+			for name, on := range bits {
+				idx := i
+				i++
+				list.Nested(unix.ETHTOOL_A_BITSET_BITS_BIT, func(bit *netlink.AttributeEncoder) error {
+					bit.Uint32(unix.ETHTOOL_A_BITSET_BIT_INDEX, idx)
+					bit.String(unix.ETHTOOL_A_BITSET_BIT_NAME, name)
+					bit.Flag(unix.ETHTOOL_A_BITSET_BIT_VALUE, on)
+					return nil
+				})
+			}
+			return nil
+		})
+		return nil
+	}
+}
+
+func TestDecodeFeaturesReply(t *testing.T) {
+	ae := netlink.NewAttributeEncoder()
+	encodeEthtoolHeader(ae, unix.ETHTOOL_A_FEATURES_HEADER, "eth0", 0)
+	ae.Nested(unix.ETHTOOL_A_FEATURES_HW, encodeBitset(t, false, map[string]bool{
+		featureGRO: true,
+		featureGSO: true,
+		featureTSO: false,
+	}))
+	// List form: every listed bit is active regardless of the VALUE flag.
+	ae.Nested(unix.ETHTOOL_A_FEATURES_ACTIVE, encodeBitset(t, true, map[string]bool{
+		featureGRO: false,
+		featureTSO: false,
+	}))
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	fs, err := decodeFeaturesReply(b)
+	if err != nil {
+		t.Fatalf("decodeFeaturesReply: %v", err)
+	}
+	if !fs.hw[featureGRO] || !fs.hw[featureGSO] || fs.hw[featureTSO] {
+		t.Fatalf("hw mismatch: %v", fs.hw)
+	}
+	if !fs.active[featureGRO] || fs.active[featureGSO] || !fs.active[featureTSO] {
+		t.Fatalf("active mismatch: %v", fs.active)
+	}
+}
+
+func TestEncodeFeaturesSet(t *testing.T) {
+	b, err := encodeFeaturesSet("eth0", map[string]bool{featureGRO: false, featureGSO: true})
+	if err != nil {
+		t.Fatalf("encodeFeaturesSet: %v", err)
+	}
+
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		t.Fatalf("decoder: %v", err)
+	}
+	var (
+		dev    string
+		flags  uint32
+		wanted = make(map[string]bool)
+	)
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.ETHTOOL_A_FEATURES_HEADER:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				for nad.Next() {
+					switch nad.Type() {
+					case unix.ETHTOOL_A_HEADER_DEV_NAME:
+						dev = nad.String()
+					case unix.ETHTOOL_A_HEADER_FLAGS:
+						flags = nad.Uint32()
+					}
+				}
+				return nil
+			})
+		case unix.ETHTOOL_A_FEATURES_WANTED:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				return decodeBitset(nad, wanted)
+			})
+		}
+	}
+	if err := ad.Err(); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if dev != "eth0" {
+		t.Fatalf("dev name: got %q", dev)
+	}
+	if flags&unix.ETHTOOL_FLAG_OMIT_REPLY == 0 {
+		t.Fatalf("expected OMIT_REPLY flag, got %#x", flags)
+	}
+	if len(wanted) != 2 || wanted[featureGRO] || !wanted[featureGSO] {
+		t.Fatalf("wanted mismatch: %v", wanted)
+	}
+}
+
+func TestDecodeGenlFamilyID(t *testing.T) {
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.CTRL_ATTR_FAMILY_NAME, ethtoolFamilyName)
+	ae.Uint16(unix.CTRL_ATTR_FAMILY_ID, 21)
+	attrs, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	id, ok := decodeGenlFamilyID(append(genlHeader(unix.CTRL_CMD_NEWFAMILY, 2), attrs...))
+	if !ok || id != 21 {
+		t.Fatalf("decodeGenlFamilyID = (%d,%v), want (21,true)", id, ok)
+	}
+	if _, ok := decodeGenlFamilyID([]byte{1, 2}); ok {
+		t.Fatalf("expected short message to be rejected")
+	}
+}
+
+func TestDecodeRoute(t *testing.T) {
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.RTA_DST, []byte{1, 1, 1, 1})
+	ae.Uint32(unix.RTA_TABLE, unix.RT_TABLE_MAIN)
+	ae.Uint32(unix.RTA_PRIORITY, 100)
+	ae.Uint32(unix.RTA_OIF, 3)
+	attrs, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	b := append(encodeRtMsg(unix.RtMsg{Family: unix.AF_INET, Dst_len: 32}), attrs...)
+	r, err := decodeRoute(b)
+	if err != nil {
+		t.Fatalf("decodeRoute: %v", err)
+	}
+	if r.dstLen != 32 || r.table != unix.RT_TABLE_MAIN || r.oif != 3 || r.priority != 100 {
+		t.Fatalf("route mismatch: %+v", r)
+	}
+
+	if _, err := decodeRoute([]byte{1, 2, 3}); err == nil {
+		t.Fatalf("expected error for short rtmsg")
+	}
+}
+
+func TestDecodeFwmarkRule(t *testing.T) {
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.FRA_PRIORITY, 5270)
+	ae.Uint32(unix.FRA_TABLE, 52)
+	ae.Uint32(unix.FRA_FWMARK, 0x80000)
+	ae.Uint32(unix.FRA_FWMASK, 0xff0000)
+	attrs, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	hdr := make([]byte, fibRuleHdrLen)
+	hdr[0] = unix.AF_INET
+	hdr[8] = fibRuleInvert
+	r, ok, err := decodeFwmarkRule(append(hdr, attrs...))
+	if err != nil || !ok {
+		t.Fatalf("decodeFwmarkRule: ok=%v err=%v", ok, err)
+	}
+	want := FwmarkRule{Priority: 5270, Table: 52, Mark: 0x80000, Mask: 0xff0000, Invert: true}
+	if r != want {
+		t.Fatalf("rule mismatch: got %+v want %+v", r, want)
+	}
+	if r.Matches(0x80000) || !r.Matches(1) {
+		t.Fatalf("inverted match mismatch")
+	}
+
+	if _, ok, err := decodeFwmarkRule(make([]byte, fibRuleHdrLen)); err != nil || ok {
+		t.Fatalf("rule without fwmark: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestOffloadChangesKeepsEachTSOFeature(t *testing.T) {
+	fs := featureSet{
+		hw: map[string]bool{
+			featureGRO: true, featureGSO: true, featureTSO: true,
+			featureTSOECN: true, featureTSOMangleID: true, featureTSO6: true,
+		},
+		active: map[string]bool{},
+	}
+	// Restoring an interface that only had IPv4 and IPv6 TSO on.
+	got := offloadChanges(fs, OffloadState{GRO: true, TSO: true, TSO6: true})
+	want := map[string]bool{featureGRO: true, featureTSO: true, featureTSO6: true}
+	if len(got) != len(want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+	for name, on := range want {
+		if got[name] != on {
+			t.Fatalf("changes = %v, want %v", got, want)
+		}
+	}
+
+	// Features the driver does not let us change are skipped.
+	fs.hw[featureTSO6] = false
+	if _, ok := offloadChanges(fs, OffloadState{TSO6: true})[featureTSO6]; ok {
+		t.Fatalf("fixed feature changed")
+	}
+}
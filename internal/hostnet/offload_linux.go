@@ -0,0 +1,117 @@
+//go:build linux
+
+package hostnet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// OffloadState holds the GRO/GSO/TSO settings of one interface. "tso" in
+// ethtool(8) covers four TCP segmentation features; each is kept on its own
+// so restoring them does not turn on one that was off.
+type OffloadState struct {
+	GRO bool
+	GSO bool
+	// TSO is tx-tcp-segmentation.
+	TSO         bool
+	TSOECN      bool
+	TSOMangleID bool
+	TSO6        bool
+}
+
+// Feature names as exposed by the ethtool netlink family.
+const (
+	featureGRO         = "rx-gro"
+	featureGSO         = "tx-generic-segmentation"
+	featureTSO         = "tx-tcp-segmentation"
+	featureTSOECN      = "tx-tcp-ecn-segmentation"
+	featureTSOMangleID = "tx-tcp-mangleid-segmentation"
+	featureTSO6        = "tx-tcp6-segmentation"
+)
+
+// features maps st onto ethtool feature names.
+func (st OffloadState) features() map[string]bool {
+	return map[string]bool{
+		featureGRO:         st.GRO,
+		featureGSO:         st.GSO,
+		featureTSO:         st.TSO,
+		featureTSOECN:      st.TSOECN,
+		featureTSOMangleID: st.TSOMangleID,
+		featureTSO6:        st.TSO6,
+	}
+}
+
+// ReadOffload returns the current GRO/GSO/TSO state of iface. It uses the
+// ethtool generic netlink family and falls back to the legacy SIOCETHTOOL ioctl
+// on kernels that predate it (< 5.6).
+func ReadOffload(iface string) (OffloadState, error) {
+	iface = strings.TrimSpace(iface)
+	if iface == "" {
+		return OffloadState{}, errors.New("iface is empty")
+	}
+
+	feats, err := getFeatures(iface)
+	if errors.Is(err, errGenlFamilyNotFound) {
+		return readOffloadIoctl(iface)
+	}
+	if err != nil {
+		return OffloadState{}, fmt.Errorf("ethtool features get on %s: %w", iface, err)
+	}
+	return OffloadState{
+		GRO:         feats.active[featureGRO],
+		GSO:         feats.active[featureGSO],
+		TSO:         feats.active[featureTSO],
+		TSOECN:      feats.active[featureTSOECN],
+		TSOMangleID: feats.active[featureTSOMangleID],
+		TSO6:        feats.active[featureTSO6],
+	}, nil
+}
+
+// SetOffload applies st to iface. Features the driver reports as fixed are
+// left untouched.
+func SetOffload(iface string, st OffloadState) error {
+	iface = strings.TrimSpace(iface)
+	if iface == "" {
+		return errors.New("iface is empty")
+	}
+
+	feats, err := getFeatures(iface)
+	if errors.Is(err, errGenlFamilyNotFound) {
+		return setOffloadIoctl(iface, st)
+	}
+	if err != nil {
+		return fmt.Errorf("ethtool features get on %s: %w", iface, err)
+	}
+
+	changes := offloadChanges(feats, st)
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := setFeatures(iface, changes); err != nil {
+		return fmt.Errorf("ethtool features set on %s: %w", iface, err)
+	}
+	return nil
+}
+
+// offloadChanges returns the features whose active state differs from st,
+// skipping the ones the driver does not let us change.
+func offloadChanges(feats featureSet, st OffloadState) map[string]bool {
+	changes := make(map[string]bool)
+	for name, on := range st.features() {
+		if !feats.hw[name] {
+			continue
+		}
+		if feats.active[name] == on {
+			continue
+		}
+		changes[name] = on
+	}
+	return changes
+}
+
+// DisableOffload turns GRO, GSO and TSO off on iface.
+func DisableOffload(iface string) error {
+	return SetOffload(iface, OffloadState{})
+}
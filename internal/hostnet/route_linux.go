@@ -0,0 +1,161 @@
+//go:build linux
+
+package hostnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+// ProbeDestination is the address used to find the egress interface when no
+// interface is configured explicitly.
+var ProbeDestination = [4]byte{1, 1, 1, 1}
+
+// EgressInterface returns the name of the interface the kernel would use to
+// reach dst. If the route lookup yields nothing, the interface of the main
+// table's IPv4 default route is used instead.
+func EgressInterface(dst [4]byte) (string, error) {
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return "", fmt.Errorf("rtnetlink dial: %w", err)
+	}
+	defer c.Close()
+
+	idx, err := routeGet(c, dst)
+	if err != nil || idx == 0 {
+		idx, err = defaultRouteIndex(c)
+		if err != nil {
+			return "", fmt.Errorf("route lookup failed: %w", err)
+		}
+	}
+	if idx == 0 {
+		return "", errors.New("could not detect egress interface")
+	}
+	ifi, err := net.InterfaceByIndex(int(idx))
+	if err != nil {
+		return "", fmt.Errorf("interface index %d: %w", idx, err)
+	}
+	return ifi.Name, nil
+}
+
+func routeGet(c *netlink.Conn, dst [4]byte) (uint32, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.RTA_DST, dst[:])
+	attrs, err := ae.Encode()
+	if err != nil {
+		return 0, err
+	}
+
+	msgs, err := c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_GETROUTE,
+			Flags: netlink.Request,
+		},
+		Data: append(encodeRtMsg(unix.RtMsg{Family: unix.AF_INET, Dst_len: 32}), attrs...),
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWROUTE {
+			continue
+		}
+		r, err := decodeRoute(m.Data)
+		if err != nil {
+			return 0, err
+		}
+		return r.oif, nil
+	}
+	return 0, nil
+}
+
+func defaultRouteIndex(c *netlink.Conn) (uint32, error) {
+	msgs, err := c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_GETROUTE,
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: encodeRtMsg(unix.RtMsg{Family: unix.AF_INET}),
+	})
+	if err != nil {
+		return 0, err
+	}
+	var (
+		best       uint32
+		bestMetric uint32
+		found      bool
+	)
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWROUTE {
+			continue
+		}
+		r, err := decodeRoute(m.Data)
+		if err != nil {
+			continue
+		}
+		if r.dstLen != 0 || r.table != unix.RT_TABLE_MAIN || r.oif == 0 {
+			continue
+		}
+		if !found || r.priority < bestMetric {
+			best, bestMetric, found = r.oif, r.priority, true
+		}
+	}
+	if !found {
+		return 0, errors.New("no IPv4 default route")
+	}
+	return best, nil
+}
+
+type route struct {
+	dstLen   uint8
+	table    uint32
+	oif      uint32
+	priority uint32
+}
+
+func encodeRtMsg(rt unix.RtMsg) []byte {
+	b := make([]byte, unix.SizeofRtMsg)
+	b[0] = rt.Family
+	b[1] = rt.Dst_len
+	b[2] = rt.Src_len
+	b[3] = rt.Tos
+	b[4] = rt.Table
+	b[5] = rt.Protocol
+	b[6] = rt.Scope
+	b[7] = rt.Type
+	nlenc.PutUint32(b[8:12], rt.Flags)
+	return b
+}
+
+func decodeRoute(b []byte) (route, error) {
+	if len(b) < unix.SizeofRtMsg {
+		return route{}, errors.New("short rtmsg")
+	}
+	r := route{
+		dstLen: b[1],
+		table:  uint32(b[4]),
+	}
+	ad, err := netlink.NewAttributeDecoder(b[unix.SizeofRtMsg:])
+	if err != nil {
+		return route{}, err
+	}
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.RTA_OIF:
+			r.oif = ad.Uint32()
+		case unix.RTA_TABLE:
+			r.table = ad.Uint32()
+		case unix.RTA_PRIORITY:
+			r.priority = ad.Uint32()
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return route{}, err
+	}
+	return r, nil
+}
@@ -0,0 +1,104 @@
+//go:build linux
+
+package hostnet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+// fibRuleHdrLen is sizeof(struct fib_rule_hdr).
+const fibRuleHdrLen = 12
+
+// fibRuleInvert is FIB_RULE_INVERT ("not fwmark ...").
+const fibRuleInvert = 0x2
+
+// FwmarkRule is a policy routing rule that selects on the packet mark, as
+// installed by WireGuard (wg-quick), Tailscale and similar tools.
+type FwmarkRule struct {
+	Priority uint32
+	Table    uint32
+	Mark     uint32
+	Mask     uint32
+	Invert   bool
+}
+
+// Matches reports whether a packet carrying mark would be selected by r.
+func (r FwmarkRule) Matches(mark uint32) bool {
+	hit := mark&r.Mask == r.Mark&r.Mask
+	return hit != r.Invert
+}
+
+// FwmarkRules lists the IPv4 policy routing rules that match on fwmark.
+func FwmarkRules() ([]FwmarkRule, error) {
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return nil, fmt.Errorf("rtnetlink dial: %w", err)
+	}
+	defer c.Close()
+
+	hdr := make([]byte, fibRuleHdrLen)
+	hdr[0] = unix.AF_INET
+	msgs, err := c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_GETRULE,
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: hdr,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []FwmarkRule
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWRULE {
+			continue
+		}
+		r, ok, err := decodeFwmarkRule(m.Data)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+func decodeFwmarkRule(b []byte) (FwmarkRule, bool, error) {
+	if len(b) < fibRuleHdrLen {
+		return FwmarkRule{}, false, errors.New("short fib_rule_hdr")
+	}
+	r := FwmarkRule{
+		Table:  uint32(b[4]),
+		Mask:   0xffffffff,
+		Invert: nlenc.Uint32(b[8:12])&fibRuleInvert != 0,
+	}
+	ad, err := netlink.NewAttributeDecoder(b[fibRuleHdrLen:])
+	if err != nil {
+		return FwmarkRule{}, false, err
+	}
+	hasMark := false
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.FRA_PRIORITY:
+			r.Priority = ad.Uint32()
+		case unix.FRA_TABLE:
+			r.Table = ad.Uint32()
+		case unix.FRA_FWMARK:
+			r.Mark = ad.Uint32()
+			hasMark = true
+		case unix.FRA_FWMASK:
+			r.Mask = ad.Uint32()
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return FwmarkRule{}, false, err
+	}
+	return r, hasMark, nil
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package hostnet
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// WatchRouteChanges subscribes to rtnetlink link and IPv4 route notifications
+// and calls notify for every batch of relevant messages. A receive buffer
+// overrun is reported as a change as well, since events may have been lost.
+// It blocks until ctx is canceled or the socket fails.
+func WatchRouteChanges(ctx context.Context, notify func()) error {
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return err
+	}
+	for _, group := range []uint32{unix.RTNLGRP_LINK, unix.RTNLGRP_IPV4_ROUTE} {
+		if err := c.JoinGroup(group); err != nil {
+			_ = c.Close()
+			return err
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.Close()
+		case <-stop:
+			_ = c.Close()
+		}
+	}()
+
+	for {
+		msgs, err := c.Receive()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, unix.ENOBUFS) {
+				notify()
+				continue
+			}
+			return err
+		}
+		if isRouteChange(msgs) {
+			notify()
+		}
+	}
+}
+
+func isRouteChange(msgs []netlink.Message) bool {
+	for _, m := range msgs {
+		switch m.Header.Type {
+		case unix.RTM_NEWLINK, unix.RTM_DELLINK, unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+			return true
+		}
+	}
+	return false
+}
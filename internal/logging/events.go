@@ -0,0 +1,81 @@
+package logging
+
+// Event names, logged under KeyEvent. They are part of the splitter's
+// interface: alerts and dashboards match on them, so existing names are
+// never changed or reused.
+const (
+	// Splitter process.
+	EventStarted        = "splitter.started"
+	EventStopped        = "splitter.stopped"
+	EventConfigIgnored  = "config.ignored"
+	EventConfigWarning  = "config.warning"
+	EventToolsInstall   = "tools.install"
+	EventCleanup        = "cleanup.done"
+	EventCleanupSkipped = "cleanup.skipped"
+
+	// Configuration reload.
+	EventReloadApplied         = "reload.applied"
+	EventReloadFailed          = "reload.failed"
+	EventReloadRestartRequired = "reload.restart_required"
+
+	// Engine and flows.
+	EventEngineStarted    = "engine.started"
+	EventEngineStopped    = "engine.stopped"
+	EventWorkerFailed     = "engine.worker_failed"
+	EventWorkersRebuilt   = "engine.workers_rebuilt"
+	EventShutdownFlush    = "engine.shutdown_flush"
+	EventPaused           = "engine.paused"
+	EventResumed          = "engine.resumed"
+	EventPauseIncomplete  = "engine.pause_incomplete"
+	EventFailOpen         = "flow.fail_open"
+	EventBudgetExhausted  = "flow.budget_exhausted"
+	EventHandshake        = "flow.handshake"
+	EventShadowSplit      = "shadow.split"
+	EventShadowFailOpen   = "shadow.fail_open"
+	EventAdapterOverflow  = "adapter.overflow"
+	EventAdapterSendError = "adapter.send_error"
+	EventAdapterError     = "adapter.error"
+
+	// Linux rules, offload and state journal.
+	EventRulesInstalled       = "rules.installed"
+	EventRulesRemoved         = "rules.removed"
+	EventRulesRemoveFailed    = "rules.remove_failed"
+	EventRulesCheckFailed     = "rules.check_failed"
+	EventRulesDrift           = "rules.drift"
+	EventRulesReinstalled     = "rules.reinstalled"
+	EventRulesReinstallFailed = "rules.reinstall_failed"
+	EventOffloadDisabled      = "offload.disabled"
+	EventOffloadRestored      = "offload.restored"
+	EventOffloadFailed        = "offload.failed"
+	EventEgressWatch          = "egress.watch"
+	EventEgressChanged        = "egress.changed"
+	EventEgressFailed         = "egress.failed"
+	EventJournalFailed        = "journal.failed"
+	EventJournalStale         = "journal.stale"
+
+	// Flow trace.
+	EventTraceDumped     = "trace.dumped"
+	EventTraceDumpFailed = "trace.dump_failed"
+
+	// Packet capture.
+	EventCaptureStarted = "capture.started"
+	EventCaptureRotated = "capture.rotated"
+	EventCaptureFailed  = "capture.failed"
+
+	// Control API and metrics.
+	EventControlListening  = "control.listening"
+	EventControlDisabled   = "control.disabled"
+	EventControlRestricted = "control.restricted"
+	EventControlRequest    = "control.request"
+	EventControlDenied     = "control.denied"
+	EventLogLevel          = "control.log_level"
+	EventMetricsListening  = "metrics.listening"
+	EventMetricsDisabled   = "metrics.disabled"
+	EventMetricsExposed    = "metrics.exposed"
+
+	// Windows service and driver.
+	EventService        = "service.state"
+	EventServiceReload  = "service.reload"
+	EventDriverDownload = "driver.download"
+	EventDriverFailed   = "driver.failed"
+)
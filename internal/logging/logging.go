@@ -0,0 +1,107 @@
+// Package logging sets up the splitter's log/slog handler: text or JSON
+// output, a runtime-adjustable level, per-event rate limiting and optional
+// redaction of addresses and server names.
+//
+// Every record that matters to operators carries an "event" attribute with
+// one of the stable names in events.go; messages may be reworded, event
+// names are not.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Attribute keys with a fixed meaning. Values under KeySrc, KeyDst, KeyIP
+// and KeySNI are rewritten when Options.Redact is set.
+const (
+	KeyEvent = "event"
+	KeySrc   = "src"
+	KeyDst   = "dst"
+	KeyIP    = "ip"
+	KeySNI   = "sni"
+	KeyError = "err"
+	// KeySuppressed is added to the first record of an event after the rate
+	// limiter dropped some; its value is the number dropped.
+	KeySuppressed = "suppressed"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type Options struct {
+	// Level is read on every record, so changing it takes effect at once.
+	Level slog.Leveler
+	// Format is FormatText (the default when empty) or FormatJSON.
+	Format string
+	// Redact replaces IP addresses and server names with a keyed hash that
+	// is stable for the life of the process, so records about one flow can
+	// still be matched up.
+	Redact bool
+	// RateLimit caps the records per second for each event; 0 is unlimited.
+	RateLimit int
+}
+
+// New returns a logger writing to w.
+func New(w io.Writer, o Options) (*slog.Logger, error) {
+	ho := &slog.HandlerOptions{Level: o.Level}
+	if o.Redact {
+		ho.ReplaceAttr = newRedactor().replace
+	}
+	var h slog.Handler
+	switch o.Format {
+	case "", FormatText:
+		h = slog.NewTextHandler(w, ho)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, ho)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", o.Format)
+	}
+	if o.RateLimit > 0 {
+		h = newRateLimiter(h, o.RateLimit)
+	}
+	return slog.New(h), nil
+}
+
+// Event is the attribute naming a record's event.
+func Event(name string) slog.Attr {
+	return slog.String(KeyEvent, name)
+}
+
+// Err is the attribute carrying an error.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// ParseLevel accepts debug, info, warn and error (any case).
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+}
+
+// FormatLevel is the lower-case name ParseLevel accepts.
+func FormatLevel(l slog.Level) string {
+	switch {
+	case l <= slog.LevelDebug:
+		return "debug"
+	case l <= slog.LevelInfo:
+		return "info"
+	case l <= slog.LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
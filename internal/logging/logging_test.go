@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestNew_JSONLevelAndEvent(t *testing.T) {
+	var buf bytes.Buffer
+	var level slog.LevelVar
+	l, err := New(&buf, Options{Level: &level, Format: FormatJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("hidden", Event(EventFailOpen))
+	l.Warn("send failed", Event(EventAdapterSendError), Err(errors.New("boom")))
+	level.Set(slog.LevelDebug)
+	l.Debug("shown", Event(EventFailOpen))
+
+	lines := decodeLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("got %d records, want 2: %s", len(lines), buf.String())
+	}
+	if lines[0][KeyEvent] != EventAdapterSendError || lines[0][KeyError] != "boom" || lines[0]["level"] != "WARN" {
+		t.Fatalf("record = %v", lines[0])
+	}
+	if lines[1]["msg"] != "shown" {
+		t.Fatalf("record = %v", lines[1])
+	}
+}
+
+func TestNew_UnknownFormat(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, Options{Format: "xml"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRateLimiter_SuppressesPerEvent(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Unix(1000, 0)
+	rl := newRateLimiter(slog.NewJSONHandler(&buf, nil), 2)
+	rl.state.now = func() time.Time { return now }
+	l := slog.New(rl)
+
+	for i := 0; i < 5; i++ {
+		l.Warn("fail-open", Event(EventFailOpen))
+	}
+	// Other events and events bound with With have their own buckets.
+	l.Warn("overflow", Event(EventAdapterOverflow))
+	l.With(Event(EventAdapterSendError)).Warn("send")
+	now = now.Add(time.Second)
+	l.Warn("fail-open", Event(EventFailOpen))
+
+	lines := decodeLines(t, &buf)
+	var events []string
+	for _, m := range lines {
+		events = append(events, m[KeyEvent].(string))
+	}
+	want := []string{EventFailOpen, EventFailOpen, EventAdapterOverflow, EventAdapterSendError, EventFailOpen}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	if got := lines[4][KeySuppressed]; got != float64(3) {
+		t.Fatalf("suppressed = %v, want 3", got)
+	}
+	if _, ok := lines[1][KeySuppressed]; ok {
+		t.Fatalf("unexpected suppressed count: %v", lines[1])
+	}
+}
+
+func TestRateLimiter_KeysByMessageWithoutEvent(t *testing.T) {
+	var buf bytes.Buffer
+	rl := newRateLimiter(slog.NewTextHandler(&buf, nil), 1)
+	rl.state.now = func() time.Time { return time.Unix(0, 0) }
+	l := slog.New(rl)
+	l.Info("a")
+	l.Info("a")
+	l.Info("b")
+	if got := strings.Count(buf.String(), "\n"); got != 2 {
+		t.Fatalf("got %d records, want 2:\n%s", got, buf.String())
+	}
+}
+
+func TestRateLimiter_BoundsBuckets(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rl := newRateLimiter(slog.NewTextHandler(io.Discard, nil), 1)
+	rl.state.now = func() time.Time { return now }
+	l := slog.New(rl)
+	buckets := func() int {
+		rl.state.mu.Lock()
+		defer rl.state.mu.Unlock()
+		return len(rl.state.buckets)
+	}
+
+	// Messages with varying text in one burst: keys past the cap share a
+	// bucket.
+	for i := 0; i < 3*maxBuckets; i++ {
+		l.Info(fmt.Sprintf("dial %d failed", i))
+	}
+	if n := buckets(); n > maxBuckets+1 {
+		t.Fatalf("%d buckets after a burst, want at most %d", n, maxBuckets+1)
+	}
+
+	// Once idle, buckets with nothing to report are swept.
+	now = now.Add(2 * time.Second)
+	l.Info("later")
+	if n := buckets(); n > 2 {
+		t.Fatalf("%d buckets after the burst went idle, want the new one and the overflow bucket with its suppressed count", n)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, Options{Format: FormatJSON, Redact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := netip.MustParseAddrPort("192.0.2.1:443")
+	l.Info("flow", KeySrc, "10.0.0.1:50000", KeyDst, dst, KeyIP, "192.0.2.1", KeySNI, "Example.com", "worker", 3)
+	l.Info("flow", KeyDst, "192.0.2.1:8443", KeySNI, "example.com")
+
+	lines := decodeLines(t, &buf)
+	m := lines[0]
+	if s := m[KeySrc].(string); !strings.HasPrefix(s, "ip-") || !strings.HasSuffix(s, ":50000") || strings.Contains(s, "10.0.0.1") {
+		t.Fatalf("src = %q", s)
+	}
+	d := m[KeyDst].(string)
+	if !strings.HasPrefix(d, "ip-") || !strings.HasSuffix(d, ":443") {
+		t.Fatalf("dst = %q", d)
+	}
+	if ip := m[KeyIP].(string); ip+":443" != d {
+		t.Fatalf("ip = %q, dst = %q: same address should hash the same", ip, d)
+	}
+	if sni := m[KeySNI].(string); !strings.HasPrefix(sni, "host-") || sni != lines[1][KeySNI] {
+		t.Fatalf("sni = %q, %q", sni, lines[1][KeySNI])
+	}
+	if m["worker"] != float64(3) {
+		t.Fatalf("worker = %v", m["worker"])
+	}
+	if d2 := lines[1][KeyDst].(string); !strings.HasSuffix(d2, ":8443") || strings.TrimSuffix(d2, ":8443") != m[KeyIP] {
+		t.Fatalf("dst = %q", d2)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{"debug", "INFO", "warn", "warning", " error "} {
+		l, err := ParseLevel(s)
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if got, _ := ParseLevel(FormatLevel(l)); got != l {
+			t.Fatalf("%q round trip = %v", s, got)
+		}
+	}
+	if _, err := ParseLevel("trace"); err == nil {
+		t.Fatal("expected error for trace")
+	}
+}
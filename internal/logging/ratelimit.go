@@ -0,0 +1,147 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// rateLimiter drops records of an event beyond limit per second. Records
+// without an event attribute are limited by their message instead.
+type rateLimiter struct {
+	next  slog.Handler
+	state *limiterState
+	// event is set when the event attribute came from WithAttrs.
+	event string
+}
+
+// maxBuckets bounds the keys tracked at once. Records of new keys beyond it
+// share one bucket until idle keys are swept.
+const maxBuckets = 1024
+
+// overflowKey is the bucket shared by keys past maxBuckets.
+const overflowKey = "\x00overflow"
+
+type limiterState struct {
+	limit int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// swept is when idle buckets were last removed.
+	swept time.Time
+}
+
+// bucket is a token bucket holding up to limit tokens, refilled at limit
+// per second.
+type bucket struct {
+	tokens     float64
+	last       time.Time
+	suppressed uint64
+}
+
+func newRateLimiter(next slog.Handler, limit int) *rateLimiter {
+	return &rateLimiter{next: next, state: &limiterState{
+		limit:   limit,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}}
+}
+
+func (h *rateLimiter) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *rateLimiter) Handle(ctx context.Context, r slog.Record) error {
+	key := h.event
+	if key == "" {
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == KeyEvent {
+				key = a.Value.String()
+				return false
+			}
+			return true
+		})
+	}
+	if key == "" {
+		key = "msg:" + r.Message
+	}
+	ok, suppressed := h.state.take(key)
+	if !ok {
+		return nil
+	}
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Uint64(KeySuppressed, suppressed))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *rateLimiter) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	for _, a := range attrs {
+		if a.Key == KeyEvent {
+			c.event = a.Value.String()
+		}
+	}
+	return &c
+}
+
+func (h *rateLimiter) WithGroup(name string) slog.Handler {
+	c := *h
+	c.next = h.next.WithGroup(name)
+	return &c
+}
+
+// take spends a token of key. When it succeeds it also returns how many
+// records of key were dropped since the last one that got through.
+func (s *limiterState) take(key string) (bool, uint64) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.swept) >= time.Second {
+		s.sweep(now)
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= maxBuckets {
+			s.sweep(now)
+		}
+		if len(s.buckets) >= maxBuckets {
+			key = overflowKey
+			b = s.buckets[key]
+		}
+		if b == nil {
+			b = &bucket{tokens: float64(s.limit), last: now}
+			s.buckets[key] = b
+		}
+	}
+	b.tokens += now.Sub(b.last).Seconds() * float64(s.limit)
+	if b.tokens > float64(s.limit) {
+		b.tokens = float64(s.limit)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		b.suppressed++
+		return false, 0
+	}
+	b.tokens--
+	suppressed := b.suppressed
+	b.suppressed = 0
+	return true, suppressed
+}
+
+// sweep removes the buckets that have refilled and have no suppressed
+// records to report: a new bucket for the same key would behave the same.
+// Messages with varying text would otherwise grow the map forever. Callers
+// hold s.mu.
+func (s *limiterState) sweep(now time.Time) {
+	s.swept = now
+	for key, b := range s.buckets {
+		if b.suppressed == 0 && now.Sub(b.last) >= time.Second {
+			delete(s.buckets, key)
+		}
+	}
+}
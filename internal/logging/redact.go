@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/netip"
+	"strings"
+)
+
+// redactor hashes addresses and server names with a key drawn at startup:
+// equal inputs map to equal outputs within one process and cannot be
+// reversed or matched across restarts.
+type redactor struct {
+	key []byte
+}
+
+func newRedactor() *redactor {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return &redactor{key: key}
+}
+
+func (r *redactor) replace(_ []string, a slog.Attr) slog.Attr {
+	switch a.Key {
+	case KeySrc, KeyDst, KeyIP, KeySNI:
+		a.Value = slog.StringValue(r.redact(a.Value.Resolve().String()))
+	}
+	return a
+}
+
+// redact keeps the port of an address and the shape of the value, so
+// "10.0.0.1:443" becomes "ip-1a2b3c4d:443" and "example.com" becomes
+// "host-5e6f7a8b".
+func (r *redactor) redact(s string) string {
+	if s == "" {
+		return s
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return r.hash("ip", ap.Addr().String()) + ":" + portString(s)
+	}
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return r.hash("ip", addr.String())
+	}
+	return r.hash("host", strings.ToLower(s))
+}
+
+func (r *redactor) hash(prefix, s string) string {
+	m := hmac.New(sha256.New, r.key)
+	m.Write([]byte(s))
+	return prefix + "-" + hex.EncodeToString(m.Sum(nil)[:4])
+}
+
+func portString(addrPort string) string {
+	return addrPort[strings.LastIndexByte(addrPort, ':')+1:]
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"fk-gov/internal/adapter"
+	"fk-gov/internal/engine"
+	"fk-gov/internal/flow"
+)
+
+// statsTimeout bounds how long a scrape waits for busy workers.
+const statsTimeout = 2 * time.Second
+
+// EngineCollector writes the engine's counters and, when ad keeps them, the
+// adapter's. Once the engine has stopped only the shutdown and adapter
+// metrics are written, so the final flush outcomes can still be scraped.
+func EngineCollector(eng *engine.Engine, ad adapter.Adapter) func(context.Context, *Writer) {
+	return func(ctx context.Context, w *Writer) {
+		ctx, cancel := context.WithTimeout(ctx, statsTimeout)
+		defer cancel()
+		if st, err := eng.Stats(ctx); err == nil {
+			writeStats(w, st)
+		}
+		if src, ok := ad.(adapter.CounterSource); ok {
+			writeAdapter(w, src.Counters())
+		}
+		writeShutdown(w, eng.ShutdownStats())
+	}
+}
+
+func writeStats(w *Writer, st engine.Stats) {
+	w.Gauge("gov_pass_paused", "Whether splitting is paused (1) or active (0).", Sample{Value: boolValue(st.Paused)})
+	w.Counter("gov_pass_packets_received", "Packets read from the adapter.", Sample{Value: float64(st.Received)})
+	w.Counter("gov_pass_packets_bypassed", "Packets passed through unchanged while paused.", Sample{Value: float64(st.Bypassed)})
+	w.Counter("gov_pass_flows_created", "Flows that started tracking.", Sample{Value: float64(st.FlowsCreated)})
+	w.Counter("gov_pass_flows_evicted", "Flows removed by the idle GC.", Sample{Value: float64(st.FlowsEvicted)})
+	w.Gauge("gov_pass_canary_percent", "Percentage of new flows put in the canary cohort.", Sample{Value: float64(st.CanaryPercent)})
+	var cohorts []Sample
+	for _, c := range []engine.Cohort{engine.CohortCanary, engine.CohortControl} {
+		cohorts = append(cohorts, Sample{Labels: []Label{{"cohort", c.String()}}, Value: float64(st.FlowsByCohort[c])})
+	}
+	w.Counter("gov_pass_cohort_flows", "Flows created, by canary cohort; only canary flows are split or fail open.", cohorts...)
+	var ended []Sample
+	for _, c := range []engine.Cohort{engine.CohortCanary, engine.CohortControl} {
+		for _, e := range []engine.EndCause{engine.EndFIN, engine.EndRST, engine.EndIdle} {
+			ended = append(ended, Sample{Labels: []Label{{"cohort", c.String()}, {"cause", e.String()}}, Value: float64(st.FlowsEnded[c][e])})
+		}
+	}
+	w.Counter("gov_pass_flows_ended", "Flows whose state was removed, by canary cohort and by FIN, RST or idle eviction.", ended...)
+
+	var splits []Sample
+	for _, m := range []engine.SplitMode{engine.SplitModeImmediate, engine.SplitModeTLSHello} {
+		splits = append(splits, Sample{Labels: []Label{{"mode", m.String()}}, Value: float64(st.SplitsByMode[m])})
+	}
+	w.Counter("gov_pass_splits", "Flows whose first payload was split, by split mode.", splits...)
+
+	var failOpens []Sample
+	for r := flow.FailOpenReason(1); int(r) < flow.NumFailOpenReasons; r++ {
+		failOpens = append(failOpens, Sample{Labels: []Label{{"reason", r.String()}}, Value: float64(st.FailOpensByReason[r])})
+	}
+	w.Counter("gov_pass_fail_opens", "Flows passed through unsplit, by reason.", failOpens...)
+
+	var flows, held, reassembly, depth, capacity []Sample
+	for _, ws := range st.Workers {
+		l := []Label{{"worker", strconv.Itoa(ws.ID)}}
+		flows = append(flows, Sample{Labels: l, Value: float64(ws.Flows)})
+		held = append(held, Sample{Labels: l, Value: float64(ws.HeldBytes)})
+		reassembly = append(reassembly, Sample{Labels: l, Value: float64(ws.ReassemblyBytes)})
+		depth = append(depth, Sample{Labels: l, Value: float64(ws.Queued)})
+		capacity = append(capacity, Sample{Labels: l, Value: float64(ws.QueueCap)})
+	}
+	w.Gauge("gov_pass_worker_flows", "Flows tracked by the worker.", flows...)
+	w.Gauge("gov_pass_worker_held_bytes", "Bytes of held packets in the worker.", held...)
+	w.Gauge("gov_pass_worker_reassembly_bytes", "Bytes buffered for ClientHello reassembly in the worker.", reassembly...)
+	w.Gauge("gov_pass_worker_queue_depth", "Packets waiting in the worker queue.", depth...)
+	w.Gauge("gov_pass_worker_queue_capacity", "Size of the worker queue.", capacity...)
+
+	h := st.HoldTime
+	w.Histogram("gov_pass_hold_seconds", "How long flows held packets before they were split or failed open.", h.Bounds, h.Counts, h.Sum)
+
+	writeShadow(w, st)
+	writeHandshakes(w, st)
+}
+
+// writeHandshakes writes the classified handshake outcomes by strategy.
+// Destinations are left to `splitter ctl handshakes`: as labels they would
+// grow without bound.
+func writeHandshakes(w *Writer, st engine.Stats) {
+	w.Gauge("gov_pass_track_handshakes", "Whether inbound packets are classified into handshake outcomes (1) or not (0).", Sample{Value: boolValue(st.TrackHandshakes)})
+	byStrategy := st.HandshakesByStrategy()
+	strategies := make([]string, 0, len(byStrategy))
+	for s := range byStrategy {
+		strategies = append(strategies, s)
+	}
+	sort.Strings(strategies)
+	var samples []Sample
+	for _, s := range strategies {
+		for o := flow.HandshakeOutcome(1); int(o) < flow.NumHandshakeOutcomes; o++ {
+			samples = append(samples, Sample{Labels: []Label{{"strategy", s}, {"outcome", o.String()}}, Value: float64(byStrategy[s][o])})
+		}
+	}
+	w.Counter("gov_pass_handshakes", "Tracked flows by how the server answered the ClientHello and by split strategy.", samples...)
+}
+
+// writeShadow writes what flows seen in shadow mode would have done. The
+// counters keep their totals when shadow mode is turned off.
+func writeShadow(w *Writer, st engine.Stats) {
+	sh := st.ShadowDecisions
+	w.Gauge("gov_pass_shadow", "Whether the engine only observes (1) or splits (0).", Sample{Value: boolValue(st.Shadow)})
+
+	var splits []Sample
+	for _, m := range []engine.SplitMode{engine.SplitModeImmediate, engine.SplitModeTLSHello} {
+		splits = append(splits, Sample{Labels: []Label{{"mode", m.String()}}, Value: float64(sh.SplitsByMode[m])})
+	}
+	w.Counter("gov_pass_shadow_splits", "Flows that would have been split in shadow mode, by split mode.", splits...)
+
+	var failOpens []Sample
+	for r := flow.FailOpenReason(1); int(r) < flow.NumFailOpenReasons; r++ {
+		failOpens = append(failOpens, Sample{Labels: []Label{{"reason", r.String()}}, Value: float64(sh.FailOpensByReason[r])})
+	}
+	w.Counter("gov_pass_shadow_fail_opens", "Flows that would have passed through unsplit in shadow mode, by reason.", failOpens...)
+	w.Counter("gov_pass_shadow_segments", "Segments the shadow splits would have sent.", Sample{Value: float64(sh.Segments)})
+
+	h := sh.HoldTime
+	w.Histogram("gov_pass_shadow_hold_seconds", "How long shadow copies were held before a decision.", h.Bounds, h.Counts, h.Sum)
+}
+
+func writeAdapter(w *Writer, c adapter.Counters) {
+	w.Counter("gov_pass_adapter_overflow_accepts", "Packets passed through unprocessed because the recv buffer was full.", Sample{Value: float64(c.OverflowAccepts)})
+	w.Counter("gov_pass_adapter_send_errors", "Packets the adapter failed to send.", Sample{Value: float64(c.SendErrors)})
+	w.Counter("gov_pass_adapter_flushed_packets", "Packets passed through while the adapter was shutting down.", Sample{Value: float64(c.FlushedPackets)})
+}
+
+func writeShutdown(w *Writer, st engine.ShutdownStats) {
+	var samples []Sample
+	for _, stage := range []struct {
+		name    string
+		results map[engine.FlushResult]uint64
+	}{{"worker", st.Workers}, {"adapter", st.Adapter}} {
+		for _, r := range []engine.FlushResult{engine.FlushOK, engine.FlushTimeout, engine.FlushLimit, engine.FlushError} {
+			samples = append(samples, Sample{
+				Labels: []Label{{"stage", stage.name}, {"result", r.String()}},
+				Value:  float64(stage.results[r]),
+			})
+		}
+	}
+	w.Counter("gov_pass_shutdown_flushes", "Fail-open flushes run at shutdown, by stage and outcome.", samples...)
+	w.Counter("gov_pass_shutdown_flushed_packets", "Held and queued packets workers passed through at shutdown.", Sample{Value: float64(st.WorkerPackets)})
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,145 @@
+// Package metrics exports the splitter's counters over HTTP in the
+// Prometheus text format, or in OpenMetrics when the scraper asks for it.
+// Values are collected on every scrape; nothing is kept between scrapes.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	contentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one value of a metric family.
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Writer renders metric families in one exposition format.
+type Writer struct {
+	buf         bytes.Buffer
+	openMetrics bool
+}
+
+// Counter writes a counter family. name is given without the _total suffix.
+func (w *Writer) Counter(name, help string, samples ...Sample) {
+	family := name + "_total"
+	if w.openMetrics {
+		family = name
+	}
+	w.header(family, "counter", help)
+	for _, s := range samples {
+		w.sample(name+"_total", s.Labels, s.Value)
+	}
+}
+
+func (w *Writer) Gauge(name, help string, samples ...Sample) {
+	w.header(name, "gauge", help)
+	for _, s := range samples {
+		w.sample(name, s.Labels, s.Value)
+	}
+}
+
+// Histogram writes a histogram in seconds. counts[i] counts observations up
+// to bounds[i] (not cumulative); the extra last element counts the rest.
+func (w *Writer) Histogram(name, help string, bounds []time.Duration, counts []uint64, sum time.Duration) {
+	w.header(name, "histogram", help)
+	var cum uint64
+	for i, c := range counts {
+		cum += c
+		le := "+Inf"
+		if i < len(bounds) {
+			le = formatFloat(bounds[i].Seconds())
+		}
+		w.sample(name+"_bucket", []Label{{"le", le}}, float64(cum))
+	}
+	w.sample(name+"_sum", nil, sum.Seconds())
+	w.sample(name+"_count", nil, float64(cum))
+}
+
+func (w *Writer) header(name, typ, help string) {
+	if w.openMetrics {
+		fmt.Fprintf(&w.buf, "# TYPE %s %s\n# HELP %s %s\n", name, typ, name, escapeHelp(help))
+		return
+	}
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+func (w *Writer) sample(name string, labels []Label, v float64) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buf, "%s=\"%s\"", l.Name, escapeLabel(l.Value))
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(formatFloat(v))
+	w.buf.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// Handler serves what collect writes. A scraper that accepts
+// application/openmetrics-text gets OpenMetrics, everyone else the
+// Prometheus text format.
+func Handler(collect func(context.Context, *Writer)) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w := &Writer{openMetrics: strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")}
+		collect(r.Context(), w)
+		contentType := contentTypeText
+		if w.openMetrics {
+			w.buf.WriteString("# EOF\n")
+			contentType = contentTypeOpenMetrics
+		}
+		rw.Header().Set("Content-Type", contentType)
+		_, _ = w.buf.WriteTo(rw)
+	})
+}
+
+// Serve answers /metrics on ln until ctx is done.
+func Serve(ctx context.Context, ln net.Listener, h http.Handler) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCollect(_ context.Context, w *Writer) {
+	w.Counter("gov_pass_fail_opens", "Flows passed through unsplit.",
+		Sample{Labels: []Label{{"reason", "not-tls"}}, Value: 3},
+		Sample{Labels: []Label{{"reason", `a"b\c`}}, Value: 0},
+	)
+	w.Gauge("gov_pass_paused", "Paused.", Sample{Value: 1})
+	w.Histogram("gov_pass_hold_seconds", "Hold time.",
+		[]time.Duration{time.Millisecond, 10 * time.Millisecond},
+		[]uint64{2, 1, 1}, 25*time.Millisecond)
+}
+
+func scrape(t *testing.T, accept string) (string, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	Handler(testCollect).ServeHTTP(rec, req)
+	return rec.Header().Get("Content-Type"), rec.Body.String()
+}
+
+func TestHandler_PrometheusText(t *testing.T) {
+	ct, body := scrape(t, "text/plain")
+	if ct != contentTypeText {
+		t.Fatalf("content type = %q", ct)
+	}
+	want := `# HELP gov_pass_fail_opens_total Flows passed through unsplit.
+# TYPE gov_pass_fail_opens_total counter
+gov_pass_fail_opens_total{reason="not-tls"} 3
+gov_pass_fail_opens_total{reason="a\"b\\c"} 0
+# HELP gov_pass_paused Paused.
+# TYPE gov_pass_paused gauge
+gov_pass_paused 1
+# HELP gov_pass_hold_seconds Hold time.
+# TYPE gov_pass_hold_seconds histogram
+gov_pass_hold_seconds_bucket{le="0.001"} 2
+gov_pass_hold_seconds_bucket{le="0.01"} 3
+gov_pass_hold_seconds_bucket{le="+Inf"} 4
+gov_pass_hold_seconds_sum 0.025
+gov_pass_hold_seconds_count 4
+`
+	if body != want {
+		t.Fatalf("body:\n%s\nwant:\n%s", body, want)
+	}
+}
+
+func TestHandler_OpenMetrics(t *testing.T) {
+	ct, body := scrape(t, "application/openmetrics-text;version=1.0.0,text/plain;q=0.5")
+	if ct != contentTypeOpenMetrics {
+		t.Fatalf("content type = %q", ct)
+	}
+	for _, line := range []string{
+		"# TYPE gov_pass_fail_opens counter\n",
+		`gov_pass_fail_opens_total{reason="not-tls"} 3` + "\n",
+		"# TYPE gov_pass_hold_seconds histogram\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Fatalf("body does not end with # EOF:\n%s", body)
+	}
+}
@@ -56,6 +56,10 @@ const (
 	SourceUnknown Source = iota
 	SourceCaptured
 	SourceInjected
+	// SourceShadow marks a copy of a captured packet that was already passed
+	// through; the engine processes it but never sends or drops it, nor
+	// anything built from it.
+	SourceShadow
 )
 
 func (p *Packet) Payload() []byte {
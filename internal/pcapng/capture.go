@@ -0,0 +1,176 @@
+package pcapng
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"fk-gov/internal/logging"
+)
+
+// Interfaces of a capture file, one per kind of record.
+const (
+	// IfaceCaptured holds packets as the adapter delivered them.
+	IfaceCaptured = iota
+	// IfaceSent holds packets the splitter sent: passed-through originals,
+	// split segments and trimmed reinjections.
+	IfaceSent
+	// IfaceDropped holds originals dropped after their split replacement
+	// was sent.
+	IfaceDropped
+)
+
+var ifaceNames = []string{"captured", "sent", "dropped"}
+
+// Options configures a Capture.
+type Options struct {
+	// Path is the capture file. Rotated files are renamed to Path.1,
+	// Path.2 and so on, newest first.
+	Path string
+	// MaxBytes rotates the file once it grows past this size; 0 never
+	// rotates.
+	MaxBytes int64
+	// MaxFiles is how many rotated files are kept besides Path.
+	MaxFiles int
+	// Snaplen cuts packets to this many bytes; 0 keeps them whole.
+	Snaplen int
+	// Hosts limits the capture to packets from or to these prefixes; empty
+	// captures everything.
+	Hosts []netip.Prefix
+}
+
+// Capture records packets to a pcapng file with a comment on what the
+// splitter decided. It is safe for concurrent use; a write error stops
+// the capture without affecting the caller.
+type Capture struct {
+	opts Options
+
+	mu sync.Mutex
+	f  *os.File
+	w  *Writer
+}
+
+// Open creates (or truncates) o.Path and writes the file header.
+func Open(o Options) (*Capture, error) {
+	c := &Capture{opts: o}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Capture) open() error {
+	f, err := os.OpenFile(c.opts.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w, err := NewWriter(f, "gov-pass splitter")
+	for i := 0; err == nil && i < len(ifaceNames); i++ {
+		err = w.AddInterface(ifaceNames[i], LinkTypeRaw, uint32(c.opts.Snaplen))
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	c.f, c.w = f, w
+	return nil
+}
+
+// Wants reports whether a packet would be recorded, so callers can skip
+// building its comment.
+func (c *Capture) Wants(data []byte) bool {
+	if c == nil {
+		return false
+	}
+	if len(c.opts.Hosts) == 0 {
+		return true
+	}
+	if len(data) < 20 {
+		return false
+	}
+	src, dst := netip.AddrFrom4([4]byte(data[12:16])), netip.AddrFrom4([4]byte(data[16:20]))
+	for _, p := range c.opts.Hosts {
+		if p.Contains(src) || p.Contains(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+// Record writes a packet on one of the Iface interfaces.
+func (c *Capture) Record(iface uint32, data []byte, comment string) {
+	if !c.Wants(data) {
+		return
+	}
+	capLen := len(data)
+	if c.opts.Snaplen > 0 && capLen > c.opts.Snaplen {
+		capLen = c.opts.Snaplen
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w == nil {
+		return
+	}
+	if err := c.w.WritePacket(iface, time.Now(), data[:capLen], len(data), comment); err != nil {
+		c.fail(err)
+		return
+	}
+	if c.opts.MaxBytes > 0 && c.w.Written() >= c.opts.MaxBytes {
+		if err := c.rotate(); err != nil {
+			c.fail(err)
+		}
+	}
+}
+
+// rotate shifts Path to Path.1 (and so on, dropping the oldest) and starts
+// a new file. c.mu is held.
+func (c *Capture) rotate() error {
+	if err := c.f.Close(); err != nil {
+		return err
+	}
+	c.f, c.w = nil, nil
+	if c.opts.MaxFiles > 0 {
+		for i := c.opts.MaxFiles - 1; i >= 1; i-- {
+			_ = os.Rename(rotated(c.opts.Path, i), rotated(c.opts.Path, i+1))
+		}
+		if err := os.Rename(c.opts.Path, rotated(c.opts.Path, 1)); err != nil {
+			return err
+		}
+	}
+	if err := c.open(); err != nil {
+		return err
+	}
+	slog.Info("packet capture rotated", logging.Event(logging.EventCaptureRotated), "path", c.opts.Path)
+	return nil
+}
+
+func rotated(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// fail stops the capture after a write error. c.mu is held.
+func (c *Capture) fail(err error) {
+	slog.Error("packet capture stopped", logging.Event(logging.EventCaptureFailed), "path", c.opts.Path, logging.Err(err))
+	if c.f != nil {
+		c.f.Close()
+	}
+	c.f, c.w = nil, nil
+}
+
+// Close closes the file. Later records are ignored.
+func (c *Capture) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	err := c.f.Close()
+	c.f, c.w = nil, nil
+	return err
+}
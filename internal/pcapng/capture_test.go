@@ -0,0 +1,119 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ipv4 is a bare IPv4 header from src to dst followed by n payload bytes.
+func ipv4(src, dst string, n int) []byte {
+	b := make([]byte, 20+n)
+	b[0] = 0x45
+	s, d := netip.MustParseAddr(src).As4(), netip.MustParseAddr(dst).As4()
+	copy(b[12:], s[:])
+	copy(b[16:], d[:])
+	return b
+}
+
+// packets returns the interface, captured length and comment of each
+// packet in a capture file.
+func packets(t *testing.T, path string) (ifaces []uint32, lens []int, comments []string) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blk := range readBlocks(t, b) {
+		if blk.typ != blockEPB {
+			continue
+		}
+		n := int(binary.LittleEndian.Uint32(blk.body[12:]))
+		ifaces = append(ifaces, binary.LittleEndian.Uint32(blk.body))
+		lens = append(lens, n)
+		comments = append(comments, options(t, blk.body[20+(n+3)&^3:])[optComment])
+	}
+	return ifaces, lens, comments
+}
+
+func TestCapture_FiltersAndSnaplen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cap.pcapng")
+	c, err := Open(Options{
+		Path:    path,
+		Snaplen: 40,
+		Hosts:   []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Wants(ipv4("10.0.0.1", "198.51.100.1", 0)) {
+		t.Fatal("unfiltered host wanted")
+	}
+	c.Record(IfaceCaptured, ipv4("10.0.0.1", "203.0.113.7", 100), "")
+	c.Record(IfaceSent, ipv4("10.0.0.1", "198.51.100.1", 10), "other host")
+	c.Record(IfaceDropped, ipv4("203.0.113.7", "10.0.0.1", 4), "dropped: test")
+	c.Record(IfaceSent, []byte{0x45}, "runt")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Records after Close are ignored.
+	c.Record(IfaceSent, ipv4("10.0.0.1", "203.0.113.7", 0), "late")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, blk := range readBlocks(t, b) {
+		if blk.typ == blockIDB {
+			names = append(names, options(t, blk.body[8:])[optIfName])
+		}
+	}
+	if len(names) != 3 || names[IfaceCaptured] != "captured" || names[IfaceSent] != "sent" || names[IfaceDropped] != "dropped" {
+		t.Fatalf("interfaces = %v", names)
+	}
+
+	ifaces, lens, comments := packets(t, path)
+	if len(ifaces) != 2 {
+		t.Fatalf("packets = %d, want 2", len(ifaces))
+	}
+	if ifaces[0] != IfaceCaptured || lens[0] != 40 || comments[0] != "" {
+		t.Fatalf("packet 0 = iface %d, %d bytes, %q", ifaces[0], lens[0], comments[0])
+	}
+	if ifaces[1] != IfaceDropped || lens[1] != 24 || comments[1] != "dropped: test" {
+		t.Fatalf("packet 1 = iface %d, %d bytes, %q", ifaces[1], lens[1], comments[1])
+	}
+}
+
+func TestCapture_Rotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cap.pcapng")
+	c, err := Open(Options{Path: path, MaxBytes: 400, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// Each record is about 150 bytes, so a file holds two.
+	for i := 0; i < 8; i++ {
+		c.Record(IfaceSent, ipv4("10.0.0.1", "203.0.113.7", 100), "")
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("%s.3 kept past MaxFiles: %v", path, err)
+	}
+	for _, p := range []string{path + ".1", path + ".2"} {
+		st, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Size() < 400 {
+			t.Fatalf("%s rotated at %d bytes", p, st.Size())
+		}
+		if ifaces, _, _ := packets(t, p); len(ifaces) != 2 {
+			t.Fatalf("%s holds %d packets, want 2", p, len(ifaces))
+		}
+	}
+	if ifaces, _, _ := packets(t, path); len(ifaces) != 0 {
+		t.Fatalf("current file holds %d packets, want 0", len(ifaces))
+	}
+}
@@ -0,0 +1,273 @@
+package pcapng
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Link types found in captures besides LinkTypeRaw.
+const (
+	LinkTypeNull      = 0
+	LinkTypeEthernet  = 1
+	LinkTypeLoop      = 108
+	LinkTypeLinuxSLL  = 113
+	LinkTypeIPv4      = 228
+	LinkTypeLinuxSLL2 = 276
+)
+
+const (
+	blockSPB = 0x00000003
+	// blockPB is the obsolete packet block older writers still emit.
+	blockPB = 0x00000002
+
+	optIfTsresol = 9
+
+	pcapMagicMicro = 0xA1B2C3D4
+	pcapMagicNano  = 0xA1B23C4D
+
+	// maxBlock bounds a block or record so a corrupt length cannot make the
+	// reader allocate without limit.
+	maxBlock = 16 << 20
+)
+
+// ErrFormat reports input that is neither pcap nor pcapng.
+var ErrFormat = errors.New("not a pcap or pcapng file")
+
+// Packet is one packet read from a capture file.
+type Packet struct {
+	// Time is zero for pcapng simple packet blocks, which carry none.
+	Time time.Time
+	// Interface is the pcapng interface the packet was captured on, 0 for
+	// classic pcap.
+	Interface uint32
+	LinkType  uint16
+	// Data is the captured bytes, starting at the link-layer header.
+	Data []byte
+	// OrigLen is the packet's length on the wire.
+	OrigLen int
+}
+
+// Reader reads packets from a classic pcap file or a pcapng file with any
+// number of sections and interfaces.
+type Reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	ng    bool
+
+	// Classic pcap header fields.
+	linkType uint16
+	nano     bool
+
+	// ifaces describes the interfaces of the current pcapng section.
+	ifaces []readerIface
+}
+
+type readerIface struct {
+	linkType uint16
+	// unit is the length of one timestamp tick.
+	unit time.Duration
+}
+
+// NewReader reads the file header and returns a Reader positioned at the
+// first packet.
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{r: bufio.NewReader(r)}
+	head, err := rd.r.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if binary.LittleEndian.Uint32(head) == blockSHB {
+		rd.ng = true
+		return rd, nil
+	}
+	var hdr [24]byte
+	if _, err := io.ReadFull(rd.r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr[:]) {
+		case pcapMagicMicro:
+			rd.order = order
+		case pcapMagicNano:
+			rd.order, rd.nano = order, true
+		default:
+			continue
+		}
+		rd.linkType = uint16(rd.order.Uint32(hdr[20:]))
+		return rd, nil
+	}
+	return nil, ErrFormat
+}
+
+// Next returns the next packet, or io.EOF after the last one.
+func (rd *Reader) Next() (Packet, error) {
+	if rd.ng {
+		return rd.nextBlock()
+	}
+	var hdr [16]byte
+	if _, err := io.ReadFull(rd.r, hdr[:]); err != nil {
+		return Packet{}, err
+	}
+	sec, frac := rd.order.Uint32(hdr[0:]), rd.order.Uint32(hdr[4:])
+	capLen, origLen := rd.order.Uint32(hdr[8:]), rd.order.Uint32(hdr[12:])
+	if capLen > maxBlock {
+		return Packet{}, fmt.Errorf("pcap record of %d bytes", capLen)
+	}
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(rd.r, data); err != nil {
+		return Packet{}, unexpectedEOF(err)
+	}
+	nsec := int64(frac)
+	if !rd.nano {
+		nsec *= 1000
+	}
+	return Packet{Time: time.Unix(int64(sec), nsec), LinkType: rd.linkType, Data: data, OrigLen: int(origLen)}, nil
+}
+
+func (rd *Reader) nextBlock() (Packet, error) {
+	for {
+		typ, body, err := rd.readBlock()
+		if err != nil {
+			return Packet{}, err
+		}
+		switch typ {
+		case blockIDB:
+			if len(body) < 8 {
+				return Packet{}, errors.New("pcapng: short interface block")
+			}
+			iface := readerIface{linkType: rd.order.Uint16(body), unit: time.Microsecond}
+			if v, ok := rd.option(body[8:], optIfTsresol); ok && len(v) == 1 {
+				iface.unit = tsUnit(v[0])
+			}
+			rd.ifaces = append(rd.ifaces, iface)
+		case blockEPB, blockPB:
+			if len(body) < 20 {
+				return Packet{}, errors.New("pcapng: short packet block")
+			}
+			id := rd.order.Uint32(body)
+			if typ == blockPB {
+				id = uint32(rd.order.Uint16(body))
+			}
+			if int(id) >= len(rd.ifaces) {
+				return Packet{}, fmt.Errorf("pcapng: packet on undeclared interface %d", id)
+			}
+			iface := rd.ifaces[id]
+			ticks := uint64(rd.order.Uint32(body[4:]))<<32 | uint64(rd.order.Uint32(body[8:]))
+			capLen := int(rd.order.Uint32(body[12:]))
+			if capLen > len(body)-20 {
+				return Packet{}, errors.New("pcapng: packet overruns its block")
+			}
+			return Packet{
+				Time:      ticksTime(ticks, iface.unit),
+				Interface: id,
+				LinkType:  iface.linkType,
+				Data:      body[20 : 20+capLen],
+				OrigLen:   int(rd.order.Uint32(body[16:])),
+			}, nil
+		case blockSPB:
+			if len(body) < 4 || len(rd.ifaces) == 0 {
+				return Packet{}, errors.New("pcapng: bad simple packet block")
+			}
+			origLen := int(rd.order.Uint32(body))
+			capLen := origLen
+			if capLen > len(body)-4 {
+				capLen = len(body) - 4
+			}
+			return Packet{LinkType: rd.ifaces[0].linkType, Data: body[4 : 4+capLen], OrigLen: origLen}, nil
+		}
+	}
+}
+
+// readBlock reads one block and returns its body, a fresh slice. A section
+// header resets the byte order and interfaces and is returned like any
+// other block.
+func (rd *Reader) readBlock() (uint32, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(rd.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	if binary.LittleEndian.Uint32(hdr[:]) == blockSHB {
+		magic, err := rd.r.Peek(4)
+		if err != nil {
+			return 0, nil, unexpectedEOF(err)
+		}
+		switch binary.LittleEndian.Uint32(magic) {
+		case byteOrderMagic:
+			rd.order = binary.LittleEndian
+		case 0x4D3C2B1A:
+			rd.order = binary.BigEndian
+		default:
+			return 0, nil, errors.New("pcapng: bad byte-order magic")
+		}
+		rd.ifaces = nil
+	}
+	if rd.order == nil {
+		return 0, nil, errors.New("pcapng: block before section header")
+	}
+	typ, total := rd.order.Uint32(hdr[:]), rd.order.Uint32(hdr[4:])
+	if total < 12 || total%4 != 0 || total > maxBlock {
+		return 0, nil, fmt.Errorf("pcapng: bad block length %d", total)
+	}
+	buf := make([]byte, total-8)
+	if _, err := io.ReadFull(rd.r, buf); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	body, trailer := buf[:total-12], buf[total-12:]
+	if rd.order.Uint32(trailer) != total {
+		return 0, nil, errors.New("pcapng: block lengths disagree")
+	}
+	return typ, body, nil
+}
+
+// option returns the value of the first option code in opts.
+func (rd *Reader) option(opts []byte, code uint16) ([]byte, bool) {
+	for len(opts) >= 4 {
+		c, n := rd.order.Uint16(opts), int(rd.order.Uint16(opts[2:]))
+		if c == optEndOfOpt || 4+n > len(opts) {
+			break
+		}
+		if c == code {
+			return opts[4 : 4+n], true
+		}
+		opts = opts[4+(n+3)&^3:]
+	}
+	return nil, false
+}
+
+// tsUnit decodes if_tsresol: a power of ten, or of two when the top bit is
+// set.
+func tsUnit(v byte) time.Duration {
+	if v&0x80 != 0 {
+		// Sub-nanosecond binary units are rounded to a nanosecond.
+		exp := v & 0x7f
+		if exp >= 30 {
+			return time.Nanosecond
+		}
+		return time.Second >> exp
+	}
+	if v > 9 {
+		return time.Nanosecond
+	}
+	unit := time.Second
+	for ; v > 0; v-- {
+		unit /= 10
+	}
+	return unit
+}
+
+func ticksTime(ticks uint64, unit time.Duration) time.Time {
+	perSec := uint64(time.Second / unit)
+	return time.Unix(int64(ticks/perSec), int64(ticks%perSec)*int64(unit))
+}
+
+// unexpectedEOF reports a record cut short at the end of the file.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestReader_ReadsWriterOutput(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddInterface("a", LinkTypeRaw, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddInterface("b", LinkTypeEthernet, 0); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 123456000)
+	if err := w.WritePacket(1, ts, []byte{1, 2, 3}, 7, "note"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(0, ts.Add(time.Second), []byte{4}, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := rd.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Time.Equal(ts) || p.Interface != 1 || p.LinkType != LinkTypeEthernet || !bytes.Equal(p.Data, []byte{1, 2, 3}) || p.OrigLen != 7 {
+		t.Fatalf("packet 0 = %+v", p)
+	}
+	p, err = rd.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Time.Equal(ts.Add(time.Second)) || p.LinkType != LinkTypeRaw || !bytes.Equal(p.Data, []byte{4}) {
+		t.Fatalf("packet 1 = %+v", p)
+	}
+	if _, err := rd.Next(); err != io.EOF {
+		t.Fatalf("after last packet: %v, want io.EOF", err)
+	}
+}
+
+// classicPcap is a pcap file of one packet in order, with nanosecond
+// timestamps when nano is set.
+func classicPcap(order binary.AppendByteOrder, nano bool, linkType uint32, ts time.Time, data []byte) []byte {
+	magic, frac := uint32(pcapMagicMicro), uint32(ts.Nanosecond()/1000)
+	if nano {
+		magic, frac = pcapMagicNano, uint32(ts.Nanosecond())
+	}
+	b := order.AppendUint32(nil, magic)
+	b = order.AppendUint16(b, 2)
+	b = order.AppendUint16(b, 4)
+	b = order.AppendUint32(b, 0)
+	b = order.AppendUint32(b, 0)
+	b = order.AppendUint32(b, 65535)
+	b = order.AppendUint32(b, linkType)
+	b = order.AppendUint32(b, uint32(ts.Unix()))
+	b = order.AppendUint32(b, frac)
+	b = order.AppendUint32(b, uint32(len(data)))
+	b = order.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+func TestReader_ClassicPcap(t *testing.T) {
+	ts := time.Unix(1700000000, 123456789)
+	for _, tc := range []struct {
+		name  string
+		order binary.AppendByteOrder
+		nano  bool
+		want  time.Time
+	}{
+		{"little-endian micro", binary.LittleEndian, false, ts.Truncate(time.Microsecond)},
+		{"big-endian micro", binary.BigEndian, false, ts.Truncate(time.Microsecond)},
+		{"little-endian nano", binary.LittleEndian, true, ts},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rd, err := NewReader(bytes.NewReader(classicPcap(tc.order, tc.nano, LinkTypeLinuxSLL, ts, []byte{9, 8})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := rd.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !p.Time.Equal(tc.want) || p.LinkType != LinkTypeLinuxSLL || !bytes.Equal(p.Data, []byte{9, 8}) {
+				t.Fatalf("packet = %+v, want time %v", p, tc.want)
+			}
+			if _, err := rd.Next(); err != io.EOF {
+				t.Fatalf("after last packet: %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestReader_Errors(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n\r\nxxxxxxxxxx"))); !errors.Is(err, ErrFormat) {
+		t.Fatalf("text input: %v, want ErrFormat", err)
+	}
+	file := classicPcap(binary.LittleEndian, false, LinkTypeRaw, time.Unix(1, 0), []byte{1, 2, 3, 4})
+	rd, err := NewReader(bytes.NewReader(file[:len(file)-2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rd.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("cut record: %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestTsUnit(t *testing.T) {
+	for v, want := range map[byte]time.Duration{
+		6:         time.Microsecond,
+		9:         time.Nanosecond,
+		3:         time.Millisecond,
+		0x80 | 10: time.Second >> 10,
+	} {
+		if got := tsUnit(v); got != want {
+			t.Errorf("tsUnit(%#x) = %v, want %v", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package pcapng writes packets to pcapng files: the block Writer, and
+// Capture, the splitter's own filtered and size-capped capture of what it
+// received, sent and dropped.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// LinkTypeRaw is LINKTYPE_RAW: packets start with the IP header.
+const LinkTypeRaw = 101
+
+const (
+	blockSHB = 0x0A0D0D0A
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	optEndOfOpt   = 0
+	optComment    = 1
+	optIfName     = 2
+	optShbUserApp = 4
+)
+
+// Writer writes one pcapng section. Timestamps use the default resolution
+// of microseconds.
+type Writer struct {
+	w io.Writer
+	// body and out are reused between packets.
+	body, out []byte
+	// n counts bytes written, for size caps.
+	n int64
+}
+
+// NewWriter writes a section header block naming app as the writer.
+func NewWriter(w io.Writer, app string) (*Writer, error) {
+	pw := &Writer{w: w}
+	body := make([]byte, 0, 16+len(app))
+	body = binary.LittleEndian.AppendUint32(body, byteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // major
+	body = binary.LittleEndian.AppendUint16(body, 0) // minor
+	body = binary.LittleEndian.AppendUint64(body, ^uint64(0))
+	body = appendOption(body, optShbUserApp, []byte(app))
+	body = appendOption(body, optEndOfOpt, nil)
+	return pw, pw.block(blockSHB, body)
+}
+
+// AddInterface writes an interface description block. Interfaces are
+// numbered from 0 in the order they are added.
+func (pw *Writer) AddInterface(name string, linkType uint16, snaplen uint32) error {
+	body := make([]byte, 0, 16+len(name))
+	body = binary.LittleEndian.AppendUint16(body, linkType)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, snaplen)
+	body = appendOption(body, optIfName, []byte(name))
+	body = appendOption(body, optEndOfOpt, nil)
+	return pw.block(blockIDB, body)
+}
+
+// WritePacket writes an enhanced packet block. data may be shorter than
+// origLen when it was cut to the snap length; comment is omitted when
+// empty.
+func (pw *Writer) WritePacket(iface uint32, ts time.Time, data []byte, origLen int, comment string) error {
+	us := uint64(ts.UnixMicro())
+	body := pw.body[:0]
+	body = binary.LittleEndian.AppendUint32(body, iface)
+	body = binary.LittleEndian.AppendUint32(body, uint32(us>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(us))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(origLen))
+	body = append(body, data...)
+	body = appendPad(body, len(data))
+	if comment != "" {
+		body = appendOption(body, optComment, []byte(comment))
+		body = appendOption(body, optEndOfOpt, nil)
+	}
+	pw.body = body
+	return pw.block(blockEPB, body)
+}
+
+// Written is the number of bytes written so far.
+func (pw *Writer) Written() int64 {
+	return pw.n
+}
+
+func (pw *Writer) block(typ uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	out := binary.LittleEndian.AppendUint32(pw.out[:0], typ)
+	out = binary.LittleEndian.AppendUint32(out, total)
+	out = append(out, body...)
+	out = binary.LittleEndian.AppendUint32(out, total)
+	pw.out = out
+	n, err := pw.w.Write(out)
+	pw.n += int64(n)
+	return err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return appendPad(b, len(value))
+}
+
+// appendPad pads a field of n bytes to a multiple of 4.
+func appendPad(b []byte, n int) []byte {
+	for ; n%4 != 0; n++ {
+		b = append(b, 0)
+	}
+	return b
+}
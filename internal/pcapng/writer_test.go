@@ -0,0 +1,121 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type testBlock struct {
+	typ  uint32
+	body []byte
+}
+
+// readBlocks splits a little-endian pcapng file into blocks, checking that
+// both length fields agree and are 32-bit aligned.
+func readBlocks(t *testing.T, b []byte) []testBlock {
+	t.Helper()
+	var out []testBlock
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("trailing %d bytes", len(b))
+		}
+		typ := binary.LittleEndian.Uint32(b)
+		n := int(binary.LittleEndian.Uint32(b[4:]))
+		if n%4 != 0 || n < 12 || n > len(b) {
+			t.Fatalf("block %#x: bad length %d", typ, n)
+		}
+		if trailer := int(binary.LittleEndian.Uint32(b[n-4:])); trailer != n {
+			t.Fatalf("block %#x: trailing length %d, want %d", typ, trailer, n)
+		}
+		out = append(out, testBlock{typ: typ, body: b[8 : n-4]})
+		b = b[n:]
+	}
+	return out
+}
+
+// options parses the options ending a block body; b is empty when the
+// block has none.
+func options(t *testing.T, b []byte) map[uint16]string {
+	t.Helper()
+	out := map[uint16]string{}
+	if len(b) == 0 {
+		return out
+	}
+	for len(b) >= 4 {
+		code := binary.LittleEndian.Uint16(b)
+		n := int(binary.LittleEndian.Uint16(b[2:]))
+		if code == optEndOfOpt {
+			return out
+		}
+		padded := (n + 3) &^ 3
+		if 4+padded > len(b) {
+			t.Fatalf("option %d overruns block", code)
+		}
+		out[code] = string(b[4 : 4+n])
+		b = b[4+padded:]
+	}
+	t.Fatal("options not terminated")
+	return nil
+}
+
+func TestWriter_Blocks(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddInterface("sent", LinkTypeRaw, 128); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 123456000)
+	if err := w.WritePacket(0, ts, []byte{1, 2, 3, 4, 5}, 9, "split segment 1/2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(0, ts, []byte{6}, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if w.Written() != int64(buf.Len()) {
+		t.Fatalf("Written = %d, file is %d bytes", w.Written(), buf.Len())
+	}
+
+	blocks := readBlocks(t, buf.Bytes())
+	if len(blocks) != 4 {
+		t.Fatalf("blocks = %d, want 4", len(blocks))
+	}
+	shb, idb, epb, bare := blocks[0], blocks[1], blocks[2], blocks[3]
+	if shb.typ != blockSHB || binary.LittleEndian.Uint32(shb.body) != byteOrderMagic {
+		t.Fatalf("bad section header: %#x %x", shb.typ, shb.body)
+	}
+	if got := options(t, shb.body[16:])[optShbUserApp]; got != "test" {
+		t.Fatalf("shb_userappl = %q", got)
+	}
+	if idb.typ != blockIDB || binary.LittleEndian.Uint16(idb.body) != LinkTypeRaw || binary.LittleEndian.Uint32(idb.body[4:]) != 128 {
+		t.Fatalf("bad interface block: %x", idb.body)
+	}
+	if got := options(t, idb.body[8:])[optIfName]; got != "sent" {
+		t.Fatalf("if_name = %q", got)
+	}
+
+	if epb.typ != blockEPB {
+		t.Fatalf("block 2 type %#x", epb.typ)
+	}
+	us := uint64(binary.LittleEndian.Uint32(epb.body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(epb.body[8:]))
+	if us != uint64(ts.UnixMicro()) {
+		t.Fatalf("timestamp = %d, want %d", us, ts.UnixMicro())
+	}
+	if capLen, origLen := binary.LittleEndian.Uint32(epb.body[12:]), binary.LittleEndian.Uint32(epb.body[16:]); capLen != 5 || origLen != 9 {
+		t.Fatalf("lengths = %d/%d, want 5/9", capLen, origLen)
+	}
+	if !bytes.Equal(epb.body[20:25], []byte{1, 2, 3, 4, 5}) {
+		t.Fatalf("data = %x", epb.body[20:25])
+	}
+	if got := options(t, epb.body[28:])[optComment]; got != "split segment 1/2" {
+		t.Fatalf("comment = %q", got)
+	}
+	// Without a comment the block ends right after the padded data.
+	if len(bare.body) != 24 {
+		t.Fatalf("uncommented block body = %d bytes, want 24", len(bare.body))
+	}
+}
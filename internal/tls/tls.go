@@ -30,3 +30,68 @@ func DetectClientHelloRecord(buf []byte) (uint16, Result) {
 	}
 	return recordLen, ResultMatch
 }
+
+// ServerName returns the host_name of the server_name extension in a
+// complete ClientHello record (header included). ok is false when the
+// record has no such extension or is malformed.
+func ServerName(record []byte) (name string, ok bool) {
+	// Record header (5), handshake type (1) and length (3), client version
+	// (2) and random (32).
+	p := 5 + 4 + 2 + 32
+	if len(record) < p+1 {
+		return "", false
+	}
+	p += 1 + int(record[p]) // session id
+	if len(record) < p+2 {
+		return "", false
+	}
+	p += 2 + int(readUint16(record[p:])) // cipher suites
+	if len(record) < p+1 {
+		return "", false
+	}
+	p += 1 + int(record[p]) // compression methods
+	if len(record) < p+2 {
+		return "", false
+	}
+	end := p + 2 + int(readUint16(record[p:]))
+	p += 2
+	if end > len(record) {
+		return "", false
+	}
+	for p+4 <= end {
+		extType := readUint16(record[p:])
+		extLen := int(readUint16(record[p+2:]))
+		p += 4
+		if p+extLen > end {
+			return "", false
+		}
+		if extType != 0 {
+			p += extLen
+			continue
+		}
+		// server_name: list length (2), then entries of type (1) and
+		// length-prefixed name.
+		ext := record[p : p+extLen]
+		if len(ext) < 2 {
+			return "", false
+		}
+		ext = ext[2:]
+		for len(ext) >= 3 {
+			nameType := ext[0]
+			n := int(readUint16(ext[1:]))
+			if len(ext) < 3+n {
+				return "", false
+			}
+			if nameType == 0 {
+				return string(ext[3 : 3+n]), true
+			}
+			ext = ext[3+n:]
+		}
+		return "", false
+	}
+	return "", false
+}
+
+func readUint16(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
@@ -52,3 +52,42 @@ func TestDetectClientHello_ZeroRecordLen(t *testing.T) {
 		t.Fatalf("expected Mismatch for zero recordLen, got %v", result)
 	}
 }
+
+// clientHello builds a minimal ClientHello record with the given
+// extensions block.
+func clientHello(exts []byte) []byte {
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...)    // random
+	body = append(body, 0)                      // session id
+	body = append(body, 0x00, 0x02, 0x13, 0x01) // cipher suites
+	body = append(body, 0x01, 0x00)             // compression
+	body = append(body, byte(len(exts)>>8), byte(len(exts)))
+	body = append(body, exts...)
+	hs := append([]byte{0x01, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{0x16, 0x03, 0x01, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+}
+
+func sniExtension(name string) []byte {
+	n := len(name)
+	ext := []byte{0x00, 0x00, byte((n + 5) >> 8), byte(n + 5), byte((n + 3) >> 8), byte(n + 3), 0x00, byte(n >> 8), byte(n)}
+	return append(ext, name...)
+}
+
+func TestServerName(t *testing.T) {
+	// An unrelated extension (supported_versions) before server_name.
+	exts := append([]byte{0x00, 0x2b, 0x00, 0x03, 0x02, 0x03, 0x04}, sniExtension("example.com")...)
+	name, ok := ServerName(clientHello(exts))
+	if !ok || name != "example.com" {
+		t.Fatalf("ServerName = %q, %v", name, ok)
+	}
+}
+
+func TestServerName_Missing(t *testing.T) {
+	if name, ok := ServerName(clientHello(nil)); ok {
+		t.Fatalf("ServerName = %q, want none", name)
+	}
+	rec := clientHello(sniExtension("example.com"))
+	if _, ok := ServerName(rec[:len(rec)-4]); ok {
+		t.Fatal("truncated record: want no name")
+	}
+}
@@ -0,0 +1,277 @@
+// Package trace keeps an opt-in, bounded record of what the engine did to
+// individual flows — collection, detection, the split plan, what was sent
+// and why a flow failed open — so one failing site can be looked at after
+// the fact. A Ring is shared by all workers; a nil Ring or Flow records
+// nothing.
+package trace
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"fk-gov/internal/clock"
+)
+
+// Kind is the lifecycle step an Event records.
+type Kind uint8
+
+const (
+	// KindCreated: the first payload packet created the flow.
+	KindCreated Kind = iota
+	// KindCollected: a payload packet was held and buffered.
+	KindCollected
+	// KindDetected: the buffered bytes were classified.
+	KindDetected
+	// KindSplitPlan: the split window and segment sizes were chosen.
+	KindSplitPlan
+	// KindSent: the split segments were sent.
+	KindSent
+	// KindTrimmed: held packets past the window were reinjected trimmed.
+	KindTrimmed
+	// KindFailOpen: held packets were released unsplit.
+	KindFailOpen
+	// KindHandshake: the server's answer to the ClientHello was classified.
+	KindHandshake
+	// KindClosed: the flow state was removed.
+	KindClosed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindCreated:
+		return "created"
+	case KindCollected:
+		return "collected"
+	case KindDetected:
+		return "detected"
+	case KindSplitPlan:
+		return "split-plan"
+	case KindSent:
+		return "sent"
+	case KindTrimmed:
+		return "trimmed"
+	case KindFailOpen:
+		return "fail-open"
+	case KindHandshake:
+		return "handshake"
+	case KindClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("Kind(%d)", uint8(k))
+	}
+}
+
+// Event is one step of one flow.
+type Event struct {
+	Time   time.Time
+	Worker int
+	Src    netip.AddrPort
+	Dst    netip.AddrPort
+	Kind   Kind
+	// Bytes is the payload size the step is about, 0 when none.
+	Bytes  int
+	Detail string
+}
+
+// maxPending bounds the events a flow keeps while it waits for its server
+// name to decide whether it is traced.
+const maxPending = 32
+
+// Ring holds the last events of the traced flows, oldest overwritten first.
+type Ring struct {
+	filter Filter
+
+	mu     sync.Mutex
+	events []Event
+	next   int
+	total  uint64
+}
+
+// New returns a ring holding up to size events (at least 1) of the flows
+// that match f.
+func New(size int, f Filter) *Ring {
+	if size < 1 {
+		size = 1
+	}
+	return &Ring{filter: f, events: make([]Event, 0, size)}
+}
+
+// Begin starts tracing a new flow whose events are stamped with clk, the
+// engine's clock. It returns nil when the flow cannot match the filter;
+// when only its server name can decide, the flow's events are held back
+// until Match.
+func (r *Ring) Begin(clk clock.Clock, worker int, src, dst netip.AddrPort) *Flow {
+	if r == nil {
+		return nil
+	}
+	f := &Flow{ring: r, clk: clk, worker: worker, src: src, dst: dst}
+	switch {
+	case r.filter.Empty(), r.filter.matchDst(dst.Addr()):
+		f.matched = true
+	case len(r.filter.SNI) == 0:
+		return nil
+	}
+	return f
+}
+
+func (r *Ring) add(evs ...Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ev := range evs {
+		if len(r.events) < cap(r.events) {
+			r.events = append(r.events, ev)
+		} else {
+			r.events[r.next] = ev
+		}
+		r.next = (r.next + 1) % cap(r.events)
+		r.total++
+	}
+}
+
+// Events returns the buffered events, oldest first, and how many older
+// events were overwritten.
+func (r *Ring) Events() ([]Event, uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Event, 0, len(r.events))
+	if len(r.events) == cap(r.events) {
+		out = append(out, r.events[r.next:]...)
+		out = append(out, r.events[:r.next]...)
+	} else {
+		out = append(out, r.events...)
+	}
+	return out, r.total - uint64(len(out))
+}
+
+// Flow records the events of one flow. Its methods are not safe for
+// concurrent use; like the flow state holding it, it belongs to one worker
+// at a time.
+type Flow struct {
+	ring     *Ring
+	clk      clock.Clock
+	worker   int
+	src, dst netip.AddrPort
+	matched  bool
+	pending  []Event
+}
+
+// Add records an event. A nil Flow records nothing, so callers only need to
+// check for nil before building an expensive detail.
+func (f *Flow) Add(kind Kind, bytes int, detail string) {
+	if f == nil {
+		return
+	}
+	ev := Event{Time: f.clk.Now(), Worker: f.worker, Src: f.src, Dst: f.dst, Kind: kind, Bytes: bytes, Detail: detail}
+	if f.matched {
+		f.ring.add(ev)
+		return
+	}
+	if len(f.pending) < maxPending {
+		f.pending = append(f.pending, ev)
+	}
+}
+
+// Match settles a flow waiting on its server name: the held-back events
+// are recorded and the flow is returned when name matches the filter,
+// otherwise it is dropped and Match returns nil. name is empty when the
+// flow has none.
+func (f *Flow) Match(name string) *Flow {
+	if f == nil || f.matched {
+		return f
+	}
+	if !f.ring.filter.matchSNI(name) {
+		return nil
+	}
+	f.matched = true
+	f.ring.add(f.pending...)
+	f.pending = nil
+	return f
+}
+
+// SetWorker records the worker that owns the flow from now on.
+func (f *Flow) SetWorker(id int) {
+	if f != nil {
+		f.worker = id
+	}
+}
+
+// Filter selects the flows a Ring records. An empty filter records every
+// flow; otherwise a flow is recorded when its destination is in Dst or its
+// server name matches SNI.
+type Filter struct {
+	Dst []netip.Prefix
+	// SNI entries match the name itself and its subdomains.
+	SNI []string
+}
+
+// ParseFilter parses comma-separated destination addresses or prefixes and
+// server names.
+func ParseFilter(dst, sni string) (Filter, error) {
+	var f Filter
+	var err error
+	if f.Dst, err = ParsePrefixes(dst); err != nil {
+		return Filter{}, err
+	}
+	for _, s := range splitList(sni) {
+		f.SNI = append(f.SNI, strings.ToLower(strings.TrimPrefix(s, ".")))
+	}
+	return f, nil
+}
+
+// ParsePrefixes parses comma-separated IPv4 addresses and prefixes; an
+// address is a /32.
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range splitList(list) {
+		if p, err := netip.ParsePrefix(s); err == nil && p.Addr().Is4() {
+			out = append(out, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(s)
+		if err != nil || !a.Is4() {
+			return nil, fmt.Errorf("%q is not an IPv4 address or prefix", s)
+		}
+		out = append(out, netip.PrefixFrom(a, 32))
+	}
+	return out, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// Empty reports whether the filter matches every flow.
+func (f Filter) Empty() bool {
+	return len(f.Dst) == 0 && len(f.SNI) == 0
+}
+
+func (f Filter) matchDst(a netip.Addr) bool {
+	for _, p := range f.Dst {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) matchSNI(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return false
+	}
+	for _, s := range f.SNI {
+		if name == s || strings.HasSuffix(name, "."+s) {
+			return true
+		}
+	}
+	return false
+}
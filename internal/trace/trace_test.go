@@ -0,0 +1,90 @@
+package trace
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"fk-gov/internal/clock"
+)
+
+var (
+	src = netip.MustParseAddrPort("10.0.0.2:40000")
+	dst = netip.MustParseAddrPort("192.0.2.10:443")
+)
+
+func TestRing_Overwrites(t *testing.T) {
+	r := New(3, Filter{})
+	start := time.Unix(1700000000, 0)
+	clk := clock.NewManual(start)
+	f := r.Begin(clk, 0, src, dst)
+	for i := 1; i <= 5; i++ {
+		f.Add(KindCollected, i, "")
+		clk.Advance(time.Second)
+	}
+	events, overwritten := r.Events()
+	if overwritten != 2 || len(events) != 3 {
+		t.Fatalf("got %d events, %d overwritten", len(events), overwritten)
+	}
+	for i, ev := range events {
+		// Stamped by the clock passed to Begin, not the wall clock.
+		if ev.Bytes != i+3 || ev.Src != src || ev.Dst != dst || !ev.Time.Equal(start.Add(time.Duration(i+2)*time.Second)) {
+			t.Fatalf("event %d: %+v", i, ev)
+		}
+	}
+}
+
+func TestRing_FilterByDst(t *testing.T) {
+	f, err := ParseFilter("192.0.2.0/24, 198.51.100.7", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(8, f)
+	if r.Begin(clock.Wall{}, 0, src, netip.MustParseAddrPort("203.0.113.1:443")) != nil {
+		t.Fatal("flow outside the filter traced")
+	}
+	r.Begin(clock.Wall{}, 0, src, dst).Add(KindCreated, 1, "")
+	r.Begin(clock.Wall{}, 0, src, netip.MustParseAddrPort("198.51.100.7:443")).Add(KindCreated, 1, "")
+	if events, _ := r.Events(); len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	var nilRing *Ring
+	nilRing.Begin(clock.Wall{}, 0, src, dst).Add(KindCreated, 1, "") // records nothing
+}
+
+func TestFlow_MatchBySNI(t *testing.T) {
+	f, err := ParseFilter("", ".Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(8, f)
+
+	hit := r.Begin(clock.Wall{}, 1, src, dst)
+	hit.Add(KindCreated, 10, "")
+	hit.Add(KindCollected, 10, "")
+	if events, _ := r.Events(); len(events) != 0 {
+		t.Fatalf("events recorded before the server name was known: %+v", events)
+	}
+	hit = hit.Match("www.example.COM")
+	hit.SetWorker(2)
+	hit.Add(KindSent, 10, "")
+
+	miss := r.Begin(clock.Wall{}, 1, src, dst)
+	miss.Add(KindCreated, 10, "")
+	if miss.Match("notexample.com") != nil || miss.Match("") != nil {
+		t.Fatal("non-matching name traced")
+	}
+
+	events, _ := r.Events()
+	if len(events) != 3 || events[0].Kind != KindCreated || events[2].Kind != KindSent || events[2].Worker != 2 {
+		t.Fatalf("events = %+v", events)
+	}
+}
+
+func TestParseFilter_Invalid(t *testing.T) {
+	for _, dst := range []string{"example.com", "::1", "10.0.0.0/33"} {
+		if _, err := ParseFilter(dst, ""); err == nil {
+			t.Fatalf("%q: expected error", dst)
+		}
+	}
+}
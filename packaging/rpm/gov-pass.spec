@@ -16,8 +16,7 @@ BuildRequires:  golang >= 1.21
 BuildRequires:  gcc
 BuildRequires:  systemd-rpm-macros
 Requires:       systemd
-Requires:       ethtool
-Requires:       iproute
+Requires(pre):  shadow-utils
 Recommends:     nftables
 Recommends:     iptables
 %{?systemd_requires}
@@ -32,6 +31,7 @@ This package installs:
 - NFQUEUE install/uninstall helper scripts
 - systemd unit file
 - /etc/sysconfig override file
+- /etc/gov-pass config directory
 
 %prep
 %autosetup -n %{name}-%{version}
@@ -51,11 +51,14 @@ install -m 0755 scripts/linux/uninstall_nfqueue.sh %{buildroot}%{_libexecdir}/%{
 
 install -D -m 0644 %{SOURCE1} %{buildroot}%{_unitdir}/gov-pass.service
 install -D -m 0644 %{SOURCE2} %{buildroot}%{_sysconfdir}/sysconfig/gov-pass
+install -d -m 0755 %{buildroot}%{_sysconfdir}/gov-pass/conf.d
 
 %pre
 if ! command -v nft >/dev/null 2>&1 && ! command -v iptables >/dev/null 2>&1; then
   echo "Warning: neither nft nor iptables found; gov-pass requires one of them." >&2
 fi
+# Members of gov-pass may use `splitter ctl` without root.
+getent group gov-pass >/dev/null || groupadd -r gov-pass
 
 %post
 %systemd_post gov-pass.service
@@ -70,6 +73,8 @@ fi
 %license LICENSE
 %doc README.md docs/PACKAGING.md docs/THIRD_PARTY_NOTICES.md
 %config(noreplace) %{_sysconfdir}/sysconfig/gov-pass
+%dir %{_sysconfdir}/gov-pass
+%dir %{_sysconfdir}/gov-pass/conf.d
 %{_unitdir}/gov-pass.service
 %{_libexecdir}/%{name}/splitter
 %{_libexecdir}/%{name}/install_nfqueue.sh